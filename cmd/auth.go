@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thatmatin/subserv/internal/auth"
+)
+
+var authConfig auth.Config
+
+func addAuthFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&authConfig.Secret, "jwt-secret", os.Getenv("SUBSERV_JWT_SECRET"), "HS256 secret for access tokens (env SUBSERV_JWT_SECRET)")
+	flags.StringVar(&authConfig.JWKSFile, "jwks-file", os.Getenv("SUBSERV_JWKS_FILE"), "Local JWKS file with RS256/ES256 verification keys (env SUBSERV_JWKS_FILE)")
+	flags.StringVar(&authConfig.Issuer, "jwt-issuer", os.Getenv("SUBSERV_JWT_ISSUER"), "Expected token issuer (env SUBSERV_JWT_ISSUER)")
+	flags.StringVar(&authConfig.Audience, "jwt-audience", os.Getenv("SUBSERV_JWT_AUDIENCE"), "Expected token audience (env SUBSERV_JWT_AUDIENCE)")
+	flags.DurationVar(&authConfig.Leeway, "jwt-leeway", 0, "Allowed clock skew when validating exp/nbf")
+	flags.DurationVar(&authConfig.AccessTTL, "access-token-ttl", auth.DefaultAccessTTL, "Lifetime of access tokens issued at login")
+	flags.DurationVar(&authConfig.RefreshTTL, "refresh-token-ttl", auth.DefaultRefreshTTL, "Lifetime of refresh tokens issued at login")
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thatmatin/subserv/internal/app"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+var (
+	invoicePolicy service.InvoicePolicy
+	renderConfig  app.RenderConfig
+)
+
+var invoiceCmd = &cobra.Command{
+	Use:   "invoice",
+	Short: "Work with issued invoices",
+}
+
+var invoiceRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render invoices to PDF files, e.g. after changing the branding",
+	Run: func(cmd *cobra.Command, args []string) {
+		renderConfig.Branding = invoicePolicy.Branding
+		n, err := app.RenderInvoices(renderConfig)
+		if err != nil {
+			log.Fatalf("failed to render invoices: %v", err)
+		}
+		log.Printf("Rendered %d invoices to %s", n, renderConfig.Out)
+	},
+}
+
+func addInvoiceFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&invoicePolicy.Prefix, "invoice-prefix", os.Getenv("SUBSERV_INVOICE_PREFIX"), "Prefix of invoice numbers, followed by the year and a gap-free sequence; defaults to INV (env SUBSERV_INVOICE_PREFIX)")
+	flags.StringVar(&invoicePolicy.CreditNotePrefix, "credit-note-prefix", os.Getenv("SUBSERV_CREDIT_NOTE_PREFIX"), "Prefix of credit note numbers, followed by the year and a gap-free sequence; defaults to CN (env SUBSERV_CREDIT_NOTE_PREFIX)")
+	flags.StringVar(&invoicePolicy.Seller.Name, "seller-name", os.Getenv("SUBSERV_SELLER_NAME"), "Seller name printed on invoices; defaults to Subserv (env SUBSERV_SELLER_NAME)")
+	flags.StringVar(&invoicePolicy.Seller.Address, "seller-address", os.Getenv("SUBSERV_SELLER_ADDRESS"), "Seller address printed on invoices (env SUBSERV_SELLER_ADDRESS)")
+	flags.StringVar(&invoicePolicy.Seller.Country, "seller-country", os.Getenv("SUBSERV_SELLER_COUNTRY"), "Seller country (ISO 3166-1 alpha-2) printed on invoices (env SUBSERV_SELLER_COUNTRY)")
+	flags.StringVar(&invoicePolicy.Seller.TaxID, "seller-tax-id", os.Getenv("SUBSERV_SELLER_TAX_ID"), "Seller VAT or tax ID printed on invoices (env SUBSERV_SELLER_TAX_ID)")
+}
+
+func addBrandingFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&invoicePolicy.Branding.Logo, "invoice-logo", os.Getenv("SUBSERV_INVOICE_LOGO"), "PNG, JPEG or GIF logo printed on invoice PDFs (env SUBSERV_INVOICE_LOGO)")
+	flags.StringVar(&invoicePolicy.Branding.Color, "invoice-color", os.Getenv("SUBSERV_INVOICE_COLOR"), "Accent color of invoice PDFs as #rrggbb; defaults to #1f2937 (env SUBSERV_INVOICE_COLOR)")
+	flags.StringVar(&invoicePolicy.Branding.Footer, "invoice-footer", os.Getenv("SUBSERV_INVOICE_FOOTER"), "Footer printed on every page of invoice PDFs, e.g. bank details (env SUBSERV_INVOICE_FOOTER)")
+}
+
+func init() {
+	rootCmd.AddCommand(invoiceCmd)
+	invoiceCmd.AddCommand(invoiceRenderCmd)
+	invoiceRenderCmd.Flags().UintSliceVar(&renderConfig.IDs, "id", nil, "IDs of the invoices to render; all invoices without it")
+	invoiceRenderCmd.Flags().StringVarP(&renderConfig.Out, "out", "o", ".", "Directory the PDFs are written to, as <number>.pdf")
+	addBrandingFlags(invoiceRenderCmd)
+}
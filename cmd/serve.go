@@ -10,15 +10,29 @@ import (
 var withSwagger bool
 
 var serveCmd = &cobra.Command{
-	Use:   "serve",
-	Short: "Start the Subserv server",
+	Use:     "serve",
+	Short:   "Start the Subserv server",
+	PreRunE: parseRunFlags,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Println("Starting Subserv server...")
-		app.RunAppandServe(withSwagger)
+		app.RunAppandServe(app.Config{
+			WithSwagger: withSwagger,
+			Auth:        authConfig,
+			TaxRules:    taxRulesFile,
+			TaxPolicy:   taxPolicy,
+			Invoices:    invoicePolicy,
+			Worker:      workerConfig,
+		})
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	serveCmd.PersistentFlags().BoolVarP(&withSwagger, "swagger", "s", false, "Enable Swagger UI")
+	serveCmd.Flags().BoolVar(&workerConfig.Enabled, "worker", false, "Run the background jobs inside the server process")
+	addAuthFlags(serveCmd)
+	addTaxFlags(serveCmd)
+	addInvoiceFlags(serveCmd)
+	addBrandingFlags(serveCmd)
+	addWorkerFlags(serveCmd)
 }
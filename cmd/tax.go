@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thatmatin/subserv/internal/money"
+)
+
+var (
+	taxRulesFile     string
+	taxPolicy        money.TaxPolicy
+	taxRounding      string
+	taxRoundingLevel string
+	taxPrices        string
+)
+
+func addTaxFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&taxRulesFile, "tax-rules", os.Getenv("SUBSERV_TAX_RULES"), "JSON file with tax rules by country; without it products are taxed at their own rate (env SUBSERV_TAX_RULES)")
+	flags.StringVar(&taxRounding, "tax-rounding", os.Getenv("SUBSERV_TAX_ROUNDING"), "How tax halfway between two minor units is rounded: half-up or half-even; defaults to half-up (env SUBSERV_TAX_ROUNDING)")
+	flags.StringVar(&taxRoundingLevel, "tax-rounding-level", os.Getenv("SUBSERV_TAX_ROUNDING_LEVEL"), "Round tax per line, or once per rate over an invoice: line or invoice; defaults to line (env SUBSERV_TAX_ROUNDING_LEVEL)")
+	flags.StringVar(&taxPrices, "tax-prices", os.Getenv("SUBSERV_TAX_PRICES"), "Whether product prices exclude or include tax: net or gross; defaults to net (env SUBSERV_TAX_PRICES)")
+}
+
+func parseTaxFlags() error {
+	taxPolicy = money.DefaultTaxPolicy
+	if taxRounding != "" {
+		rounding, ok := money.ParseRoundingMode(taxRounding)
+		if !ok {
+			return fmt.Errorf("invalid --tax-rounding %q, expected half-up or half-even", taxRounding)
+		}
+		taxPolicy.Rounding = rounding
+	}
+	if taxRoundingLevel != "" {
+		level, ok := money.ParseRoundingLevel(taxRoundingLevel)
+		if !ok {
+			return fmt.Errorf("invalid --tax-rounding-level %q, expected line or invoice", taxRoundingLevel)
+		}
+		taxPolicy.Level = level
+	}
+	switch taxPrices {
+	case "", "net":
+	case "gross":
+		taxPolicy.Inclusive = true
+	default:
+		return fmt.Errorf("invalid --tax-prices %q, expected net or gross", taxPrices)
+	}
+	return nil
+}
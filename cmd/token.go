@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+var (
+	tokenUserID uint
+	tokenTTL    time.Duration
+	tokenRole   string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Issue an HS256 access token for local testing",
+	Run: func(cmd *cobra.Command, args []string) {
+		role := model.Role(tokenRole)
+		if !role.Valid() {
+			log.Fatalf("unknown role %q, expected customer, support or admin", tokenRole)
+		}
+
+		token, err := auth.IssueTokenWithRole(authConfig, tokenUserID, role, tokenTTL)
+		if err != nil {
+			log.Fatalf("failed to issue token: %v", err)
+		}
+		fmt.Println(token)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.Flags().UintVarP(&tokenUserID, "user", "u", 1, "User ID to put in the sub claim")
+	tokenCmd.Flags().DurationVar(&tokenTTL, "ttl", time.Hour, "Token lifetime")
+	tokenCmd.Flags().StringVar(&tokenRole, "role", string(model.RoleCustomer), "Role to put in the token: customer, support or admin")
+	addAuthFlags(tokenCmd)
+}
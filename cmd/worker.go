@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thatmatin/subserv/internal/app"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+var (
+	workerConfig      app.WorkerConfig
+	dunningFinalState string
+)
+
+var workerCmd = &cobra.Command{
+	Use:     "worker",
+	Short:   "Run the background subscription jobs",
+	PreRunE: parseRunFlags,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Println("Starting Subserv worker...")
+		workerConfig.TaxRules = taxRulesFile
+		workerConfig.TaxPolicy = taxPolicy
+		workerConfig.Invoices = invoicePolicy
+		app.RunWorker(workerConfig)
+	},
+}
+
+func addWorkerFlags(cmd *cobra.Command) {
+	defaults := service.DefaultDunningPolicy()
+
+	flags := cmd.Flags()
+	flags.DurationVar(&workerConfig.ExpiryInterval, "expiry-interval", time.Minute, "How often to sweep for expired subscriptions")
+	flags.DurationVar(&workerConfig.RenewalInterval, "renewal-interval", time.Minute, "How often to renew auto-renewing subscriptions")
+	flags.DurationVar(&workerConfig.RenewalLeadTime, "renewal-lead-time", time.Hour*24, "How long before the end of a period the next one is charged")
+	flags.DurationVar(&workerConfig.TrialReminderLead, "trial-reminder-lead", time.Hour*72, "How long before the end of a trial the user is reminded")
+	flags.IntVar(&workerConfig.BatchSize, "batch-size", 100, "Number of subscriptions updated per batch")
+	flags.DurationVar(&workerConfig.MaxPause, "max-pause", 0, "Expire subscriptions paused for longer than this (0 keeps paused subscriptions forever)")
+	flags.DurationSliceVar(&workerConfig.Dunning.RetryAfter, "dunning-retries", defaults.RetryAfter, "Retry a declined renewal at these offsets from the first failure")
+	flags.DurationVar(&workerConfig.Dunning.GracePeriod, "dunning-grace", defaults.GracePeriod, "How long a past due subscription keeps its entitlements")
+	flags.StringVar(&dunningFinalState, "dunning-final-state", "failed", "State after all retries are declined (failed or cancelled)")
+}
+
+// parseRunFlags checks the flags shared by serve and worker.
+func parseRunFlags(cmd *cobra.Command, args []string) error {
+	if err := parseTaxFlags(); err != nil {
+		return err
+	}
+	return parseWorkerFlags(cmd, args)
+}
+
+func parseWorkerFlags(cmd *cobra.Command, args []string) error {
+	if workerConfig.ExpiryInterval <= 0 {
+		return fmt.Errorf("invalid --expiry-interval %s, expected a positive duration", workerConfig.ExpiryInterval)
+	}
+	if workerConfig.RenewalInterval <= 0 {
+		return fmt.Errorf("invalid --renewal-interval %s, expected a positive duration", workerConfig.RenewalInterval)
+	}
+	state, ok := model.ParseState(dunningFinalState)
+	if !ok || (state != model.Failed && state != model.Cancelled) {
+		return fmt.Errorf("invalid --dunning-final-state %q, expected failed or cancelled", dunningFinalState)
+	}
+	workerConfig.Dunning.FinalState = state
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(workerCmd)
+	addWorkerFlags(workerCmd)
+	addTaxFlags(workerCmd)
+	addInvoiceFlags(workerCmd)
+}
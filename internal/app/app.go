@@ -14,40 +14,117 @@ import (
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	_ "github.com/thatmatin/subserv/docs"
+	"github.com/thatmatin/subserv/internal/auth"
 	"github.com/thatmatin/subserv/internal/controller"
 	"github.com/thatmatin/subserv/internal/db"
+	"github.com/thatmatin/subserv/internal/middleware"
+	"github.com/thatmatin/subserv/internal/money"
 	"github.com/thatmatin/subserv/internal/repo"
 	"github.com/thatmatin/subserv/internal/routers"
 	"github.com/thatmatin/subserv/internal/service"
 )
 
-func RunAppandServe(withSwagger bool) {
+type Config struct {
+	WithSwagger bool
+	Auth        auth.Config
+	// TaxRules is the tax rules file, see tax.Load. Without one products are
+	// taxed at their own rate.
+	TaxRules string
+	// TaxPolicy is how charges, quotes and invoices are taxed and rounded.
+	TaxPolicy money.TaxPolicy
+	Invoices  service.InvoicePolicy
+	Worker    WorkerConfig
+}
+
+func RunAppandServe(cfg Config) {
 	r := gin.Default()
 	database, err := db.Setup()
 	if err != nil {
 		log.Fatalf("failed to setup database: %v", err)
 	}
 
+	verifier, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		log.Fatalf("failed to setup token verification: %v", err)
+	}
+
+	taxRules, err := loadTaxRules(cfg.TaxRules)
+	if err != nil {
+		log.Fatalf("failed to load tax rules: %v", err)
+	}
+
+	if err := cfg.Invoices.Branding.Validate(); err != nil {
+		log.Fatalf("failed to load invoice branding: %v", err)
+	}
+	cfg.Invoices.Tax = cfg.TaxPolicy
+	quotePolicy := service.DefaultQuotePolicy()
+	quotePolicy.Tax = cfg.TaxPolicy
+
 	paymentProcessor := service.NewDummyPaymentProcessor()
 
 	productRepo := repo.NewProductRepository(database)
 	userRepo := repo.NewUserRepository(database)
 	subscriptionRepo := repo.NewSubscriptionRepository(database)
+	paymentRepo := repo.NewPaymentRepository(database)
+	idempotencyRepo := repo.NewIdempotencyRepository(database)
+	sessionRepo := repo.NewSessionRepository(database)
+	quoteRepo := repo.NewQuoteRepository(database)
+	invoiceRepo := repo.NewInvoiceRepository(database)
+	creditRepo := repo.NewCreditRepository(database)
+	couponRepo := repo.NewCouponRepository(database)
 
 	productService := service.NewProductService(productRepo)
 	userService := service.NewUserService(userRepo)
-	subscriptionService := service.NewSubscriptionService(subscriptionRepo, productService, userService, paymentProcessor)
+	taxService := service.NewTaxService(taxRules)
+	invoiceService := service.NewInvoiceService(invoiceRepo, productService, userService, cfg.Invoices)
+	creditService := service.NewCreditService(creditRepo, invoiceRepo, cfg.Invoices)
+	couponService := service.NewCouponService(couponRepo, productService)
+	quoteService := service.NewQuoteService(quoteRepo, productService, userService, taxService, couponService, quotePolicy)
+	subscriptionService := service.NewSubscriptionService(subscriptionRepo, paymentRepo, productService, userService, paymentProcessor, quoteService, taxService, invoiceService, creditService, couponService, cfg.TaxPolicy)
+	refundService := service.NewRefundService(subscriptionRepo, paymentRepo, creditService, paymentProcessor, cfg.TaxPolicy)
+	idempotencyService := service.NewIdempotencyService(idempotencyRepo, service.DefaultIdempotencyPolicy())
+	authService := service.NewAuthService(userService, sessionRepo, cfg.Auth)
 
 	productController := controller.NewProductController(&productService)
 	subscriptionController := controller.NewSubscriptionController(&subscriptionService)
+	userController := controller.NewUserController(&userService)
+	authController := controller.NewAuthController(&userService, &authService)
+	paymentController := controller.NewPaymentController(&refundService)
+	quoteController := controller.NewQuoteController(&quoteService)
+	invoiceController := controller.NewInvoiceController(&invoiceService)
+	creditController := controller.NewCreditController(&creditService)
+	couponController := controller.NewCouponController(&couponService)
+
+	authMiddleware := middleware.AuthMiddleware(verifier)
+	routers.RegisterAuthRoutes(r, authController)
+	routers.RegisterUserRoutes(r, userController, authMiddleware)
+	routers.RegisterSessionRoutes(r, authController, authMiddleware)
 	routers.RegisterProductRoutes(r, productController)
-	routers.RegisterSubscriptionRoutes(r, subscriptionController)
+	idempotency := middleware.Idempotency(idempotencyService)
+	routers.RegisterSubscriptionRoutes(r, subscriptionController, authMiddleware, idempotency)
+	routers.RegisterQuoteRoutes(r, quoteController, authMiddleware)
+	routers.RegisterInvoiceRoutes(r, invoiceController, authMiddleware)
+	routers.RegisterCreditRoutes(r, creditController, authMiddleware)
+	routers.RegisterAdminProductRoutes(r, productController, authMiddleware)
+	routers.RegisterAdminCouponRoutes(r, couponController, authMiddleware)
+	routers.RegisterSupportSubscriptionRoutes(r, subscriptionController, authMiddleware)
+	routers.RegisterSupportCreditRoutes(r, creditController, authMiddleware)
+	routers.RegisterAdminUserRoutes(r, userController, authMiddleware)
+	routers.RegisterAdminPaymentRoutes(r, paymentController, authMiddleware, idempotency)
 
-	if withSwagger {
+	if cfg.WithSwagger {
 		log.Println("Serving Swagger UI at http://localhost:8080/swagger/index.html")
 		r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	}
 
+	if cfg.Worker.Enabled {
+		log.Println("Starting background worker...")
+		cfg.Worker.Invoices = cfg.Invoices
+		cfg.Worker.TaxPolicy = cfg.TaxPolicy
+		stopWorker := startWorker(database, cfg.Worker, taxRules)
+		defer stopWorker()
+	}
+
 	server := &http.Server{
 		Addr:    ":8080",
 		Handler: r,
@@ -59,7 +136,7 @@ func RunAppandServe(withSwagger bool) {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down server...")
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/thatmatin/subserv/internal/db"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/pdf"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type RenderConfig struct {
+	// IDs are the invoices to render; all invoices when empty.
+	IDs      []uint
+	Out      string
+	Branding pdf.Branding
+}
+
+// RenderInvoices writes invoices to <Out>/<number>.pdf, overwriting earlier
+// renderings, and returns how many were written.
+func RenderInvoices(cfg RenderConfig) (int, error) {
+	if err := cfg.Branding.Validate(); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(cfg.Out, 0o755); err != nil {
+		return 0, err
+	}
+
+	database, err := db.Setup()
+	if err != nil {
+		return 0, fmt.Errorf("failed to setup database: %w", err)
+	}
+
+	// rendering only reads the finalized invoices, so the services that
+	// issue them aren't needed
+	invoiceService := service.NewInvoiceService(repo.NewInvoiceRepository(database), nil, nil, service.InvoicePolicy{Branding: cfg.Branding})
+	ctx := context.Background()
+
+	ids := cfg.IDs
+	if len(ids) == 0 {
+		cursor := ""
+		for {
+			page, err := invoiceService.ListAll(ctx, cursor, 100)
+			if err != nil {
+				return 0, err
+			}
+			for _, invoice := range page.Invoices {
+				ids = append(ids, invoice.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	for n, id := range ids {
+		invoice, err := invoiceService.Lookup(ctx, id)
+		if err != nil {
+			return n, fmt.Errorf("invoice %d: %w", id, err)
+		}
+		if err := writePDF(invoiceService, invoice, filepath.Join(cfg.Out, invoice.Number+".pdf")); err != nil {
+			return n, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// writePDF renders the invoice in memory first, so a failed rendering
+// doesn't leave a truncated file behind.
+func writePDF(invoiceService service.InvoiceService, invoice *model.Invoice, path string) error {
+	var buf bytes.Buffer
+	if err := invoiceService.RenderPDF(invoice, &buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/db"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/service"
+	"github.com/thatmatin/subserv/internal/tax"
+	"github.com/thatmatin/subserv/internal/worker"
+	"gorm.io/gorm"
+)
+
+type WorkerConfig struct {
+	Enabled         bool
+	ExpiryInterval  time.Duration
+	RenewalInterval time.Duration
+	RenewalLeadTime time.Duration
+	BatchSize       int
+	MaxPause        time.Duration
+	Dunning         service.DunningPolicy
+	// TrialReminderLead is how long before a trial ends the user is
+	// reminded.
+	TrialReminderLead time.Duration
+	// TaxRules is the tax rules file of a standalone worker. A worker
+	// running in the server uses the server's rules.
+	TaxRules string
+	// TaxPolicy is how renewals and their invoices are taxed and rounded,
+	// see Config.
+	TaxPolicy money.TaxPolicy
+	Invoices  service.InvoicePolicy
+}
+
+func newScheduler(database *gorm.DB, cfg WorkerConfig, taxRules *tax.Rules) *worker.Scheduler {
+	subscriptionRepo := repo.NewSubscriptionRepository(database)
+	productService := service.NewProductService(repo.NewProductRepository(database))
+	userService := service.NewUserService(repo.NewUserRepository(database))
+	paymentProcessor := service.NewDummyPaymentProcessor()
+
+	expiryService := service.NewExpiryService(subscriptionRepo, service.ExpiryPolicy{
+		BatchSize: cfg.BatchSize,
+		MaxPause:  cfg.MaxPause,
+	})
+	dunningRepo := repo.NewDunningRepository(database)
+	paymentRepo := repo.NewPaymentRepository(database)
+	taxService := service.NewTaxService(taxRules)
+	invoiceRepo := repo.NewInvoiceRepository(database)
+	cfg.Invoices.Tax = cfg.TaxPolicy
+	invoiceService := service.NewInvoiceService(invoiceRepo, productService, userService, cfg.Invoices)
+	creditService := service.NewCreditService(repo.NewCreditRepository(database), invoiceRepo, cfg.Invoices)
+	couponService := service.NewCouponService(repo.NewCouponRepository(database), productService)
+	renewalService := service.NewRenewalService(subscriptionRepo, dunningRepo, paymentRepo, productService, userService, taxService, invoiceService, creditService, couponService, paymentProcessor, service.RenewalPolicy{
+		LeadTime:  cfg.RenewalLeadTime,
+		BatchSize: cfg.BatchSize,
+		Dunning:   cfg.Dunning,
+		Tax:       cfg.TaxPolicy,
+	})
+	trialService := service.NewTrialService(subscriptionRepo, userService, service.NewLogNotifier(), service.TrialPolicy{
+		ReminderLead: cfg.TrialReminderLead,
+		BatchSize:    cfg.BatchSize,
+	})
+
+	return worker.NewScheduler(
+		worker.Job{
+			Name:     "expire-subscriptions",
+			Interval: cfg.ExpiryInterval,
+			Run: func(ctx context.Context) error {
+				// scheduled cancellations first, so they end as Cancelled
+				// rather than Expired
+				now := time.Now()
+				cancelled, err := expiryService.CancelScheduled(ctx, now)
+				if cancelled > 0 {
+					log.Printf("worker: cancelled %d subscriptions at period end", cancelled)
+				}
+				if err != nil {
+					return err
+				}
+
+				n, err := expiryService.ExpireDue(ctx, now)
+				if n > 0 {
+					log.Printf("worker: expired %d subscriptions", n)
+				}
+				return err
+			},
+		},
+		worker.Job{
+			Name:     "renew-subscriptions",
+			Interval: cfg.RenewalInterval,
+			Run: func(ctx context.Context) error {
+				res, err := renewalService.RenewDue(ctx, time.Now())
+				if res.Renewed > 0 || res.PastDue > 0 || res.Failed > 0 || res.Ended > 0 {
+					log.Printf("worker: renewed %d subscriptions, %d past due, %d failed, %d trials ended", res.Renewed, res.PastDue, res.Failed, res.Ended)
+				}
+				return err
+			},
+		},
+		worker.Job{
+			Name:     "remind-trials",
+			Interval: cfg.RenewalInterval,
+			Run: func(ctx context.Context) error {
+				n, err := trialService.RemindEnding(ctx, time.Now())
+				if n > 0 {
+					log.Printf("worker: reminded %d users of their trial ending", n)
+				}
+				return err
+			},
+		},
+		worker.Job{
+			Name:     "retry-past-due-subscriptions",
+			Interval: cfg.RenewalInterval,
+			Run: func(ctx context.Context) error {
+				res, err := renewalService.RetryPastDue(ctx, time.Now())
+				if res.Renewed > 0 || res.PastDue > 0 || res.Failed > 0 {
+					log.Printf("worker: recovered %d past due subscriptions, %d still past due, %d failed", res.Renewed, res.PastDue, res.Failed)
+				}
+				return err
+			},
+		},
+	)
+}
+
+// RunWorker runs the background jobs in the foreground until SIGINT/SIGTERM.
+func RunWorker(cfg WorkerConfig) {
+	database, err := db.Setup()
+	if err != nil {
+		log.Fatalf("failed to setup database: %v", err)
+	}
+	taxRules, err := loadTaxRules(cfg.TaxRules)
+	if err != nil {
+		log.Fatalf("failed to load tax rules: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Println("Worker started")
+	newScheduler(database, cfg, taxRules).Run(ctx)
+	log.Println("Worker exiting")
+}
+
+// startWorker runs the scheduler in the background and returns a function
+// that stops it and waits for running jobs to finish.
+func startWorker(database *gorm.DB, cfg WorkerConfig, taxRules *tax.Rules) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		newScheduler(database, cfg, taxRules).Run(ctx)
+		close(done)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// loadTaxRules loads the rules file, if there is one.
+func loadTaxRules(path string) (*tax.Rules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	rules, err := tax.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded tax rules for %d countries from %s", len(rules.Countries), path)
+	return rules, nil
+}
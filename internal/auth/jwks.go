@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type publicKey struct {
+	alg string
+	key any
+}
+
+// keySet holds the verification keys of a local JWKS file indexed by kid.
+type keySet struct {
+	byKID map[string]publicKey
+}
+
+func loadJWKS(path string) (*keySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks document: %w", err)
+	}
+
+	ks := &keySet{byKID: make(map[string]publicKey)}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pk, err := parseJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key at index %d: %w", i, err)
+		}
+		ks.byKID[k.Kid] = pk
+	}
+
+	if len(ks.byKID) == 0 {
+		return nil, errors.New("jwks contains no signing keys")
+	}
+
+	return ks, nil
+}
+
+// lookup returns the key for kid. A token without kid is accepted only when
+// the set holds a single key.
+func (ks *keySet) lookup(kid string, alg string) (any, error) {
+	pk, ok := ks.byKID[kid]
+	if !ok && kid == "" && len(ks.byKID) == 1 {
+		for _, only := range ks.byKID {
+			pk, ok = only, true
+		}
+	}
+	if !ok || pk.alg != alg {
+		return nil, ErrUnknownKey
+	}
+	return pk.key, nil
+}
+
+func parseJWK(k jwk) (publicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" {
+			return publicKey{}, fmt.Errorf("unsupported rsa algorithm %q", k.Alg)
+		}
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid exponent: %w", err)
+		}
+		return publicKey{alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != "ES256") {
+			return publicKey{}, fmt.Errorf("unsupported ec curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return publicKey{}, errors.New("point is not on curve")
+		}
+		return publicKey{alg: "ES256", key: key}, nil
+	default:
+		return publicKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
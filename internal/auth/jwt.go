@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not valid yet")
+	ErrInvalidIssuer    = errors.New("invalid token issuer")
+	ErrInvalidAudience  = errors.New("invalid token audience")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrInvalidSubject   = errors.New("invalid token subject")
+	ErrUnknownKey       = errors.New("no key available to verify token")
+	ErrMissingClaim     = errors.New("required token claim missing")
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrWrongTokenUse    = errors.New("token can't be used here")
+)
+
+// Config holds the verification settings for bearer tokens. At least one of
+// Secret (HS256) or JWKSFile (RS256/ES256) must be set. Tokens are only
+// issued with Secret; AccessTTL and RefreshTTL are their lifetimes.
+type Config struct {
+	Secret     string
+	JWKSFile   string
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// AccessToken is the token_use of tokens accepted by Verify. Tokens without
+// a token_use claim are access tokens too.
+const AccessToken = "access"
+
+type Claims struct {
+	jwt.RegisteredClaims
+	Role     model.Role `json:"role,omitempty"`
+	TokenUse string     `json:"token_use,omitempty"`
+	// SessionID is the login session the token was issued for. Tokens
+	// minted with the token command have none.
+	SessionID uint `json:"sid,omitempty"`
+}
+
+// UserRole returns the role claim, tokens without one belong to customers.
+func (c *Claims) UserRole() model.Role {
+	if c.Role == "" {
+		return model.RoleCustomer
+	}
+	return c.Role
+}
+
+// UserID parses the subject claim as a user ID.
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidSubject
+	}
+	return uint(id), nil
+}
+
+type Verifier struct {
+	secret  []byte
+	keys    *keySet
+	methods []string
+	parser  *jwt.Parser
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	v := &Verifier{}
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+		v.methods = append(v.methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load jwks: %w", err)
+		}
+		v.keys = keys
+		v.methods = append(v.methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+
+	if len(v.methods) == 0 {
+		return nil, errors.New("either a jwt secret or a jwks file must be configured")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+// Verify checks the signature and registered claims of the raw access token
+// and returns its claims. Errors are one of the sentinel errors of this
+// package.
+func (v *Verifier) Verify(raw string) (*Claims, error) {
+	claims, err := v.parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenUse != "" && claims.TokenUse != AccessToken {
+		return nil, ErrWrongTokenUse
+	}
+	return claims, nil
+}
+
+func (v *Verifier) parse(raw string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(raw, claims, v.keyFunc); err != nil {
+		return nil, translateError(err)
+	}
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Verifier) keyFunc(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.secret == nil {
+			return nil, ErrUnknownKey
+		}
+		return v.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		if v.keys == nil {
+			return nil, ErrUnknownKey
+		}
+		kid, _ := token.Header["kid"].(string)
+		return v.keys.lookup(kid, token.Method.Alg())
+	default:
+		return nil, ErrUnknownKey
+	}
+}
+
+func translateError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownKey):
+		return ErrUnknownKey
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrMalformedToken
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrInvalidSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return ErrInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ErrInvalidAudience
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return ErrMissingClaim
+	default:
+		return ErrInvalidToken
+	}
+}
+
+// IssueToken signs an HS256 access token for the given user using the
+// configured secret, issuer and audience.
+func IssueToken(cfg Config, userID uint, ttl time.Duration) (string, error) {
+	return IssueTokenWithRole(cfg, userID, model.RoleCustomer, ttl)
+}
+
+// IssueTokenWithRole is IssueToken for a user with the given role.
+func IssueTokenWithRole(cfg Config, userID uint, role model.Role, ttl time.Duration) (string, error) {
+	return issue(cfg, userID, Claims{Role: role, TokenUse: AccessToken}, ttl)
+}
+
+// IssueSessionToken is IssueTokenWithRole for a token issued at login,
+// carrying the session in the sid claim.
+func IssueSessionToken(cfg Config, userID uint, role model.Role, sessionID uint, ttl time.Duration) (string, error) {
+	return issue(cfg, userID, Claims{Role: role, TokenUse: AccessToken, SessionID: sessionID}, ttl)
+}
+
+func issue(cfg Config, userID uint, claims Claims, ttl time.Duration) (string, error) {
+	if cfg.Secret == "" {
+		return "", errors.New("jwt secret is not configured")
+	}
+
+	now := time.Now()
+	claims.Subject = strconv.FormatUint(uint64(userID), 10)
+	claims.Issuer = cfg.Issuer
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	if cfg.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{cfg.Audience}
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.Secret))
+}
@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+func writeJWKS(t *testing.T, keys ...jwk) string {
+	t.Helper()
+	data, err := json.Marshal(jwks{Keys: keys})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func claimsFor(sub string, exp time.Time) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   sub,
+		Issuer:    "subserv",
+		Audience:  jwt.ClaimStrings{"subserv-api"},
+		ExpiresAt: jwt.NewNumericDate(exp),
+	}
+}
+
+func TestVerifyHS256(t *testing.T) {
+	cfg := Config{Secret: "secret", Issuer: "subserv", Audience: "subserv-api"}
+	v, err := NewVerifier(cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	sign := func(secret string, c jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(secret))
+		require.NoError(t, err)
+		return token
+	}
+
+	testCases := []struct {
+		name        string
+		token       string
+		expectedID  uint
+		expectedErr error
+	}{
+		{
+			name:       "valid token",
+			token:      sign("secret", claimsFor("7", now.Add(time.Hour))),
+			expectedID: 7,
+		},
+		{
+			name:        "expired token",
+			token:       sign("secret", claimsFor("7", now.Add(-time.Minute))),
+			expectedErr: ErrTokenExpired,
+		},
+		{
+			name: "not yet valid",
+			token: sign("secret", func() jwt.RegisteredClaims {
+				c := claimsFor("7", now.Add(time.Hour))
+				c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute * 10))
+				return c
+			}()),
+			expectedErr: ErrTokenNotYetValid,
+		},
+		{
+			name: "wrong issuer",
+			token: sign("secret", func() jwt.RegisteredClaims {
+				c := claimsFor("7", now.Add(time.Hour))
+				c.Issuer = "someone-else"
+				return c
+			}()),
+			expectedErr: ErrInvalidIssuer,
+		},
+		{
+			name: "wrong audience",
+			token: sign("secret", func() jwt.RegisteredClaims {
+				c := claimsFor("7", now.Add(time.Hour))
+				c.Audience = jwt.ClaimStrings{"other-api"}
+				return c
+			}()),
+			expectedErr: ErrInvalidAudience,
+		},
+		{
+			name:        "wrong secret",
+			token:       sign("not-the-secret", claimsFor("7", now.Add(time.Hour))),
+			expectedErr: ErrInvalidSignature,
+		},
+		{
+			name: "missing exp",
+			token: sign("secret", func() jwt.RegisteredClaims {
+				c := claimsFor("7", now)
+				c.ExpiresAt = nil
+				return c
+			}()),
+			expectedErr: ErrMissingClaim,
+		},
+		{
+			name:        "non numeric subject",
+			token:       sign("secret", claimsFor("alice", now.Add(time.Hour))),
+			expectedErr: ErrInvalidSubject,
+		},
+		{
+			name:        "garbage",
+			token:       "not.a.jwt",
+			expectedErr: ErrMalformedToken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.Verify(tc.token)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			id, err := claims.UserID()
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedID, id)
+		})
+	}
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	path := writeJWKS(t,
+		jwk{Kty: "RSA", Kid: "rsa-1", Use: "sig", Alg: "RS256", N: b64(rsaKey.N), E: b64(big.NewInt(int64(rsaKey.E)))},
+		jwk{Kty: "EC", Kid: "ec-1", Use: "sig", Alg: "ES256", Crv: "P-256", X: b64(ecKey.X), Y: b64(ecKey.Y)},
+	)
+
+	v, err := NewVerifier(Config{JWKSFile: path, Issuer: "subserv", Audience: "subserv-api"})
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, claimsFor("3", time.Now().Add(time.Hour)))
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{name: "rs256", token: sign(jwt.SigningMethodRS256, "rsa-1", rsaKey)},
+		{name: "es256", token: sign(jwt.SigningMethodES256, "ec-1", ecKey)},
+		{name: "unknown kid", token: sign(jwt.SigningMethodRS256, "rsa-2", rsaKey), expectedErr: ErrUnknownKey},
+		{name: "kid of another algorithm", token: sign(jwt.SigningMethodRS256, "ec-1", rsaKey), expectedErr: ErrUnknownKey},
+		{name: "missing kid with several keys", token: sign(jwt.SigningMethodRS256, "", rsaKey), expectedErr: ErrUnknownKey},
+		{name: "signed by another key", token: sign(jwt.SigningMethodRS256, "rsa-1", otherRSA), expectedErr: ErrInvalidSignature},
+		{name: "hs256 without secret", token: func() string {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claimsFor("3", time.Now().Add(time.Hour))).SignedString([]byte("x"))
+			require.NoError(t, err)
+			return signed
+		}(), expectedErr: ErrInvalidSignature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := v.Verify(tc.token)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNewVerifierRequiresKeys(t *testing.T) {
+	_, err := NewVerifier(Config{})
+	require.Error(t, err)
+}
+
+func TestTokenUse(t *testing.T) {
+	cfg := Config{Secret: "secret", Issuer: "subserv"}
+	v, err := NewVerifier(cfg)
+	require.NoError(t, err)
+
+	access, err := IssueSessionToken(cfg, 4, model.RoleSupport, 9, time.Hour)
+	require.NoError(t, err)
+	claims, err := v.Verify(access)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleSupport, claims.UserRole())
+	require.Equal(t, uint(9), claims.SessionID)
+
+	other, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: claimsFor("4", time.Now().Add(time.Hour)),
+		TokenUse:         "id",
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = v.Verify(other)
+	require.ErrorIs(t, err, ErrWrongTokenUse, "only access tokens authenticate requests")
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	require.NoError(t, err)
+	require.Equal(t, HashRefreshToken(token), hash)
+	require.NotEqual(t, token, hash)
+
+	other, _, err := NewRefreshToken()
+	require.NoError(t, err)
+	require.NotEqual(t, token, other)
+}
@@ -0,0 +1,35 @@
+package auth
+
+import "github.com/thatmatin/subserv/internal/model"
+
+// Permission names an action guarded by RequirePermission. Every
+// authenticated user may manage their own subscriptions; permissions cover
+// everything beyond that.
+type Permission string
+
+const (
+	ProductsWrite        Permission = "products:write"
+	SubscriptionsReadAny Permission = "subscriptions:read:any"
+	UsersWrite           Permission = "users:write"
+	PaymentsRefund       Permission = "payments:refund"
+	// BillingReadAny covers any user's credit notes and balance.
+	BillingReadAny Permission = "billing:read:any"
+	CouponsWrite   Permission = "coupons:write"
+)
+
+var rolePermissions = map[model.Role][]Permission{
+	model.RoleCustomer: {},
+	model.RoleSupport:  {SubscriptionsReadAny, BillingReadAny},
+	model.RoleAdmin:    {ProductsWrite, SubscriptionsReadAny, UsersWrite, PaymentsRefund, BillingReadAny, CouponsWrite},
+}
+
+// HasPermission reports whether the role grants the permission. Unknown roles
+// have no permissions.
+func HasPermission(role model.Role, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
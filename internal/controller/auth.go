@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type AuthController struct {
+	users service.UserService
+	auth  service.AuthService
+}
+
+func NewAuthController(userService *service.UserService, authService *service.AuthService) *AuthController {
+	controller := &AuthController{
+		users: *userService,
+		auth:  *authService,
+	}
+
+	return controller
+}
+
+// @Summary Register
+// @Description Create a customer account. Log in afterwards to get tokens.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body dto.RegisterRequest true "Account"
+// @Success 201 {object} dto.UserResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /auth/register [post]
+func (c *AuthController) Register(ctx *gin.Context) {
+	var req dto.RegisterRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	user, err := c.users.Register(ctx, service.RegisterParams{Name: req.Name, Email: req.Email, Password: req.Password})
+	if err != nil {
+		profileError(ctx, err, "Failed to register")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.ToUserResponse(user))
+}
+
+// @Summary Log in
+// @Description Exchange email and password for an access token and a refresh token
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body dto.LoginRequest true "Credentials"
+// @Success 200 {object} dto.TokenResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /auth/login [post]
+func (c *AuthController) Login(ctx *gin.Context) {
+	var req dto.LoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	pair, err := c.auth.Login(ctx, req.Email, req.Password, clientInfo(ctx, req.DeviceName))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Invalid email or password"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to log in"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToTokenResponse(pair.AccessToken, pair.RefreshToken, pair.ExpiresIn))
+}
+
+// @Summary Refresh tokens
+// @Description Exchange a refresh token for a new access token and refresh token. Each refresh token works once; reusing one logs its session out.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body dto.RefreshRequest true "Refresh token"
+// @Success 200 {object} dto.TokenResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /auth/refresh [post]
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var req dto.RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	pair, err := c.auth.Refresh(ctx, req.RefreshToken, clientInfo(ctx, ""))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Invalid refresh token"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to refresh tokens"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToTokenResponse(pair.AccessToken, pair.RefreshToken, pair.ExpiresIn))
+}
+
+// @Summary List sessions
+// @Description List the devices the authenticated user is logged in on
+// @Tags Profile
+// @Produce json
+// @Success 200 {object} dto.SessionListResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /me/sessions [get]
+// @Security ApiKeyAuth
+func (c *AuthController) ListSessions(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	sessions, err := c.auth.ListSessions(ctx, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list sessions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToSessionListResponse(sessions, currentSessionID(ctx)))
+}
+
+// @Summary Revoke a session
+// @Description Log out a device. Its refresh token stops working immediately, its access token when it expires.
+// @Tags Profile
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} dto.SessionMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /me/sessions/{id} [delete]
+// @Security ApiKeyAuth
+func (c *AuthController) RevokeSession(ctx *gin.Context) {
+	var uri dto.SessionRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid session ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	if err := c.auth.RevokeSession(ctx, uri.ID, userID); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Session not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to revoke session"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.SessionMessageResponse{Message: "Session revoked successfully"})
+}
+
+func clientInfo(ctx *gin.Context, device string) service.ClientInfo {
+	return service.ClientInfo{
+		Device:    device,
+		UserAgent: truncate(ctx.Request.UserAgent(), 255),
+		IP:        ctx.ClientIP(),
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
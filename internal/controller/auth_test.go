@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func TestAuthController(t *testing.T) {
+	router := gin.Default()
+
+	mockUserRepo := new(mock.MockUserRepo)
+	mockSessionRepo := new(mock.MockSessionRepo)
+	userService := service.NewUserService(mockUserRepo)
+	authService := service.NewAuthService(userService, mockSessionRepo, testAuthConfig)
+	authController := NewAuthController(&userService, &authService)
+	userController := NewUserController(&userService)
+
+	router.POST("/auth/register", authController.Register)
+	router.POST("/auth/login", authController.Login)
+	router.POST("/auth/refresh", authController.Refresh)
+	router.GET("/me", authMiddleware(t), userController.GetMe)
+	router.PATCH("/me", authMiddleware(t), userController.UpdateMe)
+	router.GET("/me/sessions", authMiddleware(t), authController.ListSessions)
+	router.DELETE("/me/sessions/:id", authMiddleware(t), authController.RevokeSession)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	alice := &model.User{Model: gorm.Model{ID: 1}, Name: "Alice", Email: "alice@d.com", Role: model.RoleCustomer, PasswordHash: string(hash)}
+
+	send := func(method, path, body, authorization string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "subserv-test")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("register", func(t *testing.T) {
+		mockUserRepo.On("Create", mocklib.Anything, mocklib.AnythingOfType("*model.User")).Return(nil).Once()
+
+		w := send(http.MethodPost, "/auth/register", `{"name":"Alice","email":"alice@d.com","password":"correct horse"}`, "")
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"email":"alice@d.com","role":"customer"`)
+		require.NotContains(t, w.Body.String(), "password")
+	})
+
+	t.Run("register with taken email", func(t *testing.T) {
+		mockUserRepo.On("Create", mocklib.Anything, mocklib.AnythingOfType("*model.User")).Return(gorm.ErrDuplicatedKey).Once()
+
+		w := send(http.MethodPost, "/auth/register", `{"name":"Alice","email":"alice@d.com","password":"correct horse"}`, "")
+
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.JSONEq(t, `{"message":"Email is already registered"}`, w.Body.String())
+	})
+
+	t.Run("register with short password", func(t *testing.T) {
+		w := send(http.MethodPost, "/auth/register", `{"name":"Alice","email":"alice@d.com","password":"short"}`, "")
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	mockUserRepo.On("GetByEmail", mocklib.Anything, "alice@d.com").Return(alice, nil)
+	mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(alice, nil)
+
+	t.Run("login with wrong password", func(t *testing.T) {
+		w := send(http.MethodPost, "/auth/login", `{"email":"alice@d.com","password":"wrong horse"}`, "")
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.JSONEq(t, `{"message":"Invalid email or password"}`, w.Body.String())
+	})
+
+	session := model.Session{Model: gorm.Model{ID: 4}, UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	var tokens dto.TokenResponse
+	t.Run("login", func(t *testing.T) {
+		mockSessionRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(s *model.Session) bool {
+			return s.Device == "phone" && s.UserAgent == "subserv-test"
+		}), mocklib.AnythingOfType("*model.SessionToken")).Run(func(args mocklib.Arguments) {
+			args.Get(1).(*model.Session).ID = session.ID
+		}).Return(nil).Once()
+
+		w := send(http.MethodPost, "/auth/login", `{"email":"alice@d.com","password":"correct horse","device_name":"phone"}`, "")
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+		require.Equal(t, "Bearer", tokens.TokenType)
+		require.NotEmpty(t, tokens.RefreshToken)
+	})
+
+	t.Run("get profile", func(t *testing.T) {
+		w := send(http.MethodGet, "/me", "", "Bearer "+tokens.AccessToken)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"id":1,"name":"Alice","email":"alice@d.com"`)
+	})
+
+	t.Run("refresh token can't authenticate requests", func(t *testing.T) {
+		w := send(http.MethodGet, "/me", "", "Bearer "+tokens.RefreshToken)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		mockSessionRepo.On("GetToken", mocklib.Anything, auth.HashRefreshToken(tokens.RefreshToken)).
+			Return(&model.SessionToken{ID: 1, SessionID: session.ID, Session: session}, nil).Once()
+		mockSessionRepo.On("Rotate", mocklib.Anything, mocklib.Anything, mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+
+		w := send(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`, "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		usedAt := time.Now()
+		mockSessionRepo.On("GetToken", mocklib.Anything, auth.HashRefreshToken(tokens.RefreshToken)).
+			Return(&model.SessionToken{ID: 1, SessionID: session.ID, Session: session, UsedAt: &usedAt}, nil).Once()
+		mockSessionRepo.On("Revoke", mocklib.Anything, session.ID, mocklib.Anything).Return(nil).Once()
+
+		w = send(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tokens.RefreshToken+`"}`, "")
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.JSONEq(t, `{"message":"Invalid refresh token"}`, w.Body.String())
+	})
+
+	t.Run("list sessions", func(t *testing.T) {
+		other := model.Session{Model: gorm.Model{ID: 2}, UserID: 1, Device: "laptop"}
+		mockSessionRepo.On("ListActive", mocklib.Anything, uint(1), mocklib.Anything).Return([]model.Session{session, other}, nil).Once()
+
+		w := send(http.MethodGet, "/me/sessions", "", "Bearer "+tokens.AccessToken)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp dto.SessionListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Sessions, 2)
+		require.True(t, resp.Sessions[0].Current)
+		require.False(t, resp.Sessions[1].Current)
+	})
+
+	t.Run("revoke session", func(t *testing.T) {
+		mockSessionRepo.On("GetByID", mocklib.Anything, uint(2)).Return(&model.Session{Model: gorm.Model{ID: 2}, UserID: 1}, nil).Once()
+		mockSessionRepo.On("Revoke", mocklib.Anything, uint(2), mocklib.Anything).Return(nil).Once()
+		mockSessionRepo.On("GetByID", mocklib.Anything, uint(3)).Return(&model.Session{Model: gorm.Model{ID: 3}, UserID: 2}, nil).Once()
+
+		w := send(http.MethodDelete, "/me/sessions/2", "", "Bearer "+tokens.AccessToken)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = send(http.MethodDelete, "/me/sessions/3", "", "Bearer "+tokens.AccessToken)
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.JSONEq(t, `{"message":"Session not found"}`, w.Body.String())
+	})
+
+	t.Run("change email without current password", func(t *testing.T) {
+		w := send(http.MethodPatch, "/me", `{"email":"alice@b.com"}`, "Bearer "+tokens.AccessToken)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.JSONEq(t, `{"message":"Current password is incorrect"}`, w.Body.String())
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		mockUserRepo.On("Save", mocklib.Anything, mocklib.AnythingOfType("*model.User")).Return(nil).Once()
+
+		w := send(http.MethodPatch, "/me", `{"name":"Alice B"}`, "Bearer "+tokens.AccessToken)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"name":"Alice B"`)
+	})
+}
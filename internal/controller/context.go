@@ -0,0 +1,29 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/middleware"
+)
+
+// currentUserID returns the authenticated user set by the auth middleware.
+func currentUserID(ctx *gin.Context) (uint, bool) {
+	userIDVal, exists := ctx.Get(middleware.UserIDKey)
+	if !exists {
+		return 0, false
+	}
+
+	userID, ok := userIDVal.(uint)
+	return userID, ok
+}
+
+// idempotencyKey returns the Idempotency-Key accepted by the idempotency
+// middleware, or an empty string.
+func idempotencyKey(ctx *gin.Context) string {
+	return ctx.GetString(middleware.IdempotencyKeyCtxKey)
+}
+
+// currentSessionID returns the login session of the access token, or zero.
+func currentSessionID(ctx *gin.Context) uint {
+	sessionID, _ := ctx.Value(middleware.SessionIDKey).(uint)
+	return sessionID
+}
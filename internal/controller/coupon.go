@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type CouponController struct {
+	svc service.CouponService
+}
+
+func NewCouponController(couponService *service.CouponService) *CouponController {
+	controller := &CouponController{
+		svc: *couponService,
+	}
+
+	return controller
+}
+
+// @Summary Create a coupon
+// @Description Add a coupon whose code customers redeem as promo_code when they subscribe or ask for a quote
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body dto.CreateCouponRequest true "Coupon"
+// @Success 201 {object} dto.CouponResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/coupons [post]
+// @Security ApiKeyAuth
+func (c *CouponController) CreateCoupon(ctx *gin.Context) {
+	var req dto.CreateCouponRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	coupon, err := c.svc.Create(ctx, service.CouponParams{
+		Code:           req.Code,
+		Name:           req.Name,
+		PercentOff:     req.PercentOff,
+		AmountOffCent:  req.AmountOffCent,
+		Currency:       req.Currency,
+		Duration:       model.CouponDuration(req.Duration),
+		DurationCycles: req.DurationCycles,
+		MaxRedemptions: req.MaxRedemptions,
+		MaxPerUser:     req.MaxPerUser,
+		ExpiresAt:      req.ExpiresAt,
+		ProductIDs:     req.ProductIDs,
+	})
+	if err != nil {
+		c.couponError(ctx, err, "Failed to create coupon")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.ToCouponResponse(coupon))
+}
+
+// @Summary List coupons
+// @Description List the active coupons, newest first, using cursor-based pagination
+// @Tags Admin
+// @Produce json
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.CouponListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/coupons [get]
+// @Security ApiKeyAuth
+func (c *CouponController) ListCoupons(ctx *gin.Context) {
+	var req dto.CouponListRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid query parameters"})
+		return
+	}
+
+	page, err := c.svc.List(ctx, req.Cursor, req.Limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list coupons"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToCouponListResponse(page.Coupons, page.NextCursor))
+}
+
+// @Summary Get a coupon
+// @Description Fetch an active coupon and how often it was redeemed
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Coupon ID"
+// @Success 200 {object} dto.CouponResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/coupons/{id} [get]
+// @Security ApiKeyAuth
+func (c *CouponController) GetCoupon(ctx *gin.Context) {
+	var uri dto.CouponRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid coupon ID"})
+		return
+	}
+
+	coupon, err := c.svc.Get(ctx, uri.ID)
+	if err != nil {
+		c.couponError(ctx, err, "Failed to fetch coupon")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToCouponResponse(coupon))
+}
+
+// @Summary Archive a coupon
+// @Description Stop a coupon from being redeemed. Subscriptions that redeemed it keep their discount.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Coupon ID"
+// @Success 200 {object} dto.CouponMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/coupons/{id} [delete]
+// @Security ApiKeyAuth
+func (c *CouponController) ArchiveCoupon(ctx *gin.Context) {
+	var uri dto.CouponRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid coupon ID"})
+		return
+	}
+
+	if err := c.svc.Archive(ctx, uri.ID); err != nil {
+		c.couponError(ctx, err, "Failed to archive coupon")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.CouponMessageResponse{Message: "Coupon archived successfully"})
+}
+
+func (c *CouponController) couponError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrCouponNotFound):
+		ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Coupon not found"})
+	case errors.Is(err, service.ErrProductNotFound):
+		ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Product not found"})
+	case errors.Is(err, service.ErrInvalidCoupon):
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: fallback})
+	}
+}
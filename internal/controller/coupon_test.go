@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/middleware"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/service"
+	"gorm.io/gorm"
+)
+
+func TestCouponController(t *testing.T) {
+	router := gin.Default()
+
+	mockCouponRepo := new(mock.MockCouponRepo)
+	mockProductRepo := new(mock.MockProductRepo)
+	couponService := service.NewCouponService(mockCouponRepo, service.NewProductService(mockProductRepo))
+	couponController := NewCouponController(&couponService)
+
+	admin := router.Group("/admin/coupons", authMiddleware(t), middleware.RequirePermission(auth.CouponsWrite))
+	admin.POST("", couponController.CreateCoupon)
+	admin.GET("", couponController.ListCoupons)
+	admin.GET("/:id", couponController.GetCoupon)
+	admin.DELETE("/:id", couponController.ArchiveCoupon)
+
+	createdAt := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	coupon := model.Coupon{
+		Model:          gorm.Model{ID: 4, CreatedAt: createdAt},
+		Code:           "SPRING25",
+		Name:           "Spring sale",
+		PercentOff:     money.Percent(25),
+		Duration:       model.CouponRepeating,
+		DurationCycles: 3,
+		MaxRedemptions: 100,
+		TimesRedeemed:  7,
+		Products:       []model.CouponProduct{{CouponID: 4, ProductID: 1}},
+	}
+	mockCouponRepo.On("GetByID", mocklib.Anything, uint(4)).Return(&coupon, nil)
+	mockCouponRepo.On("GetByID", mocklib.Anything, uint(9)).Return((*model.Coupon)(nil), gorm.ErrRecordNotFound)
+	mockCouponRepo.On("List", mocklib.Anything, repo.CouponFilter{Limit: 21}).Return([]model.Coupon{coupon}, nil)
+	mockCouponRepo.On("Archive", mocklib.Anything, uint(4)).Return(true, nil)
+	mockCouponRepo.On("Archive", mocklib.Anything, uint(9)).Return(false, nil)
+	mockCouponRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(c *model.Coupon) bool {
+		return c.Code == "FIVEOFF"
+	})).Return(nil)
+	mockCouponRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(c *model.Coupon) bool {
+		return c.Code == "SPRING25"
+	})).Return(gorm.ErrDuplicatedKey)
+	mockProductRepo.On("GetByID", mocklib.Anything, uint(8)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+
+	adminToken := roleToken(t, 9, model.RoleAdmin)
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		token        string
+		expectedCode int
+		expectedBody string
+		contains     []string
+	}{
+		{
+			name:         "get coupon",
+			method:       http.MethodGet,
+			path:         "/admin/coupons/4",
+			token:        adminToken,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":4,"code":"SPRING25","name":"Spring sale","percent_off":25,"duration":"repeating","duration_cycles":3,"max_redemptions":100,"max_per_user":0,"times_redeemed":7,"product_ids":[1],"created_at":"2025-03-10T12:00:00Z"}`,
+		},
+		{name: "customers can't manage coupons", method: http.MethodGet, path: "/admin/coupons/4", token: roleToken(t, 2, model.RoleCustomer), expectedCode: http.StatusForbidden},
+		{name: "support can't manage coupons", method: http.MethodGet, path: "/admin/coupons", token: roleToken(t, 2, model.RoleSupport), expectedCode: http.StatusForbidden},
+		{name: "unknown coupon", method: http.MethodGet, path: "/admin/coupons/9", token: adminToken, expectedCode: http.StatusNotFound, expectedBody: `{"message":"Coupon not found"}`},
+		{name: "invalid coupon ID", method: http.MethodGet, path: "/admin/coupons/abc", token: adminToken, expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid coupon ID"}`},
+		{name: "list coupons", method: http.MethodGet, path: "/admin/coupons", token: adminToken, expectedCode: http.StatusOK, contains: []string{`"coupons":[{"id":4,"code":"SPRING25"`}},
+		{name: "list with invalid cursor", method: http.MethodGet, path: "/admin/coupons?cursor=abc", token: adminToken, expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid cursor"}`},
+		{
+			name:         "create coupon",
+			method:       http.MethodPost,
+			path:         "/admin/coupons",
+			body:         `{"code":"fiveoff","amount_off_cent":500,"currency":"EUR","duration":"forever","max_per_user":1}`,
+			token:        adminToken,
+			expectedCode: http.StatusCreated,
+			contains:     []string{`"code":"FIVEOFF","amount_off_cent":500,"currency":"EUR","duration":"forever","max_redemptions":0,"max_per_user":1`},
+		},
+		{
+			name:         "taken code",
+			method:       http.MethodPost,
+			path:         "/admin/coupons",
+			body:         `{"code":"SPRING25","percent_off":25,"duration":"once"}`,
+			token:        adminToken,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"message":"invalid coupon: code SPRING25 is taken"}`,
+		},
+		{
+			name:         "invalid coupon",
+			method:       http.MethodPost,
+			path:         "/admin/coupons",
+			body:         `{"code":"SPRING25","percent_off":25,"duration":"repeating"}`,
+			token:        adminToken,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"message":"invalid coupon: a repeating coupon needs duration cycles"}`,
+		},
+		{
+			name:         "unknown product",
+			method:       http.MethodPost,
+			path:         "/admin/coupons",
+			body:         `{"code":"SPRING25","percent_off":25,"duration":"once","product_ids":[8]}`,
+			token:        adminToken,
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"message":"Product not found"}`,
+		},
+		{name: "invalid body", method: http.MethodPost, path: "/admin/coupons", body: `{"percent_off":25}`, token: adminToken, expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid request body"}`},
+		{name: "archive coupon", method: http.MethodDelete, path: "/admin/coupons/4", token: adminToken, expectedCode: http.StatusOK, expectedBody: `{"message":"Coupon archived successfully"}`},
+		{name: "archive unknown coupon", method: http.MethodDelete, path: "/admin/coupons/9", token: adminToken, expectedCode: http.StatusNotFound, expectedBody: `{"message":"Coupon not found"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			}
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedBody != "" {
+				require.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+			for _, s := range tc.contains {
+				require.Contains(t, w.Body.String(), s)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type CreditController struct {
+	svc service.CreditService
+}
+
+func NewCreditController(creditService *service.CreditService) *CreditController {
+	controller := &CreditController{
+		svc: *creditService,
+	}
+
+	return controller
+}
+
+// @Summary List credit notes
+// @Description List the authenticated user's credit notes, newest first, using cursor-based pagination
+// @Tags Credit notes
+// @Produce json
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.CreditNoteListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /credit-notes [get]
+// @Security ApiKeyAuth
+func (c *CreditController) ListCreditNotes(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	c.listCreditNotes(ctx, userID)
+}
+
+// @Summary List a user's credit notes
+// @Description List any user's credit notes, newest first. Requires the billing:read:any permission.
+// @Tags Credit notes
+// @Produce json
+// @Param id path string true "User ID"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.CreditNoteListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/users/{id}/credit-notes [get]
+// @Security ApiKeyAuth
+func (c *CreditController) ListUserCreditNotes(ctx *gin.Context) {
+	var uri dto.UserRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	c.listCreditNotes(ctx, uri.ID)
+}
+
+func (c *CreditController) listCreditNotes(ctx *gin.Context, userID uint) {
+	var req dto.CreditListRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid query parameters"})
+		return
+	}
+
+	page, err := c.svc.ListCreditNotes(ctx, userID, req.Cursor, req.Limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list credit notes"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToCreditNoteListResponse(page.CreditNotes, page.NextCursor))
+}
+
+// @Summary Get a credit note
+// @Description Fetch one of the authenticated user's credit notes
+// @Tags Credit notes
+// @Produce json
+// @Param id path string true "Credit note ID"
+// @Success 200 {object} dto.CreditNoteResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /credit-notes/{id} [get]
+// @Security ApiKeyAuth
+func (c *CreditController) GetCreditNote(ctx *gin.Context) {
+	var uri dto.CreditNoteRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid credit note ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	note, err := c.svc.GetCreditNote(ctx, uri.ID, userID)
+	if err != nil {
+		if errors.Is(err, service.ErrCreditNoteNotFound) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Credit note not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to fetch credit note"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToCreditNoteResponse(note))
+}
+
+// @Summary Get my balance
+// @Description Get the authenticated user's credit balance in every currency they hold money in. The balance is spent on the next charges in that currency.
+// @Tags Balance
+// @Produce json
+// @Success 200 {object} dto.BalanceListResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /me/balance [get]
+// @Security ApiKeyAuth
+func (c *CreditController) GetMyBalance(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	c.getBalance(ctx, userID)
+}
+
+// @Summary Get a user's balance
+// @Description Get any user's credit balance. Requires the billing:read:any permission.
+// @Tags Balance
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} dto.BalanceListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/users/{id}/balance [get]
+// @Security ApiKeyAuth
+func (c *CreditController) GetUserBalance(ctx *gin.Context) {
+	var uri dto.UserRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	c.getBalance(ctx, uri.ID)
+}
+
+func (c *CreditController) getBalance(ctx *gin.Context, userID uint) {
+	balances, err := c.svc.Balances(ctx, userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to fetch balance"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToBalanceListResponse(balances))
+}
+
+// @Summary List my balance transactions
+// @Description List every change of the authenticated user's balance, newest first, using cursor-based pagination
+// @Tags Balance
+// @Produce json
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.BalanceTransactionListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /me/balance/transactions [get]
+// @Security ApiKeyAuth
+func (c *CreditController) ListMyBalanceTransactions(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	c.listTransactions(ctx, userID)
+}
+
+// @Summary List a user's balance transactions
+// @Description List every change of any user's balance, newest first. Requires the billing:read:any permission.
+// @Tags Balance
+// @Produce json
+// @Param id path string true "User ID"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.BalanceTransactionListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/users/{id}/balance/transactions [get]
+// @Security ApiKeyAuth
+func (c *CreditController) ListUserBalanceTransactions(ctx *gin.Context) {
+	var uri dto.UserRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	c.listTransactions(ctx, uri.ID)
+}
+
+func (c *CreditController) listTransactions(ctx *gin.Context, userID uint) {
+	var req dto.CreditListRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid query parameters"})
+		return
+	}
+
+	page, err := c.svc.ListTransactions(ctx, userID, req.Cursor, req.Limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list balance transactions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToBalanceTransactionListResponse(page.Transactions, page.NextCursor))
+}
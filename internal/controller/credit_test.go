@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/middleware"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/service"
+	"gorm.io/gorm"
+)
+
+func TestCreditController(t *testing.T) {
+	router := gin.Default()
+
+	mockCreditRepo := new(mock.MockCreditRepo)
+	creditService := service.NewCreditService(mockCreditRepo, new(mock.MockInvoiceRepo), service.DefaultInvoicePolicy())
+	creditController := NewCreditController(&creditService)
+
+	router.GET("/credit-notes", authMiddleware(t), creditController.ListCreditNotes)
+	router.GET("/credit-notes/:id", authMiddleware(t), creditController.GetCreditNote)
+	router.GET("/me/balance", authMiddleware(t), creditController.GetMyBalance)
+	router.GET("/me/balance/transactions", authMiddleware(t), creditController.ListMyBalanceTransactions)
+	admin := router.Group("/admin/users", authMiddleware(t), middleware.RequirePermission(auth.BillingReadAny))
+	admin.GET("/:id/credit-notes", creditController.ListUserCreditNotes)
+	admin.GET("/:id/balance", creditController.GetUserBalance)
+	admin.GET("/:id/balance/transactions", creditController.ListUserBalanceTransactions)
+
+	issuedAt := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	note := model.CreditNote{
+		Model:          gorm.Model{ID: 2},
+		Number:         "CN-2025-000002",
+		InvoiceID:      3,
+		InvoiceNumber:  "INV-2025-000003",
+		UserID:         1,
+		SubscriptionID: 1,
+		PaymentID:      7,
+		Method:         model.CreditBalance,
+		Currency:       "EUR",
+		Reason:         "credit for plan change",
+		NetCent:        1000,
+		TaxCent:        210,
+		TotalCent:      1210,
+		IssuedAt:       issuedAt,
+	}
+	noteID := note.ID
+	mockCreditRepo.On("GetNote", mocklib.Anything, uint(2)).Return(&note, nil)
+	mockCreditRepo.On("GetNote", mocklib.Anything, uint(9)).Return((*model.CreditNote)(nil), gorm.ErrRecordNotFound)
+	mockCreditRepo.On("ListNotes", mocklib.Anything, repo.CreditFilter{UserID: 1, Limit: 21}).Return([]model.CreditNote{note}, nil)
+	mockCreditRepo.On("ListBalances", mocklib.Anything, uint(1)).Return([]model.CustomerBalance{
+		{UserID: 1, Currency: "EUR", BalanceCent: 710, UpdatedAt: issuedAt},
+	}, nil)
+	mockCreditRepo.On("ListTransactions", mocklib.Anything, repo.CreditFilter{UserID: 1, Limit: 21}).Return([]model.BalanceTransaction{
+		{ID: 5, UserID: 1, Currency: "EUR", Kind: model.BalanceSpent, AmountCent: -500, BalanceCent: 710, CreatedAt: issuedAt},
+		{ID: 4, UserID: 1, Currency: "EUR", Kind: model.BalanceCredited, AmountCent: 1210, BalanceCent: 1210, CreditNoteID: &noteID, CreatedAt: issuedAt},
+	}, nil)
+
+	testCases := []struct {
+		name         string
+		path         string
+		token        string
+		expectedCode int
+		expectedBody string
+		contains     []string
+	}{
+		{
+			name:         "get credit note",
+			path:         "/credit-notes/2",
+			token:        bearerToken(t, 1),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":2,"number":"CN-2025-000002","invoice_id":3,"invoice_number":"INV-2025-000003","subscription_id":1,"payment_id":7,"method":"balance","currency":"EUR","reason":"credit for plan change","net_cent":1000,"tax_cent":210,"total_cent":1210,"issued_at":"2025-03-10T12:00:00Z"}`,
+		},
+		{name: "another user's credit note", path: "/credit-notes/2", token: bearerToken(t, 2), expectedCode: http.StatusNotFound, expectedBody: `{"message":"Credit note not found"}`},
+		{name: "unknown credit note", path: "/credit-notes/9", token: bearerToken(t, 1), expectedCode: http.StatusNotFound, expectedBody: `{"message":"Credit note not found"}`},
+		{name: "invalid credit note ID", path: "/credit-notes/abc", token: bearerToken(t, 1), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid credit note ID"}`},
+		{name: "list credit notes", path: "/credit-notes", token: bearerToken(t, 1), expectedCode: http.StatusOK, contains: []string{`"credit_notes":[{"id":2,"number":"CN-2025-000002"`}},
+		{name: "list with invalid cursor", path: "/credit-notes?cursor=abc", token: bearerToken(t, 1), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid cursor"}`},
+		{name: "without token", path: "/me/balance", expectedCode: http.StatusUnauthorized},
+		{
+			name:         "my balance",
+			path:         "/me/balance",
+			token:        bearerToken(t, 1),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"balances":[{"currency":"EUR","balance_cent":710,"updated_at":"2025-03-10T12:00:00Z"}]}`,
+		},
+		{
+			name:         "my balance transactions",
+			path:         "/me/balance/transactions",
+			token:        bearerToken(t, 1),
+			expectedCode: http.StatusOK,
+			contains: []string{
+				`{"id":5,"kind":"payment","currency":"EUR","amount_cent":-500,"balance_cent":710,"created_at":"2025-03-10T12:00:00Z"}`,
+				`{"id":4,"kind":"credit","currency":"EUR","amount_cent":1210,"balance_cent":1210,"credit_note_id":2,"created_at":"2025-03-10T12:00:00Z"}`,
+			},
+		},
+		{name: "customers can't look up other users", path: "/admin/users/1/balance", token: roleToken(t, 2, model.RoleCustomer), expectedCode: http.StatusForbidden},
+		{name: "support looks up a balance", path: "/admin/users/1/balance", token: roleToken(t, 9, model.RoleSupport), expectedCode: http.StatusOK, contains: []string{`"balance_cent":710`}},
+		{name: "support lists credit notes", path: "/admin/users/1/credit-notes", token: roleToken(t, 9, model.RoleSupport), expectedCode: http.StatusOK, contains: []string{`"number":"CN-2025-000002"`}},
+		{name: "admin lists balance transactions", path: "/admin/users/1/balance/transactions", token: roleToken(t, 9, model.RoleAdmin), expectedCode: http.StatusOK, contains: []string{`"kind":"credit"`}},
+		{name: "invalid user ID", path: "/admin/users/abc/balance", token: roleToken(t, 9, model.RoleAdmin), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid user ID"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			}
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedBody != "" {
+				require.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+			for _, s := range tc.contains {
+				require.Contains(t, w.Body.String(), s)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type InvoiceController struct {
+	svc service.InvoiceService
+}
+
+func NewInvoiceController(invoiceService *service.InvoiceService) *InvoiceController {
+	controller := &InvoiceController{
+		svc: *invoiceService,
+	}
+
+	return controller
+}
+
+// @Summary List invoices
+// @Description List the authenticated user's invoices, newest first, using cursor-based pagination. Lines are left out; fetch an invoice for them.
+// @Tags Invoices
+// @Produce json
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.InvoiceListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /invoices [get]
+// @Security ApiKeyAuth
+func (c *InvoiceController) ListInvoices(ctx *gin.Context) {
+	var req dto.InvoiceListRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid query parameters"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	page, err := c.svc.List(ctx, userID, req.Cursor, req.Limit)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list invoices"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToInvoiceListResponse(page.Invoices, page.NextCursor))
+}
+
+// @Summary Get an invoice
+// @Description Fetch one of the authenticated user's invoices with its lines and tax breakdown
+// @Tags Invoices
+// @Produce json
+// @Param id path string true "Invoice ID"
+// @Success 200 {object} dto.InvoiceResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /invoices/{id} [get]
+// @Security ApiKeyAuth
+func (c *InvoiceController) GetInvoice(ctx *gin.Context) {
+	var uri dto.InvoiceRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid invoice ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	invoice, err := c.svc.Get(ctx, uri.ID, userID)
+	if err != nil {
+		if errors.Is(err, service.ErrInvoiceNotFound) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Invoice not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to fetch invoice"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToInvoiceResponse(invoice))
+}
+
+// @Summary Download an invoice as PDF
+// @Description Render one of the authenticated user's invoices to a PDF document with the company branding
+// @Tags Invoices
+// @Produce application/pdf
+// @Param id path string true "Invoice ID"
+// @Success 200 {file} file
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /invoices/{id}/pdf [get]
+// @Security ApiKeyAuth
+func (c *InvoiceController) GetInvoicePDF(ctx *gin.Context) {
+	var uri dto.InvoiceRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid invoice ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	invoice, err := c.svc.Get(ctx, uri.ID, userID)
+	if err != nil {
+		if errors.Is(err, service.ErrInvoiceNotFound) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Invoice not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to fetch invoice"})
+		return
+	}
+
+	// render fully before writing, so a failure can still be a JSON error
+	var buf bytes.Buffer
+	if err := c.svc.RenderPDF(invoice, &buf); err != nil {
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to render invoice"})
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+	ctx.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/service"
+	"gorm.io/gorm"
+)
+
+func TestInvoiceController(t *testing.T) {
+	router := gin.Default()
+
+	mockInvoiceRepo := new(mock.MockInvoiceRepo)
+	invoiceService := service.NewInvoiceService(mockInvoiceRepo, nil, nil, service.DefaultInvoicePolicy())
+	invoiceController := NewInvoiceController(&invoiceService)
+
+	router.Use(authMiddleware(t))
+	router.GET("/invoices", invoiceController.ListInvoices)
+	router.GET("/invoices/:id", invoiceController.GetInvoice)
+	router.GET("/invoices/:id/pdf", invoiceController.GetInvoicePDF)
+
+	issuedAt := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	invoice := &model.Invoice{
+		Model:          gorm.Model{ID: 3},
+		Number:         "INV-2025-000003",
+		UserID:         1,
+		SubscriptionID: 1,
+		PaymentID:      7,
+		Currency:       "EUR",
+		SellerName:     "Subserv",
+		SellerCountry:  "DE",
+		BuyerName:      "Alice",
+		BuyerEmail:     "alice@d.com",
+		BuyerCountry:   "NL",
+		BuyerTaxID:     "NL123456789B01",
+		TaxCountry:     "NL",
+		TaxReason:      "reverse_charge",
+		NetCent:        3500,
+		TotalCent:      3500,
+		IssuedAt:       issuedAt,
+		Lines: []model.InvoiceLine{
+			{Position: 1, Description: "Pro Plan", ProductID: 2, NetCent: 5000, TotalCent: 5000},
+			{Position: 2, Description: "Unused time on Basic Plan", ProductID: 1, NetCent: -1500, TotalCent: -1500},
+		},
+	}
+	mockInvoiceRepo.On("GetByID", mocklib.Anything, uint(3)).Return(invoice, nil)
+	mockInvoiceRepo.On("GetByID", mocklib.Anything, uint(9)).Return((*model.Invoice)(nil), gorm.ErrRecordNotFound)
+	mockInvoiceRepo.On("List", mocklib.Anything, repo.InvoiceFilter{UserID: 1, Limit: 21}).Return([]model.Invoice{
+		{Model: gorm.Model{ID: 3}, Number: "INV-2025-000003", UserID: 1, Currency: "EUR", TotalCent: 3500, TaxCent: 0},
+	}, nil)
+
+	testCases := []struct {
+		name         string
+		path         string
+		token        string
+		expectedCode int
+		expectedBody string
+		contains     []string
+	}{
+		{
+			name:         "get invoice",
+			path:         "/invoices/3",
+			token:        bearerToken(t, 1),
+			expectedCode: http.StatusOK,
+			contains: []string{
+				`"number":"INV-2025-000003"`,
+				`"seller":{"name":"Subserv","country":"DE"}`,
+				`"buyer":{"name":"Alice","email":"alice@d.com","country":"NL","tax_id":"NL123456789B01"}`,
+				`{"description":"Unused time on Basic Plan","product_id":1,"net_cent":-1500,"tax_rate":0,"tax_cent":0,"total_cent":-1500}`,
+				`"taxes":[{"tax_rate":0,"net_cent":3500,"tax_cent":0}]`,
+				`"tax_note":"Reverse charge: tax to be accounted for by the customer"`,
+			},
+		},
+		{name: "another user's invoice", path: "/invoices/3", token: bearerToken(t, 2), expectedCode: http.StatusNotFound, expectedBody: `{"message":"Invoice not found"}`},
+		{name: "unknown invoice", path: "/invoices/9", token: bearerToken(t, 1), expectedCode: http.StatusNotFound, expectedBody: `{"message":"Invoice not found"}`},
+		{name: "invalid invoice ID", path: "/invoices/abc", token: bearerToken(t, 1), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid invoice ID"}`},
+		{
+			name:         "list invoices",
+			path:         "/invoices",
+			token:        bearerToken(t, 1),
+			expectedCode: http.StatusOK,
+			expectedBody: `{"invoices":[{"id":3,"number":"INV-2025-000003","subscription_id":0,"payment_id":0,"currency":"EUR","seller":{"name":""},"buyer":{"name":""},"net_cent":0,"tax_cent":0,"total_cent":3500,"issued_at":"0001-01-01T00:00:00Z"}]}`,
+		},
+		{name: "list with invalid cursor", path: "/invoices?cursor=abc", token: bearerToken(t, 1), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid cursor"}`},
+		{name: "without token", path: "/invoices", expectedCode: http.StatusUnauthorized},
+		{name: "download pdf", path: "/invoices/3/pdf", token: bearerToken(t, 1), expectedCode: http.StatusOK, contains: []string{"%PDF-", "/Title (Invoice INV-2025-000003)"}},
+		{name: "another user's pdf", path: "/invoices/3/pdf", token: bearerToken(t, 2), expectedCode: http.StatusNotFound, expectedBody: `{"message":"Invoice not found"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", tc.token)
+			}
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedBody != "" {
+				require.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+			for _, s := range tc.contains {
+				require.Contains(t, w.Body.String(), s)
+			}
+		})
+	}
+}
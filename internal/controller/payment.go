@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type PaymentController struct {
+	refunds service.RefundService
+}
+
+func NewPaymentController(refundService *service.RefundService) *PaymentController {
+	controller := &PaymentController{
+		refunds: *refundService,
+	}
+
+	return controller
+}
+
+// @Summary Refund a payment
+// @Description Refund a succeeded payment. Send amount_cent for a partial refund, or policy full (the default, everything not refunded yet) or prorated (the unused share of the subscription's current period).
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Param request body dto.RefundRequest false "Refund"
+// @Success 201 {object} dto.RefundResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Failure 502 {object} dto.ErrorResponse
+// @Router /admin/payments/{id}/refunds [post]
+// @Security ApiKeyAuth
+func (c *PaymentController) RefundPayment(ctx *gin.Context) {
+	var uri dto.PaymentRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid payment ID"})
+		return
+	}
+
+	var req dto.RefundRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	params := service.RefundParams{
+		AmountCent: req.AmountCent,
+		Policy:     service.RefundPolicy(req.Policy),
+		Reason:     req.Reason,
+	}
+	if params.AmountCent == 0 && params.Policy == "" {
+		params.Policy = service.RefundFull
+	}
+
+	refund, err := c.refunds.Refund(ctx, uri.ID, params)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrPaymentNotFound):
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Payment not found"})
+		case errors.Is(err, service.ErrInvalidRefund):
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+		case errors.Is(err, service.ErrPaymentNotRefundable), errors.Is(err, service.ErrRefundExceedsPayment):
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error()})
+		case refund != nil:
+			ctx.JSON(http.StatusBadGateway, dto.ErrorResponse{Message: "Refund failed at the payment processor"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to refund payment"})
+		}
+		return
+	}
+	if refund.Status != model.PaymentSucceeded {
+		ctx.JSON(http.StatusBadGateway, dto.ErrorResponse{Message: "Refund was declined: " + refund.FailureReason})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.ToRefundResponse(refund))
+}
+
+// @Summary List refunds of a payment
+// @Description List every refund attempt of a payment, newest first
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Success 200 {object} dto.RefundListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/payments/{id}/refunds [get]
+// @Security ApiKeyAuth
+func (c *PaymentController) ListRefunds(ctx *gin.Context) {
+	var uri dto.PaymentRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid payment ID"})
+		return
+	}
+
+	refunds, err := c.refunds.ListRefunds(ctx, uri.ID)
+	if err != nil {
+		if errors.Is(err, service.ErrPaymentNotFound) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Payment not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list refunds"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToRefundListResponse(refunds))
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/middleware"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/service"
+	"gorm.io/gorm"
+)
+
+func TestRefundPayment(t *testing.T) {
+	router := gin.Default()
+
+	mockSubscriptionRepo := new(mock.MockSubscriptionRepo)
+	mockPaymentRepo := new(mock.MockPaymentRepo)
+	mockCreditRepo := new(mock.MockCreditRepo)
+	mockInvoiceRepo := new(mock.MockInvoiceRepo)
+	creditService := service.NewCreditService(mockCreditRepo, mockInvoiceRepo, service.DefaultInvoicePolicy())
+	refundService := service.NewRefundService(mockSubscriptionRepo, mockPaymentRepo, creditService, approvingPaymentProcessor{}, money.DefaultTaxPolicy)
+	paymentController := NewPaymentController(&refundService)
+
+	admin := router.Group("/admin/payments", authMiddleware(t), middleware.RequirePermission(auth.PaymentsRefund))
+	admin.GET("/:id/refunds", paymentController.ListRefunds)
+	admin.POST("/:id/refunds", paymentController.RefundPayment)
+
+	payment := func() *model.Payment {
+		return &model.Payment{
+			Model:          gorm.Model{ID: 7},
+			SubscriptionID: 1,
+			UserID:         1,
+			AmountCent:     1100,
+			TaxCent:        100,
+			Currency:       model.DefaultCurrency,
+			TxID:           "tx-1",
+			Status:         model.PaymentSucceeded,
+			RefundedCent:   100,
+		}
+	}
+
+	t.Run("customers can't refund", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/payments/7/refunds", nil)
+		req.Header.Set("Authorization", roleToken(t, 1, model.RoleCustomer))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("full refund without a body", func(t *testing.T) {
+		mockPaymentRepo.On("GetByID", mocklib.Anything, uint(7)).Return(payment(), nil).Once()
+		mockPaymentRepo.On("ReserveRefund", mocklib.Anything, uint(7), 1000).Return(true, nil).Once()
+		mockPaymentRepo.On("CreateRefund", mocklib.Anything, mocklib.Anything).Return(nil).Once()
+		mockPaymentRepo.On("SaveRefund", mocklib.Anything, mocklib.Anything).Return(nil).Once()
+		mockInvoiceRepo.On("GetByPayment", mocklib.Anything, uint(7)).Return(&model.Invoice{Model: gorm.Model{ID: 3}, Number: "INV-2025-000003"}, nil).Once()
+		mockCreditRepo.On("CreateNote", mocklib.Anything, mocklib.MatchedBy(func(note *model.CreditNote) bool {
+			return note.Method == model.CreditRefund && note.InvoiceID == 3 && note.TotalCent == 1000
+		}), (*model.BalanceTransaction)(nil)).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/payments/7/refunds", nil)
+		req.Header.Set("Authorization", roleToken(t, 9, model.RoleAdmin))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"amount_cent":1000`)
+		require.Contains(t, w.Body.String(), `"status":"succeeded"`)
+		mockPaymentRepo.AssertExpectations(t)
+		mockCreditRepo.AssertExpectations(t)
+	})
+
+	t.Run("partial refund above what is left", func(t *testing.T) {
+		mockPaymentRepo.On("GetByID", mocklib.Anything, uint(7)).Return(payment(), nil).Once()
+		mockPaymentRepo.On("ReserveRefund", mocklib.Anything, uint(7), 1050).Return(false, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/payments/7/refunds", strings.NewReader(`{"amount_cent":1050,"reason":"outage"}`))
+		req.Header.Set("Authorization", roleToken(t, 9, model.RoleAdmin))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusConflict, w.Code)
+		mockPaymentRepo.AssertExpectations(t)
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/payments/7/refunds", strings.NewReader(`{"policy":"double"}`))
+		req.Header.Set("Authorization", roleToken(t, 9, model.RoleAdmin))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("unknown payment", func(t *testing.T) {
+		mockPaymentRepo.On("GetByID", mocklib.Anything, uint(8)).Return((*model.Payment)(nil), gorm.ErrRecordNotFound).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/payments/8/refunds", nil)
+		req.Header.Set("Authorization", roleToken(t, 9, model.RoleAdmin))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.JSONEq(t, `{"message":"Payment not found"}`, w.Body.String())
+		mockPaymentRepo.AssertExpectations(t)
+	})
+}
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thatmatin/subserv/internal/dto"
@@ -71,3 +73,185 @@ func (c *ProductController) GetProductByID(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, dto.ToProductResponse(product))
 }
+
+// @Summary Create a product
+// @Description Add a product to the catalog. Duration is in seconds.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param request body dto.ProductBody true "Product"
+// @Success 201 {object} dto.ProductResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/products [post]
+// @Security ApiKeyAuth
+func (c *ProductController) CreateProduct(ctx *gin.Context) {
+	var req dto.ProductBody
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	product, err := c.svc.Create(ctx, productParams(req))
+	if err != nil {
+		c.productError(ctx, err, "Failed to create product")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.ToProductResponse(product))
+}
+
+// @Summary Replace a product
+// @Description Overwrite every field of a product. Existing subscriptions keep their price.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param request body dto.ProductBody true "Product"
+// @Success 200 {object} dto.ProductResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/products/{id} [put]
+// @Security ApiKeyAuth
+func (c *ProductController) ReplaceProduct(ctx *gin.Context) {
+	var uri dto.ProductRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid product ID"})
+		return
+	}
+
+	var req dto.ProductBody
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	product, err := c.svc.Replace(ctx, uri.ID, productParams(req))
+	if err != nil {
+		c.productError(ctx, err, "Failed to update product")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToProductResponse(product))
+}
+
+// @Summary Update a product
+// @Description Change only the given fields of a product. Existing subscriptions keep their price.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param request body dto.UpdateProductRequest true "Fields to change"
+// @Success 200 {object} dto.ProductResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/products/{id} [patch]
+// @Security ApiKeyAuth
+func (c *ProductController) UpdateProduct(ctx *gin.Context) {
+	var uri dto.ProductRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid product ID"})
+		return
+	}
+
+	var req dto.UpdateProductRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	patch := service.ProductPatch{
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       req.Price,
+		TaxRate:     req.TaxRate,
+		TaxCategory: req.TaxCategory,
+		TrialDays:   req.TrialDays,
+		Family:      req.Family,
+	}
+	if req.Prices != nil {
+		patch.Prices = priceMap(req.Prices)
+	}
+	if req.Duration != nil {
+		duration := time.Duration(*req.Duration) * time.Second
+		patch.Duration = &duration
+	}
+
+	product, err := c.svc.Update(ctx, uri.ID, patch)
+	if err != nil {
+		c.productError(ctx, err, "Failed to update product")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToProductResponse(product))
+}
+
+// @Summary Archive a product
+// @Description Remove a product from the catalog. Existing subscriptions keep working and renewing.
+// @Tags Admin
+// @Produce json
+// @Param id path string true "Product ID"
+// @Success 200 {object} dto.ProductMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/products/{id} [delete]
+// @Security ApiKeyAuth
+func (c *ProductController) ArchiveProduct(ctx *gin.Context) {
+	var uri dto.ProductRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid product ID"})
+		return
+	}
+
+	if err := c.svc.Archive(ctx, uri.ID); err != nil {
+		c.productError(ctx, err, "Failed to archive product")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ProductMessageResponse{Message: "Product archived successfully"})
+}
+
+func (c *ProductController) productError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrProductNotFound):
+		ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Product not found"})
+	case errors.Is(err, service.ErrInvalidProduct):
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: fallback})
+	}
+}
+
+func productParams(req dto.ProductBody) service.ProductParams {
+	return service.ProductParams{
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       req.Price,
+		Prices:      priceMap(req.Prices),
+		TaxRate:     req.TaxRate,
+		TaxCategory: req.TaxCategory,
+		Duration:    time.Duration(req.Duration) * time.Second,
+		TrialDays:   req.TrialDays,
+		Family:      req.Family,
+	}
+}
+
+// priceMap maps each currency to its amount.
+func priceMap(prices []dto.Price) map[string]int {
+	res := make(map[string]int, len(prices))
+	for _, price := range prices {
+		res[price.Currency] = price.Amount
+	}
+	return res
+}
@@ -3,11 +3,15 @@ package controller
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	mocklib "github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/middleware"
 	"github.com/thatmatin/subserv/internal/mock"
 	"github.com/thatmatin/subserv/internal/model"
 	"github.com/thatmatin/subserv/internal/service"
@@ -39,13 +43,99 @@ func TestProductController(t *testing.T) {
 	})
 
 	t.Run("get product by id", func(t *testing.T) {
-		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}}, nil)
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{
+			Model:  gorm.Model{ID: 1},
+			Price:  1000,
+			Prices: []model.ProductPrice{{Currency: "JPY", Amount: 1500}},
+		}, nil)
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"prices":[{"currency":"USD","amount":1000},{"currency":"JPY","amount":1500}]`)
 		mockProductRepo.AssertExpectations(t)
 	})
 }
+
+func TestAdminProductController(t *testing.T) {
+	cfg := auth.Config{Secret: "test-secret"}
+	verifier, err := auth.NewVerifier(cfg)
+	require.NoError(t, err)
+	adminToken, err := auth.IssueTokenWithRole(cfg, 1, model.RoleAdmin, time.Hour)
+	require.NoError(t, err)
+	supportToken, err := auth.IssueTokenWithRole(cfg, 3, model.RoleSupport, time.Hour)
+	require.NoError(t, err)
+	userToken, err := auth.IssueToken(cfg, 2, time.Hour)
+	require.NoError(t, err)
+
+	router := gin.Default()
+	mockProductRepo := new(mock.MockProductRepo)
+	productService := service.NewProductService(mockProductRepo)
+	productController := NewProductController(&productService)
+	admin := router.Group("/admin/products", middleware.AuthMiddleware(verifier), middleware.RequirePermission(auth.ProductsWrite))
+	admin.POST("", productController.CreateProduct)
+	admin.PUT("/:id", productController.ReplaceProduct)
+	admin.PATCH("/:id", productController.UpdateProduct)
+	admin.DELETE("/:id", productController.ArchiveProduct)
+
+	mockProductRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(p *model.Product) bool {
+		return p.Name == "flowmotion" && p.Duration == 2592000
+	})).Return(nil)
+	mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "flowmotion", Price: 1000, Duration: 2592000}, nil)
+	mockProductRepo.On("GetByID", mocklib.Anything, uint(9)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+	mockProductRepo.On("Save", mocklib.Anything, mocklib.AnythingOfType("*model.Product")).Return(nil)
+	mockProductRepo.On("Archive", mocklib.Anything, uint(1)).Return(true, nil)
+	mockProductRepo.On("Archive", mocklib.Anything, uint(9)).Return(false, nil)
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "create", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"tax_rate":9,"duration":2592000}`, token: adminToken, expectedCode: http.StatusCreated},
+		{name: "create as customer", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"duration":2592000}`, token: userToken, expectedCode: http.StatusForbidden, expectedBody: `{"message":"insufficient permissions"}`},
+		{name: "create as support", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"duration":2592000}`, token: supportToken, expectedCode: http.StatusForbidden, expectedBody: `{"message":"insufficient permissions"}`},
+		{name: "create without token", method: http.MethodPost, path: "/admin/products", body: `{}`, expectedCode: http.StatusUnauthorized},
+		{name: "create with negative price", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":-5,"duration":2592000}`, token: adminToken, expectedCode: http.StatusBadRequest},
+		{name: "create with tax rate over 100", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"tax_rate":150,"duration":2592000}`, token: adminToken, expectedCode: http.StatusBadRequest},
+		{name: "create with prices", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"prices":[{"currency":"JPY","amount":1500},{"currency":"EUR","amount":900}],"duration":2592000}`, token: adminToken, expectedCode: http.StatusCreated,
+			expectedBody: `{"id":0,"name":"flowmotion","description":"","price":1000,"prices":[{"currency":"USD","amount":1000},{"currency":"EUR","amount":900},{"currency":"JPY","amount":1500}],"tax_rate":0,"tax_category":"standard","duration":2592000,"trial_days":0}`},
+		{name: "create with a currency twice", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"prices":[{"currency":"EUR","amount":900},{"currency":"EUR","amount":800}],"duration":2592000}`, token: adminToken, expectedCode: http.StatusBadRequest},
+		{name: "create with an invalid currency", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"prices":[{"currency":"eur","amount":900}],"duration":2592000}`, token: adminToken, expectedCode: http.StatusBadRequest,
+			expectedBody: `{"message":"invalid product: \"eur\" is not an ISO 4217 currency code"}`},
+		{name: "replace", method: http.MethodPut, path: "/admin/products/1", body: `{"name":"flowmotion pro","price":2000,"duration":2592000}`, token: adminToken, expectedCode: http.StatusOK},
+		{name: "replace missing product", method: http.MethodPut, path: "/admin/products/9", body: `{"name":"flowmotion pro","price":2000,"duration":2592000}`, token: adminToken, expectedCode: http.StatusNotFound, expectedBody: `{"message":"Product not found"}`},
+		{name: "patch price", method: http.MethodPatch, path: "/admin/products/1", body: `{"price":1500}`, token: adminToken, expectedCode: http.StatusOK},
+		{name: "patch prices", method: http.MethodPatch, path: "/admin/products/1", body: `{"prices":[{"currency":"GBP","amount":800}]}`, token: adminToken, expectedCode: http.StatusOK},
+		{name: "patch trial", method: http.MethodPatch, path: "/admin/products/1", body: `{"trial_days":14,"family":"flowmotion"}`, token: adminToken, expectedCode: http.StatusOK},
+		{name: "create with a trial over a year", method: http.MethodPost, path: "/admin/products", body: `{"name":"flowmotion","price":1000,"trial_days":400,"duration":2592000}`, token: adminToken, expectedCode: http.StatusBadRequest},
+		{name: "patch with zero duration", method: http.MethodPatch, path: "/admin/products/1", body: `{"duration":0}`, token: adminToken, expectedCode: http.StatusBadRequest},
+		{name: "archive", method: http.MethodDelete, path: "/admin/products/1", token: adminToken, expectedCode: http.StatusOK, expectedBody: `{"message":"Product archived successfully"}`},
+		{name: "archive missing product", method: http.MethodDelete, path: "/admin/products/9", token: adminToken, expectedCode: http.StatusNotFound, expectedBody: `{"message":"Product not found"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+			if tc.expectedBody != "" {
+				require.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+
+	mockProductRepo.AssertNumberOfCalls(t, "Create", 2)
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type QuoteController struct {
+	svc service.QuoteService
+}
+
+func NewQuoteController(quoteService *service.QuoteService) *QuoteController {
+	controller := &QuoteController{
+		svc: *quoteService,
+	}
+
+	return controller
+}
+
+// @Summary Quote the price of a subscription
+// @Description Preview the first charge of a subscription to a product: net price, discount, tax and total, and the period it covers, in the given currency or the user's billing currency. Tax is quoted at the user's billing address; a country or region that differs from it is rejected. Create the subscription with the returned id as quote_id before expires_at to be charged the quoted total.
+// @Tags Subscriptions
+// @Accept json
+// @Produce json
+// @Param request body dto.QuoteRequest true "Quote request"
+// @Success 201 {object} dto.QuoteResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /quotes [post]
+// @Security ApiKeyAuth
+func (c *QuoteController) CreateQuote(ctx *gin.Context) {
+	var req dto.QuoteRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	quote, err := c.svc.Create(ctx, userID, service.QuoteParams{
+		ProductID:  req.ProductID,
+		CouponCode: req.CouponCode,
+		Country:    req.Country,
+		Region:     req.Region,
+		Currency:   req.Currency,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrProductNotFound):
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Product not found"})
+		case errors.Is(err, service.ErrInvalidQuote), errors.Is(err, service.ErrPriceNotAvailable),
+			errors.Is(err, service.ErrInvalidPromoCode):
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+		default:
+			ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to create quote"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.ToQuoteResponse(quote))
+}
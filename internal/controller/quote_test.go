@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/service"
+	"github.com/thatmatin/subserv/internal/tax"
+	"gorm.io/gorm"
+)
+
+func TestQuoteController(t *testing.T) {
+	router := gin.Default()
+
+	mockQuoteRepo := new(mock.MockQuoteRepo)
+	mockProductRepo := new(mock.MockProductRepo)
+	mockSubscriptionRepo := new(mock.MockSubscriptionRepo)
+	mockUserRepo := new(mock.MockUserRepo)
+	productService := service.NewProductService(mockProductRepo)
+	userService := service.NewUserService(mockUserRepo)
+	taxService := service.NewTaxService(&tax.Rules{
+		SellerCountry: "DE",
+		Countries: map[string]tax.Jurisdiction{
+			"NL": {Rates: map[tax.Category]money.Rate{tax.Standard: 2100}, ReverseCharge: true},
+		},
+	})
+	quoteService := service.NewQuoteService(mockQuoteRepo, productService, userService, taxService, nil, service.DefaultQuotePolicy())
+	subscriptionService := service.NewSubscriptionService(mockSubscriptionRepo, new(mock.MockPaymentRepo), productService, userService, approvingPaymentProcessor{}, quoteService, taxService, nil, nil, nil, money.DefaultTaxPolicy)
+	quoteController := NewQuoteController(&quoteService)
+	subscriptionController := NewSubscriptionController(&subscriptionService)
+
+	router.Use(authMiddleware(t))
+	router.POST("/quotes", quoteController.CreateQuote)
+	router.POST("/subscriptions", subscriptionController.CreateSubscription)
+
+	t.Run("quote a product", func(t *testing.T) {
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Basic Plan", Price: 1000, Duration: 3600}, nil).Once()
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, BillingCountry: "NL"}, nil).Once()
+		mockQuoteRepo.On("Create", mocklib.Anything, mocklib.Anything).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/quotes", strings.NewReader(`{"product_id":1,"country":"NL"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"lines":[{"type":"net","description":"Basic Plan","amount_cent":1000},{"type":"tax","description":"Tax 21% (NL)","amount_cent":210}]`)
+		require.Contains(t, w.Body.String(), `"country":"NL"`)
+		require.Contains(t, w.Body.String(), `"tax_rate":21,"tax_reason":"taxed"`)
+		require.Contains(t, w.Body.String(), `"expires_at"`)
+		mockQuoteRepo.AssertExpectations(t)
+		mockProductRepo.AssertExpectations(t)
+	})
+
+	t.Run("reverse charged quote", func(t *testing.T) {
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Basic Plan", Price: 1000, Duration: 3600}, nil).Once()
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, BillingCountry: "NL", TaxID: "NL123456789B01"}, nil).Once()
+		mockQuoteRepo.On("Create", mocklib.Anything, mocklib.Anything).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/quotes", strings.NewReader(`{"product_id":1,"country":"NL"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `{"type":"tax","description":"Reverse charge: tax to be accounted for by the customer","amount_cent":0}`)
+		require.Contains(t, w.Body.String(), `"tax_reason":"reverse_charge","tax_cent":0,"total_cent":1000`)
+	})
+
+	t.Run("quote in a currency the product isn't sold in", func(t *testing.T) {
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Basic Plan", Price: 1000, Duration: 3600}, nil).Once()
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/quotes", strings.NewReader(`{"product_id":1,"currency":"JPY"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"product has no price in this currency: JPY"}`, w.Body.String())
+	})
+
+	t.Run("quote for another country than the billing country", func(t *testing.T) {
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Basic Plan", Price: 1000, Duration: 3600}, nil).Once()
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, BillingCountry: "NL"}, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/quotes", strings.NewReader(`{"product_id":1,"country":"US"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"invalid quote: country must be the billing country \"NL\""}`, w.Body.String())
+	})
+
+	t.Run("subscribe with a quote", func(t *testing.T) {
+		now := time.Now()
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, BillingCountry: "NL"}, nil).Once()
+		mockQuoteRepo.On("GetByID", mocklib.Anything, uint(5)).Return(&model.Quote{
+			Model:       gorm.Model{ID: 5},
+			UserID:      1,
+			ProductID:   1,
+			NetCent:     1000,
+			Country:     "NL",
+			TaxRate:     2100,
+			TaxCategory: string(tax.Standard),
+			TaxReason:   string(tax.Taxed),
+			PeriodStart: now,
+			PeriodEnd:   now.Add(time.Hour),
+			ExpiresAt:   now.Add(time.Minute),
+		}, nil).Once()
+		mockQuoteRepo.On("Use", mocklib.Anything, uint(5), mocklib.Anything).Return(true, nil).Once()
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Price: 1200}, nil).Once()
+		mockSubscriptionRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(sub *model.Subscription) bool { return sub.PriceCent == 1000 })).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(`{"quote_id":5}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"price_cent":1000`)
+		mockQuoteRepo.AssertExpectations(t)
+		mockSubscriptionRepo.AssertExpectations(t)
+	})
+
+	t.Run("subscribe with an expired quote", func(t *testing.T) {
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil).Once()
+		mockQuoteRepo.On("GetByID", mocklib.Anything, uint(6)).Return(&model.Quote{
+			Model:     gorm.Model{ID: 6},
+			UserID:    1,
+			ProductID: 1,
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}, nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(`{"quote_id":6}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.JSONEq(t, `{"message":"quote has expired"}`, w.Body.String())
+		mockQuoteRepo.AssertExpectations(t)
+	})
+}
@@ -2,10 +2,12 @@ package controller
 
 import (
 	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/model"
 	"github.com/thatmatin/subserv/internal/service"
 )
 
@@ -21,6 +23,13 @@ func NewSubscriptionController(subService *service.SubscriptionService) *Subscri
 	return controller
 }
 
+// isSubscriptionNotFound reports whether err should be answered with 404.
+// Subscriptions of other users are reported as missing so their existence
+// is not disclosed.
+func isSubscriptionNotFound(err error) bool {
+	return errors.Is(err, service.ErrSubscriptionNotFound) || errors.Is(err, service.ErrUnauthorizedAccess)
+}
+
 // @Summary Get subscription
 // @Description Fetch subscription by ID for the authenticated user
 // @Tags Subscriptions
@@ -39,7 +48,183 @@ func (c *SubscriptionController) GetSubscriptionByID(ctx *gin.Context) {
 		return
 	}
 
-	subscription, err := c.svc.Get(ctx, uri.ID)
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	subscription, err := c.svc.Get(ctx, uri.ID, userID)
+	if err != nil {
+		if isSubscriptionNotFound(err) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to fetch subscription"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToSubscriptionResponse(subscription))
+}
+
+// @Summary List subscription payments
+// @Description List every charge attempt of the authenticated user's subscription, newest first
+// @Tags Subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} dto.PaymentListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /subscriptions/{id}/payments [get]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) ListPayments(ctx *gin.Context) {
+	var uri dto.SubscriptionRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid subscription ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	payments, err := c.svc.ListPayments(ctx, uri.ID, userID)
+	if err != nil {
+		if isSubscriptionNotFound(err) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list payments"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToPaymentListResponse(payments))
+}
+
+// @Summary List subscriptions
+// @Description List the authenticated user's subscriptions, newest first, using cursor-based pagination
+// @Tags Subscriptions
+// @Produce json
+// @Param state query string false "Filter by state" Enums(Pending, Active, Paused, Cancelled, Expired, Failed, PastDue, Trialing)
+// @Param product_id query int false "Filter by product ID"
+// @Param start_from query string false "Subscriptions starting at or after this time (RFC3339)"
+// @Param start_to query string false "Subscriptions starting before this time (RFC3339)"
+// @Param end_from query string false "Subscriptions ending at or after this time (RFC3339)"
+// @Param end_to query string false "Subscriptions ending before this time (RFC3339)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.SubscriptionListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /subscriptions [get]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) ListSubscriptions(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	c.listSubscriptions(ctx, userID)
+}
+
+// @Summary List a user's subscriptions
+// @Description List the subscriptions of any user, newest first. Requires the subscriptions:read:any permission.
+// @Tags Support
+// @Produce json
+// @Param id path string true "User ID"
+// @Param state query string false "Filter by state" Enums(Pending, Active, Paused, Cancelled, Expired, Failed, PastDue, Trialing)
+// @Param product_id query int false "Filter by product ID"
+// @Param start_from query string false "Subscriptions starting at or after this time (RFC3339)"
+// @Param start_to query string false "Subscriptions starting before this time (RFC3339)"
+// @Param end_from query string false "Subscriptions ending at or after this time (RFC3339)"
+// @Param end_to query string false "Subscriptions ending before this time (RFC3339)"
+// @Param cursor query string false "Cursor returned as next_cursor by the previous page"
+// @Param limit query int false "Page size (1-100, default 20)"
+// @Success 200 {object} dto.SubscriptionListResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/users/{id}/subscriptions [get]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) ListUserSubscriptions(ctx *gin.Context) {
+	var uri dto.UserRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	c.listSubscriptions(ctx, uri.ID)
+}
+
+func (c *SubscriptionController) listSubscriptions(ctx *gin.Context, userID uint) {
+	var req dto.ListSubscriptionsRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid query parameters"})
+		return
+	}
+
+	opts := service.SubscriptionListOptions{
+		ProductID: req.ProductID,
+		StartFrom: req.StartFrom,
+		StartTo:   req.StartTo,
+		EndFrom:   req.EndFrom,
+		EndTo:     req.EndTo,
+		Cursor:    req.Cursor,
+		Limit:     req.Limit,
+	}
+	if req.State != "" {
+		state, ok := model.ParseState(req.State)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid state"})
+			return
+		}
+		opts.State = &state
+	}
+
+	page, err := c.svc.List(ctx, userID, opts)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid cursor"})
+			return
+		}
+
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to list subscriptions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToSubscriptionListResponse(page.Subscriptions, page.NextCursor))
+}
+
+// @Summary Look up a subscription
+// @Description Fetch any user's subscription by ID. Requires the subscriptions:read:any permission.
+// @Tags Support
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} dto.SubscriptionResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/subscriptions/{id} [get]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) LookupSubscription(ctx *gin.Context) {
+	var uri dto.SubscriptionRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid subscription ID"})
+		return
+	}
+
+	subscription, err := c.svc.Lookup(ctx, uri.ID)
 	if err != nil {
 		if errors.Is(err, service.ErrSubscriptionNotFound) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
@@ -54,15 +239,18 @@ func (c *SubscriptionController) GetSubscriptionByID(ctx *gin.Context) {
 }
 
 // @Summary Create a new subscription
-// @Description Create a new subscription for the authenticated user
+// @Description Create a new subscription for the authenticated user, priced in the requested currency or the user's billing currency. If the product has a free trial the user hasn't had in its family, the subscription starts Trialing and is charged when the trial ends.
 // @Tags Subscriptions
 // @Accept json
 // @Produce json
-// @Param request body dto.CreateSubscriptionRequest true "Subscription creation request"
+// @Param request body dto.CreateSubscriptionRequest true "Subscription creation request, with a product_id or a quote_id from POST /quotes"
+// @Param Idempotency-Key header string false "Replays the original response when the request is retried with the same key"
 // @Success 201 {object} dto.SubscriptionResponse
 // @Failure 400 {object} dto.ErrorResponse
 // @failure 401 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 422 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /subscriptions [post]
 // @Security ApiKeyAuth
@@ -73,15 +261,19 @@ func (c *SubscriptionController) CreateSubscription(ctx *gin.Context) {
 		return
 	}
 
-	userIDVal, exists := ctx.Get("userID")
-	if !exists {
+	userID, ok := currentUserID(ctx)
+	if !ok {
 		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
 		return
 	}
 
-	userID := userIDVal.(uint)
-
-	subscription, err := c.svc.Create(ctx, req.ProductID, userID)
+	var subscription *model.Subscription
+	var err error
+	if req.QuoteID != 0 {
+		subscription, err = c.svc.CreateFromQuote(ctx, req.QuoteID, req.ProductID, userID, req.PromoCode)
+	} else {
+		subscription, err = c.svc.Create(ctx, req.ProductID, userID, req.Currency, req.PromoCode)
+	}
 	if err != nil {
 		if errors.Is(err, service.ErrProductNotFound) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Product not found"})
@@ -91,6 +283,24 @@ func (c *SubscriptionController) CreateSubscription(ctx *gin.Context) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "User not found"})
 			return
 		}
+		if errors.Is(err, service.ErrQuoteNotFound) {
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Quote not found"})
+			return
+		}
+		if errors.Is(err, service.ErrQuoteExpired) || errors.Is(err, service.ErrQuoteUsed) ||
+			errors.Is(err, service.ErrQuoteTaxChanged) {
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrTrialUsed) {
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Trial was started by another request, please retry"})
+			return
+		}
+		if errors.Is(err, service.ErrQuoteMismatch) || errors.Is(err, service.ErrPriceNotAvailable) ||
+			errors.Is(err, service.ErrInvalidPromoCode) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+			return
+		}
 
 		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to create subscription"})
 		return
@@ -104,11 +314,15 @@ func (c *SubscriptionController) CreateSubscription(ctx *gin.Context) {
 // @Tags Subscriptions
 // @Produce json
 // @Param id path string true "Subscription ID"
+// @Param Idempotency-Key header string false "Replays the original response when the request is retried with the same key"
 // @Success 200 {object} dto.SubscriptionMessageResponse
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 401 {object} dto.ErrorResponse
+// @Failure 402 {object} dto.ErrorResponse
 // @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 422 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /subscriptions/{id}/purchase [post]
 // @Security ApiKeyAuth
@@ -119,16 +333,37 @@ func (c *SubscriptionController) Purchase(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.svc.Purchase(ctx, uri.ID); err != nil {
-		if errors.Is(err, service.ErrSubscriptionNotFound) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	if err := c.svc.Purchase(ctx, uri.ID, userID, idempotencyKey(ctx)); err != nil {
+		if isSubscriptionNotFound(err) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
 			return
 		}
 
+		if errors.Is(err, service.ErrConcurrentUpdate) {
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Subscription was modified concurrently, please retry"})
+			return
+		}
+
 		if errors.Is(err, service.ErrNoPendingPayment) {
 			ctx.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "No pending payment for this subscription"})
 			return
 		}
+
+		if errors.Is(err, service.ErrFailedPayment) {
+			ctx.JSON(http.StatusPaymentRequired, dto.ErrorResponse{Message: "Payment failed"})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidPromoCode) {
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+			return
+		}
+
 		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to purchase subscription"})
 		return
 	}
@@ -136,6 +371,92 @@ func (c *SubscriptionController) Purchase(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, dto.SubscriptionMessageResponse{Message: "Subscription purchased successfully"})
 }
 
+// @Summary Change the plan of a subscription
+// @Description Move an active subscription to another product. With mode immediate (the default) the new plan starts a fresh period now: the unused share of the current period is credited, the difference is charged or refunded. With mode period_end the change applies when the subscription renews, which needs auto-renew; requesting the current product withdraws it.
+// @Tags Subscriptions
+// @Accept json
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Param Idempotency-Key header string false "Replays the original response when the request is retried with the same key"
+// @Param request body dto.ChangePlanRequest true "New plan"
+// @Success 200 {object} dto.ChangePlanResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 402 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 422 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /subscriptions/{id}/change-plan [post]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) ChangePlan(ctx *gin.Context) {
+	var uri dto.SubscriptionRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid subscription ID"})
+		return
+	}
+
+	var req dto.ChangePlanRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+	mode := service.ChangeImmediately
+	if req.Mode != "" {
+		mode = service.ChangeMode(req.Mode)
+	}
+	if !mode.Valid() {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid change mode"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	change, err := c.svc.ChangePlan(ctx, uri.ID, userID, req.ProductID, mode, idempotencyKey(ctx))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrProductNotFound):
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Product not found"})
+		case errors.Is(err, service.ErrSamePlan):
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Subscription is already on this plan"})
+		case errors.Is(err, service.ErrPriceNotAvailable):
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+		case errors.Is(err, service.ErrFailedPayment):
+			ctx.JSON(http.StatusPaymentRequired, dto.ErrorResponse{Message: "Payment failed"})
+		default:
+			c.stateChangeError(ctx, err, "Failed to change plan")
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toChangePlanResponse(change))
+}
+
+func toChangePlanResponse(change *service.PlanChange) dto.ChangePlanResponse {
+	res := dto.ChangePlanResponse{
+		Subscription:  dto.ToSubscriptionResponse(change.Subscription),
+		CreditCent:    change.CreditCent,
+		ChargeCent:    change.ChargeCent,
+		AmountDueCent: change.AmountDueCent,
+		EffectiveAt:   change.EffectiveAt,
+	}
+	if change.Payment != nil {
+		payment := dto.ToPaymentResponse(change.Payment)
+		res.Payment = &payment
+	}
+	if change.CreditNote != nil {
+		note := dto.ToCreditNoteResponse(change.CreditNote)
+		res.CreditNote = &note
+	}
+
+	return res
+}
+
 // @Summary Pause a subscription
 // @Description Pause a subscription by its ID
 // @Tags Subscriptions
@@ -146,6 +467,7 @@ func (c *SubscriptionController) Purchase(ctx *gin.Context) {
 // @Failure 401 {object} dto.ErrorResponse
 // @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /subscriptions/{id}/pause [patch]
 // @Security ApiKeyAuth
@@ -156,12 +478,23 @@ func (c *SubscriptionController) PauseSubscription(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.svc.Pause(ctx, uri.ID); err != nil {
-		if errors.Is(err, service.ErrSubscriptionNotFound) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	if err := c.svc.Pause(ctx, uri.ID, userID); err != nil {
+		if isSubscriptionNotFound(err) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
 			return
 		}
 
+		if errors.Is(err, service.ErrConcurrentUpdate) {
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Subscription was modified concurrently, please retry"})
+			return
+		}
+
 		if errors.Is(err, service.ErrInvalidState) {
 			ctx.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error()})
 			return
@@ -184,6 +517,7 @@ func (c *SubscriptionController) PauseSubscription(ctx *gin.Context) {
 // @Failure 401 {object} dto.ErrorResponse
 // @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /subscriptions/{id}/unpause [patch]
 // @Security ApiKeyAuth
@@ -194,12 +528,23 @@ func (c *SubscriptionController) UnpauseSubscription(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.svc.Unpause(ctx, uri.ID); err != nil {
-		if errors.Is(err, service.ErrSubscriptionNotFound) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	if err := c.svc.Unpause(ctx, uri.ID, userID); err != nil {
+		if isSubscriptionNotFound(err) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
 			return
 		}
 
+		if errors.Is(err, service.ErrConcurrentUpdate) {
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Subscription was modified concurrently, please retry"})
+			return
+		}
+
 		if errors.Is(err, service.ErrInvalidState) {
 			ctx.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error()})
 			return
@@ -213,15 +558,18 @@ func (c *SubscriptionController) UnpauseSubscription(ctx *gin.Context) {
 }
 
 // @Summary Cancel a subscription
-// @Description Cancel a subscription by its ID
+// @Description Cancel a subscription now (mode immediate, the default) or at the end of the paid period (mode period_end). A cancellation at period end keeps the subscription active until then and can be withdrawn with /uncancel.
 // @Tags Subscriptions
+// @Accept json
 // @Produce json
 // @Param id path string true "Subscription ID"
-// @Success 202 {object} dto.SubscriptionMessageResponse
+// @Param request body dto.CancelSubscriptionRequest false "Cancel mode"
+// @Success 202 {object} dto.CancelSubscriptionResponse
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 401 {object} dto.ErrorResponse
 // @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /subscriptions/{id}/cancel [patch]
 // @Security ApiKeyAuth
@@ -232,20 +580,161 @@ func (c *SubscriptionController) CancelSubscription(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.svc.Cancel(ctx, uri.ID); err != nil {
-		if errors.Is(err, service.ErrSubscriptionNotFound) {
+	var req dto.CancelSubscriptionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+	mode := service.CancelImmediately
+	if req.Mode != "" {
+		mode = service.CancelMode(req.Mode)
+	}
+	if !mode.Valid() {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid cancel mode"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	subscription, err := c.svc.Cancel(ctx, uri.ID, userID, mode)
+	if err != nil {
+		c.stateChangeError(ctx, err, "Failed to cancel subscription")
+		return
+	}
+
+	message := "Subscription cancelled successfully"
+	if mode == service.CancelAtPeriodEnd {
+		message = "Subscription will be cancelled at the end of the period"
+	}
+	ctx.JSON(http.StatusAccepted, dto.CancelSubscriptionResponse{Message: message, CancelAt: subscription.CancelAt})
+}
+
+// @Summary Withdraw a scheduled cancellation
+// @Description Keep a subscription that was cancelled at period end, before the cancellation takes effect
+// @Tags Subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 202 {object} dto.SubscriptionResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /subscriptions/{id}/uncancel [patch]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) UncancelSubscription(ctx *gin.Context) {
+	var uri dto.SubscriptionRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid subscription ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	subscription, err := c.svc.Uncancel(ctx, uri.ID, userID)
+	if err != nil {
+		c.stateChangeError(ctx, err, "Failed to withdraw cancellation")
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, dto.ToSubscriptionResponse(subscription))
+}
+
+// stateChangeError answers a failed state change of a subscription.
+func (c *SubscriptionController) stateChangeError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case isSubscriptionNotFound(err):
+		ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
+	case errors.Is(err, service.ErrConcurrentUpdate):
+		ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Subscription was modified concurrently, please retry"})
+	case errors.Is(err, service.ErrInvalidState):
+		ctx.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: fallback})
+	}
+}
+
+// @Summary Enable auto-renew
+// @Description Renew the subscription automatically at the end of each period
+// @Tags Subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 202 {object} dto.SubscriptionMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /subscriptions/{id}/auto-renew/enable [patch]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) EnableAutoRenew(ctx *gin.Context) {
+	c.setAutoRenew(ctx, true)
+}
+
+// @Summary Disable auto-renew
+// @Description Stop renewing the subscription at the end of the current period
+// @Tags Subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 202 {object} dto.SubscriptionMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /subscriptions/{id}/auto-renew/disable [patch]
+// @Security ApiKeyAuth
+func (c *SubscriptionController) DisableAutoRenew(ctx *gin.Context) {
+	c.setAutoRenew(ctx, false)
+}
+
+func (c *SubscriptionController) setAutoRenew(ctx *gin.Context, enabled bool) {
+	var uri dto.SubscriptionRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid subscription ID"})
+		return
+	}
+
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	if err := c.svc.SetAutoRenew(ctx, uri.ID, userID, enabled); err != nil {
+		if isSubscriptionNotFound(err) {
 			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "Subscription not found"})
 			return
 		}
 
+		if errors.Is(err, service.ErrConcurrentUpdate) {
+			ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Subscription was modified concurrently, please retry"})
+			return
+		}
+
 		if errors.Is(err, service.ErrInvalidState) {
 			ctx.JSON(http.StatusForbidden, dto.ErrorResponse{Message: err.Error()})
 			return
 		}
 
-		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to cancel subscription"})
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to update auto-renew"})
 		return
 	}
 
-	ctx.JSON(http.StatusAccepted, dto.SubscriptionMessageResponse{Message: "Subscription cancelled successfully"})
+	if enabled {
+		ctx.JSON(http.StatusAccepted, dto.SubscriptionMessageResponse{Message: "Auto-renew enabled successfully"})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, dto.SubscriptionMessageResponse{Message: "Auto-renew disabled successfully"})
 }
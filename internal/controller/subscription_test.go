@@ -10,33 +10,90 @@ import (
 	"github.com/gin-gonic/gin"
 	mocklib "github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
 	"github.com/thatmatin/subserv/internal/middleware"
 	"github.com/thatmatin/subserv/internal/mock"
 	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
 	"github.com/thatmatin/subserv/internal/service"
 	"gorm.io/gorm"
 )
 
 var fixedTime = time.Date(2020, time.May, 0, 0, 0, 0, 0, time.UTC)
 
+var testAuthConfig = auth.Config{Secret: "test-secret", Issuer: "subserv-test"}
+
+func bearerToken(t *testing.T, userID uint) string {
+	t.Helper()
+	token, err := auth.IssueToken(testAuthConfig, userID, time.Hour)
+	require.NoError(t, err)
+	return "Bearer " + token
+}
+
+func roleToken(t *testing.T, userID uint, role model.Role) string {
+	t.Helper()
+	token, err := auth.IssueTokenWithRole(testAuthConfig, userID, role, time.Hour)
+	require.NoError(t, err)
+	return "Bearer " + token
+}
+
+func authMiddleware(t *testing.T) gin.HandlerFunc {
+	t.Helper()
+	verifier, err := auth.NewVerifier(testAuthConfig)
+	require.NoError(t, err)
+	return middleware.AuthMiddleware(verifier)
+}
+
+// approvingPaymentProcessor accepts every charge and refund so purchase tests
+// are deterministic.
+type approvingPaymentProcessor struct{}
+
+func (approvingPaymentProcessor) Name() string {
+	return "approve"
+}
+
+func (approvingPaymentProcessor) Charge(req service.PaymentRequest) (*service.PaymentResult, error) {
+	return &service.PaymentResult{Success: true, TxID: "tx-1"}, nil
+}
+
+func (approvingPaymentProcessor) Refund(req service.RefundRequest) (*service.PaymentResult, error) {
+	return &service.PaymentResult{Success: true, TxID: "rf-1"}, nil
+}
+
 func TestSubscriptionController(t *testing.T) {
 	router := gin.Default()
 
 	mockSubscriptionRepo := new(mock.MockSubscriptionRepo)
+	mockPaymentRepo := new(mock.MockPaymentRepo)
 	mockUserRepo := new(mock.MockUserRepo)
-	paymentProcessor := service.NewDummyPaymentProcessor()
 	mockProductRepo := new(mock.MockProductRepo)
 	productService := service.NewProductService(mockProductRepo)
-	mockSubscriptionService := service.NewSubscriptionService(mockSubscriptionRepo, productService, mockUserRepo, paymentProcessor)
+	userService := service.NewUserService(mockUserRepo)
+	mockQuoteRepo := new(mock.MockQuoteRepo)
+	mockCouponRepo := new(mock.MockCouponRepo)
+	couponService := service.NewCouponService(mockCouponRepo, productService)
+	quoteService := service.NewQuoteService(mockQuoteRepo, productService, userService, service.NewTaxService(nil), couponService, service.DefaultQuotePolicy())
+	mockInvoiceRepo := new(mock.MockInvoiceRepo)
+	invoiceService := service.NewInvoiceService(mockInvoiceRepo, productService, userService, service.DefaultInvoicePolicy())
+	mockCreditRepo := new(mock.MockCreditRepo)
+	creditService := service.NewCreditService(mockCreditRepo, mockInvoiceRepo, service.DefaultInvoicePolicy())
+	mockSubscriptionService := service.NewSubscriptionService(mockSubscriptionRepo, mockPaymentRepo, productService, userService, approvingPaymentProcessor{}, quoteService, service.NewTaxService(nil), invoiceService, creditService, couponService, money.DefaultTaxPolicy)
 	subscriptionController := NewSubscriptionController(&mockSubscriptionService)
 
-	router.Use(middleware.AuthMiddleware())
+	router.Use(authMiddleware(t))
+	router.GET("/subscriptions", subscriptionController.ListSubscriptions)
 	router.GET("/subscriptions/:id", subscriptionController.GetSubscriptionByID)
+	router.GET("/subscriptions/:id/payments", subscriptionController.ListPayments)
 	router.POST("/subscriptions", subscriptionController.CreateSubscription)
 	router.POST("/subscriptions/:id/purchase", subscriptionController.Purchase)
 	router.PATCH("/subscriptions/:id/pause", subscriptionController.PauseSubscription)
 	router.PATCH("/subscriptions/:id/unpause", subscriptionController.UnpauseSubscription)
 	router.PATCH("/subscriptions/:id/cancel", subscriptionController.CancelSubscription)
+	router.PATCH("/subscriptions/:id/uncancel", subscriptionController.UncancelSubscription)
+	router.POST("/subscriptions/:id/change-plan", subscriptionController.ChangePlan)
+	router.PATCH("/subscriptions/:id/auto-renew/enable", subscriptionController.EnableAutoRenew)
+	router.PATCH("/subscriptions/:id/auto-renew/disable", subscriptionController.DisableAutoRenew)
 
 	t.Run("get subscription by id", func(t *testing.T) {
 		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
@@ -44,7 +101,7 @@ func TestSubscriptionController(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodGet, "/subscriptions/1", nil)
-		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Authorization", bearerToken(t, 1))
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusOK, w.Code)
@@ -54,16 +111,85 @@ func TestSubscriptionController(t *testing.T) {
 		mockSubscriptionRepo.ExpectedCalls = nil
 	})
 
+	t.Run("list subscriptions", func(t *testing.T) {
+		mockSubscriptionRepo.On("List", mocklib.Anything, mocklib.MatchedBy(func(f repo.SubscriptionFilter) bool {
+			return f.UserID == 1 && f.State != nil && *f.State == model.Active && f.ProductID == 2 && f.Limit == 2
+		})).Return([]model.Subscription{
+			{Model: gorm.Model{ID: 5}, UserID: 1, ProductID: 2, State: model.Active},
+			{Model: gorm.Model{ID: 3}, UserID: 1, ProductID: 2, State: model.Active},
+		}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/subscriptions?state=active&product_id=2&limit=1", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"subscriptions":[{"id":5,`)
+		require.Contains(t, w.Body.String(), `"next_cursor":"NQ"`)
+		mockSubscriptionRepo.AssertExpectations(t)
+
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("list subscriptions with unknown state", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/subscriptions?state=sleeping", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"Invalid state"}`, w.Body.String())
+	})
+
+	t.Run("get subscription of another user", func(t *testing.T) {
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/subscriptions/1", nil)
+		req.Header.Set("Authorization", bearerToken(t, 2))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.JSONEq(t, `{"message":"Subscription not found"}`, w.Body.String())
+		mockSubscriptionRepo.AssertExpectations(t)
+
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("state changes on another user's subscription", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Pending, Start: start, End: start.Add(time.Hour * 24)}, nil)
+
+		for _, route := range []struct{ method, path string }{
+			{http.MethodPost, "/subscriptions/1/purchase"},
+			{http.MethodPatch, "/subscriptions/1/pause"},
+			{http.MethodPatch, "/subscriptions/1/unpause"},
+			{http.MethodPatch, "/subscriptions/1/cancel"},
+		} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(route.method, route.path, nil)
+			req.Header.Set("Authorization", bearerToken(t, 2))
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusNotFound, w.Code, route.path)
+		}
+		mockSubscriptionRepo.AssertNotCalled(t, "Save", mocklib.Anything, mocklib.Anything)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
 	t.Run("create subscription", func(t *testing.T) {
 		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Test Product", Price: 1000, TaxRate: 20, Duration: 30}, nil)
-		mockUserRepo.On("Exists", mocklib.Anything, uint(1)).Return(true, nil)
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
 		mockSubscriptionRepo.On("Create", mocklib.Anything, mocklib.Anything).Return(nil)
 
 		w := httptest.NewRecorder()
 		jsonBody := `{"product_id": 1}`
 		req := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(jsonBody))
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Authorization", bearerToken(t, 1))
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusCreated, w.Code)
@@ -77,32 +203,170 @@ func TestSubscriptionController(t *testing.T) {
 		mockUserRepo.ExpectedCalls = nil
 	})
 
+	t.Run("create subscription with a promo code", func(t *testing.T) {
+		coupon := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponRepeating, DurationCycles: 3}
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Test Product", Price: 1000, TaxRate: 20, Duration: 30}, nil)
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+		mockCouponRepo.On("GetByCode", mocklib.Anything, "SPRING25").Return(coupon, nil).Once()
+		mockSubscriptionRepo.On("Create", mocklib.Anything, mocklib.Anything).Return(nil)
+
+		w := httptest.NewRecorder()
+		jsonBody := `{"product_id": 1, "promo_code": "spring25"}`
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"discount":{"coupon_code":"SPRING25","percent_off":25,"discount_cent":0,"cycles_left":3,"forever":false}`)
+		mockCouponRepo.AssertExpectations(t)
+
+		mockSubscriptionRepo.ExpectedCalls = nil
+		mockProductRepo.ExpectedCalls = nil
+		mockUserRepo.ExpectedCalls = nil
+	})
+
+	t.Run("create subscription with an unknown promo code", func(t *testing.T) {
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Test Product", Price: 1000, TaxRate: 20, Duration: 30}, nil)
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+		mockCouponRepo.On("GetByCode", mocklib.Anything, "FREE").Return((*model.Coupon)(nil), gorm.ErrRecordNotFound).Once()
+
+		w := httptest.NewRecorder()
+		jsonBody := `{"product_id": 1, "promo_code": "FREE"}`
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"invalid promo code: FREE doesn't exist"}`, w.Body.String())
+		mockCouponRepo.AssertExpectations(t)
+
+		mockProductRepo.ExpectedCalls = nil
+		mockUserRepo.ExpectedCalls = nil
+	})
+
+	t.Run("create subscription with a free trial", func(t *testing.T) {
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Test Product", Price: 1000, Duration: 30, TrialDays: 7}, nil)
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+		mockSubscriptionRepo.On("TrialUsed", mocklib.Anything, uint(1), "product-1").Return(false, nil)
+		mockSubscriptionRepo.On("CreateTrial", mocklib.Anything, mocklib.Anything, "product-1").Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(`{"product_id": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Contains(t, w.Body.String(), `"state":"Trialing"`)
+
+		mockSubscriptionRepo.On("CreateTrial", mocklib.Anything, mocklib.Anything, "product-1").Return(gorm.ErrDuplicatedKey).Once()
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(`{"product_id": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.JSONEq(t, `{"message":"Trial was started by another request, please retry"}`, w.Body.String())
+		mockSubscriptionRepo.AssertExpectations(t)
+
+		mockSubscriptionRepo.ExpectedCalls = nil
+		mockProductRepo.ExpectedCalls = nil
+		mockUserRepo.ExpectedCalls = nil
+	})
+
 	t.Run("purchase subscription", func(t *testing.T) {
 		start := time.Now().Add(-time.Hour)
 		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
-			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Pending, Start: start, End: start.Add(time.Hour * 24)}, nil)
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Pending, PriceCent: 1000, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
+		mockSubscriptionRepo.On("Claim", mocklib.Anything, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
 		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(nil)
+		mockCreditRepo.On("GetBalance", mocklib.Anything, uint(1), model.DefaultCurrency).Return((*model.CustomerBalance)(nil), gorm.ErrRecordNotFound).Once()
+		mockPaymentRepo.On("Create", mocklib.Anything, mocklib.AnythingOfType("*model.Payment")).Return(nil)
+		mockPaymentRepo.On("Save", mocklib.Anything, mocklib.MatchedBy(func(p *model.Payment) bool {
+			return p.Status == model.PaymentSucceeded && p.TxID == "tx-1"
+		})).Return(nil)
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, Name: "Alice"}, nil).Once()
+		mockProductRepo.On("GetByIDWithArchived", mocklib.Anything, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Basic Plan"}, nil).Once()
+		mockInvoiceRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(i *model.Invoice) bool {
+			return i.BuyerName == "Alice" && len(i.Lines) == 1 && i.Lines[0].Description == "Basic Plan"
+		})).Return(nil).Once()
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodPost, "/subscriptions/1/purchase", nil)
-		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Authorization", bearerToken(t, 1))
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusOK, w.Code)
 		require.Contains(t, w.Body.String(), `Subscription purchased successfully`)
 		mockSubscriptionRepo.AssertExpectations(t)
+		mockPaymentRepo.AssertExpectations(t)
+		mockInvoiceRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+		mockPaymentRepo.ExpectedCalls = nil
+	})
+
+	t.Run("purchase subscription whose coupon was used up", func(t *testing.T) {
+		couponID := uint(4)
+		coupon := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponOnce}
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Pending, PriceCent: 1000, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24), CouponID: &couponID, CouponCode: "SPRING25", DiscountPercent: money.Percent(25), DiscountCyclesLeft: 1}, nil)
+		mockSubscriptionRepo.On("Claim", mocklib.Anything, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(nil)
+		mockCouponRepo.On("GetByID", mocklib.Anything, uint(4)).Return(coupon, nil).Once()
+		mockCouponRepo.On("Redeem", mocklib.Anything, coupon, uint(1), mocklib.Anything).Return(repo.ErrCouponLimitReached).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions/1/purchase", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"invalid promo code: SPRING25 can't be redeemed anymore"}`, w.Body.String())
+		mockCouponRepo.AssertExpectations(t)
 		mockSubscriptionRepo.ExpectedCalls = nil
 	})
 
+	t.Run("list subscription payments", func(t *testing.T) {
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active}, nil)
+		mockPaymentRepo.On("ListBySubscription", mocklib.Anything, uint(1)).Return([]model.Payment{
+			{Model: gorm.Model{ID: 2}, SubscriptionID: 1, Kind: model.PaymentPurchase, AmountCent: 1100, TaxCent: 100, Currency: "USD", Provider: "approve", TxID: "tx-1", Status: model.PaymentSucceeded},
+		}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/subscriptions/1/payments", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"payments":[{"id":2,"subscription_id":1,"kind":"purchase","amount_cent":1100,"tax_cent":100,"balance_cent":0,"refunded_cent":0,"credited_cent":0,"currency":"USD","provider":"approve","tx_id":"tx-1","status":"succeeded"`)
+
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, "/subscriptions/1/payments", nil)
+		req.Header.Set("Authorization", bearerToken(t, 2))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockPaymentRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+		mockPaymentRepo.ExpectedCalls = nil
+	})
+
 	t.Run("pause subscription", func(t *testing.T) {
 		start := time.Now().Add(-time.Hour)
 		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
-			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Start: start, End: start.Add(time.Hour * 24)}, nil)
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
 		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(nil)
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/pause", nil)
-		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Authorization", bearerToken(t, 1))
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusAccepted, w.Code)
@@ -118,7 +382,7 @@ func TestSubscriptionController(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/unpause", nil)
-		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Authorization", bearerToken(t, 1))
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusAccepted, w.Code)
@@ -129,16 +393,230 @@ func TestSubscriptionController(t *testing.T) {
 	t.Run("cancel subscription", func(t *testing.T) {
 		start := time.Now().Add(-time.Hour)
 		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
-			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Start: start, End: start.Add(time.Hour * 24)}, nil)
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
 		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(nil)
 
 		w := httptest.NewRecorder()
 		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/cancel", nil)
-		req.Header.Set("Authorization", "Bearer test-token")
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Contains(t, w.Body.String(), `Subscription cancelled successfully`)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("cancel subscription at period end", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+		end := start.Add(time.Hour * 24)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Start: start, End: end}, nil)
+		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+			return sub.State == model.Active && sub.CancelAt != nil
+		})).Return(nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/cancel", strings.NewReader(`{"mode":"period_end"}`))
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.JSONEq(t, `{"message":"Subscription will be cancelled at the end of the period","cancel_at":"`+end.Format(time.RFC3339)+`"}`, w.Body.String())
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("cancel subscription with unknown mode", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/cancel", strings.NewReader(`{"mode":"tomorrow"}`))
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"Invalid cancel mode"}`, w.Body.String())
+	})
+
+	t.Run("uncancel subscription", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		end := start.Add(time.Hour * 24)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Start: start, End: end, CancelAt: &end}, nil)
+		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.MatchedBy(func(sub *model.Subscription) bool { return sub.CancelAt == nil })).Return(nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/uncancel", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
 		router.ServeHTTP(w, req)
 
 		require.Equal(t, http.StatusAccepted, w.Code)
+		require.NotContains(t, w.Body.String(), `cancel_at`)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("uncancel subscription without scheduled cancellation", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/uncancel", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
 		mockSubscriptionRepo.AssertExpectations(t)
 		mockSubscriptionRepo.ExpectedCalls = nil
 	})
+
+	t.Run("change plan", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
+		mockProductRepo.On("GetByID", mocklib.Anything, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Price: 2000, Duration: 3600}, nil).Once()
+		mockPaymentRepo.On("ListBySubscription", mocklib.Anything, uint(1)).Return([]model.Payment{}, nil).Once()
+		mockPaymentRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(p *model.Payment) bool { return p.Kind == model.PaymentPlanChange })).Return(nil).Once()
+		mockPaymentRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(nil).Once()
+		mockSubscriptionRepo.On("Claim", mocklib.Anything, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(nil).Once()
+		mockUserRepo.On("GetByID", mocklib.Anything, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil).Once()
+		mockProductRepo.On("GetByIDWithArchived", mocklib.Anything, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Name: "Pro Plan"}, nil).Once()
+		mockInvoiceRepo.On("Create", mocklib.Anything, mocklib.MatchedBy(func(i *model.Invoice) bool { return i.TotalCent == 2000 })).Return(nil).Once()
+		mockCreditRepo.On("GetBalance", mocklib.Anything, uint(1), model.DefaultCurrency).Return(&model.CustomerBalance{UserID: 1, Currency: model.DefaultCurrency, BalanceCent: 500}, nil).Once()
+		mockCreditRepo.On("Apply", mocklib.Anything, mocklib.MatchedBy(func(entry *model.BalanceTransaction) bool {
+			return entry.Kind == model.BalanceSpent && entry.AmountCent == -500
+		})).Return(nil).Once()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions/1/change-plan", strings.NewReader(`{"product_id":2}`))
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), `"credit_cent":0,"charge_cent":2000,"amount_due_cent":2000`)
+		require.Contains(t, w.Body.String(), `"product_id":2`)
+		require.Contains(t, w.Body.String(), `"kind":"plan_change"`)
+		require.Contains(t, w.Body.String(), `"balance_cent":500`)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockPaymentRepo.AssertExpectations(t)
+		mockProductRepo.AssertExpectations(t)
+		mockInvoiceRepo.AssertExpectations(t)
+		mockCreditRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("change plan to the current plan", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions/1/change-plan", strings.NewReader(`{"product_id":1,"mode":"immediate"}`))
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("enable auto-renew", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
+		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.MatchedBy(func(sub *model.Subscription) bool { return sub.AutoRenew })).Return(nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/auto-renew/enable", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Contains(t, w.Body.String(), `Auto-renew enabled successfully`)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("cancel concurrently modified subscription", func(t *testing.T) {
+		start := time.Now().Add(-time.Hour)
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Active, Currency: model.DefaultCurrency, Start: start, End: start.Add(time.Hour * 24)}, nil)
+		mockSubscriptionRepo.On("Save", mocklib.Anything, mocklib.Anything).Return(repo.ErrVersionConflict)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/cancel", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.JSONEq(t, `{"message":"Subscription was modified concurrently, please retry"}`, w.Body.String())
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+
+	t.Run("disable auto-renew on expired subscription", func(t *testing.T) {
+		mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(1)).
+			Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, State: model.Expired, AutoRenew: true}, nil)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/subscriptions/1/auto-renew/disable", nil)
+		req.Header.Set("Authorization", bearerToken(t, 1))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusForbidden, w.Code)
+		mockSubscriptionRepo.AssertExpectations(t)
+		mockSubscriptionRepo.ExpectedCalls = nil
+	})
+}
+
+func TestSupportSubscriptionLookup(t *testing.T) {
+	router := gin.Default()
+
+	mockSubscriptionRepo := new(mock.MockSubscriptionRepo)
+	subscriptionService := service.NewSubscriptionService(mockSubscriptionRepo, new(mock.MockPaymentRepo), nil, nil, approvingPaymentProcessor{}, nil, service.NewTaxService(nil), nil, nil, nil, money.DefaultTaxPolicy)
+	subscriptionController := NewSubscriptionController(&subscriptionService)
+
+	admin := router.Group("/admin", authMiddleware(t), middleware.RequirePermission(auth.SubscriptionsReadAny))
+	admin.GET("/users/:id/subscriptions", subscriptionController.ListUserSubscriptions)
+	admin.GET("/subscriptions/:id", subscriptionController.LookupSubscription)
+
+	mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(4)).
+		Return(&model.Subscription{Model: gorm.Model{ID: 4}, UserID: 7, ProductID: 1, State: model.Active}, nil)
+	mockSubscriptionRepo.On("GetByID", mocklib.Anything, uint(9)).
+		Return((*model.Subscription)(nil), gorm.ErrRecordNotFound)
+	mockSubscriptionRepo.On("List", mocklib.Anything, mocklib.MatchedBy(func(f repo.SubscriptionFilter) bool {
+		return f.UserID == 7
+	})).Return([]model.Subscription{{Model: gorm.Model{ID: 4}, UserID: 7, ProductID: 1, State: model.Active}}, nil)
+
+	testCases := []struct {
+		name         string
+		path         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "support lists a user's subscriptions", path: "/admin/users/7/subscriptions", token: roleToken(t, 2, model.RoleSupport), expectedCode: http.StatusOK, expectedBody: `"subscriptions":[{"id":4,"user_id":7`},
+		{name: "admin lists a user's subscriptions", path: "/admin/users/7/subscriptions", token: roleToken(t, 3, model.RoleAdmin), expectedCode: http.StatusOK, expectedBody: `"user_id":7`},
+		{name: "customer can't list other users", path: "/admin/users/7/subscriptions", token: bearerToken(t, 1), expectedCode: http.StatusForbidden, expectedBody: "insufficient permissions"},
+		{name: "support looks up any subscription", path: "/admin/subscriptions/4", token: roleToken(t, 2, model.RoleSupport), expectedCode: http.StatusOK, expectedBody: `"id":4,"user_id":7`},
+		{name: "missing subscription", path: "/admin/subscriptions/9", token: roleToken(t, 2, model.RoleSupport), expectedCode: http.StatusNotFound, expectedBody: "Subscription not found"},
+		{name: "customer can't look up", path: "/admin/subscriptions/4", token: bearerToken(t, 7), expectedCode: http.StatusForbidden, expectedBody: "insufficient permissions"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Authorization", tc.token)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Contains(t, w.Body.String(), tc.expectedBody)
+		})
+	}
 }
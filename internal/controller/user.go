@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+type UserController struct {
+	svc service.UserService
+}
+
+func NewUserController(userService *service.UserService) *UserController {
+	controller := &UserController{
+		svc: *userService,
+	}
+
+	return controller
+}
+
+// @Summary Get profile
+// @Description Fetch the authenticated user's profile
+// @Tags Profile
+// @Produce json
+// @Success 200 {object} dto.UserResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /me [get]
+// @Security ApiKeyAuth
+func (c *UserController) GetMe(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	user, err := c.svc.Get(ctx, userID)
+	if err != nil {
+		profileError(ctx, err, "Failed to fetch profile")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToUserResponse(user))
+}
+
+// @Summary Update profile
+// @Description Change the authenticated user's name, email or password. Changing the email or password needs current_password.
+// @Tags Profile
+// @Accept json
+// @Produce json
+// @Param request body dto.UpdateProfileRequest true "Fields to change"
+// @Success 200 {object} dto.UserResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /me [patch]
+// @Security ApiKeyAuth
+func (c *UserController) UpdateMe(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "Unauthorized"})
+		return
+	}
+
+	var req dto.UpdateProfileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	user, err := c.svc.UpdateProfile(ctx, userID, service.ProfilePatch{
+		Name:            req.Name,
+		Email:           req.Email,
+		Password:        req.Password,
+		BillingCurrency: req.BillingCurrency,
+		BillingCountry:  req.BillingCountry,
+		BillingRegion:   req.BillingRegion,
+		TaxID:           req.TaxID,
+		PaymentMethod:   req.PaymentMethod,
+		CurrentPassword: req.CurrentPassword,
+	})
+	if err != nil {
+		profileError(ctx, err, "Failed to update profile")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ToUserResponse(user))
+}
+
+// @Summary Set a user's role
+// @Description Change the role of a user. It applies to tokens issued afterwards. Requires the users:write permission.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body dto.SetRoleRequest true "Role"
+// @Success 200 {object} dto.UserMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/users/{id}/role [put]
+// @Security ApiKeyAuth
+func (c *UserController) SetRole(ctx *gin.Context) {
+	var uri dto.UserRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	var req dto.SetRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	if err := c.svc.SetRole(ctx, uri.ID, model.Role(req.Role)); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole):
+			ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid role"})
+		case errors.Is(err, service.ErrUserNotFound):
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "User not found"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to update role"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.UserMessageResponse{Message: "Role updated successfully"})
+}
+
+// @Summary Set a user's tax exemption
+// @Description Mark a user as exempt from tax, or withdraw the exemption. It applies to subscriptions created afterwards. Requires the users:write permission.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body dto.SetTaxExemptRequest true "Exemption"
+// @Success 200 {object} dto.UserMessageResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 401 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /admin/users/{id}/tax-exempt [put]
+// @Security ApiKeyAuth
+func (c *UserController) SetTaxExempt(ctx *gin.Context) {
+	var uri dto.UserRequest
+	if err := ctx.ShouldBindUri(&uri); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid user ID"})
+		return
+	}
+
+	var req dto.SetTaxExemptRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Invalid request body"})
+		return
+	}
+
+	if err := c.svc.SetTaxExempt(ctx, uri.ID, *req.Exempt); err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound):
+			ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "User not found"})
+		default:
+			ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "Failed to update tax exemption"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.UserMessageResponse{Message: "Tax exemption updated successfully"})
+}
+
+func profileError(ctx *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidProfile):
+		ctx.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+	case errors.Is(err, service.ErrEmailTaken):
+		ctx.JSON(http.StatusConflict, dto.ErrorResponse{Message: "Email is already registered"})
+	case errors.Is(err, service.ErrInvalidCredentials):
+		ctx.JSON(http.StatusForbidden, dto.ErrorResponse{Message: "Current password is incorrect"})
+	case errors.Is(err, service.ErrUserNotFound):
+		ctx.JSON(http.StatusNotFound, dto.ErrorResponse{Message: "User not found"})
+	default:
+		ctx.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: fallback})
+	}
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/middleware"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+func TestSetRole(t *testing.T) {
+	router := gin.Default()
+
+	mockUserRepo := new(mock.MockUserRepo)
+	userService := service.NewUserService(mockUserRepo)
+	userController := NewUserController(&userService)
+	router.PUT("/admin/users/:id/role", authMiddleware(t), middleware.RequirePermission(auth.UsersWrite), userController.SetRole)
+
+	mockUserRepo.On("SetRole", mocklib.Anything, uint(2), model.RoleSupport).Return(true, nil)
+	mockUserRepo.On("SetRole", mocklib.Anything, uint(9), model.RoleSupport).Return(false, nil)
+
+	testCases := []struct {
+		name         string
+		path         string
+		body         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "admin promotes user", path: "/admin/users/2/role", body: `{"role":"support"}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusOK, expectedBody: `{"message":"Role updated successfully"}`},
+		{name: "unknown role", path: "/admin/users/2/role", body: `{"role":"owner"}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid role"}`},
+		{name: "unknown user", path: "/admin/users/9/role", body: `{"role":"support"}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusNotFound, expectedBody: `{"message":"User not found"}`},
+		{name: "support can't change roles", path: "/admin/users/2/role", body: `{"role":"admin"}`, token: roleToken(t, 2, model.RoleSupport), expectedCode: http.StatusForbidden, expectedBody: `{"message":"insufficient permissions"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", tc.token)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+
+	mockUserRepo.AssertExpectations(t)
+}
+
+func TestSetTaxExempt(t *testing.T) {
+	router := gin.Default()
+
+	mockUserRepo := new(mock.MockUserRepo)
+	userService := service.NewUserService(mockUserRepo)
+	userController := NewUserController(&userService)
+	router.PUT("/admin/users/:id/tax-exempt", authMiddleware(t), middleware.RequirePermission(auth.UsersWrite), userController.SetTaxExempt)
+
+	mockUserRepo.On("SetTaxExempt", mocklib.Anything, uint(2), true).Return(true, nil)
+	mockUserRepo.On("SetTaxExempt", mocklib.Anything, uint(2), false).Return(true, nil)
+	mockUserRepo.On("SetTaxExempt", mocklib.Anything, uint(9), true).Return(false, nil)
+
+	testCases := []struct {
+		name         string
+		path         string
+		body         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "admin exempts user", path: "/admin/users/2/tax-exempt", body: `{"exempt":true}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusOK, expectedBody: `{"message":"Tax exemption updated successfully"}`},
+		{name: "admin withdraws exemption", path: "/admin/users/2/tax-exempt", body: `{"exempt":false}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusOK, expectedBody: `{"message":"Tax exemption updated successfully"}`},
+		{name: "missing exempt", path: "/admin/users/2/tax-exempt", body: `{}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusBadRequest, expectedBody: `{"message":"Invalid request body"}`},
+		{name: "unknown user", path: "/admin/users/9/tax-exempt", body: `{"exempt":true}`, token: roleToken(t, 1, model.RoleAdmin), expectedCode: http.StatusNotFound, expectedBody: `{"message":"User not found"}`},
+		{name: "support can't exempt users", path: "/admin/users/2/tax-exempt", body: `{"exempt":true}`, token: roleToken(t, 2, model.RoleSupport), expectedCode: http.StatusForbidden, expectedBody: `{"message":"insufficient permissions"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", tc.token)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+
+	mockUserRepo.AssertExpectations(t)
+}
@@ -9,14 +9,51 @@ import (
 )
 
 func Setup() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open("subserv.db"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open("subserv.db"), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
 
-	if err := db.AutoMigrate(&model.Product{}, &model.Subscription{}, &model.User{}); err != nil {
+	if err := db.AutoMigrate(&model.Product{}, &model.ProductPrice{}, &model.Subscription{}, &model.User{}, &model.DunningAttempt{}, &model.Payment{}, &model.Refund{}, &model.Quote{}, &model.IdempotencyKey{}, &model.Session{}, &model.SessionToken{}, &model.Invoice{}, &model.InvoiceLine{}, &model.InvoiceSequence{}, &model.CreditNote{}, &model.CustomerBalance{}, &model.BalanceTransaction{}, &model.Coupon{}, &model.CouponProduct{}, &model.CouponRedemption{}, &model.Trial{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	for _, table := range []any{&model.Subscription{}, &model.Quote{}} {
+		if err := migrateTaxRate(db, table); err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+	if err := migrateCouponCodeIndex(db); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return db, nil
 }
+
+// migrateCouponCodeIndex drops the unique index older databases have on the
+// codes of all coupons, archived ones included. Only active codes are unique
+// now.
+func migrateCouponCodeIndex(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasIndex(&model.Coupon{}, "idx_coupons_code") {
+		return nil
+	}
+
+	return migrator.DropIndex(&model.Coupon{}, "idx_coupons_code")
+}
+
+// migrateTaxRate moves tax rates from the whole percent tax_rate column of
+// older databases to the basis point tax_rate_bp column.
+func migrateTaxRate(db *gorm.DB, table any) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(table, "tax_rate") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(table).Where("tax_rate_bp = 0").
+			UpdateColumn("tax_rate_bp", gorm.Expr("tax_rate * 100")).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(table, "tax_rate")
+	})
+}
@@ -0,0 +1,84 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type RegisterRequest struct {
+	Name     string `json:"name" binding:"required,max=100"`
+	Email    string `json:"email" binding:"required,email,max=100"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	// DeviceName labels the session in the session list, e.g. "Work laptop".
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// TokenResponse follows the OAuth 2.0 token response. ExpiresIn is the
+// access token lifetime in seconds.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func ToTokenResponse(accessToken string, refreshToken string, expiresIn time.Duration) TokenResponse {
+	return TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(expiresIn.Seconds()),
+	}
+}
+
+type SessionRequest struct {
+	ID uint `uri:"id" binding:"required,gt=0"`
+}
+
+type SessionResponse struct {
+	ID         uint      `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type SessionListResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+type SessionMessageResponse struct {
+	Message string `json:"message"`
+}
+
+// ToSessionListResponse marks the session with currentID as the one the
+// request was made from.
+func ToSessionListResponse(sessions []model.Session, currentID uint) SessionListResponse {
+	resp := SessionListResponse{Sessions: make([]SessionResponse, 0, len(sessions))}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.ID == currentID,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+		})
+	}
+	return resp
+}
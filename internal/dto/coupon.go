@@ -0,0 +1,99 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+)
+
+type CouponRequest struct {
+	ID uint `uri:"id" binding:"required,gt=0"`
+}
+
+// CouponListRequest pages through the active coupons.
+type CouponListRequest struct {
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// CreateCouponRequest takes either percent_off or amount_off_cent, the
+// latter in currency. duration_cycles is the number of charged periods a
+// repeating coupon discounts. Zero limits are unlimited, and without
+// product_ids the coupon applies to every product.
+type CreateCouponRequest struct {
+	Code           string     `json:"code" binding:"required,max=50" example:"SPRING25"`
+	Name           string     `json:"name" binding:"max=255"`
+	PercentOff     money.Rate `json:"percent_off" swaggertype:"number" example:"25"`
+	AmountOffCent  int        `json:"amount_off_cent" binding:"min=0"`
+	Currency       string     `json:"currency" binding:"omitempty,len=3" example:"EUR"`
+	Duration       string     `json:"duration" binding:"required" enums:"once,repeating,forever"`
+	DurationCycles uint       `json:"duration_cycles"`
+	MaxRedemptions uint       `json:"max_redemptions"`
+	MaxPerUser     uint       `json:"max_per_user"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	ProductIDs     []uint     `json:"product_ids" binding:"dive,gt=0"`
+}
+
+type CouponResponse struct {
+	ID             uint       `json:"id"`
+	Code           string     `json:"code"`
+	Name           string     `json:"name,omitempty"`
+	PercentOff     money.Rate `json:"percent_off,omitempty" swaggertype:"number" example:"25"`
+	AmountOffCent  int        `json:"amount_off_cent,omitempty"`
+	Currency       string     `json:"currency,omitempty"`
+	Duration       string     `json:"duration" enums:"once,repeating,forever"`
+	DurationCycles uint       `json:"duration_cycles,omitempty"`
+	MaxRedemptions uint       `json:"max_redemptions"`
+	MaxPerUser     uint       `json:"max_per_user"`
+	TimesRedeemed  uint       `json:"times_redeemed"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	ProductIDs     []uint     `json:"product_ids"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type CouponListResponse struct {
+	Coupons    []CouponResponse `json:"coupons"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type CouponMessageResponse struct {
+	Message string `json:"message"`
+}
+
+func ToCouponResponse(coupon *model.Coupon) CouponResponse {
+	productIDs := make([]uint, len(coupon.Products))
+	for i, product := range coupon.Products {
+		productIDs[i] = product.ProductID
+	}
+
+	return CouponResponse{
+		ID:             coupon.ID,
+		Code:           coupon.Code,
+		Name:           coupon.Name,
+		PercentOff:     coupon.PercentOff,
+		AmountOffCent:  coupon.AmountOffCent,
+		Currency:       coupon.Currency,
+		Duration:       string(coupon.Duration),
+		DurationCycles: coupon.DurationCycles,
+		MaxRedemptions: coupon.MaxRedemptions,
+		MaxPerUser:     coupon.MaxPerUser,
+		TimesRedeemed:  coupon.TimesRedeemed,
+		ExpiresAt:      coupon.ExpiresAt,
+		ProductIDs:     productIDs,
+		CreatedAt:      coupon.CreatedAt,
+	}
+}
+
+func ToCouponListResponse(coupons []model.Coupon, nextCursor string) CouponListResponse {
+	res := CouponListResponse{
+		Coupons:    make([]CouponResponse, len(coupons)),
+		NextCursor: nextCursor,
+	}
+
+	for i, coupon := range coupons {
+		res.Coupons[i] = ToCouponResponse(&coupon)
+	}
+
+	return res
+}
@@ -0,0 +1,140 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type CreditNoteRequest struct {
+	ID uint `uri:"id" binding:"required,gt=0"`
+}
+
+// CreditListRequest pages through credit notes and balance transactions.
+type CreditListRequest struct {
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+// CreditNoteResponse credits total_cent of an invoice back, either refunded
+// to the payment method (method refund) or to the customer balance (method
+// balance).
+type CreditNoteResponse struct {
+	ID             uint      `json:"id"`
+	Number         string    `json:"number"`
+	InvoiceID      uint      `json:"invoice_id"`
+	InvoiceNumber  string    `json:"invoice_number"`
+	SubscriptionID uint      `json:"subscription_id"`
+	PaymentID      uint      `json:"payment_id"`
+	RefundID       *uint     `json:"refund_id,omitempty"`
+	Method         string    `json:"method" enums:"refund,balance"`
+	Currency       string    `json:"currency"`
+	Reason         string    `json:"reason,omitempty"`
+	NetCent        int       `json:"net_cent"`
+	TaxCent        int       `json:"tax_cent"`
+	TotalCent      int       `json:"total_cent"`
+	IssuedAt       time.Time `json:"issued_at"`
+}
+
+type CreditNoteListResponse struct {
+	CreditNotes []CreditNoteResponse `json:"credit_notes"`
+	NextCursor  string               `json:"next_cursor,omitempty"`
+}
+
+type BalanceResponse struct {
+	Currency    string    `json:"currency"`
+	BalanceCent int       `json:"balance_cent"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type BalanceListResponse struct {
+	Balances []BalanceResponse `json:"balances"`
+}
+
+// BalanceTransactionResponse is one change of a balance: amount_cent is
+// positive for credits and negative for money spent, balance_cent is the
+// balance after it.
+type BalanceTransactionResponse struct {
+	ID           uint      `json:"id"`
+	Kind         string    `json:"kind" enums:"credit,payment,reversal"`
+	Currency     string    `json:"currency"`
+	AmountCent   int       `json:"amount_cent"`
+	BalanceCent  int       `json:"balance_cent"`
+	CreditNoteID *uint     `json:"credit_note_id,omitempty"`
+	PaymentID    *uint     `json:"payment_id,omitempty"`
+	Description  string    `json:"description,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type BalanceTransactionListResponse struct {
+	Transactions []BalanceTransactionResponse `json:"transactions"`
+	NextCursor   string                       `json:"next_cursor,omitempty"`
+}
+
+func ToCreditNoteResponse(n *model.CreditNote) CreditNoteResponse {
+	return CreditNoteResponse{
+		ID:             n.ID,
+		Number:         n.Number,
+		InvoiceID:      n.InvoiceID,
+		InvoiceNumber:  n.InvoiceNumber,
+		SubscriptionID: n.SubscriptionID,
+		PaymentID:      n.PaymentID,
+		RefundID:       n.RefundID,
+		Method:         string(n.Method),
+		Currency:       n.Currency,
+		Reason:         n.Reason,
+		NetCent:        n.NetCent,
+		TaxCent:        n.TaxCent,
+		TotalCent:      n.TotalCent,
+		IssuedAt:       n.IssuedAt,
+	}
+}
+
+func ToCreditNoteListResponse(notes []model.CreditNote, nextCursor string) CreditNoteListResponse {
+	res := CreditNoteListResponse{
+		CreditNotes: make([]CreditNoteResponse, len(notes)),
+		NextCursor:  nextCursor,
+	}
+
+	for i, note := range notes {
+		res.CreditNotes[i] = ToCreditNoteResponse(&note)
+	}
+
+	return res
+}
+
+func ToBalanceListResponse(balances []model.CustomerBalance) BalanceListResponse {
+	res := BalanceListResponse{Balances: make([]BalanceResponse, len(balances))}
+	for i, balance := range balances {
+		res.Balances[i] = BalanceResponse{
+			Currency:    balance.Currency,
+			BalanceCent: balance.BalanceCent,
+			UpdatedAt:   balance.UpdatedAt,
+		}
+	}
+
+	return res
+}
+
+func ToBalanceTransactionListResponse(transactions []model.BalanceTransaction, nextCursor string) BalanceTransactionListResponse {
+	res := BalanceTransactionListResponse{
+		Transactions: make([]BalanceTransactionResponse, len(transactions)),
+		NextCursor:   nextCursor,
+	}
+
+	for i, t := range transactions {
+		res.Transactions[i] = BalanceTransactionResponse{
+			ID:           t.ID,
+			Kind:         string(t.Kind),
+			Currency:     t.Currency,
+			AmountCent:   t.AmountCent,
+			BalanceCent:  t.BalanceCent,
+			CreditNoteID: t.CreditNoteID,
+			PaymentID:    t.PaymentID,
+			Description:  t.Description,
+			CreatedAt:    t.CreatedAt,
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,131 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/tax"
+)
+
+type InvoiceRequest struct {
+	ID uint `uri:"id" binding:"required,gt=0"`
+}
+
+type InvoiceListRequest struct {
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100"`
+}
+
+type InvoicePartyResponse struct {
+	Name    string `json:"name"`
+	Email   string `json:"email,omitempty"`
+	Address string `json:"address,omitempty"`
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	TaxID   string `json:"tax_id,omitempty"`
+}
+
+type InvoiceLineResponse struct {
+	Description string     `json:"description"`
+	ProductID   uint       `json:"product_id,omitempty"`
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	PeriodEnd   *time.Time `json:"period_end,omitempty"`
+	NetCent     int        `json:"net_cent"`
+	TaxRate     money.Rate `json:"tax_rate" swaggertype:"number" example:"21"`
+	TaxCent     int        `json:"tax_cent"`
+	TotalCent   int        `json:"total_cent"`
+}
+
+// InvoiceTaxResponse sums the lines taxed at one rate.
+type InvoiceTaxResponse struct {
+	TaxRate money.Rate `json:"tax_rate" swaggertype:"number" example:"21"`
+	NetCent int        `json:"net_cent"`
+	TaxCent int        `json:"tax_cent"`
+}
+
+type InvoiceResponse struct {
+	ID             uint                  `json:"id"`
+	Number         string                `json:"number"`
+	SubscriptionID uint                  `json:"subscription_id"`
+	PaymentID      uint                  `json:"payment_id"`
+	Currency       string                `json:"currency"`
+	Seller         InvoicePartyResponse  `json:"seller"`
+	Buyer          InvoicePartyResponse  `json:"buyer"`
+	Lines          []InvoiceLineResponse `json:"lines,omitempty"`
+	Taxes          []InvoiceTaxResponse  `json:"taxes,omitempty"`
+	TaxCountry     string                `json:"tax_country,omitempty"`
+	TaxRegion      string                `json:"tax_region,omitempty"`
+	TaxNote        string                `json:"tax_note,omitempty"`
+	NetCent        int                   `json:"net_cent"`
+	TaxCent        int                   `json:"tax_cent"`
+	TotalCent      int                   `json:"total_cent"`
+	IssuedAt       time.Time             `json:"issued_at"`
+}
+
+type InvoiceListResponse struct {
+	Invoices   []InvoiceResponse `json:"invoices"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+func ToInvoiceResponse(i *model.Invoice) InvoiceResponse {
+	res := InvoiceResponse{
+		ID:             i.ID,
+		Number:         i.Number,
+		SubscriptionID: i.SubscriptionID,
+		PaymentID:      i.PaymentID,
+		Currency:       i.Currency,
+		Seller: InvoicePartyResponse{
+			Name:    i.SellerName,
+			Address: i.SellerAddress,
+			Country: i.SellerCountry,
+			TaxID:   i.SellerTaxID,
+		},
+		Buyer: InvoicePartyResponse{
+			Name:    i.BuyerName,
+			Email:   i.BuyerEmail,
+			Country: i.BuyerCountry,
+			Region:  i.BuyerRegion,
+			TaxID:   i.BuyerTaxID,
+		},
+		TaxCountry: i.TaxCountry,
+		TaxRegion:  i.TaxRegion,
+		TaxNote:    tax.Reason(i.TaxReason).Note(),
+		NetCent:    i.NetCent,
+		TaxCent:    i.TaxCent,
+		TotalCent:  i.TotalCent,
+		IssuedAt:   i.IssuedAt,
+	}
+
+	for _, line := range i.Lines {
+		res.Lines = append(res.Lines, InvoiceLineResponse{
+			Description: line.Description,
+			ProductID:   line.ProductID,
+			PeriodStart: line.PeriodStart,
+			PeriodEnd:   line.PeriodEnd,
+			NetCent:     line.NetCent,
+			TaxRate:     line.TaxRate,
+			TaxCent:     line.TaxCent,
+			TotalCent:   line.TotalCent,
+		})
+	}
+	for _, group := range i.TaxBreakdown() {
+		res.Taxes = append(res.Taxes, InvoiceTaxResponse{TaxRate: group.Rate, NetCent: group.NetCent, TaxCent: group.TaxCent})
+	}
+
+	return res
+}
+
+// ToInvoiceListResponse lists invoices without their lines.
+func ToInvoiceListResponse(invoices []model.Invoice, nextCursor string) InvoiceListResponse {
+	res := InvoiceListResponse{
+		Invoices:   make([]InvoiceResponse, len(invoices)),
+		NextCursor: nextCursor,
+	}
+
+	for i, invoice := range invoices {
+		res.Invoices[i] = ToInvoiceResponse(&invoice)
+	}
+
+	return res
+}
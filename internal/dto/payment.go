@@ -0,0 +1,118 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type PaymentResponse struct {
+	ID             uint       `json:"id"`
+	SubscriptionID uint       `json:"subscription_id"`
+	Kind           string     `json:"kind"`
+	AmountCent     int        `json:"amount_cent"`
+	TaxCent        int        `json:"tax_cent"`
+	BalanceCent    int        `json:"balance_cent"` // paid from the customer balance
+	RefundedCent   int        `json:"refunded_cent"`
+	CreditedCent   int        `json:"credited_cent"` // credited back to the customer balance
+	Currency       string     `json:"currency"`
+	Provider       string     `json:"provider"`
+	TxID           string     `json:"tx_id,omitempty"`
+	Status         string     `json:"status"`
+	FailureReason  string     `json:"failure_reason,omitempty"`
+	AttemptedAt    time.Time  `json:"attempted_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+type PaymentRequest struct {
+	ID uint `uri:"id" binding:"required"`
+}
+
+// RefundRequest refunds amount_cent, or the amount given by policy when
+// amount_cent is left out. Without either the rest of the payment is
+// refunded.
+type RefundRequest struct {
+	AmountCent int    `json:"amount_cent" binding:"omitempty,gt=0"`
+	Policy     string `json:"policy" enums:"full,prorated"`
+	Reason     string `json:"reason" binding:"max=500"`
+}
+
+type RefundResponse struct {
+	ID             uint       `json:"id"`
+	PaymentID      uint       `json:"payment_id"`
+	SubscriptionID uint       `json:"subscription_id"`
+	AmountCent     int        `json:"amount_cent"`
+	TaxCent        int        `json:"tax_cent"`
+	Currency       string     `json:"currency"`
+	Reason         string     `json:"reason,omitempty"`
+	Provider       string     `json:"provider"`
+	TxID           string     `json:"tx_id,omitempty"`
+	Status         string     `json:"status"`
+	FailureReason  string     `json:"failure_reason,omitempty"`
+	AttemptedAt    time.Time  `json:"attempted_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+type RefundListResponse struct {
+	Refunds []RefundResponse `json:"refunds"`
+}
+
+type PaymentListResponse struct {
+	Payments []PaymentResponse `json:"payments"`
+}
+
+func ToPaymentResponse(p *model.Payment) PaymentResponse {
+	return PaymentResponse{
+		ID:             p.ID,
+		SubscriptionID: p.SubscriptionID,
+		Kind:           string(p.Kind),
+		AmountCent:     p.AmountCent,
+		TaxCent:        p.TaxCent,
+		BalanceCent:    p.BalanceCent,
+		RefundedCent:   p.RefundedCent,
+		CreditedCent:   p.CreditedCent,
+		Currency:       p.Currency,
+		Provider:       p.Provider,
+		TxID:           p.TxID,
+		Status:         string(p.Status),
+		FailureReason:  p.FailureReason,
+		AttemptedAt:    p.AttemptedAt,
+		CompletedAt:    p.CompletedAt,
+	}
+}
+
+func ToPaymentListResponse(payments []model.Payment) PaymentListResponse {
+	res := PaymentListResponse{Payments: make([]PaymentResponse, len(payments))}
+	for i, payment := range payments {
+		res.Payments[i] = ToPaymentResponse(&payment)
+	}
+
+	return res
+}
+
+func ToRefundResponse(r *model.Refund) RefundResponse {
+	return RefundResponse{
+		ID:             r.ID,
+		PaymentID:      r.PaymentID,
+		SubscriptionID: r.SubscriptionID,
+		AmountCent:     r.AmountCent,
+		TaxCent:        r.TaxCent,
+		Currency:       r.Currency,
+		Reason:         r.Reason,
+		Provider:       r.Provider,
+		TxID:           r.TxID,
+		Status:         string(r.Status),
+		FailureReason:  r.FailureReason,
+		AttemptedAt:    r.AttemptedAt,
+		CompletedAt:    r.CompletedAt,
+	}
+}
+
+func ToRefundListResponse(refunds []model.Refund) RefundListResponse {
+	res := RefundListResponse{Refunds: make([]RefundResponse, len(refunds))}
+	for i, refund := range refunds {
+		res.Refunds[i] = ToRefundResponse(&refund)
+	}
+
+	return res
+}
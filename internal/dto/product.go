@@ -14,13 +14,62 @@ type ProductRequest struct {
 	ID uint `uri:"id" binding:"required,gt=0"`
 }
 
+// Price is an amount in the minor unit of an ISO 4217 currency, e.g. 1999
+// EUR for 19.99 EUR or 2000 JPY for 2000 JPY.
+type Price struct {
+	Currency string `json:"currency" binding:"required,len=3" example:"EUR"`
+	Amount   int    `json:"amount" binding:"required,gt=0" example:"1999"`
+}
+
+// ProductBody is the full product sent to create or replace a product.
+// Duration is the subscription length in seconds. Price is in USD; prices
+// lists the other currencies the product can be bought in. tax_category
+// picks the rate from the tax rules; tax_rate applies where no rule does.
+// trial_days gives new subscribers a free trial; a user gets one trial per
+// family, and a product without a family is its own.
+type ProductBody struct {
+	Name        string  `json:"name" binding:"required"`
+	Description string  `json:"description"`
+	Price       int     `json:"price" binding:"required,gt=0"`
+	Prices      []Price `json:"prices" binding:"unique=Currency,dive"`
+	TaxRate     uint8   `json:"tax_rate" binding:"max=100"`
+	TaxCategory string  `json:"tax_category" binding:"max=50" example:"standard"`
+	Duration    int64   `json:"duration" binding:"required,gt=0"`
+	TrialDays   uint    `json:"trial_days" binding:"max=365"`
+	Family      string  `json:"family" binding:"max=50" example:"pro"`
+}
+
+// UpdateProductRequest changes only the fields that are present. Sending
+// prices replaces all prices in currencies other than USD.
+type UpdateProductRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=1"`
+	Description *string `json:"description"`
+	Price       *int    `json:"price" binding:"omitempty,gt=0"`
+	Prices      []Price `json:"prices" binding:"omitempty,unique=Currency,dive"`
+	TaxRate     *uint8  `json:"tax_rate" binding:"omitempty,max=100"`
+	TaxCategory *string `json:"tax_category" binding:"omitempty,min=1,max=50"`
+	Duration    *int64  `json:"duration" binding:"omitempty,gt=0"`
+	TrialDays   *uint   `json:"trial_days" binding:"omitempty,max=365"`
+	Family      *string `json:"family" binding:"omitempty,max=50"`
+}
+
+type ProductMessageResponse struct {
+	Message string `json:"message"`
+}
+
+// ProductResponse carries the USD price in price and every price the
+// product can be bought in, USD included, in prices.
 type ProductResponse struct {
 	ID          uint          `json:"id"`
 	Name        string        `json:"name"`
 	Description string        `json:"description"`
 	Price       int           `json:"price"`
+	Prices      []Price       `json:"prices"`
 	TaxRate     uint8         `json:"tax_rate"`
+	TaxCategory string        `json:"tax_category"`
 	Duration    time.Duration `json:"duration" swaggertype:"string,format=duration"`
+	TrialDays   uint          `json:"trial_days"`
+	Family      string        `json:"family,omitempty"`
 }
 
 type ProductListResponse struct {
@@ -28,12 +77,21 @@ type ProductListResponse struct {
 }
 
 func ToProductResponse(product *model.Product) ProductResponse {
+	prices := []Price{{Currency: model.DefaultCurrency, Amount: product.Price}}
+	for _, price := range product.Prices {
+		prices = append(prices, Price{Currency: price.Currency, Amount: price.Amount})
+	}
+
 	return ProductResponse{
 		ID:          product.ID,
 		Name:        product.Name,
 		Price:       product.Price,
+		Prices:      prices,
 		TaxRate:     product.TaxRate,
+		TaxCategory: product.TaxCategory,
 		Duration:    product.Duration,
+		TrialDays:   product.TrialDays,
+		Family:      product.Family,
 		Description: product.Description,
 	}
 }
@@ -0,0 +1,98 @@
+package dto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/tax"
+)
+
+// QuoteRequest quotes in currency, or in the user's billing currency if it
+// is left out. Tax is quoted at the user's billing address; country and
+// region, e.g. a US state, are optional and must match it if given.
+type QuoteRequest struct {
+	ProductID  uint   `json:"product_id" binding:"required,gt=0"`
+	CouponCode string `json:"coupon_code" binding:"max=50"`
+	Country    string `json:"country" binding:"omitempty,len=2" example:"DE"`
+	Region     string `json:"region" binding:"max=10" example:"CA"`
+	Currency   string `json:"currency" binding:"omitempty,len=3" example:"EUR"`
+}
+
+type QuoteLine struct {
+	Type        string `json:"type" enums:"net,discount,tax,included_tax"`
+	Description string `json:"description"`
+	AmountCent  int    `json:"amount_cent"`
+}
+
+// QuoteResponse breaks down the first charge of a subscription. Discount
+// lines are negative. With tax_inclusive the net and discount lines already
+// include tax, and an included_tax line shows the tax within the total
+// instead of adding to it. Pass the id as quote_id when creating the
+// subscription before expires_at to lock in the total.
+type QuoteResponse struct {
+	ID           uint        `json:"id"`
+	ProductID    uint        `json:"product_id"`
+	Country      string      `json:"country"`
+	Region       string      `json:"region,omitempty"`
+	CouponCode   string      `json:"coupon_code,omitempty"`
+	Currency     string      `json:"currency"`
+	Lines        []QuoteLine `json:"lines"`
+	NetCent      int         `json:"net_cent"`
+	DiscountCent int         `json:"discount_cent"`
+	TaxInclusive bool        `json:"tax_inclusive"`
+	TaxRate      money.Rate  `json:"tax_rate" swaggertype:"number" example:"19"`
+	TaxReason    string      `json:"tax_reason" enums:"taxed,reverse_charge,exempt_buyer,exempt_product,fallback"`
+	TaxCent      int         `json:"tax_cent"`
+	TotalCent    int         `json:"total_cent"`
+	PeriodStart  time.Time   `json:"period_start"`
+	PeriodEnd    time.Time   `json:"period_end"`
+	ExpiresAt    time.Time   `json:"expires_at"`
+}
+
+func ToQuoteResponse(q *model.Quote) QuoteResponse {
+	lines := []QuoteLine{{Type: "net", Description: q.ProductName, AmountCent: q.NetCent}}
+	if q.DiscountCent > 0 {
+		lines = append(lines, QuoteLine{Type: "discount", Description: "Coupon " + q.CouponCode, AmountCent: -q.DiscountCent})
+	}
+	taxLine := QuoteLine{Type: "tax", Description: taxDescription(q), AmountCent: q.TaxCent}
+	if q.TaxInclusive {
+		taxLine.Type = "included_tax"
+	}
+	lines = append(lines, taxLine)
+
+	return QuoteResponse{
+		ID:           q.ID,
+		ProductID:    q.ProductID,
+		Country:      q.Country,
+		Region:       q.Region,
+		CouponCode:   q.CouponCode,
+		Currency:     q.Currency,
+		Lines:        lines,
+		NetCent:      q.NetCent,
+		DiscountCent: q.DiscountCent,
+		TaxInclusive: q.TaxInclusive,
+		TaxRate:      q.TaxRate,
+		TaxReason:    q.TaxReason,
+		TaxCent:      q.TaxCent,
+		TotalCent:    q.TotalCent,
+		PeriodStart:  q.PeriodStart,
+		PeriodEnd:    q.PeriodEnd,
+		ExpiresAt:    q.ExpiresAt,
+	}
+}
+
+func taxDescription(q *model.Quote) string {
+	if note := tax.Reason(q.TaxReason).Note(); note != "" {
+		return note
+	}
+	jurisdiction := q.Country
+	if q.Region != "" {
+		jurisdiction += "-" + q.Region
+	}
+	if q.TaxInclusive {
+		return fmt.Sprintf("Incl. tax %s (%s)", q.TaxRate, jurisdiction)
+	}
+	return fmt.Sprintf("Tax %s (%s)", q.TaxRate, jurisdiction)
+}
@@ -4,14 +4,63 @@ import (
 	"time"
 
 	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
 )
 
 type SubscriptionRequest struct {
 	ID uint `uri:"id" binding:"required,gt=0"`
 }
 
+// CreateSubscriptionRequest needs a product or a quote. With a quote the
+// subscription keeps the quoted price and currency; product_id may be left
+// out. Otherwise it is priced in currency, or in the user's billing currency
+// if currency is left out. A promo_code redeems that coupon; with a quote it
+// must be the quoted one.
 type CreateSubscriptionRequest struct {
-	ProductID uint `json:"product_id" binding:"required,gt=0"`
+	ProductID uint   `json:"product_id" binding:"required_without=QuoteID"`
+	QuoteID   uint   `json:"quote_id"`
+	Currency  string `json:"currency" binding:"omitempty,len=3" example:"EUR"`
+	PromoCode string `json:"promo_code" binding:"max=50" example:"SPRING25"`
+}
+
+// CancelSubscriptionRequest selects how to cancel. The body is optional and
+// defaults to immediate cancellation.
+type CancelSubscriptionRequest struct {
+	Mode string `json:"mode" enums:"immediate,period_end"`
+}
+
+type CancelSubscriptionResponse struct {
+	Message  string     `json:"message"`
+	CancelAt *time.Time `json:"cancel_at"`
+}
+
+type ChangePlanRequest struct {
+	ProductID uint   `json:"product_id" binding:"required"`
+	Mode      string `json:"mode" enums:"immediate,period_end"`
+}
+
+// ChangePlanResponse shows what the change costs. amount_due_cent is
+// charge_cent minus credit_cent; a negative amount was credited to the
+// customer balance.
+type ChangePlanResponse struct {
+	Subscription  SubscriptionResponse `json:"subscription"`
+	CreditCent    int                  `json:"credit_cent"`
+	ChargeCent    int                  `json:"charge_cent"`
+	AmountDueCent int                  `json:"amount_due_cent"`
+	EffectiveAt   time.Time            `json:"effective_at"`
+	Payment       *PaymentResponse     `json:"payment,omitempty"`
+	CreditNote    *CreditNoteResponse  `json:"credit_note,omitempty"`
+}
+
+type ListSubscriptionsRequest struct {
+	State     string    `form:"state"`
+	ProductID uint      `form:"product_id"`
+	StartFrom time.Time `form:"start_from" time_format:"2006-01-02T15:04:05Z07:00"`
+	StartTo   time.Time `form:"start_to" time_format:"2006-01-02T15:04:05Z07:00"`
+	EndFrom   time.Time `form:"end_from" time_format:"2006-01-02T15:04:05Z07:00"`
+	EndTo     time.Time `form:"end_to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor    string    `form:"cursor"`
+	Limit     int       `form:"limit" binding:"omitempty,min=1,max=100"`
 }
 
 type SubscriptionResponse struct {
@@ -20,10 +69,41 @@ type SubscriptionResponse struct {
 	ProductID uint       `json:"product_id"`
 	State     string     `json:"state"`
 	PriceCent int        `json:"price_cent"`
-	TaxRate   uint8      `json:"tax_rate"`
-	Start     time.Time  `json:"start"`
-	End       time.Time  `json:"end"`
-	PausedAt  *time.Time `json:"paused_at,omitempty"`
+	Currency  string     `json:"currency"`
+	TaxRate   money.Rate `json:"tax_rate" swaggertype:"number" example:"7.25"`
+	// Tax jurisdiction, e.g. "DE" or "US-CA", and why the rate applies.
+	TaxJurisdiction string     `json:"tax_jurisdiction,omitempty" example:"US-CA"`
+	TaxReason       string     `json:"tax_reason,omitempty" enums:"taxed,reverse_charge,exempt_buyer,exempt_product,fallback"`
+	Start           time.Time  `json:"start"`
+	End             time.Time  `json:"end"`
+	PausedAt        *time.Time `json:"paused_at,omitempty"`
+	AutoRenew       bool       `json:"auto_renew"`
+	CancelAt        *time.Time `json:"cancel_at,omitempty"`
+	// PendingProductID is the plan the subscription switches to when it renews.
+	PendingProductID *uint `json:"pending_product_id,omitempty"`
+	// Entitled is false once an unpaid subscription leaves its grace period.
+	Entitled    bool       `json:"entitled"`
+	GraceUntil  *time.Time `json:"grace_until,omitempty"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	// Discount is set once a coupon was redeemed for the subscription.
+	Discount *DiscountResponse `json:"discount,omitempty"`
+}
+
+// DiscountResponse is the coupon a subscription redeemed. DiscountCent is
+// taken off the price of the current period; CyclesLeft more renewals are
+// discounted, or all of them if Forever.
+type DiscountResponse struct {
+	CouponCode    string     `json:"coupon_code" example:"SPRING25"`
+	PercentOff    money.Rate `json:"percent_off,omitempty" swaggertype:"number" example:"25"`
+	AmountOffCent int        `json:"amount_off_cent,omitempty"`
+	DiscountCent  int        `json:"discount_cent"`
+	CyclesLeft    uint       `json:"cycles_left"`
+	Forever       bool       `json:"forever"`
+}
+
+type SubscriptionListResponse struct {
+	Subscriptions []SubscriptionResponse `json:"subscriptions"`
+	NextCursor    string                 `json:"next_cursor,omitempty"`
 }
 
 type SubscriptionMessageResponse struct {
@@ -32,14 +112,51 @@ type SubscriptionMessageResponse struct {
 
 func ToSubscriptionResponse(s *model.Subscription) SubscriptionResponse {
 	return SubscriptionResponse{
-		ID:        s.ID,
-		ProductID: s.ProductID,
-		UserID:    s.UserID,
-		State:     model.StateNames[s.State],
-		PriceCent: s.PriceCent,
-		TaxRate:   s.TaxRate,
-		Start:     s.Start,
-		End:       s.End,
-		PausedAt:  s.PausedAt,
+		ID:               s.ID,
+		ProductID:        s.ProductID,
+		UserID:           s.UserID,
+		State:            model.StateNames[s.State],
+		PriceCent:        s.PriceCent,
+		Currency:         s.Currency,
+		TaxRate:          s.TaxRate,
+		TaxJurisdiction:  s.TaxJurisdiction(),
+		TaxReason:        s.TaxReason,
+		Start:            s.Start,
+		End:              s.End,
+		PausedAt:         s.PausedAt,
+		AutoRenew:        s.AutoRenew,
+		CancelAt:         s.CancelAt,
+		PendingProductID: s.PendingProductID,
+		Entitled:         s.Entitled(time.Now()),
+		GraceUntil:       s.GraceUntil,
+		NextRetryAt:      s.NextRetryAt,
+		Discount:         toDiscountResponse(s),
+	}
+}
+
+func toDiscountResponse(s *model.Subscription) *DiscountResponse {
+	if s.CouponID == nil {
+		return nil
+	}
+	return &DiscountResponse{
+		CouponCode:    s.CouponCode,
+		PercentOff:    s.DiscountPercent,
+		AmountOffCent: s.DiscountAmountCent,
+		DiscountCent:  s.DiscountCent,
+		CyclesLeft:    s.DiscountCyclesLeft,
+		Forever:       s.DiscountForever,
 	}
 }
+
+func ToSubscriptionListResponse(subscriptions []model.Subscription, nextCursor string) SubscriptionListResponse {
+	res := SubscriptionListResponse{
+		Subscriptions: make([]SubscriptionResponse, len(subscriptions)),
+		NextCursor:    nextCursor,
+	}
+
+	for i, subscription := range subscriptions {
+		res.Subscriptions[i] = ToSubscriptionResponse(&subscription)
+	}
+
+	return res
+}
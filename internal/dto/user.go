@@ -0,0 +1,70 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type UserRequest struct {
+	ID uint `uri:"id" binding:"required,gt=0"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required" enums:"customer,support,admin"`
+}
+
+type UserMessageResponse struct {
+	Message string `json:"message"`
+}
+
+// UpdateProfileRequest changes only the fields that are present. Changing the
+// email or password needs current_password. payment_method is the payment
+// processor's token of the card that converts trials; an empty one removes it.
+type UpdateProfileRequest struct {
+	Name            *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Email           *string `json:"email" binding:"omitempty,email,max=100"`
+	Password        *string `json:"password" binding:"omitempty,min=8,max=72"`
+	BillingCurrency *string `json:"billing_currency" binding:"omitempty,len=3"`
+	BillingCountry  *string `json:"billing_country" binding:"omitempty,len=2" example:"DE"`
+	BillingRegion   *string `json:"billing_region" binding:"omitempty,max=10"`
+	TaxID           *string `json:"tax_id" binding:"omitempty,max=50" example:"DE136695976"`
+	PaymentMethod   *string `json:"payment_method" binding:"omitempty,max=255" example:"pm_card_visa"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+// SetTaxExemptRequest marks a user as exempt from tax, e.g. a charity, or
+// withdraws the exemption.
+type SetTaxExemptRequest struct {
+	Exempt *bool `json:"exempt" binding:"required"`
+}
+
+type UserResponse struct {
+	ID               uint      `json:"id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	BillingCurrency  string    `json:"billing_currency"`
+	BillingCountry   string    `json:"billing_country,omitempty"`
+	BillingRegion    string    `json:"billing_region,omitempty"`
+	TaxID            string    `json:"tax_id,omitempty"`
+	TaxExempt        bool      `json:"tax_exempt"`
+	HasPaymentMethod bool      `json:"has_payment_method"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func ToUserResponse(user *model.User) UserResponse {
+	return UserResponse{
+		ID:               user.ID,
+		Name:             user.Name,
+		Email:            user.Email,
+		Role:             string(user.Role),
+		BillingCurrency:  user.Currency(),
+		BillingCountry:   user.BillingCountry,
+		BillingRegion:    user.BillingRegion,
+		TaxID:            user.TaxID,
+		TaxExempt:        user.TaxExempt,
+		HasPaymentMethod: user.PaymentMethod != "",
+		CreatedAt:        user.CreatedAt,
+	}
+}
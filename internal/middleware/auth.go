@@ -1,22 +1,88 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
 	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/model"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+const (
+	// UserIDKey is the gin context key holding the authenticated user's ID.
+	UserIDKey = "userID"
+	// RoleKey is the gin context key holding the authenticated user's role.
+	RoleKey = "role"
+	// SessionIDKey is the gin context key holding the session the token was
+	// issued for, or zero.
+	SessionIDKey = "sessionID"
+)
+
+func AuthMiddleware(verifier *auth.Verifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-		if token == "" || token != "Bearer test-token" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "unauthorized user"})
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "missing authorization header"})
+			return
+		}
+
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "malformed authorization header"})
 			return
 		}
 
-		// Simulate user ID extraction from token
-		c.Set("userID", uint(1))
+		claims, err := verifier.Verify(strings.TrimSpace(token))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, dto.ErrorResponse{Message: tokenErrorMessage(err)})
+			return
+		}
+
+		userID, _ := claims.UserID()
+		c.Set(UserIDKey, userID)
+		c.Set(RoleKey, claims.UserRole())
+		c.Set(SessionIDKey, claims.SessionID)
+		c.Next()
+	}
+}
+
+// RequirePermission rejects requests whose role lacks the permission. It
+// must run after AuthMiddleware.
+func RequirePermission(permission auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get(RoleKey)
+		if r, ok := role.(model.Role); !ok || !auth.HasPermission(r, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, dto.ErrorResponse{Message: "insufficient permissions"})
+			return
+		}
 		c.Next()
 	}
 }
+
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrMalformedToken):
+		return "malformed token"
+	case errors.Is(err, auth.ErrTokenExpired):
+		return "token expired"
+	case errors.Is(err, auth.ErrTokenNotYetValid):
+		return "token not valid yet"
+	case errors.Is(err, auth.ErrInvalidIssuer):
+		return "invalid token issuer"
+	case errors.Is(err, auth.ErrInvalidAudience):
+		return "invalid token audience"
+	case errors.Is(err, auth.ErrInvalidSignature), errors.Is(err, auth.ErrUnknownKey):
+		return "invalid token signature"
+	case errors.Is(err, auth.ErrInvalidSubject):
+		return "invalid token subject"
+	case errors.Is(err, auth.ErrMissingClaim):
+		return "token is missing required claims"
+	case errors.Is(err, auth.ErrWrongTokenUse):
+		return "not an access token"
+	default:
+		return "invalid token"
+	}
+}
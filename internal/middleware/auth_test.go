@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	cfg := auth.Config{Secret: "test-secret"}
+	verifier, err := auth.NewVerifier(cfg)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/me", AuthMiddleware(verifier), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.MustGet(UserIDKey)})
+	})
+
+	valid, err := auth.IssueToken(cfg, 5, time.Hour)
+	require.NoError(t, err)
+	expired, err := auth.IssueToken(cfg, 5, -time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		header       string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "valid token", header: "Bearer " + valid, expectedCode: http.StatusOK, expectedBody: `{"user_id":5}`},
+		{name: "missing header", header: "", expectedCode: http.StatusUnauthorized, expectedBody: `{"message":"missing authorization header"}`},
+		{name: "wrong scheme", header: "Basic " + valid, expectedCode: http.StatusUnauthorized, expectedBody: `{"message":"malformed authorization header"}`},
+		{name: "legacy test token", header: "Bearer test-token", expectedCode: http.StatusUnauthorized, expectedBody: `{"message":"malformed token"}`},
+		{name: "expired token", header: "Bearer " + expired, expectedCode: http.StatusUnauthorized, expectedBody: `{"message":"token expired"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.JSONEq(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	cfg := auth.Config{Secret: "test-secret"}
+	verifier, err := auth.NewVerifier(cfg)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/admin/products", AuthMiddleware(verifier), RequirePermission(auth.ProductsWrite), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/admin/subscriptions", AuthMiddleware(verifier), RequirePermission(auth.SubscriptionsReadAny), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tokens := map[model.Role]string{}
+	for _, role := range []model.Role{model.RoleCustomer, model.RoleSupport, model.RoleAdmin, "owner"} {
+		token, err := auth.IssueTokenWithRole(cfg, 1, role, time.Hour)
+		require.NoError(t, err)
+		tokens[role] = token
+	}
+	noRole, err := auth.IssueTokenWithRole(cfg, 1, "", time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		path         string
+		token        string
+		expectedCode int
+	}{
+		{name: "admin writes products", path: "/admin/products", token: tokens[model.RoleAdmin], expectedCode: http.StatusNoContent},
+		{name: "support can't write products", path: "/admin/products", token: tokens[model.RoleSupport], expectedCode: http.StatusForbidden},
+		{name: "customer can't write products", path: "/admin/products", token: tokens[model.RoleCustomer], expectedCode: http.StatusForbidden},
+		{name: "admin reads any subscription", path: "/admin/subscriptions", token: tokens[model.RoleAdmin], expectedCode: http.StatusNoContent},
+		{name: "support reads any subscription", path: "/admin/subscriptions", token: tokens[model.RoleSupport], expectedCode: http.StatusNoContent},
+		{name: "customer can't read any subscription", path: "/admin/subscriptions", token: tokens[model.RoleCustomer], expectedCode: http.StatusForbidden},
+		{name: "token without role is a customer", path: "/admin/subscriptions", token: noRole, expectedCode: http.StatusForbidden},
+		{name: "unknown role has no permissions", path: "/admin/subscriptions", token: tokens["owner"], expectedCode: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusForbidden {
+				require.JSONEq(t, `{"message":"insufficient permissions"}`, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/dto"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyKeyCtxKey is the gin context key holding the request's
+	// idempotency key, if it has one.
+	IdempotencyKeyCtxKey = "idempotencyKey"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// responseRecorder keeps a copy of the response body so it can be replayed.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to
+// retry. The first request with a key is executed and its response stored;
+// repeating it returns the stored response, reusing the key for a different
+// request is rejected with 422 and a duplicate sent while the first one is
+// still running gets 409. It must run after AuthMiddleware, keys are scoped
+// per user.
+func Idempotency(svc service.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, dto.ErrorResponse{Message: "invalid idempotency key"})
+			return
+		}
+
+		userID, ok := c.Get(UserIDKey)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, dto.ErrorResponse{Message: "unauthorized"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, dto.ErrorResponse{Message: "unreadable request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		record, err := svc.Begin(c, userID.(uint), key, fingerprint(c.Request, body))
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrIdempotencyKeyReused):
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, dto.ErrorResponse{Message: "idempotency key was already used for a different request"})
+			case errors.Is(err, service.ErrRequestInProgress):
+				c.AbortWithStatusJSON(http.StatusConflict, dto.ErrorResponse{Message: "a request with this idempotency key is in progress"})
+			default:
+				c.AbortWithStatusJSON(http.StatusInternalServerError, dto.ErrorResponse{Message: "failed to process idempotency key"})
+			}
+			return
+		}
+
+		if record.CompletedAt != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.StatusCode, record.ContentType, record.ResponseBody)
+			c.Abort()
+			return
+		}
+
+		// the outcome must be stored even if the client is gone by now
+		storeCtx := context.WithoutCancel(c.Request.Context())
+		defer func() {
+			if r := recover(); r != nil {
+				if err := svc.Release(storeCtx, record); err != nil {
+					log.Printf("couldn't release idempotency key %q: %v", key, err)
+				}
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Set(IdempotencyKeyCtxKey, key)
+		c.Next()
+
+		if err := svc.Complete(storeCtx, record, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes()); err != nil {
+			log.Printf("couldn't store response of idempotency key %q: %v", key, err)
+		}
+	}
+}
+
+// fingerprint identifies a request by method, path and body.
+func fingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(req.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/service"
+)
+
+// memoryIdempotency is an in-memory IdempotencyService.
+type memoryIdempotency struct {
+	mu      sync.Mutex
+	records map[string]*model.IdempotencyKey
+}
+
+func (m *memoryIdempotency) Begin(ctx context.Context, userID uint, key string, fingerprint string) (*model.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.records[key]; ok {
+		if existing.Fingerprint != fingerprint {
+			return nil, service.ErrIdempotencyKeyReused
+		}
+		if existing.CompletedAt == nil {
+			return nil, service.ErrRequestInProgress
+		}
+		return existing, nil
+	}
+
+	record := &model.IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint}
+	m.records[key] = record
+	return record, nil
+}
+
+func (m *memoryIdempotency) Complete(ctx context.Context, record *model.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.ResponseBody = body
+	record.CompletedAt = &now
+	return nil
+}
+
+func (m *memoryIdempotency) Release(ctx context.Context, record *model.IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, record.Key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	svc := &memoryIdempotency{records: map[string]*model.IdempotencyKey{}}
+
+	calls := 0
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(UserIDKey, uint(1)) })
+	router.POST("/charge", Idempotency(svc), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"call": calls, "key": c.GetString(IdempotencyKeyCtxKey)})
+	})
+	router.POST("/slow", Idempotency(svc), func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusNoContent)
+	})
+
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("replay returns the original response", func(t *testing.T) {
+		first := send("/charge", "k1", `{"product_id":1}`)
+		require.Equal(t, http.StatusCreated, first.Code)
+		require.JSONEq(t, `{"call":1,"key":"k1"}`, first.Body.String())
+
+		replay := send("/charge", "k1", `{"product_id":1}`)
+		require.Equal(t, http.StatusCreated, replay.Code)
+		require.JSONEq(t, `{"call":1,"key":"k1"}`, replay.Body.String())
+		require.Equal(t, "true", replay.Header().Get("Idempotent-Replayed"))
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("key reused with a different body", func(t *testing.T) {
+		w := send("/charge", "k1", `{"product_id":2}`)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("key reused on another endpoint", func(t *testing.T) {
+		w := send("/slow", "k1", `{"product_id":1}`)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("requests without a key are not deduplicated", func(t *testing.T) {
+		send("/charge", "", `{}`)
+		send("/charge", "", `{}`)
+		require.Equal(t, 3, calls)
+	})
+
+	t.Run("too long key", func(t *testing.T) {
+		w := send("/charge", strings.Repeat("k", 256), `{}`)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.JSONEq(t, `{"message":"invalid idempotency key"}`, w.Body.String())
+	})
+
+	t.Run("concurrent duplicate", func(t *testing.T) {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- send("/slow", "k2", "") }()
+
+		require.Eventually(t, func() bool {
+			svc.mu.Lock()
+			defer svc.mu.Unlock()
+			_, ok := svc.records["k2"]
+			return ok
+		}, time.Second, time.Millisecond)
+
+		w := send("/slow", "k2", "")
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.JSONEq(t, `{"message":"a request with this idempotency key is in progress"}`, w.Body.String())
+
+		close(release)
+		require.Equal(t, http.StatusNoContent, (<-done).Code)
+	})
+}
@@ -0,0 +1,59 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+)
+
+type MockCouponRepo struct {
+	mock.Mock
+}
+
+func (m *MockCouponRepo) GetByID(ctx context.Context, id uint) (*model.Coupon, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Coupon), args.Error(1)
+}
+
+func (m *MockCouponRepo) GetByIDWithArchived(ctx context.Context, id uint) (*model.Coupon, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Coupon), args.Error(1)
+}
+
+func (m *MockCouponRepo) GetByCode(ctx context.Context, code string) (*model.Coupon, error) {
+	args := m.Called(ctx, code)
+	return args.Get(0).(*model.Coupon), args.Error(1)
+}
+
+func (m *MockCouponRepo) List(ctx context.Context, filter repo.CouponFilter) ([]model.Coupon, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]model.Coupon), args.Error(1)
+}
+
+func (m *MockCouponRepo) Create(ctx context.Context, coupon *model.Coupon) error {
+	args := m.Called(ctx, coupon)
+	return args.Error(0)
+}
+
+func (m *MockCouponRepo) Archive(ctx context.Context, id uint) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockCouponRepo) CountRedemptions(ctx context.Context, couponID uint, userID uint) (int64, error) {
+	args := m.Called(ctx, couponID, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockCouponRepo) Redeem(ctx context.Context, coupon *model.Coupon, userID uint, at time.Time) error {
+	args := m.Called(ctx, coupon, userID, at)
+	return args.Error(0)
+}
+
+func (m *MockCouponRepo) Release(ctx context.Context, couponID uint, userID uint) error {
+	args := m.Called(ctx, couponID, userID)
+	return args.Error(0)
+}
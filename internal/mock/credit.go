@@ -0,0 +1,48 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+)
+
+type MockCreditRepo struct {
+	mock.Mock
+}
+
+func (m *MockCreditRepo) GetNote(ctx context.Context, id uint) (*model.CreditNote, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.CreditNote), args.Error(1)
+}
+
+func (m *MockCreditRepo) CreateNote(ctx context.Context, note *model.CreditNote, credit *model.BalanceTransaction) error {
+	args := m.Called(ctx, note, credit)
+	return args.Error(0)
+}
+
+func (m *MockCreditRepo) ListNotes(ctx context.Context, filter repo.CreditFilter) ([]model.CreditNote, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]model.CreditNote), args.Error(1)
+}
+
+func (m *MockCreditRepo) GetBalance(ctx context.Context, userID uint, currency string) (*model.CustomerBalance, error) {
+	args := m.Called(ctx, userID, currency)
+	return args.Get(0).(*model.CustomerBalance), args.Error(1)
+}
+
+func (m *MockCreditRepo) ListBalances(ctx context.Context, userID uint) ([]model.CustomerBalance, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]model.CustomerBalance), args.Error(1)
+}
+
+func (m *MockCreditRepo) Apply(ctx context.Context, entry *model.BalanceTransaction) error {
+	args := m.Called(ctx, entry)
+	return args.Error(0)
+}
+
+func (m *MockCreditRepo) ListTransactions(ctx context.Context, filter repo.CreditFilter) ([]model.BalanceTransaction, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]model.BalanceTransaction), args.Error(1)
+}
@@ -0,0 +1,22 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type MockDunningRepo struct {
+	mock.Mock
+}
+
+func (m *MockDunningRepo) Create(ctx context.Context, attempt *model.DunningAttempt) error {
+	args := m.Called(ctx, attempt)
+	return args.Error(0)
+}
+
+func (m *MockDunningRepo) ListBySubscription(ctx context.Context, subscriptionID uint) ([]model.DunningAttempt, error) {
+	args := m.Called(ctx, subscriptionID)
+	return args.Get(0).([]model.DunningAttempt), args.Error(1)
+}
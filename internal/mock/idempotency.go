@@ -0,0 +1,38 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type MockIdempotencyRepo struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyRepo) Get(ctx context.Context, userID uint, key string) (*model.IdempotencyKey, error) {
+	args := m.Called(ctx, userID, key)
+	return args.Get(0).(*model.IdempotencyKey), args.Error(1)
+}
+
+func (m *MockIdempotencyRepo) Create(ctx context.Context, record *model.IdempotencyKey) error {
+	args := m.Called(ctx, record)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyRepo) Save(ctx context.Context, record *model.IdempotencyKey) error {
+	args := m.Called(ctx, record)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyRepo) DeleteStale(ctx context.Context, ID uint, updatedAt time.Time) (bool, error) {
+	args := m.Called(ctx, ID, updatedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockIdempotencyRepo) Delete(ctx context.Context, ID uint) error {
+	args := m.Called(ctx, ID)
+	return args.Error(0)
+}
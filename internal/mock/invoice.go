@@ -0,0 +1,33 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+)
+
+type MockInvoiceRepo struct {
+	mock.Mock
+}
+
+func (m *MockInvoiceRepo) GetByID(ctx context.Context, id uint) (*model.Invoice, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Invoice), args.Error(1)
+}
+
+func (m *MockInvoiceRepo) GetByPayment(ctx context.Context, paymentID uint) (*model.Invoice, error) {
+	args := m.Called(ctx, paymentID)
+	return args.Get(0).(*model.Invoice), args.Error(1)
+}
+
+func (m *MockInvoiceRepo) Create(ctx context.Context, invoice *model.Invoice) error {
+	args := m.Called(ctx, invoice)
+	return args.Error(0)
+}
+
+func (m *MockInvoiceRepo) List(ctx context.Context, filter repo.InvoiceFilter) ([]model.Invoice, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]model.Invoice), args.Error(1)
+}
@@ -0,0 +1,67 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type MockPaymentRepo struct {
+	mock.Mock
+}
+
+func (m *MockPaymentRepo) GetByID(ctx context.Context, id uint) (*model.Payment, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentRepo) Create(ctx context.Context, payment *model.Payment) error {
+	args := m.Called(ctx, payment)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepo) Save(ctx context.Context, payment *model.Payment) error {
+	args := m.Called(ctx, payment)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepo) ListBySubscription(ctx context.Context, subscriptionID uint) ([]model.Payment, error) {
+	args := m.Called(ctx, subscriptionID)
+	return args.Get(0).([]model.Payment), args.Error(1)
+}
+
+func (m *MockPaymentRepo) ReserveRefund(ctx context.Context, paymentID uint, amount int) (bool, error) {
+	args := m.Called(ctx, paymentID, amount)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockPaymentRepo) ReleaseRefund(ctx context.Context, paymentID uint, amount int) error {
+	args := m.Called(ctx, paymentID, amount)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepo) ReserveCredit(ctx context.Context, paymentID uint, amount int) (bool, error) {
+	args := m.Called(ctx, paymentID, amount)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockPaymentRepo) ReleaseCredit(ctx context.Context, paymentID uint, amount int) error {
+	args := m.Called(ctx, paymentID, amount)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepo) CreateRefund(ctx context.Context, refund *model.Refund) error {
+	args := m.Called(ctx, refund)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepo) SaveRefund(ctx context.Context, refund *model.Refund) error {
+	args := m.Called(ctx, refund)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepo) ListRefunds(ctx context.Context, paymentID uint) ([]model.Refund, error) {
+	args := m.Called(ctx, paymentID)
+	return args.Get(0).([]model.Refund), args.Error(1)
+}
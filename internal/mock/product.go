@@ -20,3 +20,23 @@ func (m *MockProductRepo) GetAll(ctx context.Context) ([]model.Product, error) {
 	args := m.Called(ctx)
 	return args.Get(0).([]model.Product), args.Error(1)
 }
+
+func (m *MockProductRepo) GetByIDWithArchived(ctx context.Context, id uint) (*model.Product, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Product), args.Error(1)
+}
+
+func (m *MockProductRepo) Create(ctx context.Context, product *model.Product) error {
+	args := m.Called(ctx, product)
+	return args.Error(0)
+}
+
+func (m *MockProductRepo) Save(ctx context.Context, product *model.Product) error {
+	args := m.Called(ctx, product)
+	return args.Error(0)
+}
+
+func (m *MockProductRepo) Archive(ctx context.Context, id uint) (bool, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(bool), args.Error(1)
+}
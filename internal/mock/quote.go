@@ -0,0 +1,33 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type MockQuoteRepo struct {
+	mock.Mock
+}
+
+func (m *MockQuoteRepo) GetByID(ctx context.Context, id uint) (*model.Quote, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Quote), args.Error(1)
+}
+
+func (m *MockQuoteRepo) Create(ctx context.Context, quote *model.Quote) error {
+	args := m.Called(ctx, quote)
+	return args.Error(0)
+}
+
+func (m *MockQuoteRepo) Use(ctx context.Context, id uint, now time.Time) (bool, error) {
+	args := m.Called(ctx, id, now)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockQuoteRepo) Release(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
@@ -0,0 +1,43 @@
+package mock
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+type MockSessionRepo struct {
+	mock.Mock
+}
+
+func (m *MockSessionRepo) Create(ctx context.Context, session *model.Session, token *model.SessionToken) error {
+	args := m.Called(ctx, session, token)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepo) GetByID(ctx context.Context, id uint) (*model.Session, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+
+func (m *MockSessionRepo) GetToken(ctx context.Context, hash string) (*model.SessionToken, error) {
+	args := m.Called(ctx, hash)
+	return args.Get(0).(*model.SessionToken), args.Error(1)
+}
+
+func (m *MockSessionRepo) Rotate(ctx context.Context, used *model.SessionToken, next *model.SessionToken, session *model.Session) (bool, error) {
+	args := m.Called(ctx, used, next, session)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockSessionRepo) Revoke(ctx context.Context, id uint, now time.Time) error {
+	args := m.Called(ctx, id, now)
+	return args.Error(0)
+}
+
+func (m *MockSessionRepo) ListActive(ctx context.Context, userID uint, now time.Time) ([]model.Session, error) {
+	args := m.Called(ctx, userID, now)
+	return args.Get(0).([]model.Session), args.Error(1)
+}
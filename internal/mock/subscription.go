@@ -2,9 +2,11 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
 )
 
 type MockSubscriptionRepo struct {
@@ -21,7 +23,62 @@ func (m *MockSubscriptionRepo) Create(ctx context.Context, subscription *model.S
 	return args.Error(0)
 }
 
+func (m *MockSubscriptionRepo) CreateTrial(ctx context.Context, subscription *model.Subscription, family string) error {
+	args := m.Called(ctx, subscription, family)
+	return args.Error(0)
+}
+
+func (m *MockSubscriptionRepo) TrialUsed(ctx context.Context, userID uint, family string) (bool, error) {
+	args := m.Called(ctx, userID, family)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) TrialsEnding(ctx context.Context, before time.Time, limit int) ([]model.Subscription, error) {
+	args := m.Called(ctx, before, limit)
+	return args.Get(0).([]model.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) MarkTrialReminded(ctx context.Context, id uint, at time.Time) (bool, error) {
+	args := m.Called(ctx, id, at)
+	return args.Get(0).(bool), args.Error(1)
+}
+
 func (m *MockSubscriptionRepo) Save(ctx context.Context, subscription *model.Subscription) error {
 	args := m.Called(ctx, subscription)
 	return args.Error(0)
 }
+
+func (m *MockSubscriptionRepo) List(ctx context.Context, filter repo.SubscriptionFilter) ([]model.Subscription, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]model.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) ExpireDue(ctx context.Context, now time.Time, pausedBefore *time.Time, limit int) (int64, error) {
+	args := m.Called(ctx, now, pausedBefore, limit)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) CancelScheduled(ctx context.Context, now time.Time, limit int) (int64, error) {
+	args := m.Called(ctx, now, limit)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) DueForRenewal(ctx context.Context, now time.Time, renewBefore time.Time, limit int) ([]model.Subscription, error) {
+	args := m.Called(ctx, now, renewBefore, limit)
+	return args.Get(0).([]model.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) ClaimRenewal(ctx context.Context, id uint, version uint, now time.Time, until time.Time) (bool, error) {
+	args := m.Called(ctx, id, version, now, until)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) Claim(ctx context.Context, id uint, version uint, now time.Time, until time.Time) (bool, error) {
+	args := m.Called(ctx, id, version, now, until)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockSubscriptionRepo) DueForRetry(ctx context.Context, now time.Time, limit int) ([]model.Subscription, error) {
+	args := m.Called(ctx, now, limit)
+	return args.Get(0).([]model.Subscription), args.Error(1)
+}
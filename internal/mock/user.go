@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/stretchr/testify/mock"
+	"github.com/thatmatin/subserv/internal/model"
 )
 
 type MockUserRepo struct {
@@ -14,3 +15,33 @@ func (m *MockUserRepo) Exists(ctx context.Context, id uint) (bool, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(bool), args.Error(1)
 }
+
+func (m *MockUserRepo) SetRole(ctx context.Context, id uint, role model.Role) (bool, error) {
+	args := m.Called(ctx, id, role)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockUserRepo) SetTaxExempt(ctx context.Context, id uint, exempt bool) (bool, error) {
+	args := m.Called(ctx, id, exempt)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (m *MockUserRepo) GetByID(ctx context.Context, id uint) (*model.User, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MockUserRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	args := m.Called(ctx, email)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MockUserRepo) Create(ctx context.Context, user *model.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepo) Save(ctx context.Context, user *model.User) error {
+	args := m.Called(ctx, user)
+	return args.Error(0)
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/money"
+	"gorm.io/gorm"
+)
+
+type CouponDuration string
+
+const (
+	// CouponOnce discounts the first charged period only.
+	CouponOnce CouponDuration = "once"
+	// CouponRepeating discounts the first DurationCycles charged periods.
+	CouponRepeating CouponDuration = "repeating"
+	// CouponForever discounts every charged period.
+	CouponForever CouponDuration = "forever"
+)
+
+func (d CouponDuration) Valid() bool {
+	return d == CouponOnce || d == CouponRepeating || d == CouponForever
+}
+
+// Coupon is a discount given with its promotion code when a subscription is
+// created, and redeemed once it's purchased or its trial starts. It takes
+// either PercentOff or AmountOffCent off the net price of each discounted
+// period; an amount off is in Currency and only applies to subscriptions in
+// that currency. Archived coupons can't be redeemed anymore, but
+// subscriptions keep the discount they got, and their code can be given to
+// a new coupon.
+type Coupon struct {
+	gorm.Model
+	Code           string         `gorm:"not null;uniqueIndex:idx_coupons_active_code,where:deleted_at IS NULL;type:varchar(50)"` // upper case
+	Name           string         `gorm:"size:255"`
+	PercentOff     money.Rate     `gorm:"column:percent_off_bp;not null;default:0"` // basis points
+	AmountOffCent  int            `gorm:"not null;default:0;type:int"`
+	Currency       string         `gorm:"type:varchar(3)"` // of AmountOffCent
+	Duration       CouponDuration `gorm:"not null;type:varchar(20)"`
+	DurationCycles uint           `gorm:"not null;default:0"` // for CouponRepeating
+	// MaxRedemptions caps the redemptions by all users, MaxPerUser those by
+	// one user. Zero is unlimited.
+	MaxRedemptions uint       `gorm:"not null;default:0"`
+	MaxPerUser     uint       `gorm:"not null;default:0"`
+	TimesRedeemed  uint       `gorm:"not null;default:0"`
+	ExpiresAt      *time.Time `gorm:"default:null;type:timestamp"`
+	// Products restricts the coupon to these products; without any it
+	// applies to all of them.
+	Products []CouponProduct `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// CouponProduct is a product a coupon is restricted to.
+type CouponProduct struct {
+	CouponID  uint `gorm:"primaryKey;type:bigint"`
+	ProductID uint `gorm:"primaryKey;type:bigint"`
+}
+
+// CouponRedemption is one use of a coupon by a user, counted against
+// MaxPerUser.
+type CouponRedemption struct {
+	ID         uint      `gorm:"primarykey"`
+	CouponID   uint      `gorm:"type:bigint;not null;index:idx_coupon_redemption_user"`
+	UserID     uint      `gorm:"type:bigint;not null;index:idx_coupon_redemption_user"`
+	RedeemedAt time.Time `gorm:"not null"`
+}
+
+// Expired reports whether the coupon can no longer be redeemed at now.
+func (c *Coupon) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// AppliesTo reports whether the coupon discounts the product.
+func (c *Coupon) AppliesTo(productID uint) bool {
+	if len(c.Products) == 0 {
+		return true
+	}
+	for _, p := range c.Products {
+		if p.ProductID == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// Cycles is the number of charged periods the coupon discounts, and whether
+// it discounts all of them.
+func (c *Coupon) Cycles() (cycles uint, forever bool) {
+	switch c.Duration {
+	case CouponForever:
+		return 0, true
+	case CouponRepeating:
+		return c.DurationCycles, false
+	default:
+		return 1, false
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrCreditNoteFinalized is returned when an issued credit note or a
+// recorded balance transaction would be changed or deleted.
+var ErrCreditNoteFinalized = errors.New("credit note is finalized")
+
+type CreditMethod string
+
+const (
+	// CreditRefund documents money given back to the payment method.
+	CreditRefund CreditMethod = "refund"
+	// CreditBalance documents money credited to the customer balance.
+	CreditBalance CreditMethod = "balance"
+)
+
+// CreditNote credits (part of) a finalized invoice back. Like invoices,
+// credit notes are numbered without gaps per yearly series and can't be
+// changed or deleted once issued.
+type CreditNote struct {
+	gorm.Model
+	Number         string       `gorm:"not null;uniqueIndex;type:varchar(50)"`
+	Series         string       `gorm:"not null;uniqueIndex:idx_credit_note_series_sequence;type:varchar(30)"`
+	Sequence       uint         `gorm:"not null;uniqueIndex:idx_credit_note_series_sequence"`
+	InvoiceID      uint         `gorm:"type:bigint;not null;index"`
+	InvoiceNumber  string       `gorm:"not null;type:varchar(50)"`
+	UserID         uint         `gorm:"type:bigint;not null;index"`
+	SubscriptionID uint         `gorm:"type:bigint;not null;index"`
+	PaymentID      uint         `gorm:"type:bigint;not null;index"`
+	RefundID       *uint        `gorm:"type:bigint;uniqueIndex"` // set for CreditRefund
+	Method         CreditMethod `gorm:"not null;type:varchar(20)"`
+	Currency       string       `gorm:"not null;type:varchar(3)"`
+	Reason         string       `gorm:"type:text"`
+	NetCent        int          `gorm:"not null;type:int"`
+	TaxCent        int          `gorm:"not null;type:int"`
+	TotalCent      int          `gorm:"not null;type:int"` // net plus tax, what was credited
+	IssuedAt       time.Time    `gorm:"not null"`
+}
+
+// CustomerBalance is money a user holds in one currency. It is spent on
+// their next charges in that currency before the payment method is charged,
+// and never goes below zero.
+type CustomerBalance struct {
+	UserID      uint   `gorm:"primaryKey;type:bigint"`
+	Currency    string `gorm:"primaryKey;type:varchar(3)"`
+	BalanceCent int    `gorm:"not null;default:0;type:int"`
+	UpdatedAt   time.Time
+}
+
+type BalanceTransactionKind string
+
+const (
+	// BalanceCredited is money credited by a credit note.
+	BalanceCredited BalanceTransactionKind = "credit"
+	// BalanceSpent is money spent on a payment.
+	BalanceSpent BalanceTransactionKind = "payment"
+	// BalanceRestored gives back what a declined payment spent.
+	BalanceRestored BalanceTransactionKind = "reversal"
+)
+
+// BalanceTransaction is one change of a customer balance. The history is
+// append-only: AmountCent is positive for credits and negative for debits,
+// and BalanceCent is the balance right after the change.
+type BalanceTransaction struct {
+	ID           uint                   `gorm:"primarykey"`
+	UserID       uint                   `gorm:"type:bigint;not null;index"`
+	Currency     string                 `gorm:"not null;type:varchar(3)"`
+	Kind         BalanceTransactionKind `gorm:"not null;type:varchar(20)"`
+	AmountCent   int                    `gorm:"not null;type:int"`
+	BalanceCent  int                    `gorm:"not null;type:int"`
+	CreditNoteID *uint                  `gorm:"type:bigint;index"`
+	PaymentID    *uint                  `gorm:"type:bigint;index"`
+	Description  string                 `gorm:"size:255"`
+	CreatedAt    time.Time
+}
+
+func (n *CreditNote) BeforeUpdate(tx *gorm.DB) error {
+	return ErrCreditNoteFinalized
+}
+
+func (n *CreditNote) BeforeDelete(tx *gorm.DB) error {
+	return ErrCreditNoteFinalized
+}
+
+func (t *BalanceTransaction) BeforeUpdate(tx *gorm.DB) error {
+	return ErrCreditNoteFinalized
+}
+
+func (t *BalanceTransaction) BeforeDelete(tx *gorm.DB) error {
+	return ErrCreditNoteFinalized
+}
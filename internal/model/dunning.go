@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DunningAttempt records a renewal charge of a subscription and its outcome.
+// Attempt 0 is the scheduled renewal, the following ones are retries.
+type DunningAttempt struct {
+	gorm.Model
+	SubscriptionID uint      `gorm:"type:bigint;not null;index"`
+	PaymentID      uint      `gorm:"type:bigint;index"`
+	Attempt        uint8     `gorm:"not null;type:tinyint"`
+	AmountCent     int       `gorm:"not null;type:int"`
+	Success        bool      `gorm:"not null"`
+	TxID           string    `gorm:"type:varchar(100)"`
+	FailureReason  string    `gorm:"type:text"`
+	AttemptedAt    time.Time `gorm:"not null"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyKey remembers the response of a request sent with an
+// Idempotency-Key header, so a retry of the same request gets the same answer
+// instead of being executed again. A key without CompletedAt belongs to a
+// request that is still running.
+type IdempotencyKey struct {
+	gorm.Model
+	UserID       uint       `gorm:"type:bigint;not null;uniqueIndex:idx_idempotency_user_key"`
+	Key          string     `gorm:"not null;type:varchar(255);uniqueIndex:idx_idempotency_user_key"`
+	Fingerprint  string     `gorm:"not null;type:varchar(64)"` // sha256 of method, path and body
+	StatusCode   int        `gorm:"type:int"`
+	ContentType  string     `gorm:"type:varchar(100)"`
+	ResponseBody []byte     `gorm:"type:blob"`
+	CompletedAt  *time.Time `gorm:"default:null;type:timestamp"`
+}
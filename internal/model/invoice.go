@@ -0,0 +1,118 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/money"
+	"gorm.io/gorm"
+)
+
+// ErrInvoiceFinalized is returned when a finalized invoice would be changed
+// or deleted.
+var ErrInvoiceFinalized = errors.New("invoice is finalized")
+
+// Invoice is the bill of one succeeded payment. Invoices are finalized when
+// they are issued: the seller and buyer details are copied onto them and
+// neither the invoice nor its lines can be changed or deleted afterwards.
+type Invoice struct {
+	gorm.Model
+	// Number is Series and Sequence, e.g. INV-2026-000042. Sequence counts
+	// up without gaps within a series.
+	Number         string `gorm:"not null;uniqueIndex;type:varchar(50)"`
+	Series         string `gorm:"not null;uniqueIndex:idx_invoice_series_sequence;type:varchar(30)"`
+	Sequence       uint   `gorm:"not null;uniqueIndex:idx_invoice_series_sequence"`
+	UserID         uint   `gorm:"type:bigint;not null;index"`
+	SubscriptionID uint   `gorm:"type:bigint;not null;index"`
+	PaymentID      uint   `gorm:"type:bigint;not null;uniqueIndex"`
+	Currency       string `gorm:"not null;type:varchar(3)"`
+
+	SellerName    string `gorm:"not null;size:255"`
+	SellerAddress string `gorm:"type:text"`
+	SellerCountry string `gorm:"type:varchar(2)"`
+	SellerTaxID   string `gorm:"type:varchar(50)"`
+
+	BuyerName    string `gorm:"not null;size:255"`
+	BuyerEmail   string `gorm:"not null;size:255"`
+	BuyerCountry string `gorm:"type:varchar(2)"`
+	BuyerRegion  string `gorm:"type:varchar(10)"`
+	BuyerTaxID   string `gorm:"type:varchar(50)"`
+
+	TaxCountry string `gorm:"type:varchar(2)"` // where the tax is due
+	TaxRegion  string `gorm:"type:varchar(10)"`
+	TaxReason  string `gorm:"type:varchar(20)"`
+	NetCent    int    `gorm:"not null;type:int"`
+	TaxCent    int    `gorm:"not null;type:int"`
+	TotalCent  int    `gorm:"not null;type:int"` // net plus tax, what the payment charged
+
+	IssuedAt time.Time     `gorm:"not null"`
+	Lines    []InvoiceLine `gorm:"constraint:OnDelete:RESTRICT"`
+}
+
+// InvoiceLine is one item of an invoice. Credit lines, such as the unused
+// time of a previous plan, have negative amounts.
+type InvoiceLine struct {
+	ID          uint       `gorm:"primarykey"`
+	InvoiceID   uint       `gorm:"type:bigint;not null;index"`
+	Position    int        `gorm:"not null"`
+	Description string     `gorm:"not null;size:255"`
+	ProductID   uint       `gorm:"type:bigint"`
+	PeriodStart *time.Time `gorm:"default:null;type:timestamp"`
+	PeriodEnd   *time.Time `gorm:"default:null;type:timestamp"`
+	NetCent     int        `gorm:"not null;type:int"`
+	TaxRate     money.Rate `gorm:"column:tax_rate_bp;not null;default:0"` // basis points
+	TaxCent     int        `gorm:"not null;type:int"`
+	TotalCent   int        `gorm:"not null;type:int"`
+}
+
+// TaxGroup sums the lines of an invoice taxed at one rate.
+type TaxGroup struct {
+	Rate    money.Rate
+	NetCent int
+	TaxCent int
+}
+
+// TaxBreakdown groups the lines by tax rate, in the order the rates first
+// appear.
+func (i *Invoice) TaxBreakdown() []TaxGroup {
+	var groups []TaxGroup
+	for _, line := range i.Lines {
+		found := false
+		for g := range groups {
+			if groups[g].Rate == line.TaxRate {
+				groups[g].NetCent += line.NetCent
+				groups[g].TaxCent += line.TaxCent
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, TaxGroup{Rate: line.TaxRate, NetCent: line.NetCent, TaxCent: line.TaxCent})
+		}
+	}
+	return groups
+}
+
+// InvoiceSequence is the last number used in an invoice or credit note
+// series. It is incremented in the transaction that inserts the document, so
+// a failed insert doesn't use up a number.
+type InvoiceSequence struct {
+	Series string `gorm:"primaryKey;type:varchar(30)"`
+	Last   uint   `gorm:"not null"`
+}
+
+func (i *Invoice) BeforeUpdate(tx *gorm.DB) error {
+	return ErrInvoiceFinalized
+}
+
+func (i *Invoice) BeforeDelete(tx *gorm.DB) error {
+	return ErrInvoiceFinalized
+}
+
+func (l *InvoiceLine) BeforeUpdate(tx *gorm.DB) error {
+	return ErrInvoiceFinalized
+}
+
+func (l *InvoiceLine) BeforeDelete(tx *gorm.DB) error {
+	return ErrInvoiceFinalized
+}
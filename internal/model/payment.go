@@ -0,0 +1,89 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultCurrency is the currency of Product.Price, and the billing currency
+// of users who didn't choose one.
+const DefaultCurrency = "USD"
+
+type PaymentStatus string
+
+const (
+	PaymentPending   PaymentStatus = "pending"
+	PaymentSucceeded PaymentStatus = "succeeded"
+	PaymentFailed    PaymentStatus = "failed"
+)
+
+type PaymentKind string
+
+const (
+	PaymentPurchase PaymentKind = "purchase"
+	PaymentRenewal  PaymentKind = "renewal"
+	PaymentRetry    PaymentKind = "retry"
+	// PaymentPlanChange is the difference charged when switching to a more
+	// expensive plan mid-period.
+	PaymentPlanChange PaymentKind = "plan_change"
+)
+
+// Payment is one charge attempt against the payment processor. The row is
+// written as pending before the processor is called, so a charge is never
+// made without a record of it.
+type Payment struct {
+	gorm.Model
+	SubscriptionID uint          `gorm:"type:bigint;not null;index"`
+	UserID         uint          `gorm:"type:bigint;not null;index"`
+	Kind           PaymentKind   `gorm:"not null;type:varchar(20)"`
+	AmountCent     int           `gorm:"not null;type:int"` // total charged, tax included
+	TaxCent        int           `gorm:"not null;type:int"`
+	Currency       string        `gorm:"not null;type:varchar(3)"`
+	Provider       string        `gorm:"not null;type:varchar(50)"`
+	TxID           string        `gorm:"type:varchar(100);index"`
+	IdempotencyKey string        `gorm:"type:varchar(300);index"` // sent to the processor
+	Status         PaymentStatus `gorm:"not null;type:varchar(20);index"`
+	FailureReason  string        `gorm:"type:text"`
+	AttemptedAt    time.Time     `gorm:"not null"`
+	CompletedAt    *time.Time    `gorm:"default:null;type:timestamp"`
+	// BalanceCent is the part of AmountCent paid from the customer balance;
+	// only the rest went through the processor.
+	BalanceCent int `gorm:"not null;default:0;type:int"`
+	// RefundedCent is held by pending and succeeded refunds and can never
+	// exceed what went through the processor.
+	RefundedCent int `gorm:"not null;default:0;type:int"`
+	// CreditedCent was credited back to the customer balance. Refunded and
+	// credited together can never exceed AmountCent.
+	CreditedCent int `gorm:"not null;default:0;type:int"`
+}
+
+// RefundableCent is what can still be refunded to the payment method.
+func (p *Payment) RefundableCent() int {
+	return max(min(p.AmountCent-p.BalanceCent-p.RefundedCent, p.CreditableCent()), 0)
+}
+
+// CreditableCent is what can still be given back in any form.
+func (p *Payment) CreditableCent() int {
+	return max(p.AmountCent-p.RefundedCent-p.CreditedCent, 0)
+}
+
+// Refund gives back (part of) a succeeded payment. Like payments, the row is
+// written as pending before the processor is called.
+type Refund struct {
+	gorm.Model
+	PaymentID      uint          `gorm:"type:bigint;not null;index"`
+	SubscriptionID uint          `gorm:"type:bigint;not null;index"`
+	UserID         uint          `gorm:"type:bigint;not null;index"`
+	AmountCent     int           `gorm:"not null;type:int"` // total refunded, tax included
+	TaxCent        int           `gorm:"not null;type:int"`
+	Currency       string        `gorm:"not null;type:varchar(3)"`
+	Reason         string        `gorm:"type:text"`
+	Provider       string        `gorm:"not null;type:varchar(50)"`
+	TxID           string        `gorm:"type:varchar(100);index"`
+	IdempotencyKey string        `gorm:"type:varchar(300);index"`
+	Status         PaymentStatus `gorm:"not null;type:varchar(20);index"`
+	FailureReason  string        `gorm:"type:text"`
+	AttemptedAt    time.Time     `gorm:"not null"`
+	CompletedAt    *time.Time    `gorm:"default:null;type:timestamp"`
+}
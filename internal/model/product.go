@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,9 +9,57 @@ import (
 
 type Product struct {
 	gorm.Model
-	Name        string        `gorm:"not null;size:255"`
-	Price       int           `gorm:"not null;type:int"`     // price in cents, e.g., 1999 for $19.99
-	TaxRate     uint8         `gorm:"not null;type:tinyint"` // percentage, e.g., 20 for 20%
-	Duration    time.Duration `gorm:"not null;type:bigint"`  // duration in seconds, e.g., 2592000 for 30 days
+	Name    string `gorm:"not null;size:255"`
+	Price   int    `gorm:"not null;type:int"`     // price in cents of DefaultCurrency, e.g., 1999 for $19.99
+	TaxRate uint8  `gorm:"not null;type:tinyint"` // percentage, e.g., 20 for 20%, used where no tax rule applies
+	// TaxCategory picks the rate from the tax rules, e.g. "ebook".
+	TaxCategory string        `gorm:"not null;type:varchar(50);default:standard"`
+	Duration    time.Duration `gorm:"not null;type:bigint"` // duration in seconds, e.g., 2592000 for 30 days
 	Description string        `gorm:"null;type:text"`
+	// TrialDays is the length of the free trial new subscribers get, zero
+	// for none. A user gets one trial per Family; a product without a Family
+	// is its own.
+	TrialDays uint   `gorm:"not null;default:0"`
+	Family    string `gorm:"type:varchar(50)"`
+	// Prices are the prices in currencies other than DefaultCurrency.
+	Prices []ProductPrice `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// ProductPrice is the price of a product in one currency.
+type ProductPrice struct {
+	ID        uint   `gorm:"primarykey"`
+	ProductID uint   `gorm:"not null;uniqueIndex:idx_product_price_currency"`
+	Currency  string `gorm:"not null;type:varchar(3);uniqueIndex:idx_product_price_currency"`
+	Amount    int    `gorm:"not null;type:int"` // in the currency's minor unit, e.g., 1999 for 19.99 EUR or 2000 for 2000 JPY
+}
+
+// PriceIn returns the price in the currency, if the product has one.
+func (p *Product) PriceIn(currency string) (int, bool) {
+	if currency == DefaultCurrency {
+		return p.Price, true
+	}
+	for _, price := range p.Prices {
+		if price.Currency == currency {
+			return price.Amount, true
+		}
+	}
+	return 0, false
+}
+
+// Period returns the subscription length of the product.
+func (p *Product) Period() time.Duration {
+	return p.Duration * time.Second
+}
+
+// TrialPeriod returns the length of the product's free trial.
+func (p *Product) TrialPeriod() time.Duration {
+	return time.Duration(p.TrialDays) * time.Hour * 24
+}
+
+// TrialFamily names the products that share the product's trial.
+func (p *Product) TrialFamily() string {
+	if p.Family == "" {
+		return fmt.Sprintf("product-%d", p.ID)
+	}
+	return p.Family
 }
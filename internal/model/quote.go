@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/thatmatin/subserv/internal/money"
+	"gorm.io/gorm"
+)
+
+// Quote is the price offered to a user for one period of a product. A
+// subscription created from the quote before ExpiresAt keeps the quoted
+// price; each quote can be used once.
+type Quote struct {
+	gorm.Model
+	UserID       uint       `gorm:"type:bigint;not null;index"`
+	ProductID    uint       `gorm:"type:bigint;not null"`
+	ProductName  string     `gorm:"not null;size:255"`        // as quoted, for the breakdown
+	Country      string     `gorm:"not null;type:varchar(2)"` // ISO 3166-1 alpha-2 billing country
+	Region       string     `gorm:"type:varchar(10)"`
+	CouponID     *uint      `gorm:"default:null;type:bigint"`
+	CouponCode   string     `gorm:"type:varchar(50)"`
+	Currency     string     `gorm:"not null;type:varchar(3)"`
+	NetCent      int        `gorm:"not null;type:int"` // product price before discount
+	DiscountCent int        `gorm:"not null;type:int"`
+	TaxInclusive bool       `gorm:"not null;default:false"`                // net and discount include tax, as prices did when quoted
+	TaxRate      money.Rate `gorm:"column:tax_rate_bp;not null;default:0"` // basis points
+	TaxCategory  string     `gorm:"type:varchar(50)"`
+	TaxReason    string     `gorm:"type:varchar(20)"`
+	TaxCent      int        `gorm:"not null;type:int"`
+	TotalCent    int        `gorm:"not null;type:int"` // net minus discount, plus tax unless TaxInclusive
+	PeriodStart  time.Time  `gorm:"not null"`
+	PeriodEnd    time.Time  `gorm:"not null"`
+	ExpiresAt    time.Time  `gorm:"not null"`
+	UsedAt       *time.Time `gorm:"default:null;type:timestamp"`
+}
+
+// Expired reports whether the quote can no longer be used at now.
+func (q *Quote) Expired(now time.Time) bool {
+	return !now.Before(q.ExpiresAt)
+}
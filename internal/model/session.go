@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a login on one device. Its refresh tokens form a family: every
+// refresh replaces the token, and presenting a replaced token again revokes
+// the whole session.
+type Session struct {
+	gorm.Model
+	UserID     uint       `gorm:"type:bigint;not null;index"`
+	Device     string     `gorm:"type:varchar(100)"`
+	UserAgent  string     `gorm:"type:varchar(255)"`
+	IP         string     `gorm:"type:varchar(45)"`
+	LastUsedAt time.Time  `gorm:"not null;type:timestamp"`
+	ExpiresAt  time.Time  `gorm:"not null;type:timestamp"`
+	RevokedAt  *time.Time `gorm:"default:null;type:timestamp"`
+}
+
+// SessionToken is a refresh token of a session. Only the sha256 hash of the
+// token is stored. UsedAt is set once the token was exchanged.
+type SessionToken struct {
+	ID        uint       `gorm:"primarykey"`
+	SessionID uint       `gorm:"type:bigint;not null;index"`
+	Session   Session    `gorm:"foreignKey:SessionID"`
+	TokenHash string     `gorm:"not null;type:varchar(64);uniqueIndex"`
+	UsedAt    *time.Time `gorm:"default:null;type:timestamp"`
+	CreatedAt time.Time
+}
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"strings"
 	"time"
 
+	"github.com/thatmatin/subserv/internal/money"
 	"gorm.io/gorm"
 )
 
@@ -10,12 +12,61 @@ type Subscription struct {
 	gorm.Model
 	UserID    uint       `gorm:"foreignKey:UserID;type:bigint;not null"`
 	ProductID uint       `gorm:"foreignKey:ProductID;type:bigint;not null"`
-	State     State      `gorm:"default:0;type:tinyint"` // 0: Pending, 1: Active, 2: Paused, 3: Cancelled, 4: Expired, 5: Failed
+	State     State      `gorm:"default:0;type:tinyint"` // 0: Pending, 1: Active, 2: Paused, 3: Cancelled, 4: Expired, 5: Failed, 6: PastDue, 7: Trialing
 	PriceCent int        `gorm:"not null;type:int"`      // price in cents, e.g., 1999 for $19.99
-	TaxRate   uint8      `gorm:"default:0;type:tinyint"` // percentage, e.g., 20 for 20%
 	Start     time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP"`
 	End       time.Time  `gorm:"not null;default:CURRENT_TIMESTAMP"`
 	PausedAt  *time.Time `gorm:"default:null;type:timestamp"`
+	AutoRenew bool       `gorm:"not null;default:false"`
+	// Currency is the ISO 4217 currency of PriceCent, fixed when the
+	// subscription is bought.
+	Currency string `gorm:"not null;type:varchar(3);default:USD"`
+	// Tax applied to PriceCent, decided when the subscription is created or
+	// changes plan and kept for audit. TaxRegion is empty for taxes levied by
+	// the country.
+	TaxRate     money.Rate `gorm:"column:tax_rate_bp;not null;default:0"` // basis points, e.g., 725 for 7.25%
+	TaxCountry  string     `gorm:"type:varchar(2)"`
+	TaxRegion   string     `gorm:"type:varchar(10)"`
+	TaxCategory string     `gorm:"type:varchar(50)"`
+	TaxReason   string     `gorm:"type:varchar(20)"` // see tax.Reason
+	// Discount of the coupon redeemed with the subscription, applied to
+	// PriceCent. The coupon's terms are copied when it is redeemed; the next
+	// DiscountCyclesLeft charged periods are discounted, or all of them if
+	// DiscountForever. DiscountCent is the net discount of the current
+	// period.
+	CouponID           *uint      `gorm:"default:null;type:bigint"`
+	CouponCode         string     `gorm:"type:varchar(50)"`
+	DiscountPercent    money.Rate `gorm:"column:discount_percent_bp;not null;default:0"` // basis points
+	DiscountAmountCent int        `gorm:"not null;default:0;type:int"`
+	DiscountCyclesLeft uint       `gorm:"not null;default:0"`
+	DiscountForever    bool       `gorm:"not null;default:false"`
+	DiscountCent       int        `gorm:"not null;default:0;type:int"`
+	// CancelAt is when the subscription is cancelled. A future CancelAt is a
+	// cancellation scheduled for the end of the paid period; the subscription
+	// stays Active and isn't renewed until then.
+	CancelAt *time.Time `gorm:"default:null;type:timestamp"`
+	// PendingProductID is a plan change scheduled for the end of the period.
+	// The renewal job switches to it before charging the next period.
+	PendingProductID *uint `gorm:"default:null;type:bigint"`
+	// TrialRemindedAt is when the user was reminded that the trial ends.
+	TrialRemindedAt *time.Time `gorm:"default:null;type:timestamp"`
+	// RenewalLockedUntil is a lease taken by the renewal job while it charges
+	// the next period, so concurrent workers do not charge twice.
+	RenewalLockedUntil *time.Time `gorm:"default:null;type:timestamp"`
+	// ClaimedUntil is a lease taken by a purchase or an immediate plan change
+	// while it charges, so a concurrent request for the subscription is
+	// rejected instead of charging again. It is cleared when the change is
+	// saved.
+	ClaimedUntil *time.Time `gorm:"default:null;type:timestamp"`
+	// Dunning state of a PastDue subscription. The subscription keeps its
+	// entitlements until GraceUntil while retries are scheduled.
+	PastDueSince *time.Time `gorm:"default:null;type:timestamp"`
+	GraceUntil   *time.Time `gorm:"default:null;type:timestamp"`
+	NextRetryAt  *time.Time `gorm:"default:null;type:timestamp"`
+	RetryCount   uint8      `gorm:"not null;default:0;type:tinyint"`
+	// Version is bumped on every write. Saves are conditional on it, so a
+	// change based on a stale read is rejected instead of overwriting.
+	Version uint `gorm:"not null;default:0"`
 }
 
 type State uint
@@ -27,6 +78,58 @@ const (
 	Cancelled
 	Expired
 	Failed
+	PastDue  // renewal charge failed
+	Trialing // free trial until End, converted by the renewal job
 )
 
-var StateNames = [...]string{"Pending", "Active", "Paused", "Cancelled", "Expired", "Failed"}
+var StateNames = [...]string{"Pending", "Active", "Paused", "Cancelled", "Expired", "Failed", "PastDue", "Trialing"}
+
+// TaxJurisdiction names where the subscription's tax is due, e.g. "DE" or
+// "US-CA".
+func (s *Subscription) TaxJurisdiction() string {
+	if s.TaxRegion == "" {
+		return s.TaxCountry
+	}
+	return s.TaxCountry + "-" + s.TaxRegion
+}
+
+// Discounted reports whether the next charged period gets the coupon's
+// discount.
+func (s *Subscription) Discounted() bool {
+	return s.CouponID != nil && (s.DiscountForever || s.DiscountCyclesLeft > 0)
+}
+
+// Claimed reports whether a purchase or plan change is charging the
+// subscription at now.
+func (s *Subscription) Claimed(now time.Time) bool {
+	return s.ClaimedUntil != nil && now.Before(*s.ClaimedUntil)
+}
+
+// Entitled reports whether the subscriber has access to the product at now.
+func (s *Subscription) Entitled(now time.Time) bool {
+	switch s.State {
+	case Active, Trialing:
+		return true
+	case PastDue:
+		return s.GraceUntil != nil && now.Before(*s.GraceUntil)
+	default:
+		return false
+	}
+}
+
+func (s State) String() string {
+	if int(s) < len(StateNames) {
+		return StateNames[s]
+	}
+	return "Unknown"
+}
+
+// ParseState maps a state name from StateNames (case-insensitive) to its State.
+func ParseState(name string) (State, bool) {
+	for i, n := range StateNames {
+		if strings.EqualFold(n, name) {
+			return State(i), true
+		}
+	}
+	return 0, false
+}
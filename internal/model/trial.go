@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Trial records that a user started the free trial of a product family, so
+// they don't get a second one.
+type Trial struct {
+	ID             uint      `gorm:"primarykey"`
+	UserID         uint      `gorm:"type:bigint;not null;uniqueIndex:idx_trial_user_family"`
+	Family         string    `gorm:"not null;type:varchar(60);uniqueIndex:idx_trial_user_family"`
+	SubscriptionID uint      `gorm:"type:bigint;not null"`
+	StartedAt      time.Time `gorm:"not null"`
+}
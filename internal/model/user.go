@@ -2,8 +2,51 @@ package model
 
 import "gorm.io/gorm"
 
+// Role decides what a user may do besides managing their own subscriptions.
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleSupport  Role = "support"
+	RoleAdmin    Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleCustomer, RoleSupport, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	gorm.Model
 	Name  string `gorm:"not null;type:varchar(100)"`
 	Email string `gorm:"not null;unique;type:varchar(100)"`
+	Role  Role   `gorm:"not null;type:varchar(20);default:customer"`
+	// BillingCurrency is the ISO 4217 currency the user is charged in unless
+	// a purchase asks for another one.
+	BillingCurrency string `gorm:"not null;type:varchar(3);default:USD"`
+	// Billing address and tax status, which decide the tax the user pays.
+	// Users with a TaxID buy as businesses.
+	BillingCountry string `gorm:"type:varchar(2)"` // ISO 3166-1 alpha-2
+	BillingRegion  string `gorm:"type:varchar(10)"`
+	TaxID          string `gorm:"type:varchar(50)"`
+	TaxExempt      bool   `gorm:"not null;default:false"`
+	// PaymentMethod is the payment processor's token of the card the user
+	// saved. Charges the user doesn't start, like converting a trial, need
+	// one.
+	PaymentMethod string `gorm:"type:varchar(255)"`
+	// PasswordHash is the bcrypt hash of the password. Users without one
+	// can't log in.
+	PasswordHash string `gorm:"type:varchar(60)"`
+}
+
+// Currency is the user's billing currency, DefaultCurrency if they didn't
+// choose one.
+func (u *User) Currency() string {
+	if u.BillingCurrency == "" {
+		return DefaultCurrency
+	}
+	return u.BillingCurrency
 }
@@ -0,0 +1,108 @@
+// Package money represents amounts in the minor unit of their currency and
+// calculates tax on them in integer arithmetic, so no amount ever goes
+// through a float.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCurrencyMismatch is returned when amounts of different currencies are
+// combined.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+// zeroDecimalCurrencies and threeDecimalCurrencies list the currencies whose
+// minor unit isn't a hundredth.
+var (
+	zeroDecimalCurrencies  = map[Currency]bool{"JPY": true, "KRW": true, "VND": true, "CLP": true, "ISK": true}
+	threeDecimalCurrencies = map[Currency]bool{"BHD": true, "KWD": true, "OMR": true, "JOD": true, "TND": true}
+)
+
+// Valid reports whether c has the shape of an ISO 4217 code: three
+// uppercase letters.
+func (c Currency) Valid() bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimals is the number of digits after the decimal point of the currency's
+// minor unit.
+func (c Currency) Decimals() int {
+	switch {
+	case zeroDecimalCurrencies[c]:
+		return 0
+	case threeDecimalCurrencies[c]:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// Money is an amount in the minor unit (e.g. cents) of its currency.
+type Money struct {
+	Amount   int64
+	Currency Currency
+}
+
+// New returns amount minor units of the currency.
+func New(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Add returns m + o. Both must be of the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o. Both must be of the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String formats the amount in major units followed by the currency, e.g.
+// "-10.50 USD".
+func (m Money) String() string {
+	decimals := m.Currency.Decimals()
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if decimals == 0 {
+		return fmt.Sprintf("%s%d %s", sign, amount, m.Currency)
+	}
+
+	unit := int64(1)
+	for range decimals {
+		unit *= 10
+	}
+	fraction := fmt.Sprintf("%d", amount%unit)
+	fraction = strings.Repeat("0", decimals-len(fraction)) + fraction
+	return fmt.Sprintf("%s%d.%s %s", sign, amount/unit, fraction, m.Currency)
+}
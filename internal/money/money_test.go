@@ -0,0 +1,85 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	testCases := []struct {
+		name        string
+		a, b        Money
+		sum, diff   Money
+		expectedErr error
+	}{
+		{
+			name: "same currency",
+			a:    New(1050, "USD"),
+			b:    New(75, "USD"),
+			sum:  New(1125, "USD"),
+			diff: New(975, "USD"),
+		},
+		{
+			name: "negative result",
+			a:    New(75, "EUR"),
+			b:    New(1050, "EUR"),
+			sum:  New(1125, "EUR"),
+			diff: New(-975, "EUR"),
+		},
+		{
+			name:        "different currencies",
+			a:           New(100, "USD"),
+			b:           New(100, "EUR"),
+			expectedErr: ErrCurrencyMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sum, err := tc.a.Add(tc.b)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.sum, sum)
+			}
+
+			diff, err := tc.a.Sub(tc.b)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.diff, diff)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	testCases := []struct {
+		money    Money
+		expected string
+	}{
+		{New(1050, "USD"), "10.50 USD"},
+		{New(5, "EUR"), "0.05 EUR"},
+		{New(-1999, "USD"), "-19.99 USD"},
+		{New(0, "USD"), "0.00 USD"},
+		{New(1500, "JPY"), "1500 JPY"},
+		{New(-1500, "JPY"), "-1500 JPY"},
+		{New(1005, "KWD"), "1.005 KWD"},
+		{New(42, "BHD"), "0.042 BHD"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.money.String())
+		})
+	}
+}
+
+func TestCurrencyValid(t *testing.T) {
+	for currency, valid := range map[Currency]bool{"USD": true, "JPY": true, "usd": false, "US": false, "USDT": false, "U5D": false, "": false} {
+		require.Equal(t, valid, currency.Valid(), string(currency))
+	}
+}
@@ -0,0 +1,49 @@
+package money
+
+// RoundingMode decides which way an amount exactly halfway between two minor
+// units is rounded. Amounts that aren't halfway always go to the nearest unit.
+type RoundingMode int
+
+const (
+	// HalfUp rounds halves away from zero: 0.5 becomes 1 and -0.5 becomes -1.
+	HalfUp RoundingMode = iota
+	// HalfEven rounds halves to the even neighbour (banker's rounding): 0.5
+	// becomes 0 and 1.5 becomes 2.
+	HalfEven
+)
+
+// ParseRoundingMode parses "half-up" or "half-even".
+func ParseRoundingMode(s string) (RoundingMode, bool) {
+	switch s {
+	case "half-up":
+		return HalfUp, true
+	case "half-even":
+		return HalfEven, true
+	}
+	return 0, false
+}
+
+// Divide returns num / den rounded to an integer. den must be positive.
+func (m RoundingMode) Divide(num, den int64) int64 {
+	quotient, remainder := num/den, num%den
+	if remainder == 0 {
+		return quotient
+	}
+
+	step := int64(1)
+	if num < 0 {
+		step = -1
+		remainder = -remainder
+	}
+
+	switch twice := 2 * remainder; {
+	case twice < den:
+		return quotient
+	case twice > den:
+		return quotient + step
+	case m == HalfEven && quotient%2 == 0:
+		return quotient
+	default:
+		return quotient + step
+	}
+}
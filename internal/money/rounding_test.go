@@ -0,0 +1,49 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDivide(t *testing.T) {
+	testCases := []struct {
+		name     string
+		num, den int64
+		halfUp   int64
+		halfEven int64
+	}{
+		{name: "exact", num: 300, den: 100, halfUp: 3, halfEven: 3},
+		{name: "below half", num: 249, den: 100, halfUp: 2, halfEven: 2},
+		{name: "above half", num: 251, den: 100, halfUp: 3, halfEven: 3},
+		{name: "half to even below", num: 250, den: 100, halfUp: 3, halfEven: 2},
+		{name: "half to even above", num: 350, den: 100, halfUp: 4, halfEven: 4},
+		{name: "half of zero", num: 50, den: 100, halfUp: 1, halfEven: 0},
+		{name: "negative below half", num: -249, den: 100, halfUp: -2, halfEven: -2},
+		{name: "negative above half", num: -251, den: 100, halfUp: -3, halfEven: -3},
+		{name: "negative half", num: -250, den: 100, halfUp: -3, halfEven: -2},
+		{name: "negative half of zero", num: -50, den: 100, halfUp: -1, halfEven: 0},
+		{name: "odd denominator", num: 7, den: 3, halfUp: 2, halfEven: 2},
+		{name: "just below half of odd denominator", num: 10, den: 21, halfUp: 0, halfEven: 0},
+		{name: "just above half of odd denominator", num: 11, den: 21, halfUp: 1, halfEven: 1},
+		{name: "large amount", num: 1_000_000_000_005 * 10, den: 100, halfUp: 100_000_000_001, halfEven: 100_000_000_000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.halfUp, HalfUp.Divide(tc.num, tc.den))
+			require.Equal(t, tc.halfEven, HalfEven.Divide(tc.num, tc.den))
+		})
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	mode, ok := ParseRoundingMode("half-up")
+	require.True(t, ok)
+	require.Equal(t, HalfUp, mode)
+	mode, ok = ParseRoundingMode("half-even")
+	require.True(t, ok)
+	require.Equal(t, HalfEven, mode)
+	_, ok = ParseRoundingMode("bankers")
+	require.False(t, ok)
+}
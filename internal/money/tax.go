@@ -0,0 +1,201 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNoLines is returned when tax is calculated for an empty list of lines.
+var ErrNoLines = errors.New("no lines to calculate tax for")
+
+// Rate is a tax rate in basis points (hundredths of a percent), so 8.1% is
+// 810. Rates must not be negative.
+type Rate int64
+
+// basisPoints is 100%.
+const basisPoints = 10000
+
+// Percent returns a rate of whole percents.
+func Percent(p uint8) Rate {
+	return Rate(p) * 100
+}
+
+// String formats the rate as a percentage, e.g. "8.1%".
+func (r Rate) String() string {
+	if r%100 == 0 {
+		return fmt.Sprintf("%d%%", r/100)
+	}
+	if r%10 == 0 {
+		return fmt.Sprintf("%d.%d%%", r/100, r%100/10)
+	}
+	return fmt.Sprintf("%d.%02d%%", r/100, r%100)
+}
+
+// Of returns the rate's share of amount, e.g. a percentage discount, rounded
+// to a minor unit.
+func (r Rate) Of(amount int64, rounding RoundingMode) int64 {
+	return rounding.Divide(amount*int64(r), basisPoints)
+}
+
+// ParseRate parses a percentage with up to two decimals, e.g. "19" or
+// "7.25".
+func ParseRate(s string) (Rate, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" || len(fraction) > 2 || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("invalid tax rate %q", s)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	percent, err := strconv.ParseInt(whole, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tax rate %q", s)
+	}
+	hundredths, err := strconv.ParseUint(fraction, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tax rate %q", s)
+	}
+	return Rate(percent*100 + int64(hundredths)), nil
+}
+
+// MarshalJSON writes the rate as a percentage, e.g. 8.1.
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(strings.TrimSuffix(r.String(), "%")), nil
+}
+
+// UnmarshalJSON reads a percentage written by MarshalJSON.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	rate, err := ParseRate(string(data))
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
+}
+
+// RoundingLevel decides where tax is rounded to minor units.
+type RoundingLevel int
+
+const (
+	// PerLine rounds the tax of every line on its own; the total is the sum
+	// of the rounded lines.
+	PerLine RoundingLevel = iota
+	// PerInvoice rounds the tax once per rate over the sum of the lines with
+	// that rate. The rounded total is spread back over the lines, so lines
+	// still add up to it.
+	PerInvoice
+)
+
+// ParseRoundingLevel parses "line" or "invoice".
+func ParseRoundingLevel(s string) (RoundingLevel, bool) {
+	switch s {
+	case "line":
+		return PerLine, true
+	case "invoice":
+		return PerInvoice, true
+	}
+	return 0, false
+}
+
+// TaxPolicy describes how prices are taxed.
+type TaxPolicy struct {
+	// Inclusive means prices already include tax, which is then carved out
+	// of them. Otherwise tax is added on top.
+	Inclusive bool
+	Rounding  RoundingMode
+	Level     RoundingLevel
+}
+
+// DefaultTaxPolicy adds tax on top of the price and rounds each line half
+// up.
+var DefaultTaxPolicy = TaxPolicy{Rounding: HalfUp, Level: PerLine}
+
+// Line is a price taxed at a rate.
+type Line struct {
+	Price Money
+	Rate  Rate
+}
+
+// TaxedLine is the breakdown of a line. Net + Tax is always Gross.
+type TaxedLine struct {
+	Rate  Rate
+	Net   Money
+	Tax   Money
+	Gross Money
+}
+
+// Totals is the breakdown of every line and their sums.
+type Totals struct {
+	Lines []TaxedLine
+	Net   Money
+	Tax   Money
+	Gross Money
+}
+
+// Apply taxes a single price.
+func (p TaxPolicy) Apply(price Money, rate Rate) TaxedLine {
+	// a single line is of one currency, so this can't fail
+	totals, _ := p.Calculate([]Line{{Price: price, Rate: rate}})
+	return totals.Lines[0]
+}
+
+// Calculate taxes every line. All lines must be of the same currency.
+func (p TaxPolicy) Calculate(lines []Line) (Totals, error) {
+	if len(lines) == 0 {
+		return Totals{}, ErrNoLines
+	}
+
+	currency := lines[0].Price.Currency
+	totals := Totals{
+		Lines: make([]TaxedLine, len(lines)),
+		Net:   New(0, currency),
+		Tax:   New(0, currency),
+		Gross: New(0, currency),
+	}
+
+	// exact (unrounded) tax so far and its rounded value, per rate
+	exact := make(map[Rate]int64)
+	rounded := make(map[Rate]int64)
+
+	for i, line := range lines {
+		if line.Price.Currency != currency {
+			return Totals{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, currency, line.Price.Currency)
+		}
+
+		// tax is price * rate / den exactly: on top of a net price, or the
+		// share of a gross price that is tax
+		num := line.Price.Amount * int64(line.Rate)
+		den := int64(basisPoints)
+		if p.Inclusive {
+			den += int64(line.Rate)
+		}
+
+		var tax int64
+		if p.Level == PerInvoice {
+			exact[line.Rate] += num
+			total := p.Rounding.Divide(exact[line.Rate], den)
+			tax = total - rounded[line.Rate]
+			rounded[line.Rate] = total
+		} else {
+			tax = p.Rounding.Divide(num, den)
+		}
+
+		net, gross := line.Price.Amount, line.Price.Amount+tax
+		if p.Inclusive {
+			net, gross = line.Price.Amount-tax, line.Price.Amount
+		}
+
+		totals.Lines[i] = TaxedLine{
+			Rate:  line.Rate,
+			Net:   New(net, currency),
+			Tax:   New(tax, currency),
+			Gross: New(gross, currency),
+		}
+		totals.Net.Amount += net
+		totals.Tax.Amount += tax
+		totals.Gross.Amount += gross
+	}
+
+	return totals, nil
+}
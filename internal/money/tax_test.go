@@ -0,0 +1,339 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func usd(amount int64) Money {
+	return New(amount, "USD")
+}
+
+func TestApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   TaxPolicy
+		price    Money
+		rate     Rate
+		expected TaxedLine
+	}{
+		{
+			name:     "exclusive",
+			policy:   DefaultTaxPolicy,
+			price:    usd(1000),
+			rate:     Percent(10),
+			expected: TaxedLine{Rate: 1000, Net: usd(1000), Tax: usd(100), Gross: usd(1100)},
+		},
+		{
+			name:     "exclusive rounds up",
+			policy:   DefaultTaxPolicy,
+			price:    usd(999),
+			rate:     Percent(15),
+			expected: TaxedLine{Rate: 1500, Net: usd(999), Tax: usd(150), Gross: usd(1149)},
+		},
+		{
+			name:     "exclusive rounds down",
+			policy:   DefaultTaxPolicy,
+			price:    usd(1999),
+			rate:     Percent(5),
+			expected: TaxedLine{Rate: 500, Net: usd(1999), Tax: usd(100), Gross: usd(2099)},
+		},
+		{
+			name:     "fractional rate",
+			policy:   DefaultTaxPolicy,
+			price:    usd(1999),
+			rate:     810,
+			expected: TaxedLine{Rate: 810, Net: usd(1999), Tax: usd(162), Gross: usd(2161)},
+		},
+		{
+			name:     "zero rate",
+			policy:   DefaultTaxPolicy,
+			price:    usd(1999),
+			rate:     0,
+			expected: TaxedLine{Rate: 0, Net: usd(1999), Tax: usd(0), Gross: usd(1999)},
+		},
+		{
+			name:     "exclusive half up",
+			policy:   TaxPolicy{Rounding: HalfUp},
+			price:    usd(250),
+			rate:     Percent(1),
+			expected: TaxedLine{Rate: 100, Net: usd(250), Tax: usd(3), Gross: usd(253)},
+		},
+		{
+			name:     "exclusive half even",
+			policy:   TaxPolicy{Rounding: HalfEven},
+			price:    usd(250),
+			rate:     Percent(1),
+			expected: TaxedLine{Rate: 100, Net: usd(250), Tax: usd(2), Gross: usd(252)},
+		},
+		{
+			name:     "negative price half up",
+			policy:   TaxPolicy{Rounding: HalfUp},
+			price:    usd(-250),
+			rate:     Percent(1),
+			expected: TaxedLine{Rate: 100, Net: usd(-250), Tax: usd(-3), Gross: usd(-253)},
+		},
+		{
+			name:     "negative price half even",
+			policy:   TaxPolicy{Rounding: HalfEven},
+			price:    usd(-250),
+			rate:     Percent(1),
+			expected: TaxedLine{Rate: 100, Net: usd(-250), Tax: usd(-2), Gross: usd(-252)},
+		},
+		{
+			name:     "inclusive",
+			policy:   TaxPolicy{Inclusive: true},
+			price:    usd(1200),
+			rate:     Percent(20),
+			expected: TaxedLine{Rate: 2000, Net: usd(1000), Tax: usd(200), Gross: usd(1200)},
+		},
+		{
+			name:     "inclusive rounds",
+			policy:   TaxPolicy{Inclusive: true},
+			price:    usd(1000),
+			rate:     Percent(20),
+			expected: TaxedLine{Rate: 2000, Net: usd(833), Tax: usd(167), Gross: usd(1000)},
+		},
+		{
+			name:     "inclusive half up",
+			policy:   TaxPolicy{Inclusive: true, Rounding: HalfUp},
+			price:    usd(15),
+			rate:     Percent(20),
+			expected: TaxedLine{Rate: 2000, Net: usd(12), Tax: usd(3), Gross: usd(15)},
+		},
+		{
+			name:     "inclusive half even",
+			policy:   TaxPolicy{Inclusive: true, Rounding: HalfEven},
+			price:    usd(15),
+			rate:     Percent(20),
+			expected: TaxedLine{Rate: 2000, Net: usd(13), Tax: usd(2), Gross: usd(15)},
+		},
+		{
+			name:     "zero decimal currency",
+			policy:   DefaultTaxPolicy,
+			price:    New(1250, "JPY"),
+			rate:     Percent(10),
+			expected: TaxedLine{Rate: 1000, Net: New(1250, "JPY"), Tax: New(125, "JPY"), Gross: New(1375, "JPY")},
+		},
+		{
+			name:     "large amount",
+			policy:   DefaultTaxPolicy,
+			price:    usd(1_000_000_000_000),
+			rate:     Percent(25),
+			expected: TaxedLine{Rate: 2500, Net: usd(1_000_000_000_000), Tax: usd(250_000_000_000), Gross: usd(1_250_000_000_000)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.policy.Apply(tc.price, tc.rate))
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	// 10 cents at 15% is 1.5 cents of tax, exactly halfway
+	halves := []Line{
+		{Price: usd(10), Rate: Percent(15)},
+		{Price: usd(10), Rate: Percent(15)},
+		{Price: usd(10), Rate: Percent(15)},
+	}
+
+	testCases := []struct {
+		name        string
+		policy      TaxPolicy
+		lines       []Line
+		expectedTax []int64
+		expectedErr error
+	}{
+		{
+			name:        "per line half up",
+			policy:      TaxPolicy{Rounding: HalfUp, Level: PerLine},
+			lines:       halves,
+			expectedTax: []int64{2, 2, 2},
+		},
+		{
+			name:        "per line half even",
+			policy:      TaxPolicy{Rounding: HalfEven, Level: PerLine},
+			lines:       halves,
+			expectedTax: []int64{2, 2, 2},
+		},
+		{
+			name:        "per invoice half up",
+			policy:      TaxPolicy{Rounding: HalfUp, Level: PerInvoice},
+			lines:       halves,
+			expectedTax: []int64{2, 1, 2},
+		},
+		{
+			name:        "per invoice half even",
+			policy:      TaxPolicy{Rounding: HalfEven, Level: PerInvoice},
+			lines:       halves,
+			expectedTax: []int64{2, 1, 1},
+		},
+		{
+			name:   "per invoice rounds each rate on its own",
+			policy: TaxPolicy{Rounding: HalfUp, Level: PerInvoice},
+			lines: []Line{
+				{Price: usd(10), Rate: Percent(15)},
+				{Price: usd(10), Rate: Percent(5)},
+				{Price: usd(10), Rate: Percent(15)},
+			},
+			expectedTax: []int64{2, 1, 1},
+		},
+		{
+			name:   "per invoice with a discount line",
+			policy: TaxPolicy{Rounding: HalfUp, Level: PerInvoice},
+			lines: []Line{
+				{Price: usd(999), Rate: Percent(15)},
+				{Price: usd(-99), Rate: Percent(15)},
+			},
+			expectedTax: []int64{150, -15},
+		},
+		{
+			name:   "per line with a discount line",
+			policy: TaxPolicy{Rounding: HalfUp, Level: PerLine},
+			lines: []Line{
+				{Price: usd(999), Rate: Percent(15)},
+				{Price: usd(-99), Rate: Percent(15)},
+			},
+			expectedTax: []int64{150, -15},
+		},
+		{
+			name:   "per invoice inclusive",
+			policy: TaxPolicy{Inclusive: true, Rounding: HalfUp, Level: PerInvoice},
+			lines: []Line{
+				{Price: usd(1000), Rate: Percent(20)},
+				{Price: usd(1000), Rate: Percent(20)},
+				{Price: usd(1000), Rate: Percent(20)},
+			},
+			// 166.67 each, 500 in total
+			expectedTax: []int64{167, 166, 167},
+		},
+		{
+			name:   "per line inclusive",
+			policy: TaxPolicy{Inclusive: true, Rounding: HalfUp, Level: PerLine},
+			lines: []Line{
+				{Price: usd(1000), Rate: Percent(20)},
+				{Price: usd(1000), Rate: Percent(20)},
+				{Price: usd(1000), Rate: Percent(20)},
+			},
+			expectedTax: []int64{167, 167, 167},
+		},
+		{
+			name:   "mixed currencies",
+			policy: DefaultTaxPolicy,
+			lines: []Line{
+				{Price: usd(1000), Rate: Percent(20)},
+				{Price: New(1000, "EUR"), Rate: Percent(20)},
+			},
+			expectedErr: ErrCurrencyMismatch,
+		},
+		{
+			name:        "no lines",
+			policy:      DefaultTaxPolicy,
+			expectedErr: ErrNoLines,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			totals, err := tc.policy.Calculate(tc.lines)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, totals.Lines, len(tc.lines))
+
+			var price, net, tax, gross int64
+			for i, line := range totals.Lines {
+				require.Equal(t, tc.expectedTax[i], line.Tax.Amount, "tax of line %d", i)
+				require.Equal(t, line.Gross.Amount, line.Net.Amount+line.Tax.Amount)
+				if tc.policy.Inclusive {
+					require.Equal(t, tc.lines[i].Price, line.Gross)
+				} else {
+					require.Equal(t, tc.lines[i].Price, line.Net)
+				}
+				price += tc.lines[i].Price.Amount
+				net += line.Net.Amount
+				tax += line.Tax.Amount
+				gross += line.Gross.Amount
+			}
+			require.Equal(t, usd(net), totals.Net)
+			require.Equal(t, usd(tax), totals.Tax)
+			require.Equal(t, usd(gross), totals.Gross)
+			if tc.policy.Inclusive {
+				require.Equal(t, price, gross)
+			} else {
+				require.Equal(t, price, net)
+			}
+		})
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected Rate
+		invalid  bool
+	}{
+		{input: "19", expected: 1900},
+		{input: "0", expected: 0},
+		{input: "7.25", expected: 725},
+		{input: "8.1", expected: 810},
+		{input: "0.05", expected: 5},
+		{input: "100", expected: 10000},
+		{input: "7.255", invalid: true},
+		{input: "-5", invalid: true},
+		{input: "+5", invalid: true},
+		{input: ".5", invalid: true},
+		{input: "5.", expected: 500},
+		{input: "1e2", invalid: true},
+		{input: `"19"`, invalid: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			rate, err := ParseRate(tc.input)
+			if tc.invalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, rate)
+		})
+	}
+}
+
+func TestRateJSON(t *testing.T) {
+	var rates map[string]Rate
+	require.NoError(t, json.Unmarshal([]byte(`{"DE":19,"CH":8.1,"US-CA":7.25}`), &rates))
+	require.Equal(t, map[string]Rate{"DE": 1900, "CH": 810, "US-CA": 725}, rates)
+
+	data, err := json.Marshal(rates)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"DE":19,"CH":8.1,"US-CA":7.25}`, string(data))
+}
+
+func TestRateOf(t *testing.T) {
+	require.Equal(t, int64(250), Percent(25).Of(1000, HalfUp))
+	require.Equal(t, int64(1000), Percent(100).Of(1000, HalfUp))
+	// 12.5% of 999 is 124.875
+	require.Equal(t, int64(125), Rate(1250).Of(999, HalfUp))
+	// 50% of 5 is 2.5
+	require.Equal(t, int64(3), Percent(50).Of(5, HalfUp))
+	require.Equal(t, int64(2), Percent(50).Of(5, HalfEven))
+}
+
+func TestParseRoundingLevel(t *testing.T) {
+	level, ok := ParseRoundingLevel("line")
+	require.True(t, ok)
+	require.Equal(t, PerLine, level)
+	level, ok = ParseRoundingLevel("invoice")
+	require.True(t, ok)
+	require.Equal(t, PerInvoice, level)
+	_, ok = ParseRoundingLevel("total")
+	require.False(t, ok)
+}
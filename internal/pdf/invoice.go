@@ -0,0 +1,280 @@
+// Package pdf renders invoices to PDF with the pure Go fpdf library, so no
+// external tool is needed to produce documents.
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/tax"
+)
+
+// ErrInvalidBranding is returned for a logo or color that can't be used.
+var ErrInvalidBranding = errors.New("invalid branding")
+
+// Branding is how the company looks on its documents. The seller's name and
+// address come from the invoice itself, as they were when it was issued.
+type Branding struct {
+	// Logo is a PNG, JPEG or GIF file printed at the top left.
+	Logo string
+	// Color is the accent color of headings and the line table, as #rrggbb.
+	Color string
+	// Footer is printed at the bottom of every page, e.g. bank details or
+	// the company registration.
+	Footer string
+}
+
+// DefaultColor is the accent color without branding.
+const DefaultColor = "#1f2937"
+
+// Validate checks that the logo can be read and the color parsed, so
+// misconfiguration shows up at startup rather than on the first download.
+func (b Branding) Validate() error {
+	if b.Logo != "" {
+		if imageType(b.Logo) == "" {
+			return fmt.Errorf("%w: logo must be a PNG, JPEG or GIF file", ErrInvalidBranding)
+		}
+		if _, err := os.Stat(b.Logo); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBranding, err)
+		}
+	}
+	if _, _, _, err := parseColor(b.color()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b Branding) color() string {
+	if b.Color == "" {
+		return DefaultColor
+	}
+	return b.Color
+}
+
+const (
+	pageMargin = 15.0
+	lineHeight = 5.0
+)
+
+// lineColumns are the widths of the line table columns in mm; they add up
+// to the printable width of an A4 page.
+var lineColumns = []float64{64, 42, 22, 16, 18, 18}
+
+// RenderInvoice writes the invoice as a one or more page A4 PDF. The
+// invoice is rendered as issued; re-rendering it gives the same document
+// apart from the branding in effect.
+func RenderInvoice(w io.Writer, invoice *model.Invoice, branding Branding) error {
+	r, g, b, err := parseColor(branding.color())
+	if err != nil {
+		return err
+	}
+
+	doc := fpdf.New("P", "mm", "A4", "")
+	doc.SetMargins(pageMargin, pageMargin, pageMargin)
+	doc.SetAutoPageBreak(true, pageMargin+10)
+	doc.SetTitle("Invoice "+invoice.Number, false)
+	doc.SetAuthor(invoice.SellerName, true)
+	doc.SetCreator("Subserv", false)
+	doc.SetCreationDate(invoice.IssuedAt)
+	doc.SetModificationDate(invoice.IssuedAt)
+	doc.AliasNbPages("")
+	// the core fonts are cp1252 encoded
+	text := doc.UnicodeTranslatorFromDescriptor("")
+
+	doc.SetFooterFunc(func() {
+		doc.SetY(-pageMargin - 5)
+		doc.SetFont("Helvetica", "", 8)
+		doc.SetTextColor(107, 114, 128)
+		if branding.Footer != "" {
+			doc.MultiCell(0, 4, text(branding.Footer), "", "C", false)
+		}
+		doc.CellFormat(0, 4, fmt.Sprintf("%s - page %d of {nb}", invoice.Number, doc.PageNo()), "", 0, "C", false, 0, "")
+	})
+	doc.AddPage()
+
+	// header: logo left, document title and number right
+	top := doc.GetY()
+	if branding.Logo != "" {
+		doc.ImageOptions(branding.Logo, pageMargin, top, 0, 16, false, fpdf.ImageOptions{ImageType: imageType(branding.Logo), ReadDpi: true}, 0, "")
+	}
+	doc.SetXY(110, top)
+	doc.SetFont("Helvetica", "B", 20)
+	doc.SetTextColor(r, g, b)
+	doc.CellFormat(0, 9, "INVOICE", "", 2, "R", false, 0, "")
+	doc.SetFont("Helvetica", "", 10)
+	doc.SetTextColor(0, 0, 0)
+	doc.CellFormat(0, lineHeight, text(invoice.Number), "", 2, "R", false, 0, "")
+	doc.CellFormat(0, lineHeight, "Issued "+invoice.IssuedAt.Format("2 January 2006"), "", 2, "R", false, 0, "")
+	doc.CellFormat(0, lineHeight, fmt.Sprintf("Paid in full - payment #%d", invoice.PaymentID), "", 2, "R", false, 0, "")
+	doc.SetY(max(doc.GetY(), top+16) + 8)
+
+	// seller and buyer side by side
+	partiesTop := doc.GetY()
+	party(doc, text, pageMargin, "From", []string{
+		invoice.SellerName,
+		invoice.SellerAddress,
+		invoice.SellerCountry,
+		taxIDLine(invoice.SellerTaxID),
+	}, r, g, b)
+	sellerBottom := doc.GetY()
+	doc.SetY(partiesTop)
+	party(doc, text, 110, "Bill to", []string{
+		invoice.BuyerName,
+		invoice.BuyerEmail,
+		strings.Trim(invoice.BuyerCountry+" "+invoice.BuyerRegion, " "),
+		taxIDLine(invoice.BuyerTaxID),
+	}, r, g, b)
+	doc.SetY(max(sellerBottom, doc.GetY()) + 8)
+
+	// lines
+	currency := money.Currency(invoice.Currency)
+	doc.SetFont("Helvetica", "B", 9)
+	doc.SetFillColor(r, g, b)
+	doc.SetTextColor(255, 255, 255)
+	for i, heading := range []string{"Description", "Period", "Net", "Tax rate", "Tax", "Total"} {
+		align := "R"
+		if i < 2 {
+			align = "L"
+		}
+		doc.CellFormat(lineColumns[i], 7, heading, "", 0, align, true, 0, "")
+	}
+	doc.Ln(-1)
+
+	doc.SetFont("Helvetica", "", 9)
+	doc.SetTextColor(0, 0, 0)
+	for _, line := range invoice.Lines {
+		cells := []string{
+			text(line.Description),
+			period(line),
+			amount(line.NetCent, currency),
+			line.TaxRate.String(),
+			amount(line.TaxCent, currency),
+			amount(line.TotalCent, currency),
+		}
+		for i, cell := range cells {
+			align := "R"
+			if i < 2 {
+				align = "L"
+			}
+			doc.CellFormat(lineColumns[i], 7, fit(doc, cell, lineColumns[i]), "B", 0, align, false, 0, "")
+		}
+		doc.Ln(-1)
+	}
+	doc.Ln(4)
+
+	// totals and tax breakdown, right aligned
+	totals := [][2]string{{"Net", amount(invoice.NetCent, currency)}}
+	for _, group := range invoice.TaxBreakdown() {
+		totals = append(totals, [2]string{
+			fmt.Sprintf("Tax %s of %s", group.Rate, amount(group.NetCent, currency)),
+			amount(group.TaxCent, currency),
+		})
+	}
+	for _, total := range totals {
+		doc.SetX(95)
+		doc.CellFormat(70, lineHeight+1, total[0], "", 0, "R", false, 0, "")
+		doc.CellFormat(0, lineHeight+1, total[1], "", 1, "R", false, 0, "")
+	}
+	doc.SetX(95)
+	doc.SetFont("Helvetica", "B", 11)
+	doc.CellFormat(70, 8, "Total "+invoice.Currency, "T", 0, "R", false, 0, "")
+	doc.CellFormat(0, 8, amount(invoice.TotalCent, currency), "T", 1, "R", false, 0, "")
+
+	if note := tax.Reason(invoice.TaxReason).Note(); note != "" {
+		doc.Ln(4)
+		doc.SetFont("Helvetica", "I", 9)
+		doc.MultiCell(0, lineHeight, text(note), "", "L", false)
+	}
+	if invoice.TaxCountry != "" {
+		doc.SetFont("Helvetica", "", 8)
+		doc.SetTextColor(107, 114, 128)
+		jurisdiction := strings.Trim(invoice.TaxCountry+"-"+invoice.TaxRegion, "-")
+		doc.MultiCell(0, lineHeight, "Place of supply: "+jurisdiction, "", "L", false)
+	}
+
+	if err := doc.Output(w); err != nil {
+		return fmt.Errorf("couldn't render invoice %s: %w", invoice.Number, err)
+	}
+	return nil
+}
+
+// party prints a titled block of non-empty lines at x.
+func party(doc *fpdf.Fpdf, text func(string) string, x float64, title string, lines []string, r, g, b int) {
+	doc.SetX(x)
+	doc.SetFont("Helvetica", "B", 9)
+	doc.SetTextColor(r, g, b)
+	doc.CellFormat(85, lineHeight, title, "", 2, "L", false, 0, "")
+	doc.SetFont("Helvetica", "", 10)
+	doc.SetTextColor(0, 0, 0)
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		doc.SetX(x)
+		doc.MultiCell(85, lineHeight, text(line), "", "L", false)
+	}
+}
+
+func taxIDLine(taxID string) string {
+	if taxID == "" {
+		return ""
+	}
+	return "Tax ID " + taxID
+}
+
+func period(line model.InvoiceLine) string {
+	if line.PeriodStart == nil || line.PeriodEnd == nil {
+		return ""
+	}
+	return line.PeriodStart.Format("2 Jan 06") + " - " + line.PeriodEnd.Format("2 Jan 06")
+}
+
+// amount formats minor units in major units without the currency, which is
+// printed with the total.
+func amount(minor int, currency money.Currency) string {
+	s := money.New(int64(minor), currency).String()
+	return strings.TrimSuffix(s, " "+string(currency))
+}
+
+// fit shortens s with an ellipsis until it fits a cell of the given width.
+func fit(doc *fpdf.Fpdf, s string, width float64) string {
+	width -= 2 * doc.GetCellMargin()
+	if doc.GetStringWidth(s) <= width {
+		return s
+	}
+	for len(s) > 0 && doc.GetStringWidth(s+"...") > width {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
+
+func imageType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "PNG"
+	case ".jpg", ".jpeg":
+		return "JPG"
+	case ".gif":
+		return "GIF"
+	}
+	return ""
+}
+
+func parseColor(hex string) (int, int, int, error) {
+	if len(hex) != 7 || hex[0] != '#' {
+		return 0, 0, 0, fmt.Errorf("%w: color %q must be #rrggbb", ErrInvalidBranding, hex)
+	}
+	rgb, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: color %q must be #rrggbb", ErrInvalidBranding, hex)
+	}
+	return int(rgb >> 16), int(rgb >> 8 & 0xff), int(rgb & 0xff), nil
+}
@@ -0,0 +1,96 @@
+package pdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"gorm.io/gorm"
+)
+
+func testLogo(t *testing.T) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			img.Set(x, y, color.RGBA{R: 31, G: 111, B: 235, A: 255})
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "logo.png")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, png.Encode(f, img))
+	return path
+}
+
+func TestRenderInvoice(t *testing.T) {
+	issuedAt := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	periodEnd := issuedAt.Add(time.Hour * 24 * 30)
+	invoice := &model.Invoice{
+		Model:         gorm.Model{ID: 3},
+		Number:        "INV-2025-000003",
+		PaymentID:     7,
+		Currency:      "EUR",
+		SellerName:    "Subserv GmbH",
+		SellerAddress: "Hauptstraße 1, 10115 Berlin",
+		SellerCountry: "DE",
+		SellerTaxID:   "DE136695976",
+		BuyerName:     "Zoë Müller",
+		BuyerEmail:    "zoe@d.com",
+		BuyerCountry:  "NL",
+		TaxCountry:    "NL",
+		TaxReason:     "taxed",
+		NetCent:       3500,
+		TaxCent:       735,
+		TotalCent:     4235,
+		IssuedAt:      issuedAt,
+		Lines: []model.InvoiceLine{
+			{Position: 1, Description: "Pro Plan", PeriodStart: &issuedAt, PeriodEnd: &periodEnd, NetCent: 5000, TaxRate: money.Percent(21), TaxCent: 1050, TotalCent: 6050},
+			{Position: 2, Description: "Unused time on a plan with a name far too long to fit its column", NetCent: -1500, TaxRate: money.Percent(21), TaxCent: -315, TotalCent: -1815},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		branding    Branding
+		expectedErr error
+	}{
+		{name: "without branding"},
+		{name: "with logo, color and footer", branding: Branding{Logo: testLogo(t), Color: "#1f6feb", Footer: "IBAN DE00 0000 0000 0000 0000 00 - Amtsgericht Berlin HRB 0000"}},
+		{name: "invalid color", branding: Branding{Color: "blue"}, expectedErr: ErrInvalidBranding},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := RenderInvoice(&buf, invoice, tc.branding)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.True(t, bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")))
+			require.Contains(t, buf.String(), "/Title (Invoice INV-2025-000003)")
+			require.Contains(t, buf.String(), "/CreationDate (D:20250310120000")
+		})
+	}
+}
+
+func TestBrandingValidate(t *testing.T) {
+	require.NoError(t, Branding{}.Validate())
+	require.NoError(t, Branding{Logo: testLogo(t), Color: "#ffffff"}.Validate())
+	require.ErrorIs(t, Branding{Logo: "logo.svg"}.Validate(), ErrInvalidBranding)
+	require.ErrorIs(t, Branding{Logo: filepath.Join(t.TempDir(), "missing.png")}.Validate(), ErrInvalidBranding)
+	require.ErrorIs(t, Branding{Color: "#12345g"}.Validate(), ErrInvalidBranding)
+}
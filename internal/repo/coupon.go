@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+// ErrCouponLimitReached is returned when a redemption would exceed a
+// coupon's MaxRedemptions or MaxPerUser.
+var ErrCouponLimitReached = errors.New("coupon redemption limit reached")
+
+type CouponFilter struct {
+	BeforeID uint
+	Limit    int
+}
+
+type CouponRepository interface {
+	GetByID(ctx context.Context, ID uint) (*model.Coupon, error)
+	// GetByIDWithArchived also finds archived coupons, whose discount
+	// subscriptions keep.
+	GetByIDWithArchived(ctx context.Context, ID uint) (*model.Coupon, error)
+	// GetByCode finds an active coupon by its upper case code.
+	GetByCode(ctx context.Context, code string) (*model.Coupon, error)
+	// List returns active coupons, newest first.
+	List(ctx context.Context, filter CouponFilter) ([]model.Coupon, error)
+	Create(ctx context.Context, coupon *model.Coupon) error
+	// Archive soft-deletes the coupon. It reports false if there was no
+	// active coupon with that ID.
+	Archive(ctx context.Context, ID uint) (bool, error)
+	// CountRedemptions counts the user's redemptions of the coupon.
+	CountRedemptions(ctx context.Context, couponID uint, userID uint) (int64, error)
+	// Redeem records a redemption of the active coupon by the user. Both
+	// limits are checked in the same transaction, so concurrent redemptions
+	// can't exceed them; ErrCouponLimitReached is returned if one is
+	// reached.
+	Redeem(ctx context.Context, coupon *model.Coupon, userID uint, at time.Time) error
+	// Release removes the user's latest redemption of the coupon.
+	Release(ctx context.Context, couponID uint, userID uint) error
+}
+
+type couponRepository struct {
+	db *gorm.DB
+}
+
+func NewCouponRepository(db *gorm.DB) CouponRepository {
+	return &couponRepository{db: db}
+}
+
+func (r *couponRepository) GetByID(ctx context.Context, ID uint) (*model.Coupon, error) {
+	var coupon model.Coupon
+	if err := r.db.WithContext(ctx).Preload("Products").First(&coupon, ID).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func (r *couponRepository) GetByIDWithArchived(ctx context.Context, ID uint) (*model.Coupon, error) {
+	var coupon model.Coupon
+	if err := r.db.WithContext(ctx).Unscoped().Preload("Products").First(&coupon, ID).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func (r *couponRepository) GetByCode(ctx context.Context, code string) (*model.Coupon, error) {
+	var coupon model.Coupon
+	if err := r.db.WithContext(ctx).Preload("Products").Where("code = ?", code).First(&coupon).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func (r *couponRepository) List(ctx context.Context, filter CouponFilter) ([]model.Coupon, error) {
+	db := r.db.WithContext(ctx).Preload("Products")
+	if filter.BeforeID != 0 {
+		db = db.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+
+	var coupons []model.Coupon
+	if err := db.Order("id DESC").Find(&coupons).Error; err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+func (r *couponRepository) Create(ctx context.Context, coupon *model.Coupon) error {
+	if err := r.db.WithContext(ctx).Create(coupon).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *couponRepository) Archive(ctx context.Context, ID uint) (bool, error) {
+	res := r.db.WithContext(ctx).Delete(&model.Coupon{}, ID)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *couponRepository) CountRedemptions(ctx context.Context, couponID uint, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.CouponRedemption{}).
+		Where("coupon_id = ? AND user_id = ?", couponID, userID).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *couponRepository) Redeem(ctx context.Context, coupon *model.Coupon, userID uint, at time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the counter write locks the coupon, so the per-user count below
+		// can't race with another redemption
+		res := tx.Model(&model.Coupon{}).
+			Where("id = ? AND (max_redemptions = 0 OR times_redeemed < max_redemptions)", coupon.ID).
+			Update("times_redeemed", gorm.Expr("times_redeemed + 1"))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrCouponLimitReached
+		}
+
+		if coupon.MaxPerUser > 0 {
+			var count int64
+			err := tx.Model(&model.CouponRedemption{}).
+				Where("coupon_id = ? AND user_id = ?", coupon.ID, userID).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count >= int64(coupon.MaxPerUser) {
+				return ErrCouponLimitReached
+			}
+		}
+
+		return tx.Create(&model.CouponRedemption{CouponID: coupon.ID, UserID: userID, RedeemedAt: at}).Error
+	})
+}
+
+func (r *couponRepository) Release(ctx context.Context, couponID uint, userID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var redemption model.CouponRedemption
+		err := tx.Where("coupon_id = ? AND user_id = ?", couponID, userID).Order("id DESC").First(&redemption).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&redemption).Error; err != nil {
+			return err
+		}
+
+		// archived coupons are given the redemption back too
+		return tx.Unscoped().Model(&model.Coupon{}).
+			Where("id = ? AND times_redeemed > 0", couponID).
+			Update("times_redeemed", gorm.Expr("times_redeemed - 1")).Error
+	})
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestCouponLookup(t *testing.T) {
+	ctx := context.Background()
+	r := NewCouponRepository(newTestDB(t))
+
+	coupon := &model.Coupon{
+		Code:       "SPRING25",
+		PercentOff: 2500,
+		Duration:   model.CouponOnce,
+		Products:   []model.CouponProduct{{ProductID: 2}, {ProductID: 3}},
+	}
+	require.NoError(t, r.Create(ctx, coupon))
+	require.ErrorIs(t, r.Create(ctx, &model.Coupon{Code: "SPRING25", Duration: model.CouponOnce}), gorm.ErrDuplicatedKey)
+
+	got, err := r.GetByCode(ctx, "SPRING25")
+	require.NoError(t, err)
+	require.Equal(t, coupon.ID, got.ID)
+	require.True(t, got.AppliesTo(3))
+	require.False(t, got.AppliesTo(1))
+
+	archived, err := r.Archive(ctx, coupon.ID)
+	require.NoError(t, err)
+	require.True(t, archived)
+	_, err = r.GetByCode(ctx, "SPRING25")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	require.ErrorIs(t, r.Redeem(ctx, coupon, 1, time.Now()), ErrCouponLimitReached, "archived coupons can't be redeemed")
+
+	reused := &model.Coupon{Code: "SPRING25", PercentOff: 1000, Duration: model.CouponOnce}
+	require.NoError(t, r.Create(ctx, reused), "archived codes can be reused")
+	got, err = r.GetByCode(ctx, "SPRING25")
+	require.NoError(t, err)
+	require.Equal(t, reused.ID, got.ID)
+	require.ErrorIs(t, r.Create(ctx, &model.Coupon{Code: "SPRING25", Duration: model.CouponOnce}), gorm.ErrDuplicatedKey)
+}
+
+func TestRedeemCoupon(t *testing.T) {
+	ctx := context.Background()
+	r := NewCouponRepository(newTestDB(t))
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	coupon := &model.Coupon{Code: "WELCOME", AmountOffCent: 500, Currency: "USD", Duration: model.CouponOnce, MaxRedemptions: 3, MaxPerUser: 1}
+	require.NoError(t, r.Create(ctx, coupon))
+
+	require.NoError(t, r.Redeem(ctx, coupon, 1, now))
+	require.ErrorIs(t, r.Redeem(ctx, coupon, 1, now), ErrCouponLimitReached, "one redemption per user")
+	require.NoError(t, r.Redeem(ctx, coupon, 2, now))
+
+	count, err := r.CountRedemptions(ctx, coupon.ID, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	// a released redemption can be used again
+	require.NoError(t, r.Release(ctx, coupon.ID, 1))
+	require.NoError(t, r.Redeem(ctx, coupon, 1, now))
+	require.NoError(t, r.Redeem(ctx, coupon, 3, now))
+	require.ErrorIs(t, r.Redeem(ctx, coupon, 4, now), ErrCouponLimitReached, "all redemptions used")
+
+	stored, err := r.GetByID(ctx, coupon.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(3), stored.TimesRedeemed)
+}
+
+func TestConcurrentRedemptions(t *testing.T) {
+	ctx := context.Background()
+	r := NewCouponRepository(newTestDB(t))
+
+	coupon := &model.Coupon{Code: "FLASH", PercentOff: 5000, Duration: model.CouponOnce, MaxRedemptions: 3}
+	require.NoError(t, r.Create(ctx, coupon))
+
+	const users = 8
+	var wg sync.WaitGroup
+	var redeemed, rejected atomic.Int32
+	start := make(chan struct{})
+	for i := range users {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			<-start
+			switch err := r.Redeem(ctx, coupon, userID, time.Now()); {
+			case err == nil:
+				redeemed.Add(1)
+			case errors.Is(err, ErrCouponLimitReached):
+				rejected.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(uint(i + 1))
+	}
+	close(start)
+	wg.Wait()
+
+	require.Equal(t, int32(3), redeemed.Load())
+	require.Equal(t, int32(users-3), rejected.Load())
+}
@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreditFilter narrows down the credit note and balance transaction lists.
+// Zero values match everything.
+type CreditFilter struct {
+	UserID   uint
+	BeforeID uint
+	Limit    int
+}
+
+type CreditRepository interface {
+	GetNote(ctx context.Context, ID uint) (*model.CreditNote, error)
+	// CreateNote numbers the credit note with the next number of its Series
+	// and stores it. A non-nil credit is applied to the customer balance in
+	// the same transaction and linked to the note.
+	CreateNote(ctx context.Context, note *model.CreditNote, credit *model.BalanceTransaction) error
+	// ListNotes returns credit notes, newest first.
+	ListNotes(ctx context.Context, filter CreditFilter) ([]model.CreditNote, error)
+	// GetBalance returns gorm.ErrRecordNotFound for a user who never held
+	// money in the currency.
+	GetBalance(ctx context.Context, userID uint, currency string) (*model.CustomerBalance, error)
+	ListBalances(ctx context.Context, userID uint) ([]model.CustomerBalance, error)
+	// Apply changes the balance by entry.AmountCent and records the entry
+	// with the resulting balance. A debit the balance can't cover fails with
+	// ErrInsufficientBalance and changes nothing.
+	Apply(ctx context.Context, entry *model.BalanceTransaction) error
+	// ListTransactions returns balance transactions, newest first.
+	ListTransactions(ctx context.Context, filter CreditFilter) ([]model.BalanceTransaction, error)
+}
+
+type creditRepository struct {
+	db *gorm.DB
+}
+
+func NewCreditRepository(db *gorm.DB) CreditRepository {
+	return &creditRepository{db: db}
+}
+
+func (r *creditRepository) GetNote(ctx context.Context, ID uint) (*model.CreditNote, error) {
+	var note model.CreditNote
+	if err := r.db.WithContext(ctx).First(&note, ID).Error; err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+// CreateNote shares the numbering of invoices, see invoiceRepository.Create.
+func (r *creditRepository) CreateNote(ctx context.Context, note *model.CreditNote, credit *model.BalanceTransaction) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sequence, err := nextInSeries(tx, note.Series)
+		if err != nil {
+			return err
+		}
+
+		note.Sequence = sequence
+		note.Number = documentNumber(note.Series, sequence)
+		if err := tx.Create(note).Error; err != nil {
+			return err
+		}
+
+		if credit == nil {
+			return nil
+		}
+		credit.CreditNoteID = &note.ID
+		return apply(tx, credit)
+	})
+}
+
+func (r *creditRepository) ListNotes(ctx context.Context, filter CreditFilter) ([]model.CreditNote, error) {
+	var notes []model.CreditNote
+	if err := filter.query(r.db.WithContext(ctx)).Find(&notes).Error; err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+func (r *creditRepository) GetBalance(ctx context.Context, userID uint, currency string) (*model.CustomerBalance, error) {
+	var balance model.CustomerBalance
+	if err := r.db.WithContext(ctx).First(&balance, "user_id = ? AND currency = ?", userID, currency).Error; err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+func (r *creditRepository) ListBalances(ctx context.Context, userID uint) ([]model.CustomerBalance, error) {
+	var balances []model.CustomerBalance
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("currency").Find(&balances).Error; err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+func (r *creditRepository) Apply(ctx context.Context, entry *model.BalanceTransaction) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return apply(tx, entry)
+	})
+}
+
+// apply changes the balance and records the entry inside tx. The balance
+// row stays locked until tx ends, so BalanceCent is exact even with
+// concurrent changes.
+func apply(tx *gorm.DB, entry *model.BalanceTransaction) error {
+	if entry.AmountCent >= 0 {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "currency"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"balance_cent": gorm.Expr("customer_balances.balance_cent + ?", entry.AmountCent),
+				"updated_at":   gorm.Expr("excluded.updated_at"),
+			}),
+		}).Create(&model.CustomerBalance{UserID: entry.UserID, Currency: entry.Currency, BalanceCent: entry.AmountCent}).Error
+		if err != nil {
+			return err
+		}
+	} else {
+		// the check is part of the UPDATE, so concurrent debits can't
+		// overdraw the balance
+		res := tx.Model(&model.CustomerBalance{}).
+			Where("user_id = ? AND currency = ? AND balance_cent + ? >= 0", entry.UserID, entry.Currency, entry.AmountCent).
+			Update("balance_cent", gorm.Expr("balance_cent + ?", entry.AmountCent))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrInsufficientBalance
+		}
+	}
+
+	var balance model.CustomerBalance
+	if err := tx.First(&balance, "user_id = ? AND currency = ?", entry.UserID, entry.Currency).Error; err != nil {
+		return err
+	}
+	entry.BalanceCent = balance.BalanceCent
+	return tx.Create(entry).Error
+}
+
+func (r *creditRepository) ListTransactions(ctx context.Context, filter CreditFilter) ([]model.BalanceTransaction, error) {
+	var transactions []model.BalanceTransaction
+	if err := filter.query(r.db.WithContext(ctx)).Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func (f CreditFilter) query(db *gorm.DB) *gorm.DB {
+	if f.UserID != 0 {
+		db = db.Where("user_id = ?", f.UserID)
+	}
+	if f.BeforeID != 0 {
+		db = db.Where("id < ?", f.BeforeID)
+	}
+	if f.Limit > 0 {
+		db = db.Limit(f.Limit)
+	}
+	return db.Order("id DESC")
+}
@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestCreateCreditNote(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewCreditRepository(db)
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	note := func(method model.CreditMethod, refundID *uint) *model.CreditNote {
+		return &model.CreditNote{
+			Series:         "CN-2025",
+			InvoiceID:      1,
+			InvoiceNumber:  "INV-2025-000001",
+			UserID:         1,
+			SubscriptionID: 1,
+			PaymentID:      1,
+			RefundID:       refundID,
+			Method:         method,
+			Currency:       "EUR",
+			NetCent:        1000,
+			TaxCent:        210,
+			TotalCent:      1210,
+			IssuedAt:       now,
+		}
+	}
+
+	refundID := uint(4)
+	refunded := note(model.CreditRefund, &refundID)
+	require.NoError(t, r.CreateNote(ctx, refunded, nil))
+	require.Equal(t, "CN-2025-000001", refunded.Number)
+
+	// a failed insert gives its number back
+	require.ErrorIs(t, r.CreateNote(ctx, note(model.CreditRefund, &refundID), nil), gorm.ErrDuplicatedKey)
+
+	credited := note(model.CreditBalance, nil)
+	credit := &model.BalanceTransaction{UserID: 1, Currency: "EUR", Kind: model.BalanceCredited, AmountCent: 1210}
+	require.NoError(t, r.CreateNote(ctx, credited, credit))
+	require.Equal(t, "CN-2025-000002", credited.Number)
+	require.Equal(t, credited.ID, *credit.CreditNoteID)
+	require.Equal(t, 1210, credit.BalanceCent)
+
+	got, err := r.GetNote(ctx, credited.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.CreditBalance, got.Method)
+
+	notes, err := r.ListNotes(ctx, CreditFilter{UserID: 1, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, notes, 2)
+	require.Equal(t, credited.ID, notes[0].ID)
+
+	notes[0].TotalCent = 1
+	require.ErrorIs(t, db.Save(&notes[0]).Error, model.ErrCreditNoteFinalized)
+	require.ErrorIs(t, db.Delete(&model.CreditNote{}, credited.ID).Error, model.ErrCreditNoteFinalized)
+}
+
+func TestApplyBalance(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewCreditRepository(db)
+
+	_, err := r.GetBalance(ctx, 1, "EUR")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	paymentID := uint(9)
+	entries := []*model.BalanceTransaction{
+		{UserID: 1, Currency: "EUR", Kind: model.BalanceCredited, AmountCent: 1000},
+		{UserID: 1, Currency: "EUR", Kind: model.BalanceCredited, AmountCent: 500},
+		{UserID: 1, Currency: "EUR", Kind: model.BalanceSpent, AmountCent: -1200, PaymentID: &paymentID},
+		{UserID: 1, Currency: "USD", Kind: model.BalanceCredited, AmountCent: 700},
+	}
+	for _, entry := range entries {
+		require.NoError(t, r.Apply(ctx, entry))
+	}
+	require.Equal(t, []int{1000, 1500, 300, 700}, []int{entries[0].BalanceCent, entries[1].BalanceCent, entries[2].BalanceCent, entries[3].BalanceCent})
+
+	overdraw := &model.BalanceTransaction{UserID: 1, Currency: "EUR", Kind: model.BalanceSpent, AmountCent: -301}
+	require.ErrorIs(t, r.Apply(ctx, overdraw), ErrInsufficientBalance)
+	require.ErrorIs(t, r.Apply(ctx, &model.BalanceTransaction{UserID: 2, Currency: "EUR", Kind: model.BalanceSpent, AmountCent: -1}), ErrInsufficientBalance)
+
+	balance, err := r.GetBalance(ctx, 1, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, 300, balance.BalanceCent)
+
+	balances, err := r.ListBalances(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	require.Equal(t, "EUR", balances[0].Currency)
+	require.Equal(t, 700, balances[1].BalanceCent)
+
+	transactions, err := r.ListTransactions(ctx, CreditFilter{UserID: 1, BeforeID: entries[3].ID, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, transactions, 2)
+	require.Equal(t, entries[2].ID, transactions[0].ID)
+	require.Equal(t, -1200, transactions[0].AmountCent)
+
+	require.ErrorIs(t, db.Delete(&model.BalanceTransaction{}, entries[0].ID).Error, model.ErrCreditNoteFinalized)
+}
@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+type DunningRepository interface {
+	Create(ctx context.Context, attempt *model.DunningAttempt) error
+	ListBySubscription(ctx context.Context, subscriptionID uint) ([]model.DunningAttempt, error)
+}
+
+type dunningRepository struct {
+	db *gorm.DB
+}
+
+func NewDunningRepository(db *gorm.DB) DunningRepository {
+	return &dunningRepository{db: db}
+}
+
+func (r *dunningRepository) Create(ctx context.Context, attempt *model.DunningAttempt) error {
+	if err := r.db.WithContext(ctx).Create(attempt).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *dunningRepository) ListBySubscription(ctx context.Context, subscriptionID uint) ([]model.DunningAttempt, error) {
+	var attempts []model.DunningAttempt
+	if err := r.db.WithContext(ctx).Where("subscription_id = ?", subscriptionID).Order("id").Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
@@ -0,0 +1,11 @@
+package repo
+
+import "errors"
+
+// ErrVersionConflict is returned when a conditional write finds that the row
+// was changed since it was read.
+var ErrVersionConflict = errors.New("row was modified concurrently")
+
+// ErrInsufficientBalance is returned when a debit would take a customer
+// balance below zero.
+var ErrInsufficientBalance = errors.New("insufficient balance")
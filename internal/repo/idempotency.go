@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+type IdempotencyRepository interface {
+	Get(ctx context.Context, userID uint, key string) (*model.IdempotencyKey, error)
+	Create(ctx context.Context, record *model.IdempotencyKey) error
+	Save(ctx context.Context, record *model.IdempotencyKey) error
+	// DeleteStale removes the record only if it wasn't touched since
+	// updatedAt, so two requests can't both take over the same key.
+	DeleteStale(ctx context.Context, ID uint, updatedAt time.Time) (bool, error)
+	Delete(ctx context.Context, ID uint) error
+}
+
+type idempotencyRepository struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) IdempotencyRepository {
+	return &idempotencyRepository{db: db}
+}
+
+func (r *idempotencyRepository) Get(ctx context.Context, userID uint, key string) (*model.IdempotencyKey, error) {
+	var record model.IdempotencyKey
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND \"key\" = ?", userID, key).First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *idempotencyRepository) Create(ctx context.Context, record *model.IdempotencyKey) error {
+	if err := r.db.WithContext(ctx).Create(record).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *idempotencyRepository) Save(ctx context.Context, record *model.IdempotencyKey) error {
+	if err := r.db.WithContext(ctx).Save(record).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *idempotencyRepository) DeleteStale(ctx context.Context, ID uint, updatedAt time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Unscoped().
+		Where("id = ? AND updated_at = ?", ID, updatedAt).
+		Delete(&model.IdempotencyKey{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *idempotencyRepository) Delete(ctx context.Context, ID uint) error {
+	if err := r.db.WithContext(ctx).Unscoped().Delete(&model.IdempotencyKey{}, ID).Error; err != nil {
+		return err
+	}
+	return nil
+}
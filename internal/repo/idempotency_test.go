@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	r := NewIdempotencyRepository(newTestDB(t))
+
+	first := &model.IdempotencyKey{UserID: 1, Key: "abc", Fingerprint: "f1"}
+	require.NoError(t, r.Create(ctx, first))
+
+	// keys are unique per user
+	require.ErrorIs(t, r.Create(ctx, &model.IdempotencyKey{UserID: 1, Key: "abc", Fingerprint: "f2"}), gorm.ErrDuplicatedKey)
+	require.NoError(t, r.Create(ctx, &model.IdempotencyKey{UserID: 2, Key: "abc", Fingerprint: "f1"}))
+
+	stored, err := r.Get(ctx, 1, "abc")
+	require.NoError(t, err)
+	require.Equal(t, first.ID, stored.ID)
+	require.Nil(t, stored.CompletedAt)
+
+	// a record touched after it was read is not stale anymore
+	seen := stored.UpdatedAt
+	stored.StatusCode = 201
+	require.NoError(t, r.Save(ctx, stored))
+	deleted, err := r.DeleteStale(ctx, stored.ID, seen)
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	deleted, err = r.DeleteStale(ctx, stored.ID, stored.UpdatedAt)
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	_, err = r.Get(ctx, 1, "abc")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	require.NoError(t, r.Create(ctx, &model.IdempotencyKey{UserID: 1, Key: "abc", Fingerprint: "f2"}))
+}
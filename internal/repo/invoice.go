@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InvoiceFilter narrows down List. Zero values match everything.
+type InvoiceFilter struct {
+	UserID   uint
+	BeforeID uint
+	Limit    int
+}
+
+type InvoiceRepository interface {
+	// GetByID fetches the invoice with its lines.
+	GetByID(ctx context.Context, ID uint) (*model.Invoice, error)
+	GetByPayment(ctx context.Context, paymentID uint) (*model.Invoice, error)
+	// Create numbers the invoice with the next number of its Series and
+	// stores it with its lines.
+	Create(ctx context.Context, invoice *model.Invoice) error
+	// List returns invoices without their lines, newest first.
+	List(ctx context.Context, filter InvoiceFilter) ([]model.Invoice, error)
+}
+
+type invoiceRepository struct {
+	db *gorm.DB
+}
+
+func NewInvoiceRepository(db *gorm.DB) InvoiceRepository {
+	return &invoiceRepository{db: db}
+}
+
+func (r *invoiceRepository) GetByID(ctx context.Context, ID uint) (*model.Invoice, error) {
+	var invoice model.Invoice
+	if err := r.db.WithContext(ctx).Preload("Lines", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).First(&invoice, ID).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+func (r *invoiceRepository) GetByPayment(ctx context.Context, paymentID uint) (*model.Invoice, error) {
+	var invoice model.Invoice
+	if err := r.db.WithContext(ctx).Where("payment_id = ?", paymentID).First(&invoice).Error; err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// Create takes the number and inserts the invoice in one transaction. The
+// sequence row stays locked until the transaction ends, so concurrent
+// invoices of a series are numbered one after the other and a failed insert
+// gives its number back.
+func (r *invoiceRepository) Create(ctx context.Context, invoice *model.Invoice) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sequence, err := nextInSeries(tx, invoice.Series)
+		if err != nil {
+			return err
+		}
+
+		invoice.Sequence = sequence
+		invoice.Number = documentNumber(invoice.Series, sequence)
+		return tx.Create(invoice).Error
+	})
+}
+
+// nextInSeries takes the next number of a document series inside tx.
+func nextInSeries(tx *gorm.DB, series string) (uint, error) {
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "series"}},
+		DoUpdates: clause.Assignments(map[string]any{"last": gorm.Expr("invoice_sequences.last + 1")}),
+	}).Create(&model.InvoiceSequence{Series: series, Last: 1}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var sequence model.InvoiceSequence
+	if err := tx.First(&sequence, "series = ?", series).Error; err != nil {
+		return 0, err
+	}
+	return sequence.Last, nil
+}
+
+func documentNumber(series string, sequence uint) string {
+	return fmt.Sprintf("%s-%06d", series, sequence)
+}
+
+func (r *invoiceRepository) List(ctx context.Context, filter InvoiceFilter) ([]model.Invoice, error) {
+	query := r.db.WithContext(ctx).Model(&model.Invoice{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var invoices []model.Invoice
+	if err := query.Order("id DESC").Find(&invoices).Error; err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestCreateInvoice(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewInvoiceRepository(db)
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	invoice := func(series string, paymentID uint) *model.Invoice {
+		return &model.Invoice{
+			Series:     series,
+			UserID:     1,
+			PaymentID:  paymentID,
+			Currency:   model.DefaultCurrency,
+			SellerName: "Subserv",
+			BuyerName:  "Alice",
+			BuyerEmail: "alice@d.com",
+			NetCent:    1000,
+			TotalCent:  1000,
+			IssuedAt:   now,
+			Lines:      []model.InvoiceLine{{Position: 1, Description: "Basic Plan", NetCent: 1000, TotalCent: 1000}},
+		}
+	}
+
+	first := invoice("INV-2025", 1)
+	require.NoError(t, r.Create(ctx, first))
+	require.Equal(t, "INV-2025-000001", first.Number)
+
+	// a failed insert gives its number back
+	require.ErrorIs(t, r.Create(ctx, invoice("INV-2025", 1)), gorm.ErrDuplicatedKey)
+
+	second := invoice("INV-2025", 2)
+	require.NoError(t, r.Create(ctx, second))
+	require.Equal(t, "INV-2025-000002", second.Number)
+
+	other := invoice("INV-2026", 3)
+	require.NoError(t, r.Create(ctx, other))
+	require.Equal(t, "INV-2026-000001", other.Number)
+
+	got, err := r.GetByID(ctx, first.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Lines, 1)
+	require.Equal(t, "Basic Plan", got.Lines[0].Description)
+
+	got, err = r.GetByPayment(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, second.ID, got.ID)
+
+	invoices, err := r.List(ctx, InvoiceFilter{UserID: 1, BeforeID: other.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, invoices, 2)
+	require.Equal(t, second.ID, invoices[0].ID)
+}
+
+func TestInvoicesAreImmutable(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewInvoiceRepository(db)
+
+	invoice := &model.Invoice{
+		Series:     "INV-2025",
+		UserID:     1,
+		PaymentID:  1,
+		Currency:   model.DefaultCurrency,
+		SellerName: "Subserv",
+		BuyerName:  "Alice",
+		BuyerEmail: "alice@d.com",
+		IssuedAt:   time.Now(),
+		Lines:      []model.InvoiceLine{{Position: 1, Description: "Basic Plan"}},
+	}
+	require.NoError(t, r.Create(ctx, invoice))
+
+	invoice.TotalCent = 1
+	require.ErrorIs(t, db.Save(invoice).Error, model.ErrInvoiceFinalized)
+	require.ErrorIs(t, db.Model(&model.Invoice{}).Where("id = ?", invoice.ID).Update("total_cent", 1).Error, model.ErrInvoiceFinalized)
+	require.ErrorIs(t, db.Delete(&model.Invoice{}, invoice.ID).Error, model.ErrInvoiceFinalized)
+	require.ErrorIs(t, db.Model(&invoice.Lines[0]).Update("net_cent", 1).Error, model.ErrInvoiceFinalized)
+
+	got, err := r.GetByID(ctx, invoice.ID)
+	require.NoError(t, err)
+	require.Zero(t, got.TotalCent)
+	require.Zero(t, got.Lines[0].NetCent)
+}
@@ -0,0 +1,123 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+type PaymentRepository interface {
+	GetByID(ctx context.Context, ID uint) (*model.Payment, error)
+	Create(ctx context.Context, payment *model.Payment) error
+	Save(ctx context.Context, payment *model.Payment) error
+	ListBySubscription(ctx context.Context, subscriptionID uint) ([]model.Payment, error)
+	ReserveRefund(ctx context.Context, paymentID uint, amount int) (bool, error)
+	ReleaseRefund(ctx context.Context, paymentID uint, amount int) error
+	ReserveCredit(ctx context.Context, paymentID uint, amount int) (bool, error)
+	ReleaseCredit(ctx context.Context, paymentID uint, amount int) error
+	CreateRefund(ctx context.Context, refund *model.Refund) error
+	SaveRefund(ctx context.Context, refund *model.Refund) error
+	ListRefunds(ctx context.Context, paymentID uint) ([]model.Refund, error)
+}
+
+type paymentRepository struct {
+	db *gorm.DB
+}
+
+func NewPaymentRepository(db *gorm.DB) PaymentRepository {
+	return &paymentRepository{db: db}
+}
+
+func (r *paymentRepository) GetByID(ctx context.Context, ID uint) (*model.Payment, error) {
+	var payment model.Payment
+	if err := r.db.WithContext(ctx).First(&payment, ID).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *paymentRepository) Create(ctx context.Context, payment *model.Payment) error {
+	if err := r.db.WithContext(ctx).Create(payment).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *paymentRepository) Save(ctx context.Context, payment *model.Payment) error {
+	if err := r.db.WithContext(ctx).Save(payment).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *paymentRepository) ListBySubscription(ctx context.Context, subscriptionID uint) ([]model.Payment, error) {
+	var payments []model.Payment
+	if err := r.db.WithContext(ctx).Where("subscription_id = ?", subscriptionID).Order("id DESC").Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// ReserveRefund adds amount to the refunded total of a succeeded payment. It
+// reports false if the payment didn't succeed or the refunds would exceed
+// what the processor charged or, with credits, the payment. The check is part of the UPDATE, so concurrent refunds
+// can't overdraw a payment.
+func (r *paymentRepository) ReserveRefund(ctx context.Context, paymentID uint, amount int) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.Payment{}).
+		Where("id = ? AND status = ? AND refunded_cent + ? <= amount_cent - balance_cent AND refunded_cent + credited_cent + ? <= amount_cent",
+			paymentID, model.PaymentSucceeded, amount, amount).
+		Update("refunded_cent", gorm.Expr("refunded_cent + ?", amount))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// ReleaseRefund gives back an amount reserved for a refund that failed.
+func (r *paymentRepository) ReleaseRefund(ctx context.Context, paymentID uint, amount int) error {
+	return r.db.WithContext(ctx).Model(&model.Payment{}).
+		Where("id = ?", paymentID).
+		Update("refunded_cent", gorm.Expr("refunded_cent - ?", amount)).Error
+}
+
+// ReserveCredit adds amount to the credited total of a succeeded payment,
+// like ReserveRefund. Refunds and credits together can't exceed the payment.
+func (r *paymentRepository) ReserveCredit(ctx context.Context, paymentID uint, amount int) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.Payment{}).
+		Where("id = ? AND status = ? AND refunded_cent + credited_cent + ? <= amount_cent", paymentID, model.PaymentSucceeded, amount).
+		Update("credited_cent", gorm.Expr("credited_cent + ?", amount))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// ReleaseCredit gives back an amount reserved for a credit that failed.
+func (r *paymentRepository) ReleaseCredit(ctx context.Context, paymentID uint, amount int) error {
+	return r.db.WithContext(ctx).Model(&model.Payment{}).
+		Where("id = ?", paymentID).
+		Update("credited_cent", gorm.Expr("credited_cent - ?", amount)).Error
+}
+
+func (r *paymentRepository) CreateRefund(ctx context.Context, refund *model.Refund) error {
+	if err := r.db.WithContext(ctx).Create(refund).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *paymentRepository) SaveRefund(ctx context.Context, refund *model.Refund) error {
+	if err := r.db.WithContext(ctx).Save(refund).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *paymentRepository) ListRefunds(ctx context.Context, paymentID uint) ([]model.Refund, error) {
+	var refunds []model.Refund
+	if err := r.db.WithContext(ctx).Where("payment_id = ?", paymentID).Order("id DESC").Find(&refunds).Error; err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
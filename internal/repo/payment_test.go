@@ -0,0 +1,160 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+func TestPaymentLedger(t *testing.T) {
+	ctx := context.Background()
+	r := NewPaymentRepository(newTestDB(t))
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+
+	payment := func(subscriptionID uint) *model.Payment {
+		return &model.Payment{
+			SubscriptionID: subscriptionID,
+			UserID:         1,
+			Kind:           model.PaymentPurchase,
+			AmountCent:     1100,
+			TaxCent:        100,
+			Currency:       model.DefaultCurrency,
+			Provider:       "dummy",
+			Status:         model.PaymentPending,
+			AttemptedAt:    now,
+		}
+	}
+
+	first := payment(1)
+	require.NoError(t, r.Create(ctx, first))
+	require.NoError(t, r.Create(ctx, payment(2)))
+	second := payment(1)
+	require.NoError(t, r.Create(ctx, second))
+
+	completed := now.Add(time.Second)
+	first.Status = model.PaymentSucceeded
+	first.TxID = "tx-1"
+	first.CompletedAt = &completed
+	require.NoError(t, r.Save(ctx, first))
+
+	payments, err := r.ListBySubscription(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	require.Equal(t, second.ID, payments[0].ID)
+	require.Equal(t, model.PaymentPending, payments[0].Status)
+	require.Equal(t, first.ID, payments[1].ID)
+	require.Equal(t, model.PaymentSucceeded, payments[1].Status)
+	require.Equal(t, "tx-1", payments[1].TxID)
+	require.True(t, payments[1].CompletedAt.Equal(completed))
+}
+
+func TestRefundReservation(t *testing.T) {
+	ctx := context.Background()
+	r := NewPaymentRepository(newTestDB(t))
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+
+	payment := &model.Payment{
+		SubscriptionID: 1,
+		UserID:         1,
+		Kind:           model.PaymentPurchase,
+		AmountCent:     1100,
+		TaxCent:        100,
+		Currency:       model.DefaultCurrency,
+		Provider:       "dummy",
+		Status:         model.PaymentPending,
+		AttemptedAt:    now,
+	}
+	require.NoError(t, r.Create(ctx, payment))
+
+	ok, err := r.ReserveRefund(ctx, payment.ID, 100)
+	require.NoError(t, err)
+	require.False(t, ok, "pending payments can't be refunded")
+
+	payment.Status = model.PaymentSucceeded
+	require.NoError(t, r.Save(ctx, payment))
+
+	ok, err = r.ReserveRefund(ctx, payment.ID, 1000)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = r.ReserveRefund(ctx, payment.ID, 101)
+	require.NoError(t, err)
+	require.False(t, ok, "refunds can't exceed the payment")
+
+	require.NoError(t, r.ReleaseRefund(ctx, payment.ID, 1000))
+	ok, err = r.ReserveRefund(ctx, payment.ID, 1100)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	got, err := r.GetByID(ctx, payment.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1100, got.RefundedCent)
+
+	refund := &model.Refund{
+		PaymentID:      payment.ID,
+		SubscriptionID: 1,
+		UserID:         1,
+		AmountCent:     1100,
+		TaxCent:        100,
+		Currency:       model.DefaultCurrency,
+		Provider:       "dummy",
+		Status:         model.PaymentPending,
+		AttemptedAt:    now,
+	}
+	require.NoError(t, r.CreateRefund(ctx, refund))
+	refund.Status = model.PaymentSucceeded
+	require.NoError(t, r.SaveRefund(ctx, refund))
+
+	refunds, err := r.ListRefunds(ctx, payment.ID)
+	require.NoError(t, err)
+	require.Len(t, refunds, 1)
+	require.Equal(t, model.PaymentSucceeded, refunds[0].Status)
+}
+
+func TestCreditReservation(t *testing.T) {
+	ctx := context.Background()
+	r := NewPaymentRepository(newTestDB(t))
+
+	// 400 of the 1100 were paid from the balance
+	payment := &model.Payment{
+		SubscriptionID: 1,
+		UserID:         1,
+		Kind:           model.PaymentPurchase,
+		AmountCent:     1100,
+		TaxCent:        100,
+		BalanceCent:    400,
+		Currency:       model.DefaultCurrency,
+		Provider:       "dummy",
+		Status:         model.PaymentSucceeded,
+		AttemptedAt:    time.Now(),
+	}
+	require.NoError(t, r.Create(ctx, payment))
+
+	ok, err := r.ReserveRefund(ctx, payment.ID, 701)
+	require.NoError(t, err)
+	require.False(t, ok, "refunds can't exceed what the processor charged")
+	ok, err = r.ReserveRefund(ctx, payment.ID, 500)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = r.ReserveCredit(ctx, payment.ID, 601)
+	require.NoError(t, err)
+	require.False(t, ok, "refunds and credits can't exceed the payment")
+	ok, err = r.ReserveCredit(ctx, payment.ID, 600)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = r.ReserveRefund(ctx, payment.ID, 100)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, r.ReleaseCredit(ctx, payment.ID, 600))
+	got, err := r.GetByID(ctx, payment.ID)
+	require.NoError(t, err)
+	require.Equal(t, 500, got.RefundedCent)
+	require.Zero(t, got.CreditedCent)
+	require.Equal(t, 200, got.RefundableCent())
+	require.Equal(t, 600, got.CreditableCent())
+}
@@ -9,7 +9,13 @@ import (
 
 type ProductRepository interface {
 	GetByID(ctx context.Context, ID uint) (*model.Product, error)
+	// GetByIDWithArchived also finds archived products, which existing
+	// subscriptions keep referencing.
+	GetByIDWithArchived(ctx context.Context, ID uint) (*model.Product, error)
 	GetAll(ctx context.Context) ([]model.Product, error)
+	Create(ctx context.Context, product *model.Product) error
+	Save(ctx context.Context, product *model.Product) error
+	Archive(ctx context.Context, ID uint) (bool, error)
 }
 
 type productRepository struct {
@@ -22,7 +28,15 @@ func NewProductRepository(db *gorm.DB) ProductRepository {
 
 func (r *productRepository) GetByID(ctx context.Context, ID uint) (*model.Product, error) {
 	var product model.Product
-	if err := r.db.WithContext(ctx).First(&product, ID).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Prices").First(&product, ID).Error; err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (r *productRepository) GetByIDWithArchived(ctx context.Context, ID uint) (*model.Product, error) {
+	var product model.Product
+	if err := r.db.WithContext(ctx).Unscoped().Preload("Prices").First(&product, ID).Error; err != nil {
 		return nil, err
 	}
 	return &product, nil
@@ -30,8 +44,39 @@ func (r *productRepository) GetByID(ctx context.Context, ID uint) (*model.Produc
 
 func (r *productRepository) GetAll(ctx context.Context) ([]model.Product, error) {
 	var products []model.Product
-	if err := r.db.WithContext(ctx).Find(&products).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Prices").Find(&products).Error; err != nil {
 		return nil, err
 	}
 	return products, nil
 }
+
+func (r *productRepository) Create(ctx context.Context, product *model.Product) error {
+	if err := r.db.WithContext(ctx).Create(product).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save updates the product and replaces its prices with product.Prices.
+func (r *productRepository) Save(ctx context.Context, product *model.Product) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&model.ProductPrice{}).Error; err != nil {
+			return err
+		}
+		for i := range product.Prices {
+			product.Prices[i].ID = 0
+		}
+
+		return tx.Save(product).Error
+	})
+}
+
+// Archive soft-deletes the product. It reports false if there was no active
+// product with that ID.
+func (r *productRepository) Archive(ctx context.Context, ID uint) (bool, error) {
+	res := r.db.WithContext(ctx).Delete(&model.Product{}, ID)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestArchiveProduct(t *testing.T) {
+	ctx := context.Background()
+	r := NewProductRepository(newTestDB(t))
+
+	product := &model.Product{Name: "flowmotion", Price: 1000, Duration: 60}
+	require.NoError(t, r.Create(ctx, product))
+
+	archived, err := r.Archive(ctx, product.ID)
+	require.NoError(t, err)
+	require.True(t, archived)
+
+	archived, err = r.Archive(ctx, product.ID)
+	require.NoError(t, err)
+	require.False(t, archived, "archiving twice must report no product")
+
+	_, err = r.GetByID(ctx, product.ID)
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	all, err := r.GetAll(ctx)
+	require.NoError(t, err)
+	require.Empty(t, all)
+
+	got, err := r.GetByIDWithArchived(ctx, product.ID)
+	require.NoError(t, err)
+	require.Equal(t, "flowmotion", got.Name)
+	require.True(t, got.DeletedAt.Valid)
+}
+
+func TestProductPrices(t *testing.T) {
+	ctx := context.Background()
+	r := NewProductRepository(newTestDB(t))
+
+	product := &model.Product{
+		Name:     "flowmotion",
+		Price:    1000,
+		Duration: 60,
+		Prices:   []model.ProductPrice{{Currency: "EUR", Amount: 900}, {Currency: "JPY", Amount: 1500}},
+	}
+	require.NoError(t, r.Create(ctx, product))
+
+	got, err := r.GetByID(ctx, product.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Prices, 2)
+	amount, ok := got.PriceIn("JPY")
+	require.True(t, ok)
+	require.Equal(t, 1500, amount)
+
+	got.Prices = []model.ProductPrice{{Currency: "EUR", Amount: 950}, {Currency: "GBP", Amount: 800}}
+	require.NoError(t, r.Save(ctx, got))
+
+	got, err = r.GetByID(ctx, product.ID)
+	require.NoError(t, err)
+	require.Len(t, got.Prices, 2)
+	_, ok = got.PriceIn("JPY")
+	require.False(t, ok, "saving must drop prices that were removed")
+	amount, ok = got.PriceIn("EUR")
+	require.True(t, ok)
+	require.Equal(t, 950, amount)
+
+	all, err := r.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Len(t, all[0].Prices, 2)
+}
@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+type QuoteRepository interface {
+	GetByID(ctx context.Context, ID uint) (*model.Quote, error)
+	Create(ctx context.Context, quote *model.Quote) error
+	// Use marks an unused, unexpired quote as used. It reports false if the
+	// quote was used or expired in the meantime.
+	Use(ctx context.Context, ID uint, now time.Time) (bool, error)
+	// Release makes a used quote usable again.
+	Release(ctx context.Context, ID uint) error
+}
+
+type quoteRepository struct {
+	db *gorm.DB
+}
+
+func NewQuoteRepository(db *gorm.DB) QuoteRepository {
+	return &quoteRepository{db: db}
+}
+
+func (r *quoteRepository) GetByID(ctx context.Context, ID uint) (*model.Quote, error) {
+	var quote model.Quote
+	if err := r.db.WithContext(ctx).First(&quote, ID).Error; err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+func (r *quoteRepository) Create(ctx context.Context, quote *model.Quote) error {
+	if err := r.db.WithContext(ctx).Create(quote).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *quoteRepository) Use(ctx context.Context, ID uint, now time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.Quote{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", ID, now).
+		Update("used_at", now)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *quoteRepository) Release(ctx context.Context, ID uint) error {
+	return r.db.WithContext(ctx).Model(&model.Quote{}).Where("id = ?", ID).Update("used_at", nil).Error
+}
@@ -0,0 +1,51 @@
+package repo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+func TestUseQuote(t *testing.T) {
+	ctx := context.Background()
+	r := NewQuoteRepository(newTestDB(t))
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	quote := func(expiresAt time.Time) *model.Quote {
+		return &model.Quote{
+			UserID:      1,
+			ProductID:   1,
+			ProductName: "Basic Plan",
+			Country:     "DE",
+			Currency:    model.DefaultCurrency,
+			NetCent:     1000,
+			TotalCent:   1000,
+			PeriodStart: now,
+			PeriodEnd:   now.Add(time.Hour),
+			ExpiresAt:   expiresAt,
+		}
+	}
+	valid := quote(now.Add(time.Minute))
+	expired := quote(now)
+	require.NoError(t, r.Create(ctx, valid))
+	require.NoError(t, r.Create(ctx, expired))
+
+	ok, err := r.Use(ctx, expired.ID, now)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = r.Use(ctx, valid.ID, now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = r.Use(ctx, valid.ID, now)
+	require.NoError(t, err)
+	require.False(t, ok, "a quote can be used once")
+
+	require.NoError(t, r.Release(ctx, valid.ID))
+	got, err := r.GetByID(ctx, valid.ID)
+	require.NoError(t, err)
+	require.Nil(t, got.UsedAt)
+}
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+type SessionRepository interface {
+	// Create stores the session together with its first refresh token.
+	Create(ctx context.Context, session *model.Session, token *model.SessionToken) error
+	GetByID(ctx context.Context, ID uint) (*model.Session, error)
+	// GetToken finds a refresh token by its hash, with its session.
+	GetToken(ctx context.Context, hash string) (*model.SessionToken, error)
+	// Rotate marks the used token as exchanged and stores next in the same
+	// session. It reports false if the token was already used, e.g. by a
+	// concurrent refresh.
+	Rotate(ctx context.Context, used *model.SessionToken, next *model.SessionToken, session *model.Session) (bool, error)
+	Revoke(ctx context.Context, ID uint, now time.Time) error
+	ListActive(ctx context.Context, userID uint, now time.Time) ([]model.Session, error)
+}
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *model.Session, token *model.SessionToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		token.SessionID = session.ID
+		return tx.Omit("Session").Create(token).Error
+	})
+}
+
+func (r *sessionRepository) GetByID(ctx context.Context, ID uint) (*model.Session, error) {
+	var session model.Session
+	if err := r.db.WithContext(ctx).First(&session, ID).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) GetToken(ctx context.Context, hash string) (*model.SessionToken, error) {
+	var token model.SessionToken
+	if err := r.db.WithContext(ctx).Joins("Session").Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *sessionRepository) Rotate(ctx context.Context, used *model.SessionToken, next *model.SessionToken, session *model.Session) (bool, error) {
+	rotated := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.SessionToken{}).
+			Where("id = ? AND used_at IS NULL", used.ID).
+			Update("used_at", session.LastUsedAt)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		next.SessionID = session.ID
+		if err := tx.Omit("Session").Create(next).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(session).Select("user_agent", "ip", "last_used_at", "expires_at").Updates(session).Error; err != nil {
+			return err
+		}
+
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+func (r *sessionRepository) Revoke(ctx context.Context, ID uint, now time.Time) error {
+	return r.db.WithContext(ctx).Model(&model.Session{}).
+		Where("id = ? AND revoked_at IS NULL", ID).
+		Update("revoked_at", now).Error
+}
+
+func (r *sessionRepository) ListActive(ctx context.Context, userID uint, now time.Time) ([]model.Session, error) {
+	var sessions []model.Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
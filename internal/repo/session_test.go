@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestSessionRotation(t *testing.T) {
+	ctx := context.Background()
+	r := NewSessionRepository(newTestDB(t))
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	session := &model.Session{UserID: 1, Device: "phone", LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, r.Create(ctx, session, &model.SessionToken{TokenHash: "first"}))
+
+	first, err := r.GetToken(ctx, "first")
+	require.NoError(t, err)
+	require.Equal(t, session.ID, first.Session.ID)
+	require.Equal(t, "phone", first.Session.Device)
+	require.Nil(t, first.UsedAt)
+
+	later := now.Add(time.Minute)
+	first.Session.LastUsedAt, first.Session.ExpiresAt, first.Session.UserAgent = later, later.Add(time.Hour), "app/2.0"
+	rotated, err := r.Rotate(ctx, first, &model.SessionToken{TokenHash: "second"}, &first.Session)
+	require.NoError(t, err)
+	require.True(t, rotated)
+
+	rotated, err = r.Rotate(ctx, first, &model.SessionToken{TokenHash: "third"}, &first.Session)
+	require.NoError(t, err)
+	require.False(t, rotated, "a token can only be exchanged once")
+	_, err = r.GetToken(ctx, "third")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	used, err := r.GetToken(ctx, "first")
+	require.NoError(t, err)
+	require.NotNil(t, used.UsedAt)
+	second, err := r.GetToken(ctx, "second")
+	require.NoError(t, err)
+	require.Equal(t, session.ID, second.SessionID)
+	require.Equal(t, "app/2.0", second.Session.UserAgent)
+
+	active, err := r.ListActive(ctx, 1, later)
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+
+	require.NoError(t, r.Revoke(ctx, session.ID, later))
+	active, err = r.ListActive(ctx, 1, later)
+	require.NoError(t, err)
+	require.Empty(t, active)
+}
+
+func TestSessionRotationRace(t *testing.T) {
+	ctx := context.Background()
+	r := NewSessionRepository(newTestDB(t))
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	session := &model.Session{UserID: 1, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, r.Create(ctx, session, &model.SessionToken{TokenHash: "first"}))
+	token, err := r.GetToken(ctx, "first")
+	require.NoError(t, err)
+
+	const clients = 8
+	var wg sync.WaitGroup
+	var wins atomic.Int32
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next := &model.SessionToken{TokenHash: string(rune('a' + i))}
+			s := token.Session
+			rotated, err := r.Rotate(ctx, token, next, &s)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if rotated {
+				wins.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), wins.Load(), "exactly one refresh must win")
+}
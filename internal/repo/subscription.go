@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"time"
 
 	"github.com/thatmatin/subserv/internal/model"
 	"gorm.io/gorm"
@@ -10,7 +11,35 @@ import (
 type SubscriptionRepository interface {
 	GetByID(ctx context.Context, ID uint) (*model.Subscription, error)
 	Create(ctx context.Context, sub *model.Subscription) error
+	// CreateTrial inserts a trialing subscription and records the user's
+	// trial of family with it. gorm.ErrDuplicatedKey is returned if the user
+	// already had one.
+	CreateTrial(ctx context.Context, sub *model.Subscription, family string) error
+	TrialUsed(ctx context.Context, userID uint, family string) (bool, error)
+	TrialsEnding(ctx context.Context, before time.Time, limit int) ([]model.Subscription, error)
+	MarkTrialReminded(ctx context.Context, ID uint, at time.Time) (bool, error)
 	Save(ctx context.Context, sub *model.Subscription) error
+	List(ctx context.Context, filter SubscriptionFilter) ([]model.Subscription, error)
+	ExpireDue(ctx context.Context, now time.Time, pausedBefore *time.Time, limit int) (int64, error)
+	CancelScheduled(ctx context.Context, now time.Time, limit int) (int64, error)
+	DueForRenewal(ctx context.Context, now time.Time, renewBefore time.Time, limit int) ([]model.Subscription, error)
+	DueForRetry(ctx context.Context, now time.Time, limit int) ([]model.Subscription, error)
+	ClaimRenewal(ctx context.Context, ID uint, version uint, now time.Time, until time.Time) (bool, error)
+	Claim(ctx context.Context, ID uint, version uint, now time.Time, until time.Time) (bool, error)
+}
+
+// SubscriptionFilter narrows down List. Zero values are ignored. Results are
+// ordered by ID descending; BeforeID is the keyset cursor of the previous page.
+type SubscriptionFilter struct {
+	UserID    uint
+	State     *model.State
+	ProductID uint
+	StartFrom time.Time
+	StartTo   time.Time
+	EndFrom   time.Time
+	EndTo     time.Time
+	BeforeID  uint
+	Limit     int
 }
 
 type subscriptionRepository struct {
@@ -36,9 +65,221 @@ func (r *subscriptionRepository) Create(ctx context.Context, sub *model.Subscrip
 	return nil
 }
 
+func (r *subscriptionRepository) CreateTrial(ctx context.Context, sub *model.Subscription, family string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sub).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.Trial{UserID: sub.UserID, Family: family, SubscriptionID: sub.ID, StartedAt: sub.Start}).Error
+	})
+}
+
+func (r *subscriptionRepository) TrialUsed(ctx context.Context, userID uint, family string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.Trial{}).
+		Where("user_id = ? AND family = ?", userID, family).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// TrialsEnding returns Trialing subscriptions ending before the given time
+// whose user wasn't reminded yet.
+func (r *subscriptionRepository) TrialsEnding(ctx context.Context, before time.Time, limit int) ([]model.Subscription, error) {
+	var subs []model.Subscription
+	err := r.db.WithContext(ctx).
+		Where(`state = ? AND "end" < ? AND trial_reminded_at IS NULL`, model.Trialing, before).
+		Order(`"end"`).
+		Limit(limit).
+		Find(&subs).Error
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// MarkTrialReminded records that the user was reminded of the trial ending
+// and bumps the version. It reports false if another run already did.
+func (r *subscriptionRepository) MarkTrialReminded(ctx context.Context, ID uint, at time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.Subscription{}).
+		Where("id = ? AND trial_reminded_at IS NULL", ID).
+		Updates(map[string]any{
+			"trial_reminded_at": at,
+			"version":           gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Save writes the subscription only if it is still at the version it was
+// read at, and bumps the version. ErrVersionConflict is returned if another
+// write got there first.
 func (r *subscriptionRepository) Save(ctx context.Context, sub *model.Subscription) error {
-	if err := r.db.WithContext(ctx).Save(sub).Error; err != nil {
-		return err
+	version := sub.Version
+	sub.Version++
+
+	res := r.db.WithContext(ctx).Model(sub).Where("version = ?", version).Select("*").Updates(sub)
+	if res.Error != nil {
+		sub.Version = version
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		sub.Version = version
+		return ErrVersionConflict
 	}
 	return nil
 }
+
+func (r *subscriptionRepository) List(ctx context.Context, filter SubscriptionFilter) ([]model.Subscription, error) {
+	query := r.db.WithContext(ctx).Model(&model.Subscription{})
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.State != nil {
+		query = query.Where("state = ?", *filter.State)
+	}
+	if filter.ProductID != 0 {
+		query = query.Where("product_id = ?", filter.ProductID)
+	}
+	if !filter.StartFrom.IsZero() {
+		query = query.Where("start >= ?", filter.StartFrom)
+	}
+	if !filter.StartTo.IsZero() {
+		query = query.Where("start < ?", filter.StartTo)
+	}
+	if !filter.EndFrom.IsZero() {
+		query = query.Where(`"end" >= ?`, filter.EndFrom)
+	}
+	if !filter.EndTo.IsZero() {
+		query = query.Where(`"end" < ?`, filter.EndTo)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var subs []model.Subscription
+	if err := query.Order("id DESC").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// ExpireDue moves up to limit Active or Trialing subscriptions whose End has
+// passed to Expired. Auto-renewing subscriptions are left to the renewal job and
+// scheduled cancellations to CancelScheduled. When pausedBefore is set,
+// subscriptions paused before that time are expired too and their End is set
+// to now. The state condition is re-checked by the UPDATE itself, so
+// concurrent sweeps never transition a row twice.
+func (r *subscriptionRepository) ExpireDue(ctx context.Context, now time.Time, pausedBefore *time.Time, limit int) (int64, error) {
+	due := r.db.Where(`state IN (?) AND "end" < ? AND auto_renew = ? AND cancel_at IS NULL`, []model.State{model.Active, model.Trialing}, now, false)
+	if pausedBefore != nil {
+		due = due.Or("state = ? AND paused_at < ?", model.Paused, *pausedBefore)
+	}
+
+	batch := r.db.Model(&model.Subscription{}).Select("id").Where(due).Order("id").Limit(limit)
+	res := r.db.WithContext(ctx).Model(&model.Subscription{}).
+		Where("id IN (?)", batch).
+		Where(due).
+		Updates(map[string]any{
+			"end":     gorm.Expr(`CASE WHEN state = ? THEN ? ELSE "end" END`, model.Paused, now),
+			"state":   model.Expired,
+			"version": gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
+
+// CancelScheduled moves up to limit Active or Trialing subscriptions whose
+// scheduled cancellation is due to Cancelled. Like ExpireDue, the UPDATE
+// re-checks the condition so concurrent sweeps don't transition a row twice.
+func (r *subscriptionRepository) CancelScheduled(ctx context.Context, now time.Time, limit int) (int64, error) {
+	due := r.db.Where("state IN (?) AND cancel_at <= ?", []model.State{model.Active, model.Trialing}, now)
+
+	batch := r.db.Model(&model.Subscription{}).Select("id").Where(due).Order("id").Limit(limit)
+	res := r.db.WithContext(ctx).Model(&model.Subscription{}).
+		Where("id IN (?)", batch).
+		Where(due).
+		Updates(map[string]any{
+			"state":   model.Cancelled,
+			"version": gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	return res.RowsAffected, nil
+}
+
+// DueForRenewal returns Active auto-renewing subscriptions ending before
+// renewBefore, and Trialing ones whose trial is over, that are not currently
+// leased by another renewal run.
+func (r *subscriptionRepository) DueForRenewal(ctx context.Context, now time.Time, renewBefore time.Time, limit int) ([]model.Subscription, error) {
+	var subs []model.Subscription
+	err := r.db.WithContext(ctx).
+		Where(`auto_renew = ? AND cancel_at IS NULL`, true).
+		Where(`(state = ? AND "end" < ?) OR (state = ? AND "end" <= ?)`, model.Active, renewBefore, model.Trialing, now).
+		Where("renewal_locked_until IS NULL OR renewal_locked_until < ?", now).
+		Order(`"end"`).
+		Limit(limit).
+		Find(&subs).Error
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// DueForRetry returns PastDue subscriptions whose next payment retry is due
+// and that are not currently leased by another run.
+func (r *subscriptionRepository) DueForRetry(ctx context.Context, now time.Time, limit int) ([]model.Subscription, error) {
+	var subs []model.Subscription
+	err := r.db.WithContext(ctx).
+		Where("state = ? AND next_retry_at <= ?", model.PastDue, now).
+		Where("renewal_locked_until IS NULL OR renewal_locked_until < ?", now).
+		Order("next_retry_at").
+		Limit(limit).
+		Find(&subs).Error
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// ClaimRenewal takes the renewal lease of a subscription until the given time
+// and bumps its version. It reports false if another process holds the lease
+// or the subscription changed since it was read at version.
+func (r *subscriptionRepository) ClaimRenewal(ctx context.Context, ID uint, version uint, now time.Time, until time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.Subscription{}).
+		Where("id = ? AND version = ?", ID, version).
+		Where("renewal_locked_until IS NULL OR renewal_locked_until < ?", now).
+		Updates(map[string]any{
+			"renewal_locked_until": until,
+			"version":              gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// Claim takes the lease a purchase or plan change holds while it charges the
+// subscription, until the given time, and bumps its version. It reports false
+// if another request holds the lease or the subscription changed since it
+// was read at version.
+func (r *subscriptionRepository) Claim(ctx context.Context, ID uint, version uint, now time.Time, until time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.Subscription{}).
+		Where("id = ? AND version = ?", ID, version).
+		Where("claimed_until IS NULL OR claimed_until < ?", now).
+		Updates(map[string]any{
+			"claimed_until": until,
+			"version":       gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,335 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.Product{}, &model.ProductPrice{}, &model.Subscription{}, &model.User{}, &model.Payment{}, &model.Refund{}, &model.Quote{}, &model.IdempotencyKey{}, &model.Session{}, &model.SessionToken{}, &model.Invoice{}, &model.InvoiceLine{}, &model.InvoiceSequence{}, &model.CreditNote{}, &model.CustomerBalance{}, &model.BalanceTransaction{}, &model.Coupon{}, &model.CouponProduct{}, &model.CouponRedemption{}, &model.Trial{}))
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return db
+}
+
+func TestExpireDue(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewSubscriptionRepository(db)
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	pausedAt := now.Add(-time.Hour * 24 * 100)
+	recentPause := now.Add(-time.Hour)
+	subs := []*model.Subscription{
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Hour)},
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Minute)},
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+		{UserID: 1, State: model.Cancelled, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Hour)},
+		{UserID: 1, State: model.Paused, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Hour), PausedAt: &recentPause},
+		{UserID: 1, State: model.Paused, Start: now.Add(-time.Hour * 2400), End: now.Add(time.Hour * 24), PausedAt: &pausedAt},
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Hour), AutoRenew: true},
+	}
+	for _, sub := range subs {
+		require.NoError(t, r.Create(ctx, sub))
+	}
+
+	n, err := r.ExpireDue(ctx, now, nil, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	n, err = r.ExpireDue(ctx, now, nil, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	pausedBefore := now.Add(-time.Hour * 24 * 90)
+	n, err = r.ExpireDue(ctx, now, &pausedBefore, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	expected := []model.State{model.Expired, model.Expired, model.Active, model.Cancelled, model.Paused, model.Expired, model.Active}
+	for i, sub := range subs {
+		got, err := r.GetByID(ctx, sub.ID)
+		require.NoError(t, err)
+		require.Equal(t, expected[i], got.State, "subscription %d", i)
+	}
+
+	longPause, err := r.GetByID(ctx, subs[5].ID)
+	require.NoError(t, err)
+	require.True(t, longPause.End.Equal(now))
+}
+
+func TestCancelScheduled(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewSubscriptionRepository(db)
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	due := now.Add(-time.Minute)
+	later := now.Add(time.Hour)
+	subs := []*model.Subscription{
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour * 48), End: due, CancelAt: &due},
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour * 48), End: later, CancelAt: &later},
+		{UserID: 1, State: model.Active, Start: now.Add(-time.Hour * 48), End: due, AutoRenew: true, CancelAt: &due},
+		{UserID: 1, State: model.Paused, Start: now.Add(-time.Hour * 48), End: due, PausedAt: &due, CancelAt: &due},
+	}
+	for _, sub := range subs {
+		require.NoError(t, r.Create(ctx, sub))
+	}
+
+	// scheduled cancellations are neither expired nor renewed
+	n, err := r.ExpireDue(ctx, now, nil, 10)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	renewals, err := r.DueForRenewal(ctx, now, now.Add(time.Hour*24), 10)
+	require.NoError(t, err)
+	require.Empty(t, renewals)
+
+	n, err = r.CancelScheduled(ctx, now, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	expected := []model.State{model.Cancelled, model.Active, model.Cancelled, model.Paused}
+	for i, sub := range subs {
+		got, err := r.GetByID(ctx, sub.ID)
+		require.NoError(t, err)
+		require.Equal(t, expected[i], got.State, "subscription %d", i)
+	}
+
+	cancelled, err := r.GetByID(ctx, subs[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, subs[0].Version+1, cancelled.Version)
+}
+
+func TestRenewalClaim(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewSubscriptionRepository(db)
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	retryDue := now.Add(-time.Hour)
+	retryLater := now.Add(time.Hour)
+	subs := []*model.Subscription{
+		{UserID: 1, State: model.Active, AutoRenew: true, Start: now.Add(-time.Hour * 48), End: now.Add(time.Hour)},
+		{UserID: 1, State: model.Active, AutoRenew: false, Start: now.Add(-time.Hour * 48), End: now.Add(time.Hour)},
+		{UserID: 1, State: model.Active, AutoRenew: true, Start: now, End: now.Add(time.Hour * 72)},
+		{UserID: 1, State: model.PastDue, AutoRenew: true, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Hour), NextRetryAt: &retryDue},
+		{UserID: 1, State: model.PastDue, AutoRenew: true, Start: now.Add(-time.Hour * 48), End: now.Add(-time.Hour), NextRetryAt: &retryLater},
+	}
+	for _, sub := range subs {
+		require.NoError(t, r.Create(ctx, sub))
+	}
+
+	due, err := r.DueForRenewal(ctx, now, now.Add(time.Hour*24), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, subs[0].ID, due[0].ID)
+
+	retries, err := r.DueForRetry(ctx, now, 10)
+	require.NoError(t, err)
+	require.Len(t, retries, 1)
+	require.Equal(t, subs[3].ID, retries[0].ID)
+
+	claimed, err := r.ClaimRenewal(ctx, subs[0].ID, 0, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	claimed, err = r.ClaimRenewal(ctx, subs[0].ID, 1, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, claimed, "lease must not be taken twice")
+
+	due, err = r.DueForRenewal(ctx, now, now.Add(time.Hour*24), 10)
+	require.NoError(t, err)
+	require.Empty(t, due)
+
+	later := now.Add(time.Minute * 2)
+	claimed, err = r.ClaimRenewal(ctx, subs[0].ID, 0, later, later.Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, claimed, "claim based on a stale read must fail")
+
+	claimed, err = r.ClaimRenewal(ctx, subs[0].ID, 1, later, later.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, claimed, "lapsed lease can be taken over")
+}
+
+func TestClaim(t *testing.T) {
+	ctx := context.Background()
+	r := NewSubscriptionRepository(newTestDB(t))
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	sub := &model.Subscription{UserID: 1, State: model.Pending, Start: now, End: now.Add(time.Hour)}
+	require.NoError(t, r.Create(ctx, sub))
+
+	claimed, err := r.Claim(ctx, sub.ID, 0, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	claimed, err = r.Claim(ctx, sub.ID, 0, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, claimed, "claim based on a stale read must fail")
+
+	claimed, err = r.Claim(ctx, sub.ID, 1, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.False(t, claimed, "claim read after the first one must fail while it holds")
+
+	stored, err := r.GetByID(ctx, sub.ID)
+	require.NoError(t, err)
+	require.True(t, stored.Claimed(now))
+	stored.ClaimedUntil = nil
+	require.NoError(t, r.Save(ctx, stored))
+
+	claimed, err = r.Claim(ctx, sub.ID, stored.Version, now, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, claimed, "a released claim can be taken again")
+
+	later := now.Add(time.Minute * 2)
+	claimed, err = r.Claim(ctx, sub.ID, stored.Version+1, later, later.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, claimed, "lapsed claim can be taken over")
+}
+
+func TestTrials(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewSubscriptionRepository(db)
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	ending := &model.Subscription{UserID: 1, State: model.Trialing, AutoRenew: true, Start: now.Add(-time.Hour * 24 * 12), End: now.Add(time.Hour * 48)}
+	require.NoError(t, r.CreateTrial(ctx, ending, "flowmotion"))
+
+	used, err := r.TrialUsed(ctx, 1, "flowmotion")
+	require.NoError(t, err)
+	require.True(t, used)
+	used, err = r.TrialUsed(ctx, 2, "flowmotion")
+	require.NoError(t, err)
+	require.False(t, used)
+
+	second := &model.Subscription{UserID: 1, State: model.Trialing, AutoRenew: true, Start: now, End: now.Add(time.Hour * 24 * 14)}
+	require.ErrorIs(t, r.CreateTrial(ctx, second, "flowmotion"), gorm.ErrDuplicatedKey)
+	var count int64
+	require.NoError(t, db.Model(&model.Subscription{}).Count(&count).Error)
+	require.EqualValues(t, 1, count, "subscription of a rejected trial must not be kept")
+
+	// trials are converted when they end, not within the renewal lead time
+	due, err := r.DueForRenewal(ctx, now, now.Add(time.Hour*72), 10)
+	require.NoError(t, err)
+	require.Empty(t, due)
+	due, err = r.DueForRenewal(ctx, ending.End, ending.End.Add(time.Hour*72), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+
+	reminders, err := r.TrialsEnding(ctx, now.Add(time.Hour*72), 10)
+	require.NoError(t, err)
+	require.Len(t, reminders, 1)
+
+	marked, err := r.MarkTrialReminded(ctx, ending.ID, now)
+	require.NoError(t, err)
+	require.True(t, marked)
+	marked, err = r.MarkTrialReminded(ctx, ending.ID, now)
+	require.NoError(t, err)
+	require.False(t, marked, "trial must not be reminded twice")
+
+	reminders, err = r.TrialsEnding(ctx, now.Add(time.Hour*72), 10)
+	require.NoError(t, err)
+	require.Empty(t, reminders)
+}
+
+func TestSaveVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	r := NewSubscriptionRepository(newTestDB(t))
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	sub := &model.Subscription{UserID: 1, ProductID: 1, State: model.Active, Start: now, End: now.Add(time.Hour * 24)}
+	require.NoError(t, r.Create(ctx, sub))
+
+	first, err := r.GetByID(ctx, sub.ID)
+	require.NoError(t, err)
+	second, err := r.GetByID(ctx, sub.ID)
+	require.NoError(t, err)
+
+	first.State = model.Paused
+	require.NoError(t, r.Save(ctx, first))
+	require.Equal(t, uint(1), first.Version)
+
+	second.State = model.Cancelled
+	require.ErrorIs(t, r.Save(ctx, second), ErrVersionConflict)
+	require.Equal(t, uint(0), second.Version)
+
+	stored, err := r.GetByID(ctx, sub.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.Paused, stored.State)
+	require.Equal(t, uint(1), stored.Version)
+
+	// bulk transitions bump the version too
+	past := now.Add(-time.Hour)
+	stored.State, stored.End = model.Active, past
+	require.NoError(t, r.Save(ctx, stored))
+	expired, err := r.ExpireDue(ctx, now, nil, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), expired)
+	stored.State = model.Cancelled
+	require.ErrorIs(t, r.Save(ctx, stored), ErrVersionConflict)
+}
+
+func TestSaveRace(t *testing.T) {
+	ctx := context.Background()
+	r := NewSubscriptionRepository(newTestDB(t))
+
+	now := time.Date(2025, time.March, 10, 12, 0, 0, 0, time.UTC)
+	sub := &model.Subscription{UserID: 1, ProductID: 1, State: model.Active, Start: now, End: now.Add(time.Hour * 24)}
+	require.NoError(t, r.Create(ctx, sub))
+
+	const writers = 8
+	reads := make([]*model.Subscription, writers)
+	for i := range reads {
+		read, err := r.GetByID(ctx, sub.ID)
+		require.NoError(t, err)
+		reads[i] = read
+	}
+
+	var wg sync.WaitGroup
+	var saved, conflicts atomic.Int32
+	start := make(chan struct{})
+	for i := range writers {
+		wg.Add(1)
+		go func(read *model.Subscription, state model.State) {
+			defer wg.Done()
+			<-start
+			read.State = state
+			switch err := r.Save(ctx, read); {
+			case err == nil:
+				saved.Add(1)
+			case errors.Is(err, ErrVersionConflict):
+				conflicts.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(reads[i], model.State(i%2+2))
+	}
+	close(start)
+	wg.Wait()
+
+	require.Equal(t, int32(1), saved.Load(), "exactly one writer must win")
+	require.Equal(t, int32(writers-1), conflicts.Load())
+
+	stored, err := r.GetByID(ctx, sub.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), stored.Version)
+}
@@ -9,6 +9,14 @@ import (
 
 type UserRepository interface {
 	Exists(ctx context.Context, ID uint) (bool, error)
+	GetByID(ctx context.Context, ID uint) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	Create(ctx context.Context, user *model.User) error
+	Save(ctx context.Context, user *model.User) error
+	// SetRole reports false if there is no user with that ID.
+	SetRole(ctx context.Context, ID uint, role model.Role) (bool, error)
+	// SetTaxExempt reports false if there is no user with that ID.
+	SetTaxExempt(ctx context.Context, ID uint, exempt bool) (bool, error)
 }
 
 type userRepository struct {
@@ -26,3 +34,49 @@ func (r *userRepository) Exists(ctx context.Context, ID uint) (bool, error) {
 	}
 	return count > 0, nil
 }
+
+func (r *userRepository) GetByID(ctx context.Context, ID uint) (*model.User, error) {
+	var user model.User
+	if err := r.db.WithContext(ctx).First(&user, ID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	var user model.User
+	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) Create(ctx context.Context, user *model.User) error {
+	if err := r.db.WithContext(ctx).Create(user).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *userRepository) Save(ctx context.Context, user *model.User) error {
+	if err := r.db.WithContext(ctx).Save(user).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *userRepository) SetRole(ctx context.Context, ID uint, role model.Role) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", ID).Update("role", role)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *userRepository) SetTaxExempt(ctx context.Context, ID uint, exempt bool) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", ID).Update("tax_exempt", exempt)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestSetRole(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	r := NewUserRepository(db)
+
+	user := &model.User{Name: "Alice", Email: "alice@d.com"}
+	require.NoError(t, db.Create(user).Error)
+
+	var stored model.User
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	require.Equal(t, model.RoleCustomer, stored.Role, "users are customers by default")
+
+	updated, err := r.SetRole(ctx, user.ID, model.RoleSupport)
+	require.NoError(t, err)
+	require.True(t, updated)
+
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	require.Equal(t, model.RoleSupport, stored.Role)
+
+	updated, err = r.SetRole(ctx, user.ID+1, model.RoleAdmin)
+	require.NoError(t, err)
+	require.False(t, updated)
+}
+
+func TestUserEmailUnique(t *testing.T) {
+	ctx := context.Background()
+	r := NewUserRepository(newTestDB(t))
+
+	alice := &model.User{Name: "Alice", Email: "alice@d.com", PasswordHash: "hash"}
+	require.NoError(t, r.Create(ctx, alice))
+
+	err := r.Create(ctx, &model.User{Name: "Other Alice", Email: "alice@d.com"})
+	require.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+
+	bob := &model.User{Name: "Bob", Email: "bob@d.com"}
+	require.NoError(t, r.Create(ctx, bob))
+	bob.Email = "alice@d.com"
+	require.ErrorIs(t, r.Save(ctx, bob), gorm.ErrDuplicatedKey)
+
+	got, err := r.GetByEmail(ctx, "alice@d.com")
+	require.NoError(t, err)
+	require.Equal(t, alice.ID, got.ID)
+	require.Equal(t, "hash", got.PasswordHash)
+
+	_, err = r.GetByEmail(ctx, "carol@d.com")
+	require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
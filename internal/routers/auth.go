@@ -0,0 +1,23 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/controller"
+)
+
+func RegisterAuthRoutes(r *gin.Engine, c *controller.AuthController) {
+	auth := r.Group("/auth")
+	{
+		auth.POST("/register", c.Register)
+		auth.POST("/login", c.Login)
+		auth.POST("/refresh", c.Refresh)
+	}
+}
+
+func RegisterSessionRoutes(r *gin.Engine, c *controller.AuthController, authMiddleware gin.HandlerFunc) {
+	sessions := r.Group("/me/sessions", authMiddleware)
+	{
+		sessions.GET("", c.ListSessions)
+		sessions.DELETE("/:id", c.RevokeSession)
+	}
+}
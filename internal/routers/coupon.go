@@ -0,0 +1,20 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/controller"
+	"github.com/thatmatin/subserv/internal/middleware"
+)
+
+// RegisterAdminCouponRoutes registers the coupon management endpoints,
+// which need the coupons:write permission.
+func RegisterAdminCouponRoutes(r *gin.Engine, c *controller.CouponController, authMiddleware gin.HandlerFunc) {
+	coupons := r.Group("/admin/coupons", authMiddleware, middleware.RequirePermission(auth.CouponsWrite))
+	{
+		coupons.POST("", c.CreateCoupon)
+		coupons.GET("", c.ListCoupons)
+		coupons.GET("/:id", c.GetCoupon)
+		coupons.DELETE("/:id", c.ArchiveCoupon)
+	}
+}
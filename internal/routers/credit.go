@@ -0,0 +1,33 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/controller"
+	"github.com/thatmatin/subserv/internal/middleware"
+)
+
+func RegisterCreditRoutes(r *gin.Engine, c *controller.CreditController, authMiddleware gin.HandlerFunc) {
+	creditNotes := r.Group("/credit-notes", authMiddleware)
+	{
+		creditNotes.GET("", c.ListCreditNotes)
+		creditNotes.GET("/:id", c.GetCreditNote)
+	}
+
+	balance := r.Group("/me/balance", authMiddleware)
+	{
+		balance.GET("", c.GetMyBalance)
+		balance.GET("/transactions", c.ListMyBalanceTransactions)
+	}
+}
+
+// RegisterSupportCreditRoutes registers the read-only lookups of any user's
+// credit notes and balance history.
+func RegisterSupportCreditRoutes(r *gin.Engine, c *controller.CreditController, authMiddleware gin.HandlerFunc) {
+	users := r.Group("/admin/users", authMiddleware, middleware.RequirePermission(auth.BillingReadAny))
+	{
+		users.GET("/:id/credit-notes", c.ListUserCreditNotes)
+		users.GET("/:id/balance", c.GetUserBalance)
+		users.GET("/:id/balance/transactions", c.ListUserBalanceTransactions)
+	}
+}
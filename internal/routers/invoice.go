@@ -0,0 +1,15 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/controller"
+)
+
+func RegisterInvoiceRoutes(r *gin.Engine, c *controller.InvoiceController, authMiddleware gin.HandlerFunc) {
+	invoices := r.Group("/invoices", authMiddleware)
+	{
+		invoices.GET("", c.ListInvoices)
+		invoices.GET("/:id", c.GetInvoice)
+		invoices.GET("/:id/pdf", c.GetInvoicePDF)
+	}
+}
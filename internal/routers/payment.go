@@ -0,0 +1,18 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/controller"
+	"github.com/thatmatin/subserv/internal/middleware"
+)
+
+// RegisterAdminPaymentRoutes registers the refund endpoints, which need the
+// payments:refund permission.
+func RegisterAdminPaymentRoutes(r *gin.Engine, c *controller.PaymentController, authMiddleware gin.HandlerFunc, idempotency gin.HandlerFunc) {
+	payments := r.Group("/admin/payments", authMiddleware, middleware.RequirePermission(auth.PaymentsRefund))
+	{
+		payments.GET("/:id/refunds", c.ListRefunds)
+		payments.POST("/:id/refunds", idempotency, c.RefundPayment)
+	}
+}
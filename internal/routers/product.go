@@ -2,7 +2,9 @@ package routers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
 	"github.com/thatmatin/subserv/internal/controller"
+	"github.com/thatmatin/subserv/internal/middleware"
 )
 
 func RegisterProductRoutes(r *gin.Engine, c *controller.ProductController) {
@@ -12,3 +14,15 @@ func RegisterProductRoutes(r *gin.Engine, c *controller.ProductController) {
 		products.GET("/:id", c.GetProductByID)
 	}
 }
+
+// RegisterAdminProductRoutes registers the catalog management endpoints,
+// which need the products:write permission.
+func RegisterAdminProductRoutes(r *gin.Engine, c *controller.ProductController, authMiddleware gin.HandlerFunc) {
+	products := r.Group("/admin/products", authMiddleware, middleware.RequirePermission(auth.ProductsWrite))
+	{
+		products.POST("", c.CreateProduct)
+		products.PUT("/:id", c.ReplaceProduct)
+		products.PATCH("/:id", c.UpdateProduct)
+		products.DELETE("/:id", c.ArchiveProduct)
+	}
+}
@@ -0,0 +1,13 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/controller"
+)
+
+func RegisterQuoteRoutes(r *gin.Engine, c *controller.QuoteController, authMiddleware gin.HandlerFunc) {
+	quotes := r.Group("/quotes", authMiddleware)
+	{
+		quotes.POST("", c.CreateQuote)
+	}
+}
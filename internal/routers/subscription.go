@@ -2,18 +2,35 @@ package routers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
 	"github.com/thatmatin/subserv/internal/controller"
 	"github.com/thatmatin/subserv/internal/middleware"
 )
 
-func RegisterSubscriptionRoutes(r *gin.Engine, s *controller.SubscriptionController) {
-	subscriptions := r.Group("/subscriptions", middleware.AuthMiddleware())
+func RegisterSubscriptionRoutes(r *gin.Engine, s *controller.SubscriptionController, authMiddleware gin.HandlerFunc, idempotency gin.HandlerFunc) {
+	subscriptions := r.Group("/subscriptions", authMiddleware)
 	{
+		subscriptions.GET("", s.ListSubscriptions)
 		subscriptions.GET("/:id", s.GetSubscriptionByID)
-		subscriptions.POST("", s.CreateSubscription)
-		subscriptions.POST("/:id/purchase", s.Purchase)
+		subscriptions.GET("/:id/payments", s.ListPayments)
+		subscriptions.POST("", idempotency, s.CreateSubscription)
+		subscriptions.POST("/:id/purchase", idempotency, s.Purchase)
+		subscriptions.POST("/:id/change-plan", idempotency, s.ChangePlan)
 		subscriptions.PATCH("/:id/pause", s.PauseSubscription)
 		subscriptions.PATCH("/:id/unpause", s.UnpauseSubscription)
 		subscriptions.PATCH("/:id/cancel", s.CancelSubscription)
+		subscriptions.PATCH("/:id/uncancel", s.UncancelSubscription)
+		subscriptions.PATCH("/:id/auto-renew/enable", s.EnableAutoRenew)
+		subscriptions.PATCH("/:id/auto-renew/disable", s.DisableAutoRenew)
+	}
+}
+
+// RegisterSupportSubscriptionRoutes registers the read-only lookups support
+// staff use to find any user's subscriptions.
+func RegisterSupportSubscriptionRoutes(r *gin.Engine, s *controller.SubscriptionController, authMiddleware gin.HandlerFunc) {
+	admin := r.Group("/admin", authMiddleware, middleware.RequirePermission(auth.SubscriptionsReadAny))
+	{
+		admin.GET("/users/:id/subscriptions", s.ListUserSubscriptions)
+		admin.GET("/subscriptions/:id", s.LookupSubscription)
 	}
 }
@@ -0,0 +1,24 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/controller"
+	"github.com/thatmatin/subserv/internal/middleware"
+)
+
+func RegisterUserRoutes(r *gin.Engine, c *controller.UserController, authMiddleware gin.HandlerFunc) {
+	me := r.Group("/me", authMiddleware)
+	{
+		me.GET("", c.GetMe)
+		me.PATCH("", c.UpdateMe)
+	}
+}
+
+func RegisterAdminUserRoutes(r *gin.Engine, c *controller.UserController, authMiddleware gin.HandlerFunc) {
+	users := r.Group("/admin/users", authMiddleware, middleware.RequirePermission(auth.UsersWrite))
+	{
+		users.PUT("/:id/role", c.SetRole)
+		users.PUT("/:id/tax-exempt", c.SetTaxExempt)
+	}
+}
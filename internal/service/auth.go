@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// TokenPair is what a client gets after logging in. ExpiresIn is the
+// lifetime of the access token.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+}
+
+// ClientInfo describes the device a session is used from.
+type ClientInfo struct {
+	Device    string
+	UserAgent string
+	IP        string
+}
+
+type AuthService interface {
+	// Login starts a new session for the user.
+	Login(ctx context.Context, email string, password string, client ClientInfo) (*TokenPair, error)
+	// Refresh exchanges a refresh token for a new token pair of the same
+	// session. Every refresh token works once; presenting a used one again
+	// revokes the session, as the token has likely been stolen. The access
+	// token carries the user's current role.
+	Refresh(ctx context.Context, refreshToken string, client ClientInfo) (*TokenPair, error)
+	ListSessions(ctx context.Context, userID uint) ([]model.Session, error)
+	// RevokeSession logs the session out. Its access tokens stay valid until
+	// they expire.
+	RevokeSession(ctx context.Context, ID uint, userID uint) error
+}
+
+type authService struct {
+	userService UserService
+	sessionRepo repo.SessionRepository
+	cfg         auth.Config
+}
+
+func NewAuthService(userSvc UserService, sessionRepo repo.SessionRepository, cfg auth.Config) AuthService {
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = auth.DefaultAccessTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = auth.DefaultRefreshTTL
+	}
+	return &authService{userService: userSvc, sessionRepo: sessionRepo, cfg: cfg}
+}
+
+func (s *authService) Login(ctx context.Context, email string, password string, client ClientInfo) (*TokenPair, error) {
+	user, err := s.userService.Authenticate(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate refresh token: %w", err)
+	}
+
+	now := time.Now().In(UTCLocation)
+	session := &model.Session{
+		UserID:     user.ID,
+		Device:     client.Device,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.cfg.RefreshTTL),
+	}
+	if err := s.sessionRepo.Create(ctx, session, &model.SessionToken{TokenHash: hash}); err != nil {
+		return nil, fmt.Errorf("couldn't create session: %w", err)
+	}
+
+	return s.issue(user, session, refresh)
+}
+
+func (s *authService) Refresh(ctx context.Context, refreshToken string, client ClientInfo) (*TokenPair, error) {
+	used, err := s.sessionRepo.GetToken(ctx, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to fetch refresh token: %w", err)
+	}
+
+	now := time.Now().In(UTCLocation)
+	session := &used.Session
+	if session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if used.UsedAt != nil {
+		return nil, s.revokeReused(ctx, session, now)
+	}
+
+	user, err := s.userService.Get(ctx, session.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	refresh, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't generate refresh token: %w", err)
+	}
+
+	session.UserAgent = client.UserAgent
+	session.IP = client.IP
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(s.cfg.RefreshTTL)
+	rotated, err := s.sessionRepo.Rotate(ctx, used, &model.SessionToken{TokenHash: hash}, session)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't rotate refresh token: %w", err)
+	}
+	if !rotated {
+		return nil, s.revokeReused(ctx, session, now)
+	}
+
+	return s.issue(user, session, refresh)
+}
+
+// revokeReused ends a session whose refresh token was presented twice.
+func (s *authService) revokeReused(ctx context.Context, session *model.Session, now time.Time) error {
+	if err := s.sessionRepo.Revoke(ctx, session.ID, now); err != nil {
+		return fmt.Errorf("couldn't revoke session %d after refresh token reuse: %w", session.ID, err)
+	}
+	return ErrInvalidRefreshToken
+}
+
+func (s *authService) ListSessions(ctx context.Context, userID uint) ([]model.Session, error) {
+	sessions, err := s.sessionRepo.ListActive(ctx, userID, time.Now().In(UTCLocation))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (s *authService) RevokeSession(ctx context.Context, ID uint, userID uint) error {
+	session, err := s.sessionRepo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to fetch session: %w", err)
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+
+	if err := s.sessionRepo.Revoke(ctx, ID, time.Now().In(UTCLocation)); err != nil {
+		return fmt.Errorf("couldn't revoke session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *authService) issue(user *model.User, session *model.Session, refresh string) (*TokenPair, error) {
+	access, err := auth.IssueSessionToken(s.cfg, user.ID, user.Role, session.ID, s.cfg.AccessTTL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't issue access token: %w", err)
+	}
+
+	return &TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: s.cfg.AccessTTL}, nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/auth"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	cfg := auth.Config{Secret: "test-secret", AccessTTL: time.Minute}
+	verifier, err := auth.NewVerifier(cfg)
+	require.NoError(t, err)
+
+	userRepo := new(mock.MockUserRepo)
+	userRepo.On("GetByEmail", ctx, "alice@d.com").Return(hashedUser(t, "correct horse"), nil)
+	sessionRepo := new(mock.MockSessionRepo)
+	var stored *model.SessionToken
+	sessionRepo.On("Create", ctx, mocklib.MatchedBy(func(s *model.Session) bool {
+		return s.UserID == 1 && s.Device == "laptop" && s.UserAgent == "curl" && s.ExpiresAt.Sub(s.LastUsedAt) == auth.DefaultRefreshTTL
+	}), mocklib.AnythingOfType("*model.SessionToken")).Run(func(args mocklib.Arguments) {
+		args.Get(1).(*model.Session).ID = 5
+		stored = args.Get(2).(*model.SessionToken)
+	}).Return(nil).Once()
+	svc := NewAuthService(NewUserService(userRepo), sessionRepo, cfg)
+
+	_, err = svc.Login(ctx, "alice@d.com", "wrong horse", ClientInfo{})
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	pair, err := svc.Login(ctx, "alice@d.com", "correct horse", ClientInfo{Device: "laptop", UserAgent: "curl"})
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, pair.ExpiresIn)
+	require.Equal(t, auth.HashRefreshToken(pair.RefreshToken), stored.TokenHash, "only the hash is stored")
+
+	claims, err := verifier.Verify(pair.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleCustomer, claims.UserRole())
+	require.Equal(t, uint(5), claims.SessionID)
+
+	sessionRepo.AssertExpectations(t)
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	cfg := auth.Config{Secret: "test-secret"}
+	now := time.Now()
+	usedAt := now.Add(-time.Minute)
+	revokedAt := now.Add(-time.Minute)
+
+	tokenOf := func(session model.Session, usedAt *time.Time) *model.SessionToken {
+		return &model.SessionToken{ID: 3, SessionID: session.ID, Session: session, TokenHash: auth.HashRefreshToken("refresh"), UsedAt: usedAt}
+	}
+	active := model.Session{Model: gorm.Model{ID: 7}, UserID: 1, ExpiresAt: now.Add(time.Hour)}
+	revoked := active
+	revoked.RevokedAt = &revokedAt
+	expired := active
+	expired.ExpiresAt = now.Add(-time.Second)
+
+	testCases := []struct {
+		name        string
+		expectedErr error
+		setupMock   func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo)
+	}{
+		{
+			name: "token rotated",
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return(tokenOf(active, nil), nil)
+				userRepo.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, Role: model.RoleSupport}, nil)
+				sessionRepo.On("Rotate", ctx, mocklib.AnythingOfType("*model.SessionToken"), mocklib.MatchedBy(func(next *model.SessionToken) bool {
+					return next.TokenHash != auth.HashRefreshToken("refresh")
+				}), mocklib.MatchedBy(func(s *model.Session) bool {
+					return s.ID == 7 && s.UserAgent == "app/2.0"
+				})).Return(true, nil)
+			},
+		},
+		{
+			name:        "unknown token",
+			expectedErr: ErrInvalidRefreshToken,
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return((*model.SessionToken)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+		{
+			name:        "reused token revokes the session",
+			expectedErr: ErrInvalidRefreshToken,
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return(tokenOf(active, &usedAt), nil)
+				sessionRepo.On("Revoke", ctx, uint(7), mocklib.Anything).Return(nil)
+			},
+		},
+		{
+			name:        "concurrent refresh with the same token revokes the session",
+			expectedErr: ErrInvalidRefreshToken,
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return(tokenOf(active, nil), nil)
+				userRepo.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+				sessionRepo.On("Rotate", ctx, mocklib.Anything, mocklib.Anything, mocklib.Anything).Return(false, nil)
+				sessionRepo.On("Revoke", ctx, uint(7), mocklib.Anything).Return(nil)
+			},
+		},
+		{
+			name:        "revoked session",
+			expectedErr: ErrInvalidRefreshToken,
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return(tokenOf(revoked, nil), nil)
+			},
+		},
+		{
+			name:        "expired session",
+			expectedErr: ErrInvalidRefreshToken,
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return(tokenOf(expired, nil), nil)
+			},
+		},
+		{
+			name:        "deleted user",
+			expectedErr: ErrInvalidRefreshToken,
+			setupMock: func(sessionRepo *mock.MockSessionRepo, userRepo *mock.MockUserRepo) {
+				sessionRepo.On("GetToken", ctx, auth.HashRefreshToken("refresh")).Return(tokenOf(active, nil), nil)
+				userRepo.On("GetByID", ctx, uint(1)).Return((*model.User)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sessionRepo := new(mock.MockSessionRepo)
+			userRepo := new(mock.MockUserRepo)
+			tc.setupMock(sessionRepo, userRepo)
+			svc := NewAuthService(NewUserService(userRepo), sessionRepo, cfg)
+
+			pair, err := svc.Refresh(ctx, "refresh", ClientInfo{UserAgent: "app/2.0"})
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.NotEqual(t, "refresh", pair.RefreshToken)
+			}
+
+			sessionRepo.AssertExpectations(t)
+			userRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	sessionRepo := new(mock.MockSessionRepo)
+	sessionRepo.On("GetByID", ctx, uint(1)).Return(&model.Session{Model: gorm.Model{ID: 1}, UserID: 1}, nil)
+	sessionRepo.On("GetByID", ctx, uint(2)).Return((*model.Session)(nil), gorm.ErrRecordNotFound)
+	sessionRepo.On("Revoke", ctx, uint(1), mocklib.Anything).Return(nil).Once()
+	svc := NewAuthService(nil, sessionRepo, auth.Config{})
+
+	require.ErrorIs(t, svc.RevokeSession(ctx, 1, 2), ErrSessionNotFound, "sessions of other users are not found")
+	require.ErrorIs(t, svc.RevokeSession(ctx, 2, 1), ErrSessionNotFound)
+	require.NoError(t, svc.RevokeSession(ctx, 1, 1))
+
+	sessionRepo.AssertExpectations(t)
+}
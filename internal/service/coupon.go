@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// CouponParams describes a coupon. Exactly one of PercentOff and
+// AmountOffCent is set; an amount off needs its Currency. DurationCycles is
+// only set for model.CouponRepeating. Zero limits are unlimited, and without
+// ProductIDs the coupon applies to every product.
+type CouponParams struct {
+	Code           string
+	Name           string
+	PercentOff     money.Rate
+	AmountOffCent  int
+	Currency       string
+	Duration       model.CouponDuration
+	DurationCycles uint
+	MaxRedemptions uint
+	MaxPerUser     uint
+	ExpiresAt      *time.Time
+	ProductIDs     []uint
+}
+
+type CouponPage struct {
+	Coupons    []model.Coupon
+	NextCursor string
+}
+
+type CouponService interface {
+	Create(ctx context.Context, params CouponParams) (*model.Coupon, error)
+	Get(ctx context.Context, ID uint) (*model.Coupon, error)
+	// List returns one page of the active coupons, newest first.
+	List(ctx context.Context, cursor string, limit int) (*CouponPage, error)
+	// Archive stops the coupon from being redeemed. Subscriptions that
+	// redeemed it keep their discount.
+	Archive(ctx context.Context, ID uint) error
+	// Check finds the coupon with the promo code and makes sure the user can
+	// redeem it for the product priced in currency.
+	Check(ctx context.Context, code string, userID uint, productID uint, currency string) (*model.Coupon, error)
+	// Redeem counts a redemption of the coupon by the user against its
+	// limits.
+	Redeem(ctx context.Context, coupon *model.Coupon, userID uint) error
+	// Release undoes Redeem, e.g. when the subscription couldn't be created.
+	Release(ctx context.Context, couponID uint, userID uint)
+	// AppliesTo reports whether the coupon, archived or not, discounts the
+	// product.
+	AppliesTo(ctx context.Context, couponID uint, productID uint) (bool, error)
+}
+
+type couponService struct {
+	repo           repo.CouponRepository
+	productService ProductService
+}
+
+func NewCouponService(repo repo.CouponRepository, prodSvc ProductService) CouponService {
+	return &couponService{repo: repo, productService: prodSvc}
+}
+
+var couponCode = regexp.MustCompile(`^[A-Z0-9_-]{3,50}$`)
+
+func (s *couponService) Create(ctx context.Context, params CouponParams) (*model.Coupon, error) {
+	coupon := &model.Coupon{
+		Code:           normalizeCode(params.Code),
+		Name:           strings.TrimSpace(params.Name),
+		PercentOff:     params.PercentOff,
+		AmountOffCent:  params.AmountOffCent,
+		Currency:       strings.ToUpper(params.Currency),
+		Duration:       params.Duration,
+		DurationCycles: params.DurationCycles,
+		MaxRedemptions: params.MaxRedemptions,
+		MaxPerUser:     params.MaxPerUser,
+		ExpiresAt:      params.ExpiresAt,
+	}
+	if err := validateCoupon(coupon); err != nil {
+		return nil, err
+	}
+
+	ids := slices.Clone(params.ProductIDs)
+	slices.Sort(ids)
+	for _, id := range slices.Compact(ids) {
+		if _, err := s.productService.Get(ctx, id); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %d", ErrProductNotFound, id)
+			}
+			return nil, fmt.Errorf("couldn't fetch product: %w", err)
+		}
+		coupon.Products = append(coupon.Products, model.CouponProduct{ProductID: id})
+	}
+
+	if err := s.repo.Create(ctx, coupon); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, fmt.Errorf("%w: code %s is taken", ErrInvalidCoupon, coupon.Code)
+		}
+		return nil, fmt.Errorf("couldn't create coupon: %w", err)
+	}
+
+	return coupon, nil
+}
+
+func (s *couponService) Get(ctx context.Context, ID uint) (*model.Coupon, error) {
+	coupon, err := s.repo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch coupon: %w", err)
+	}
+
+	return coupon, nil
+}
+
+func (s *couponService) List(ctx context.Context, cursor string, limit int) (*CouponPage, error) {
+	beforeID, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit = pageSize(limit)
+	coupons, err := s.repo.List(ctx, repo.CouponFilter{BeforeID: beforeID, Limit: limit + 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list coupons: %w", err)
+	}
+
+	page := &CouponPage{Coupons: coupons}
+	if len(coupons) > limit {
+		page.Coupons = coupons[:limit]
+		page.NextCursor = encodeCursor(page.Coupons[limit-1].ID)
+	}
+
+	return page, nil
+}
+
+func (s *couponService) Archive(ctx context.Context, ID uint) error {
+	archived, err := s.repo.Archive(ctx, ID)
+	if err != nil {
+		return fmt.Errorf("couldn't archive coupon: %w", err)
+	}
+	if !archived {
+		return ErrCouponNotFound
+	}
+
+	return nil
+}
+
+func (s *couponService) Check(ctx context.Context, code string, userID uint, productID uint, currency string) (*model.Coupon, error) {
+	code = normalizeCode(code)
+	coupon, err := s.repo.GetByCode(ctx, code)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s doesn't exist", ErrInvalidPromoCode, code)
+		}
+		return nil, fmt.Errorf("failed to fetch coupon: %w", err)
+	}
+
+	switch {
+	case coupon.Expired(time.Now().In(UTCLocation)):
+		return nil, fmt.Errorf("%w: %s has expired", ErrInvalidPromoCode, code)
+	case !coupon.AppliesTo(productID):
+		return nil, fmt.Errorf("%w: %s isn't valid for this product", ErrInvalidPromoCode, code)
+	case coupon.AmountOffCent > 0 && coupon.Currency != currency:
+		return nil, fmt.Errorf("%w: %s is only valid in %s", ErrInvalidPromoCode, code, coupon.Currency)
+	case coupon.MaxRedemptions > 0 && coupon.TimesRedeemed >= coupon.MaxRedemptions:
+		return nil, fmt.Errorf("%w: %s has been fully redeemed", ErrInvalidPromoCode, code)
+	}
+
+	if coupon.MaxPerUser > 0 {
+		count, err := s.repo.CountRedemptions(ctx, coupon.ID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't count redemptions: %w", err)
+		}
+		if count >= int64(coupon.MaxPerUser) {
+			return nil, fmt.Errorf("%w: you already redeemed %s", ErrInvalidPromoCode, code)
+		}
+	}
+
+	return coupon, nil
+}
+
+func (s *couponService) Redeem(ctx context.Context, coupon *model.Coupon, userID uint) error {
+	err := s.repo.Redeem(ctx, coupon, userID, time.Now().In(UTCLocation))
+	if err != nil {
+		// archived coupons are rejected like used up ones
+		if errors.Is(err, repo.ErrCouponLimitReached) {
+			return fmt.Errorf("%w: %s can't be redeemed anymore", ErrInvalidPromoCode, coupon.Code)
+		}
+		return fmt.Errorf("couldn't redeem coupon: %w", err)
+	}
+
+	return nil
+}
+
+func (s *couponService) Release(ctx context.Context, couponID uint, userID uint) {
+	if err := s.repo.Release(ctx, couponID, userID); err != nil {
+		log.Printf("couldn't release redemption of coupon %d by user %d: %v", couponID, userID, err)
+	}
+}
+
+func (s *couponService) AppliesTo(ctx context.Context, couponID uint, productID uint) (bool, error) {
+	coupon, err := s.repo.GetByIDWithArchived(ctx, couponID)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch coupon: %w", err)
+	}
+
+	return coupon.AppliesTo(productID), nil
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func validateCoupon(coupon *model.Coupon) error {
+	switch {
+	case !couponCode.MatchString(coupon.Code):
+		return fmt.Errorf("%w: code must be 3 to 50 letters, digits, dashes or underscores", ErrInvalidCoupon)
+	case len(coupon.Name) > 255:
+		return fmt.Errorf("%w: name is too long", ErrInvalidCoupon)
+	case (coupon.PercentOff > 0) == (coupon.AmountOffCent > 0):
+		return fmt.Errorf("%w: set either a percent or an amount off", ErrInvalidCoupon)
+	case coupon.PercentOff < 0 || coupon.PercentOff > money.Percent(100):
+		return fmt.Errorf("%w: percent off must be between 0 and 100", ErrInvalidCoupon)
+	case coupon.AmountOffCent < 0:
+		return fmt.Errorf("%w: amount off must be positive", ErrInvalidCoupon)
+	case coupon.AmountOffCent > 0 && !money.Currency(coupon.Currency).Valid():
+		return fmt.Errorf("%w: an amount off needs an ISO 4217 currency", ErrInvalidCoupon)
+	case coupon.PercentOff > 0 && coupon.Currency != "":
+		return fmt.Errorf("%w: a percent off applies in every currency", ErrInvalidCoupon)
+	case !coupon.Duration.Valid():
+		return fmt.Errorf("%w: duration must be once, repeating or forever", ErrInvalidCoupon)
+	case coupon.Duration == model.CouponRepeating && coupon.DurationCycles == 0:
+		return fmt.Errorf("%w: a repeating coupon needs duration cycles", ErrInvalidCoupon)
+	case coupon.Duration != model.CouponRepeating && coupon.DurationCycles != 0:
+		return fmt.Errorf("%w: duration cycles are only for repeating coupons", ErrInvalidCoupon)
+	}
+
+	return nil
+}
+
+// discountOf is the discount of a percent and an amount off on one period at
+// a net price. It never exceeds the price.
+func discountOf(rounding money.RoundingMode, percent money.Rate, amountCent int, net int) int {
+	off := int(percent.Of(int64(net), rounding)) + amountCent
+	return max(min(off, net), 0)
+}
+
+// nextDiscount is the discount of the subscription's coupon on its next
+// charged period at a net price.
+func nextDiscount(rounding money.RoundingMode, sub *model.Subscription, net int) int {
+	if !sub.Discounted() {
+		return 0
+	}
+	return discountOf(rounding, sub.DiscountPercent, sub.DiscountAmountCent, net)
+}
+
+// applyCoupon copies the coupon's terms onto the subscription.
+func applyCoupon(sub *model.Subscription, coupon *model.Coupon) {
+	sub.CouponID = &coupon.ID
+	sub.CouponCode = coupon.Code
+	sub.DiscountPercent = coupon.PercentOff
+	sub.DiscountAmountCent = coupon.AmountOffCent
+	sub.DiscountCyclesLeft, sub.DiscountForever = coupon.Cycles()
+}
+
+// startDiscountedPeriod records the discount of a period that was just
+// charged at the subscription's price, and uses up one of its cycles.
+func startDiscountedPeriod(rounding money.RoundingMode, sub *model.Subscription) {
+	sub.DiscountCent = nextDiscount(rounding, sub, sub.PriceCent)
+	if sub.Discounted() && !sub.DiscountForever {
+		sub.DiscountCyclesLeft--
+	}
+}
+
+// endDiscount stops discounting the subscription's future periods.
+func endDiscount(sub *model.Subscription) {
+	sub.DiscountCyclesLeft = 0
+	sub.DiscountForever = false
+}
+
+// keepsDiscount reports whether the subscription's discount carries over to
+// the product it switches to.
+func keepsDiscount(ctx context.Context, coupons CouponService, sub *model.Subscription, productID uint) (bool, error) {
+	if !sub.Discounted() {
+		return false, nil
+	}
+	return coupons.AppliesTo(ctx, *sub.CouponID, productID)
+}
@@ -0,0 +1,566 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+func TestCreateCoupon(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name          string
+		params        CouponParams
+		setupMock     func(coupons *mock.MockCouponRepo, products *mock.MockProductRepo)
+		expectedErr   error
+		errorContains string
+	}{
+		{
+			name:   "percent off for some products",
+			params: CouponParams{Code: " spring25 ", PercentOff: money.Percent(25), Duration: model.CouponRepeating, DurationCycles: 3, ProductIDs: []uint{2, 1, 2}},
+			setupMock: func(coupons *mock.MockCouponRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}}, nil)
+				products.On("GetByID", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}}, nil)
+				coupons.On("Create", ctx, mocklib.MatchedBy(func(c *model.Coupon) bool {
+					return c.Code == "SPRING25" && len(c.Products) == 2 && c.Products[0].ProductID == 1 && c.Products[1].ProductID == 2
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "amount off",
+			params: CouponParams{Code: "FIVEOFF", AmountOffCent: 500, Currency: "eur", Duration: model.CouponForever},
+			setupMock: func(coupons *mock.MockCouponRepo, products *mock.MockProductRepo) {
+				coupons.On("Create", ctx, mocklib.MatchedBy(func(c *model.Coupon) bool {
+					return c.Currency == "EUR" && c.AmountOffCent == 500
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "taken code",
+			params:        CouponParams{Code: "FIVEOFF", AmountOffCent: 500, Currency: "EUR", Duration: model.CouponOnce},
+			expectedErr:   ErrInvalidCoupon,
+			errorContains: "code FIVEOFF is taken",
+			setupMock: func(coupons *mock.MockCouponRepo, products *mock.MockProductRepo) {
+				coupons.On("Create", ctx, mocklib.Anything).Return(gorm.ErrDuplicatedKey)
+			},
+		},
+		{
+			name:        "unknown product",
+			params:      CouponParams{Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponOnce, ProductIDs: []uint{9}},
+			expectedErr: ErrProductNotFound,
+			setupMock: func(coupons *mock.MockCouponRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(9)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+		{name: "invalid code", params: CouponParams{Code: "25%", PercentOff: money.Percent(25), Duration: model.CouponOnce}, expectedErr: ErrInvalidCoupon},
+		{name: "no discount", params: CouponParams{Code: "NOTHING", Duration: model.CouponOnce}, expectedErr: ErrInvalidCoupon},
+		{name: "percent and amount off", params: CouponParams{Code: "BOTH", PercentOff: money.Percent(10), AmountOffCent: 100, Currency: "EUR", Duration: model.CouponOnce}, expectedErr: ErrInvalidCoupon},
+		{name: "more than 100 percent", params: CouponParams{Code: "FREEPLUS", PercentOff: money.Percent(101), Duration: model.CouponOnce}, expectedErr: ErrInvalidCoupon},
+		{name: "amount off without currency", params: CouponParams{Code: "FIVEOFF", AmountOffCent: 500, Duration: model.CouponOnce}, expectedErr: ErrInvalidCoupon},
+		{name: "unknown duration", params: CouponParams{Code: "SPRING25", PercentOff: money.Percent(25), Duration: "weekly"}, expectedErr: ErrInvalidCoupon},
+		{name: "repeating without cycles", params: CouponParams{Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponRepeating}, expectedErr: ErrInvalidCoupon},
+		{name: "cycles of a one-off coupon", params: CouponParams{Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponOnce, DurationCycles: 2}, expectedErr: ErrInvalidCoupon},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			coupons := new(mock.MockCouponRepo)
+			products := new(mock.MockProductRepo)
+			if tc.setupMock != nil {
+				tc.setupMock(coupons, products)
+			}
+			svc := NewCouponService(coupons, &productService{products})
+
+			_, err := svc.Create(ctx, tc.params)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				require.ErrorContains(t, err, tc.errorContains)
+			} else {
+				require.NoError(t, err)
+			}
+
+			coupons.AssertExpectations(t)
+			products.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheckCoupon(t *testing.T) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+
+	coupon := func() *model.Coupon {
+		return &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponOnce}
+	}
+
+	testCases := []struct {
+		name          string
+		coupon        func() *model.Coupon
+		redeemed      int64
+		errorContains string
+	}{
+		{name: "valid", coupon: coupon},
+		{name: "unknown", coupon: func() *model.Coupon { return nil }, errorContains: "SPRING25 doesn't exist"},
+		{name: "expired", coupon: func() *model.Coupon { c := coupon(); c.ExpiresAt = &past; return c }, errorContains: "has expired"},
+		{
+			name: "other products only",
+			coupon: func() *model.Coupon {
+				c := coupon()
+				c.Products = []model.CouponProduct{{CouponID: 4, ProductID: 3}}
+				return c
+			},
+			errorContains: "isn't valid for this product",
+		},
+		{
+			name: "amount off in another currency",
+			coupon: func() *model.Coupon {
+				c := coupon()
+				c.PercentOff, c.AmountOffCent, c.Currency = 0, 500, "EUR"
+				return c
+			},
+			errorContains: "only valid in EUR",
+		},
+		{
+			name:          "fully redeemed",
+			coupon:        func() *model.Coupon { c := coupon(); c.MaxRedemptions, c.TimesRedeemed = 10, 10; return c },
+			errorContains: "fully redeemed",
+		},
+		{
+			name:     "below the per-user limit",
+			coupon:   func() *model.Coupon { c := coupon(); c.MaxPerUser = 2; return c },
+			redeemed: 1,
+		},
+		{
+			name:          "per-user limit reached",
+			coupon:        func() *model.Coupon { c := coupon(); c.MaxPerUser = 1; return c },
+			redeemed:      1,
+			errorContains: "you already redeemed SPRING25",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			coupons := new(mock.MockCouponRepo)
+			c := tc.coupon()
+			if c == nil {
+				coupons.On("GetByCode", ctx, "SPRING25").Return((*model.Coupon)(nil), gorm.ErrRecordNotFound)
+			} else {
+				coupons.On("GetByCode", ctx, "SPRING25").Return(c, nil)
+				coupons.On("CountRedemptions", ctx, uint(4), uint(1)).Return(tc.redeemed, nil).Maybe()
+			}
+			svc := NewCouponService(coupons, &productService{})
+
+			got, err := svc.Check(ctx, "spring25", 1, 2, model.DefaultCurrency)
+			if tc.errorContains != "" {
+				require.ErrorIs(t, err, ErrInvalidPromoCode)
+				require.ErrorContains(t, err, tc.errorContains)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c, got)
+			}
+
+			coupons.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateWithPromoCode(t *testing.T) {
+	ctx := context.Background()
+	coupon := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponRepeating, DurationCycles: 3}
+
+	testCases := []struct {
+		name        string
+		trialDays   uint
+		redeemErr   error
+		createErr   error
+		expectedErr error
+	}{
+		{name: "applies the coupon without redeeming it"},
+		{name: "trial redeems the coupon", trialDays: 14},
+		{name: "coupon used up concurrently", trialDays: 14, redeemErr: repo.ErrCouponLimitReached, expectedErr: ErrInvalidPromoCode},
+		{name: "trial redemption released when the subscription can't be created", trialDays: 14, createErr: gorm.ErrInvalidDB},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subs := new(mock.MockSubscriptionRepo)
+			products := new(mock.MockProductRepo)
+			users := new(mock.MockUserRepo)
+			coupons := new(mock.MockCouponRepo)
+
+			users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+			products.On("GetByID", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Price: 1000, Duration: 3600, TrialDays: tc.trialDays}, nil)
+			coupons.On("GetByCode", ctx, "SPRING25").Return(coupon, nil)
+			withCoupon := mocklib.MatchedBy(func(sub *model.Subscription) bool {
+				return sub.PriceCent == 1000 && *sub.CouponID == 4 && sub.CouponCode == "SPRING25" &&
+					sub.DiscountPercent == money.Percent(25) && sub.DiscountCyclesLeft == 3 && !sub.DiscountForever
+			})
+			if tc.trialDays == 0 {
+				subs.On("Create", ctx, withCoupon).Return(tc.createErr)
+			} else {
+				subs.On("TrialUsed", ctx, uint(1), "product-2").Return(false, nil)
+				coupons.On("Redeem", ctx, coupon, uint(1), mocklib.Anything).Return(tc.redeemErr)
+				if tc.redeemErr == nil {
+					subs.On("CreateTrial", ctx, withCoupon, "product-2").Return(tc.createErr)
+				}
+			}
+			if tc.createErr != nil {
+				coupons.On("Release", ctx, uint(4), uint(1)).Return(nil)
+			}
+
+			svc := NewSubscriptionService(subs, new(mock.MockPaymentRepo), &productService{products}, &userService{users}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, NewCouponService(coupons, &productService{products}), money.DefaultTaxPolicy)
+
+			_, err := svc.Create(ctx, 2, 1, "", "spring25")
+			switch {
+			case tc.expectedErr != nil:
+				require.ErrorIs(t, err, tc.expectedErr)
+			case tc.createErr != nil:
+				require.ErrorIs(t, err, tc.createErr)
+			default:
+				require.NoError(t, err)
+			}
+
+			subs.AssertExpectations(t)
+			coupons.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPurchaseWithCoupon(t *testing.T) {
+	ctx := context.Background()
+	couponID := uint(4)
+	coupon := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponRepeating, DurationCycles: 3, MaxRedemptions: 10}
+
+	subsRepo := new(mock.MockSubscriptionRepo)
+	payRepo := new(mock.MockPaymentRepo)
+	coupons := new(mock.MockCouponRepo)
+	coupons.On("GetByID", ctx, uint(4)).Return(coupon, nil)
+	coupons.On("Redeem", ctx, coupon, uint(1), mocklib.Anything).Return(nil).Once()
+	subsRepo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+		Model:              gorm.Model{ID: 1},
+		UserID:             1,
+		ProductID:          2,
+		State:              model.Pending,
+		PriceCent:          1000,
+		TaxRate:            money.Percent(10),
+		Currency:           model.DefaultCurrency,
+		Start:              fixedTime,
+		End:                fixedTime.Add(time.Hour * 24 * 30),
+		CouponID:           &couponID,
+		CouponCode:         "SPRING25",
+		DiscountPercent:    money.Percent(25),
+		DiscountCyclesLeft: 2,
+	}, nil)
+	subsRepo.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+	// 750 cents after the discount plus 10% tax
+	payRepo.On("Create", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+		return p.AmountCent == 825 && p.TaxCent == 75
+	})).Return(nil)
+	payRepo.On("Save", ctx, mocklib.Anything).Return(nil)
+	subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+		return sub.State == model.Active && sub.DiscountCent == 250 && sub.DiscountCyclesLeft == 1
+	})).Return(nil)
+
+	processor := &stubPaymentProcessor{success: true}
+	invoices := &stubInvoiceService{}
+	svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, processor, &quoteService{}, &taxService{}, invoices, &stubCreditService{}, &couponService{repo: coupons}, money.DefaultTaxPolicy)
+	require.NoError(t, svc.Purchase(ctx, 1, 1, ""))
+
+	require.Len(t, processor.requests, 1)
+	require.Equal(t, 825, processor.requests[0].Amount)
+	require.Len(t, invoices.issued, 1)
+	items := invoices.issued[0]
+	require.Len(t, items, 2)
+	require.Equal(t, InvoiceItem{ProductID: 2, Coupon: "SPRING25", PeriodStart: items[0].PeriodStart, PeriodEnd: items[0].PeriodEnd, NetCent: -250, TaxRate: money.Percent(10), TaxCent: -25}, items[1])
+	require.Equal(t, 825, items[0].NetCent+items[0].TaxCent+items[1].NetCent+items[1].TaxCent)
+
+	subsRepo.AssertExpectations(t)
+	payRepo.AssertExpectations(t)
+	coupons.AssertExpectations(t)
+}
+
+func TestPurchaseCouponRedemption(t *testing.T) {
+	ctx := context.Background()
+	couponID := uint(4)
+	coupon := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponOnce}
+
+	testCases := []struct {
+		name        string
+		archived    bool
+		redeemErr   error
+		recordErr   error
+		released    bool
+		expectedErr error
+	}{
+		{name: "used up since the subscription was created", redeemErr: repo.ErrCouponLimitReached, expectedErr: ErrInvalidPromoCode},
+		{name: "archived since the subscription was created", archived: true, expectedErr: ErrInvalidPromoCode},
+		{name: "released when the payment is declined", released: true, expectedErr: ErrFailedPayment},
+		{name: "released when the payment can't be recorded", recordErr: gorm.ErrInvalidDB, released: true, expectedErr: gorm.ErrInvalidDB},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := new(mock.MockSubscriptionRepo)
+			payRepo := new(mock.MockPaymentRepo)
+			coupons := new(mock.MockCouponRepo)
+
+			subsRepo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+				Model:              gorm.Model{ID: 1},
+				UserID:             1,
+				ProductID:          2,
+				State:              model.Pending,
+				PriceCent:          1000,
+				Currency:           model.DefaultCurrency,
+				Start:              fixedTime,
+				End:                fixedTime.Add(time.Hour * 24 * 30),
+				CouponID:           &couponID,
+				CouponCode:         "SPRING25",
+				DiscountPercent:    money.Percent(25),
+				DiscountCyclesLeft: 1,
+			}, nil)
+			subsRepo.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+			// nothing is charged, so the claim is given back
+			subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+				return sub.State == model.Pending && sub.ClaimedUntil == nil
+			})).Return(nil).Once()
+			if tc.archived {
+				coupons.On("GetByID", ctx, uint(4)).Return((*model.Coupon)(nil), gorm.ErrRecordNotFound)
+			} else {
+				coupons.On("GetByID", ctx, uint(4)).Return(coupon, nil)
+				coupons.On("Redeem", ctx, coupon, uint(1), mocklib.Anything).Return(tc.redeemErr)
+			}
+			if tc.released {
+				payRepo.On("Create", ctx, mocklib.Anything).Return(tc.recordErr)
+				payRepo.On("Save", ctx, mocklib.Anything).Return(nil).Maybe()
+				coupons.On("Release", ctx, uint(4), uint(1)).Return(nil).Once()
+			}
+
+			processor := &stubPaymentProcessor{}
+			svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, processor, &quoteService{}, &taxService{}, &stubInvoiceService{}, &stubCreditService{}, &couponService{repo: coupons}, money.DefaultTaxPolicy)
+
+			require.ErrorIs(t, svc.Purchase(ctx, 1, 1, ""), tc.expectedErr)
+			if !tc.released {
+				require.Empty(t, processor.requests, "nothing is charged without a redemption")
+			}
+			payRepo.AssertExpectations(t)
+
+			coupons.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFreeCoupon(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+	period := time.Hour * 24 * 30
+	coupon := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "FREE", PercentOff: money.Percent(100), Duration: model.CouponForever}
+
+	t.Run("purchase", func(t *testing.T) {
+		subs := new(mock.MockSubscriptionRepo)
+		products := new(mock.MockProductRepo)
+		users := new(mock.MockUserRepo)
+		coupons := new(mock.MockCouponRepo)
+		payRepo := new(mock.MockPaymentRepo)
+
+		users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+		products.On("GetByID", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Price: 1000, TaxRate: 10, Duration: period / time.Second}, nil)
+		coupons.On("GetByCode", ctx, "FREE").Return(coupon, nil)
+		coupons.On("GetByID", ctx, uint(4)).Return(coupon, nil)
+		coupons.On("Redeem", ctx, coupon, uint(1), mocklib.Anything).Return(nil)
+		var created *model.Subscription
+		subs.On("Create", ctx, mocklib.Anything).Run(func(args mocklib.Arguments) {
+			created = args.Get(1).(*model.Subscription)
+			created.ID = 1
+		}).Return(nil)
+		subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+		subs.On("Save", ctx, mocklib.Anything).Return(nil)
+		payRepo.On("Create", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+			return p.AmountCent == 0 && p.TaxCent == 0
+		})).Return(nil)
+		payRepo.On("Save", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+			return p.Status == model.PaymentSucceeded && p.Provider == ""
+		})).Return(nil)
+
+		// a declining processor shows it isn't asked for free charges
+		processor := &stubPaymentProcessor{success: false}
+		invoices := &stubInvoiceService{}
+		svc := NewSubscriptionService(subs, payRepo, &productService{products}, &userService{users}, processor, &quoteService{}, &taxService{}, invoices, &stubCreditService{}, NewCouponService(coupons, &productService{products}), money.DefaultTaxPolicy)
+
+		_, err := svc.Create(ctx, 2, 1, "", "free")
+		require.NoError(t, err)
+		subs.On("GetByID", ctx, uint(1)).Return(created, nil)
+		require.NoError(t, svc.Purchase(ctx, 1, 1, ""))
+		require.Equal(t, model.Active, created.State)
+		require.Empty(t, processor.requests)
+		require.Len(t, invoices.issued, 1)
+
+		payRepo.AssertExpectations(t)
+	})
+
+	t.Run("renewal", func(t *testing.T) {
+		couponID := uint(4)
+		policy := RenewalPolicy{LeadTime: time.Hour * 24, BatchSize: 10, Lease: time.Minute}
+		subsRepo := new(mock.MockSubscriptionRepo)
+		dunningRepo := new(mock.MockDunningRepo)
+		prodRepo := new(mock.MockProductRepo)
+		payRepo := new(mock.MockPaymentRepo)
+
+		subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{{
+			Model:           gorm.Model{ID: 1},
+			UserID:          1,
+			ProductID:       2,
+			State:           model.Active,
+			AutoRenew:       true,
+			PriceCent:       1000,
+			TaxRate:         money.Percent(10),
+			Currency:        model.DefaultCurrency,
+			Start:           now.Add(-period + time.Hour),
+			End:             now.Add(time.Hour),
+			CouponID:        &couponID,
+			CouponCode:      "FREE",
+			DiscountPercent: money.Percent(100),
+			DiscountForever: true,
+			DiscountCent:    1000,
+		}}, nil)
+		subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, now.Add(policy.Lease)).Return(true, nil)
+		prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Price: 1000, Duration: period / time.Second}, nil)
+		expectPayment(ctx, payRepo, model.PaymentRenewal)
+		dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+			return a.Success && a.AmountCent == 0
+		})).Return(nil)
+		subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+			return sub.State == model.Active && sub.End.Equal(now.Add(time.Hour+period))
+		})).Return(nil)
+
+		users := new(mock.MockUserRepo)
+		users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+
+		processor := &stubPaymentProcessor{success: false}
+		invoices := &stubInvoiceService{}
+		svc := NewRenewalService(subsRepo, dunningRepo, payRepo, &productService{prodRepo}, &userService{users}, &taxService{}, invoices, &stubCreditService{}, &couponService{}, processor, policy)
+
+		res, err := svc.RenewDue(ctx, now)
+		require.NoError(t, err)
+		require.Equal(t, RenewalResult{Renewed: 1}, res)
+		require.Empty(t, processor.requests)
+		require.Len(t, invoices.issued, 1)
+
+		subsRepo.AssertExpectations(t)
+		dunningRepo.AssertExpectations(t)
+	})
+}
+
+func TestDiscountedPeriods(t *testing.T) {
+	couponID := uint(4)
+
+	testCases := []struct {
+		name     string
+		coupon   model.Coupon
+		expected []int
+	}{
+		{name: "once", coupon: model.Coupon{PercentOff: money.Percent(25), Duration: model.CouponOnce}, expected: []int{250, 0, 0}},
+		{name: "repeating", coupon: model.Coupon{AmountOffCent: 300, Duration: model.CouponRepeating, DurationCycles: 2}, expected: []int{300, 300, 0}},
+		{name: "forever", coupon: model.Coupon{PercentOff: money.Percent(10), Duration: model.CouponForever}, expected: []int{100, 100, 100}},
+		{name: "never more than the price", coupon: model.Coupon{AmountOffCent: 1500, Duration: model.CouponOnce}, expected: []int{1000, 0, 0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.coupon.ID = couponID
+			sub := &model.Subscription{PriceCent: 1000}
+			applyCoupon(sub, &tc.coupon)
+
+			var discounts []int
+			for range tc.expected {
+				startDiscountedPeriod(money.HalfUp, sub)
+				discounts = append(discounts, sub.DiscountCent)
+			}
+			require.Equal(t, tc.expected, discounts)
+		})
+	}
+}
+
+func TestRenewalSwitchKeepsDiscount(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+	period := time.Hour * 24 * 30
+	policy := RenewalPolicy{LeadTime: time.Hour * 24, BatchSize: 10, Lease: time.Minute}
+	couponID, pendingID := uint(4), uint(3)
+
+	testCases := []struct {
+		name           string
+		coupon         *model.Coupon
+		expectedAmount int
+	}{
+		{name: "coupon for every product", coupon: &model.Coupon{Model: gorm.Model{ID: 4}}, expectedAmount: 1650},
+		{name: "coupon for the new product", coupon: &model.Coupon{Model: gorm.Model{ID: 4}, Products: []model.CouponProduct{{CouponID: 4, ProductID: 3}}}, expectedAmount: 1650},
+		{name: "coupon for the old product only", coupon: &model.Coupon{Model: gorm.Model{ID: 4}, Products: []model.CouponProduct{{CouponID: 4, ProductID: 2}}}, expectedAmount: 2200},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := new(mock.MockSubscriptionRepo)
+			dunningRepo := new(mock.MockDunningRepo)
+			prodRepo := new(mock.MockProductRepo)
+			payRepo := new(mock.MockPaymentRepo)
+			coupons := new(mock.MockCouponRepo)
+
+			subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{{
+				Model:              gorm.Model{ID: 1},
+				UserID:             1,
+				ProductID:          2,
+				PendingProductID:   &pendingID,
+				State:              model.Active,
+				AutoRenew:          true,
+				PriceCent:          1000,
+				Currency:           model.DefaultCurrency,
+				Start:              now.Add(-period + time.Hour),
+				End:                now.Add(time.Hour),
+				CouponID:           &couponID,
+				CouponCode:         "SPRING25",
+				DiscountPercent:    money.Percent(25),
+				DiscountCyclesLeft: 2,
+				DiscountCent:       250,
+			}}, nil)
+			subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, now.Add(policy.Lease)).Return(true, nil)
+			prodRepo.On("GetByIDWithArchived", ctx, uint(3)).Return(&model.Product{Model: gorm.Model{ID: 3}, Price: 2000, TaxRate: 10, Duration: period / time.Second}, nil)
+			coupons.On("GetByIDWithArchived", ctx, uint(4)).Return(tc.coupon, nil)
+			expectPayment(ctx, payRepo, model.PaymentRenewal)
+			dunningRepo.On("Create", ctx, mocklib.Anything).Return(nil)
+			subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+				return sub.ProductID == 3 && sub.PriceCent == 2000
+			})).Return(nil)
+
+			users := new(mock.MockUserRepo)
+			users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+
+			processor := &stubPaymentProcessor{success: true}
+			invoices := &stubInvoiceService{}
+			svc := NewRenewalService(subsRepo, dunningRepo, payRepo, &productService{prodRepo}, &userService{users}, &taxService{}, invoices, &stubCreditService{}, NewCouponService(coupons, &productService{prodRepo}), processor, policy)
+
+			res, err := svc.RenewDue(ctx, now)
+			require.NoError(t, err)
+			require.Equal(t, RenewalResult{Renewed: 1}, res)
+			require.Len(t, processor.requests, 1)
+			require.Equal(t, tc.expectedAmount, processor.requests[0].Amount)
+
+			var total int
+			for _, item := range invoices.issued[0] {
+				total += item.NetCent + item.TaxCent
+			}
+			require.Equal(t, tc.expectedAmount, total)
+
+			subsRepo.AssertExpectations(t)
+			coupons.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// CreditNoteParams describes money given back from a payment. With a Refund
+// the note documents that refund; without one the amount is credited to the
+// user's balance.
+type CreditNoteParams struct {
+	Payment    *model.Payment
+	Refund     *model.Refund
+	AmountCent int // tax included
+	TaxCent    int
+	Reason     string
+}
+
+type CreditNotePage struct {
+	CreditNotes []model.CreditNote
+	NextCursor  string
+}
+
+type BalanceTransactionPage struct {
+	Transactions []model.BalanceTransaction
+	NextCursor   string
+}
+
+type CreditService interface {
+	// IssueCreditNote issues a credit note against the invoice of the
+	// payment.
+	IssueCreditNote(ctx context.Context, params CreditNoteParams) (*model.CreditNote, error)
+	// GetCreditNote fetches one of the user's credit notes.
+	GetCreditNote(ctx context.Context, ID uint, userID uint) (*model.CreditNote, error)
+	// ListCreditNotes returns one page of the user's credit notes, newest
+	// first.
+	ListCreditNotes(ctx context.Context, userID uint, cursor string, limit int) (*CreditNotePage, error)
+	// Balances returns the user's balance in every currency they held money
+	// in.
+	Balances(ctx context.Context, userID uint) ([]model.CustomerBalance, error)
+	// ListTransactions returns one page of the user's balance history,
+	// newest first.
+	ListTransactions(ctx context.Context, userID uint, cursor string, limit int) (*BalanceTransactionPage, error)
+	// SpendBalance pays up to amount of a pending payment from the user's
+	// balance in its currency and returns how much it paid.
+	SpendBalance(ctx context.Context, payment *model.Payment, amount int) (int, error)
+	// RestoreBalance gives back what a declined payment spent from the
+	// balance.
+	RestoreBalance(ctx context.Context, payment *model.Payment, amount int) error
+}
+
+type creditService struct {
+	repo        repo.CreditRepository
+	invoiceRepo repo.InvoiceRepository
+	policy      InvoicePolicy
+}
+
+func NewCreditService(repo repo.CreditRepository, invoiceRepo repo.InvoiceRepository, policy InvoicePolicy) CreditService {
+	if policy.CreditNotePrefix == "" {
+		policy.CreditNotePrefix = DefaultInvoicePolicy().CreditNotePrefix
+	}
+	return &creditService{
+		repo:        repo,
+		invoiceRepo: invoiceRepo,
+		policy:      policy,
+	}
+}
+
+func (s *creditService) IssueCreditNote(ctx context.Context, params CreditNoteParams) (*model.CreditNote, error) {
+	payment := params.Payment
+	if params.AmountCent <= 0 {
+		return nil, fmt.Errorf("couldn't issue credit note: nothing to credit for payment %d", payment.ID)
+	}
+
+	invoice, err := s.invoiceRepo.GetByPayment(ctx, payment.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("couldn't issue credit note for payment %d: %w", payment.ID, ErrInvoiceNotFound)
+		}
+		return nil, fmt.Errorf("couldn't issue credit note: %w", err)
+	}
+
+	issuedAt := time.Now().In(UTCLocation)
+	note := &model.CreditNote{
+		Series:         fmt.Sprintf("%s-%d", s.policy.CreditNotePrefix, issuedAt.Year()),
+		InvoiceID:      invoice.ID,
+		InvoiceNumber:  invoice.Number,
+		UserID:         payment.UserID,
+		SubscriptionID: payment.SubscriptionID,
+		PaymentID:      payment.ID,
+		Method:         model.CreditBalance,
+		Currency:       payment.Currency,
+		Reason:         params.Reason,
+		NetCent:        params.AmountCent - params.TaxCent,
+		TaxCent:        params.TaxCent,
+		TotalCent:      params.AmountCent,
+		IssuedAt:       issuedAt,
+	}
+
+	var credit *model.BalanceTransaction
+	if params.Refund != nil {
+		note.Method = model.CreditRefund
+		note.RefundID = &params.Refund.ID
+	} else {
+		credit = &model.BalanceTransaction{
+			UserID:      payment.UserID,
+			Currency:    payment.Currency,
+			Kind:        model.BalanceCredited,
+			AmountCent:  params.AmountCent,
+			Description: params.Reason,
+		}
+	}
+
+	if err := s.repo.CreateNote(ctx, note, credit); err != nil {
+		return nil, fmt.Errorf("couldn't issue credit note: %w", err)
+	}
+	return note, nil
+}
+
+func (s *creditService) GetCreditNote(ctx context.Context, ID uint, userID uint) (*model.CreditNote, error) {
+	note, err := s.repo.GetNote(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCreditNoteNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch credit note: %w", err)
+	}
+
+	if note.UserID != userID {
+		return nil, ErrCreditNoteNotFound
+	}
+	return note, nil
+}
+
+func (s *creditService) ListCreditNotes(ctx context.Context, userID uint, cursor string, limit int) (*CreditNotePage, error) {
+	filter, err := creditFilter(userID, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.repo.ListNotes(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list credit notes: %w", err)
+	}
+
+	page := &CreditNotePage{CreditNotes: notes}
+	if len(notes) == filter.Limit {
+		page.CreditNotes = notes[:filter.Limit-1]
+		page.NextCursor = encodeCursor(page.CreditNotes[len(page.CreditNotes)-1].ID)
+	}
+	return page, nil
+}
+
+func (s *creditService) Balances(ctx context.Context, userID uint) ([]model.CustomerBalance, error) {
+	balances, err := s.repo.ListBalances(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch balances: %w", err)
+	}
+	return balances, nil
+}
+
+func (s *creditService) ListTransactions(ctx context.Context, userID uint, cursor string, limit int) (*BalanceTransactionPage, error) {
+	filter, err := creditFilter(userID, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.repo.ListTransactions(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list balance transactions: %w", err)
+	}
+
+	page := &BalanceTransactionPage{Transactions: transactions}
+	if len(transactions) == filter.Limit {
+		page.Transactions = transactions[:filter.Limit-1]
+		page.NextCursor = encodeCursor(page.Transactions[len(page.Transactions)-1].ID)
+	}
+	return page, nil
+}
+
+func (s *creditService) SpendBalance(ctx context.Context, payment *model.Payment, amount int) (int, error) {
+	balance, err := s.repo.GetBalance(ctx, payment.UserID, payment.Currency)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("couldn't fetch balance: %w", err)
+	}
+
+	spent := min(balance.BalanceCent, amount)
+	if spent <= 0 {
+		return 0, nil
+	}
+
+	err = s.repo.Apply(ctx, &model.BalanceTransaction{
+		UserID:      payment.UserID,
+		Currency:    payment.Currency,
+		Kind:        model.BalanceSpent,
+		AmountCent:  -spent,
+		PaymentID:   &payment.ID,
+		Description: fmt.Sprintf("%s payment of subscription %d", payment.Kind, payment.SubscriptionID),
+	})
+	if err != nil {
+		// a concurrent charge spent the balance first; the processor is
+		// charged in full instead
+		if errors.Is(err, repo.ErrInsufficientBalance) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("couldn't spend balance: %w", err)
+	}
+	return spent, nil
+}
+
+func (s *creditService) RestoreBalance(ctx context.Context, payment *model.Payment, amount int) error {
+	err := s.repo.Apply(ctx, &model.BalanceTransaction{
+		UserID:      payment.UserID,
+		Currency:    payment.Currency,
+		Kind:        model.BalanceRestored,
+		AmountCent:  amount,
+		PaymentID:   &payment.ID,
+		Description: fmt.Sprintf("declined %s payment of subscription %d", payment.Kind, payment.SubscriptionID),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't restore balance: %w", err)
+	}
+	return nil
+}
+
+// creditFilter fetches one row more than the page size, which tells
+// whether there is a next page.
+func creditFilter(userID uint, cursor string, limit int) (repo.CreditFilter, error) {
+	beforeID, err := decodeCursor(cursor)
+	if err != nil {
+		return repo.CreditFilter{}, err
+	}
+	return repo.CreditFilter{UserID: userID, BeforeID: beforeID, Limit: pageSize(limit) + 1}, nil
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// stubCreditService holds one balance and records every credit note.
+type stubCreditService struct {
+	CreditService
+	balance  int
+	restored int
+	notes    []CreditNoteParams
+}
+
+func (s *stubCreditService) IssueCreditNote(ctx context.Context, params CreditNoteParams) (*model.CreditNote, error) {
+	s.notes = append(s.notes, params)
+	method := model.CreditRefund
+	if params.Refund == nil {
+		method = model.CreditBalance
+		s.balance += params.AmountCent
+	}
+	return &model.CreditNote{PaymentID: params.Payment.ID, Method: method, TotalCent: params.AmountCent}, nil
+}
+
+func (s *stubCreditService) SpendBalance(ctx context.Context, payment *model.Payment, amount int) (int, error) {
+	spent := min(s.balance, amount)
+	s.balance -= spent
+	return spent, nil
+}
+
+func (s *stubCreditService) RestoreBalance(ctx context.Context, payment *model.Payment, amount int) error {
+	s.balance += amount
+	s.restored += amount
+	return nil
+}
+
+func TestIssueCreditNote(t *testing.T) {
+	ctx := context.Background()
+	payment := &model.Payment{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 1210, TaxCent: 210, Currency: "EUR", Status: model.PaymentSucceeded}
+	invoice := &model.Invoice{Model: gorm.Model{ID: 3}, Number: "INV-2025-000003"}
+	series := fmt.Sprintf("CN-%d", time.Now().UTC().Year())
+
+	testCases := []struct {
+		name          string
+		params        CreditNoteParams
+		expectedErr   error
+		errorContains string
+		setupMock     func(credits *mock.MockCreditRepo, invoices *mock.MockInvoiceRepo)
+		check         func(t *testing.T, note *model.CreditNote)
+	}{
+		{
+			name:   "documents a refund",
+			params: CreditNoteParams{Payment: payment, Refund: &model.Refund{Model: gorm.Model{ID: 4}}, AmountCent: 605, TaxCent: 105, Reason: "goodwill"},
+			setupMock: func(credits *mock.MockCreditRepo, invoices *mock.MockInvoiceRepo) {
+				invoices.On("GetByPayment", ctx, uint(7)).Return(invoice, nil)
+				credits.On("CreateNote", ctx, mocklib.AnythingOfType("*model.CreditNote"), (*model.BalanceTransaction)(nil)).Return(nil)
+			},
+			check: func(t *testing.T, note *model.CreditNote) {
+				require.Equal(t, series, note.Series)
+				require.Equal(t, model.CreditRefund, note.Method)
+				require.Equal(t, uint(4), *note.RefundID)
+				require.Equal(t, uint(3), note.InvoiceID)
+				require.Equal(t, "INV-2025-000003", note.InvoiceNumber)
+				require.Equal(t, 500, note.NetCent)
+				require.Equal(t, 605, note.TotalCent)
+				require.Equal(t, "goodwill", note.Reason)
+			},
+		},
+		{
+			name:   "credits the balance",
+			params: CreditNoteParams{Payment: payment, AmountCent: 605, TaxCent: 105},
+			setupMock: func(credits *mock.MockCreditRepo, invoices *mock.MockInvoiceRepo) {
+				invoices.On("GetByPayment", ctx, uint(7)).Return(invoice, nil)
+				credits.On("CreateNote", ctx, mocklib.AnythingOfType("*model.CreditNote"), mocklib.MatchedBy(func(credit *model.BalanceTransaction) bool {
+					return credit.UserID == 1 && credit.Currency == "EUR" && credit.Kind == model.BalanceCredited && credit.AmountCent == 605
+				})).Return(nil)
+			},
+			check: func(t *testing.T, note *model.CreditNote) {
+				require.Equal(t, model.CreditBalance, note.Method)
+				require.Nil(t, note.RefundID)
+			},
+		},
+		{
+			name:        "payment without invoice",
+			params:      CreditNoteParams{Payment: payment, AmountCent: 605},
+			expectedErr: ErrInvoiceNotFound,
+			setupMock: func(credits *mock.MockCreditRepo, invoices *mock.MockInvoiceRepo) {
+				invoices.On("GetByPayment", ctx, uint(7)).Return((*model.Invoice)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "nothing to credit",
+			params:        CreditNoteParams{Payment: payment},
+			errorContains: "nothing to credit",
+			setupMock:     func(credits *mock.MockCreditRepo, invoices *mock.MockInvoiceRepo) {},
+		},
+		{
+			name:          "numbering fails",
+			params:        CreditNoteParams{Payment: payment, AmountCent: 605},
+			errorContains: "couldn't issue credit note",
+			setupMock: func(credits *mock.MockCreditRepo, invoices *mock.MockInvoiceRepo) {
+				invoices.On("GetByPayment", ctx, uint(7)).Return(invoice, nil)
+				credits.On("CreateNote", ctx, mocklib.Anything, mocklib.Anything).Return(errors.New("database is locked"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			credits := new(mock.MockCreditRepo)
+			invoices := new(mock.MockInvoiceRepo)
+			tc.setupMock(credits, invoices)
+			svc := NewCreditService(credits, invoices, InvoicePolicy{})
+
+			note, err := svc.IssueCreditNote(ctx, tc.params)
+			switch {
+			case tc.expectedErr != nil:
+				require.ErrorIs(t, err, tc.expectedErr)
+			case tc.errorContains != "":
+				require.ErrorContains(t, err, tc.errorContains)
+			default:
+				require.NoError(t, err)
+				tc.check(t, note)
+			}
+
+			credits.AssertExpectations(t)
+			invoices.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSpendBalance(t *testing.T) {
+	ctx := context.Background()
+	payment := &model.Payment{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, Kind: model.PaymentRenewal, Currency: "EUR"}
+
+	testCases := []struct {
+		name          string
+		amount        int
+		expectedSpent int
+		setupMock     func(credits *mock.MockCreditRepo)
+	}{
+		{
+			name:          "balance covers part of the payment",
+			amount:        1210,
+			expectedSpent: 500,
+			setupMock: func(credits *mock.MockCreditRepo) {
+				credits.On("GetBalance", ctx, uint(1), "EUR").Return(&model.CustomerBalance{BalanceCent: 500}, nil)
+				credits.On("Apply", ctx, mocklib.MatchedBy(func(entry *model.BalanceTransaction) bool {
+					return entry.Kind == model.BalanceSpent && entry.AmountCent == -500 && *entry.PaymentID == 7
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "balance covers the whole payment",
+			amount:        1210,
+			expectedSpent: 1210,
+			setupMock: func(credits *mock.MockCreditRepo) {
+				credits.On("GetBalance", ctx, uint(1), "EUR").Return(&model.CustomerBalance{BalanceCent: 2000}, nil)
+				credits.On("Apply", ctx, mocklib.MatchedBy(func(entry *model.BalanceTransaction) bool {
+					return entry.AmountCent == -1210
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "no balance in the currency",
+			amount: 1210,
+			setupMock: func(credits *mock.MockCreditRepo) {
+				credits.On("GetBalance", ctx, uint(1), "EUR").Return((*model.CustomerBalance)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+		{
+			name:   "a concurrent charge spent the balance",
+			amount: 1210,
+			setupMock: func(credits *mock.MockCreditRepo) {
+				credits.On("GetBalance", ctx, uint(1), "EUR").Return(&model.CustomerBalance{BalanceCent: 500}, nil)
+				credits.On("Apply", ctx, mocklib.Anything).Return(repo.ErrInsufficientBalance)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			credits := new(mock.MockCreditRepo)
+			tc.setupMock(credits)
+			svc := NewCreditService(credits, new(mock.MockInvoiceRepo), DefaultInvoicePolicy())
+
+			spent, err := svc.SpendBalance(ctx, payment, tc.amount)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedSpent, spent)
+			credits.AssertExpectations(t)
+		})
+	}
+}
+
+func TestChargeSpendsBalanceFirst(t *testing.T) {
+	ctx := context.Background()
+	sub := &model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, ProductID: 1, PriceCent: 1000, Currency: "EUR"}
+
+	testCases := []struct {
+		name             string
+		balance          int
+		processor        *stubPaymentProcessor
+		expectedStatus   model.PaymentStatus
+		expectedProvider string
+		expectedCharged  []int
+		expectedBalance  int
+	}{
+		{
+			name:             "balance pays part",
+			balance:          400,
+			processor:        &stubPaymentProcessor{success: true},
+			expectedStatus:   model.PaymentSucceeded,
+			expectedProvider: "stub",
+			expectedCharged:  []int{600},
+		},
+		{
+			name:             "balance pays everything",
+			balance:          1500,
+			processor:        &stubPaymentProcessor{success: true},
+			expectedStatus:   model.PaymentSucceeded,
+			expectedProvider: BalanceProvider,
+			expectedBalance:  500,
+		},
+		{
+			name:             "declined charge gives the balance back",
+			balance:          400,
+			processor:        &stubPaymentProcessor{},
+			expectedStatus:   model.PaymentFailed,
+			expectedProvider: "stub",
+			expectedCharged:  []int{600},
+			expectedBalance:  400,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payments := new(mock.MockPaymentRepo)
+			expectPayment(ctx, payments, model.PaymentRenewal)
+			credits := &stubCreditService{balance: tc.balance}
+			ledger := &paymentLedger{repo: payments, processor: tc.processor, credits: credits}
+
+			payment, err := ledger.chargeSubscription(ctx, sub, model.PaymentRenewal, "", "")
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedStatus, payment.Status)
+			require.Equal(t, tc.expectedProvider, payment.Provider)
+			require.Equal(t, 1000, payment.AmountCent)
+
+			var charged []int
+			for _, req := range tc.processor.requests {
+				charged = append(charged, req.Amount)
+			}
+			require.Equal(t, tc.expectedCharged, charged)
+			require.Equal(t, tc.expectedBalance, credits.balance)
+			if tc.expectedStatus == model.PaymentSucceeded {
+				require.Equal(t, min(tc.balance, 1000), payment.BalanceCent)
+			} else {
+				require.Zero(t, payment.BalanceCent)
+			}
+		})
+	}
+}
@@ -9,13 +9,47 @@ var (
 	ErrUserNotFound         = errors.New("user not found")
 	ErrSubscriptionNotFound = errors.New("subscription not found")
 	ErrProductNotFound      = errors.New("product not found")
+	ErrInvalidProduct       = errors.New("invalid product")
+	ErrInvalidRole          = errors.New("invalid role")
+	ErrInvalidProfile       = errors.New("invalid profile")
+	ErrEmailTaken           = errors.New("email is already registered")
+	ErrInvalidCredentials   = errors.New("invalid email or password")
+	ErrInvalidRefreshToken  = errors.New("invalid refresh token")
+	ErrSessionNotFound      = errors.New("session not found")
 	ErrNoPendingPayment     = errors.New("no pending payment for this subscription")
 	ErrFailedPayment        = errors.New("payment failed")
 	ErrUnauthorizedAccess   = errors.New("unauthorized access on subscription")
+	ErrInvalidCursor        = errors.New("invalid pagination cursor")
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+	ErrRequestInProgress    = errors.New("a request with this idempotency key is in progress")
+	ErrConcurrentUpdate     = errors.New("subscription was modified concurrently")
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrPaymentNotRefundable = errors.New("only succeeded payments can be refunded")
+	ErrRefundExceedsPayment = errors.New("refund exceeds the amount left to refund")
+	ErrInvalidRefund        = errors.New("invalid refund")
+	ErrSamePlan             = errors.New("subscription is already on this plan")
+	ErrInvalidQuote         = errors.New("invalid quote")
+	ErrQuoteNotFound        = errors.New("quote not found")
+	ErrQuoteExpired         = errors.New("quote has expired")
+	ErrQuoteUsed            = errors.New("quote was already used")
+	ErrQuoteMismatch        = errors.New("quote is for a different product")
+	ErrQuoteTaxChanged      = errors.New("tax changed since the quote was made, please request a new quote")
+	ErrPriceNotAvailable    = errors.New("product has no price in this currency")
+	ErrInvoiceNotFound      = errors.New("invoice not found")
+	ErrCreditNoteNotFound   = errors.New("credit note not found")
+	ErrCouponNotFound       = errors.New("coupon not found")
+	ErrInvalidCoupon        = errors.New("invalid coupon")
+	ErrInvalidPromoCode     = errors.New("invalid promo code")
+	ErrTrialUsed            = errors.New("trial of this product family was already used")
 
-	ErrInvalidState     = errors.New("forbidden action at this state")
-	ErrAlreadyPaused    = fmt.Errorf("subscription is already paused: %w", ErrInvalidState)
-	ErrAlreadyCancelled = fmt.Errorf("subscription is already cancelled: %w", ErrInvalidState)
-	ErrAlreadyActive    = fmt.Errorf("subscription is already active: %w", ErrInvalidState)
-	ErrAlreadyExpired   = fmt.Errorf("subscription is already expired: %w", ErrInvalidState)
+	ErrInvalidState              = errors.New("forbidden action at this state")
+	ErrAlreadyPaused             = fmt.Errorf("subscription is already paused: %w", ErrInvalidState)
+	ErrAlreadyCancelled          = fmt.Errorf("subscription is already cancelled: %w", ErrInvalidState)
+	ErrAlreadyActive             = fmt.Errorf("subscription is already active: %w", ErrInvalidState)
+	ErrAlreadyExpired            = fmt.Errorf("subscription is already expired: %w", ErrInvalidState)
+	ErrNotCancellableAtPeriodEnd = fmt.Errorf("only active subscriptions can be cancelled at period end: %w", ErrInvalidState)
+	ErrNoScheduledCancellation   = fmt.Errorf("subscription has no scheduled cancellation: %w", ErrInvalidState)
+	ErrPlanNotChangeable         = fmt.Errorf("only active subscriptions can change plan: %w", ErrInvalidState)
+	ErrCancellationScheduled     = fmt.Errorf("subscription is scheduled to be cancelled, withdraw the cancellation first: %w", ErrInvalidState)
+	ErrPlanChangeNeedsAutoRenew  = fmt.Errorf("plan changes at period end need auto-renew: %w", ErrInvalidState)
 )
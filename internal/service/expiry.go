@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/repo"
+)
+
+// ExpiryPolicy configures the expiry sweep.
+//
+// Paused subscriptions are frozen: Unpause extends End by the paused time, so
+// the sweep leaves them alone. If MaxPause is set, a subscription paused for
+// longer than MaxPause is expired as well.
+type ExpiryPolicy struct {
+	BatchSize int
+	MaxPause  time.Duration
+}
+
+type ExpiryService interface {
+	ExpireDue(ctx context.Context, now time.Time) (int64, error)
+	// CancelScheduled applies cancellations scheduled for the period end.
+	CancelScheduled(ctx context.Context, now time.Time) (int64, error)
+}
+
+type expiryService struct {
+	subsRepo repo.SubscriptionRepository
+	policy   ExpiryPolicy
+}
+
+func NewExpiryService(subsRepo repo.SubscriptionRepository, policy ExpiryPolicy) ExpiryService {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 100
+	}
+	return &expiryService{subsRepo: subsRepo, policy: policy}
+}
+
+// ExpireDue expires every due subscription in batches and returns how many
+// subscriptions were moved to Expired.
+func (s *expiryService) ExpireDue(ctx context.Context, now time.Time) (int64, error) {
+	now = now.In(UTCLocation)
+
+	var pausedBefore *time.Time
+	if s.policy.MaxPause > 0 {
+		t := now.Add(-s.policy.MaxPause)
+		pausedBefore = &t
+	}
+
+	return s.sweep(ctx, func() (int64, error) {
+		n, err := s.subsRepo.ExpireDue(ctx, now, pausedBefore, s.policy.BatchSize)
+		if err != nil {
+			return n, fmt.Errorf("failed to expire subscriptions: %w", err)
+		}
+		return n, nil
+	})
+}
+
+// CancelScheduled cancels every Active subscription whose CancelAt has passed
+// in batches and returns how many subscriptions were moved to Cancelled.
+func (s *expiryService) CancelScheduled(ctx context.Context, now time.Time) (int64, error) {
+	now = now.In(UTCLocation)
+
+	return s.sweep(ctx, func() (int64, error) {
+		n, err := s.subsRepo.CancelScheduled(ctx, now, s.policy.BatchSize)
+		if err != nil {
+			return n, fmt.Errorf("failed to apply scheduled cancellations: %w", err)
+		}
+		return n, nil
+	})
+}
+
+// sweep runs batch until it returns a partial batch.
+func (s *expiryService) sweep(ctx context.Context, batch func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		n, err := batch()
+		if err != nil {
+			return total, err
+		}
+		total += n
+
+		if n < int64(s.policy.BatchSize) {
+			return total, nil
+		}
+	}
+}
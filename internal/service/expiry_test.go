@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+)
+
+func TestExpireDue(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+
+	testCases := []struct {
+		name          string
+		policy        ExpiryPolicy
+		expected      int64
+		errorContains string
+		setupMock     func(repo *mock.MockSubscriptionRepo)
+	}{
+		{
+			name:     "sweeps batches until a partial batch",
+			policy:   ExpiryPolicy{BatchSize: 2},
+			expected: 5,
+			setupMock: func(repo *mock.MockSubscriptionRepo) {
+				repo.On("ExpireDue", ctx, now, (*time.Time)(nil), 2).Return(int64(2), nil).Twice()
+				repo.On("ExpireDue", ctx, now, (*time.Time)(nil), 2).Return(int64(1), nil).Once()
+			},
+		},
+		{
+			name:     "expires long pauses when max pause is set",
+			policy:   ExpiryPolicy{BatchSize: 10, MaxPause: time.Hour * 24 * 90},
+			expected: 3,
+			setupMock: func(repo *mock.MockSubscriptionRepo) {
+				repo.On("ExpireDue", ctx, now, mocklib.MatchedBy(func(t *time.Time) bool {
+					return t != nil && t.Equal(now.Add(-time.Hour*24*90))
+				}), 10).Return(int64(3), nil).Once()
+			},
+		},
+		{
+			name:          "repository error",
+			policy:        ExpiryPolicy{BatchSize: 10},
+			errorContains: "failed to expire subscriptions",
+			setupMock: func(repo *mock.MockSubscriptionRepo) {
+				repo.On("ExpireDue", ctx, now, (*time.Time)(nil), 10).Return(int64(0), errors.New("db down")).Once()
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := new(mock.MockSubscriptionRepo)
+			tc.setupMock(repo)
+			svc := NewExpiryService(repo, tc.policy)
+
+			n, err := svc.ExpireDue(ctx, now)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, n)
+			}
+
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+
+	repo := new(mock.MockSubscriptionRepo)
+	repo.On("CancelScheduled", ctx, now, 2).Return(int64(2), nil).Once()
+	repo.On("CancelScheduled", ctx, now, 2).Return(int64(0), nil).Once()
+	svc := NewExpiryService(repo, ExpiryPolicy{BatchSize: 2})
+
+	n, err := svc.CancelScheduled(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	repo.On("CancelScheduled", ctx, now, 2).Return(int64(0), errors.New("db down")).Once()
+	_, err = svc.CancelScheduled(ctx, now)
+	require.ErrorContains(t, err, "failed to apply scheduled cancellations")
+
+	repo.AssertExpectations(t)
+}
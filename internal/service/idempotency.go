@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// IdempotencyPolicy configures how long idempotency keys are honoured. A
+// completed key is replayed for TTL; a key whose request never completed,
+// e.g. because the server crashed, is released after LockTimeout.
+type IdempotencyPolicy struct {
+	TTL         time.Duration
+	LockTimeout time.Duration
+}
+
+func DefaultIdempotencyPolicy() IdempotencyPolicy {
+	return IdempotencyPolicy{
+		TTL:         time.Hour * 24,
+		LockTimeout: time.Minute * 5,
+	}
+}
+
+type IdempotencyService interface {
+	// Begin reserves the key for a request. If the key was already used for
+	// the same request the stored record is returned with CompletedAt set and
+	// its response should be replayed.
+	Begin(ctx context.Context, userID uint, key string, fingerprint string) (*model.IdempotencyKey, error)
+	Complete(ctx context.Context, record *model.IdempotencyKey, statusCode int, contentType string, body []byte) error
+	// Release forgets a reserved key so the request can be retried.
+	Release(ctx context.Context, record *model.IdempotencyKey) error
+}
+
+type idempotencyService struct {
+	repo   repo.IdempotencyRepository
+	policy IdempotencyPolicy
+}
+
+func NewIdempotencyService(repo repo.IdempotencyRepository, policy IdempotencyPolicy) IdempotencyService {
+	defaults := DefaultIdempotencyPolicy()
+	if policy.TTL <= 0 {
+		policy.TTL = defaults.TTL
+	}
+	if policy.LockTimeout <= 0 {
+		policy.LockTimeout = defaults.LockTimeout
+	}
+	return &idempotencyService{repo: repo, policy: policy}
+}
+
+func (s *idempotencyService) Begin(ctx context.Context, userID uint, key string, fingerprint string) (*model.IdempotencyKey, error) {
+	now := time.Now().In(UTCLocation)
+
+	// The unique index on (user_id, key) decides between concurrent
+	// duplicates. The second try covers a stale key that was just removed.
+	for range 2 {
+		record := &model.IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint}
+		err := s.repo.Create(ctx, record)
+		if err == nil {
+			return record, nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, fmt.Errorf("couldn't store idempotency key: %w", err)
+		}
+
+		existing, err := s.repo.Get(ctx, userID, key)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("couldn't fetch idempotency key: %w", err)
+		}
+
+		if s.stale(existing, now) {
+			if _, err := s.repo.DeleteStale(ctx, existing.ID, existing.UpdatedAt); err != nil {
+				return nil, fmt.Errorf("couldn't release stale idempotency key: %w", err)
+			}
+			continue
+		}
+
+		if existing.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if existing.CompletedAt == nil {
+			return nil, ErrRequestInProgress
+		}
+
+		return existing, nil
+	}
+
+	return nil, ErrRequestInProgress
+}
+
+func (s *idempotencyService) stale(record *model.IdempotencyKey, now time.Time) bool {
+	if record.CompletedAt == nil {
+		return record.UpdatedAt.Add(s.policy.LockTimeout).Before(now)
+	}
+	return record.CreatedAt.Add(s.policy.TTL).Before(now)
+}
+
+func (s *idempotencyService) Complete(ctx context.Context, record *model.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	now := time.Now().In(UTCLocation)
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.ResponseBody = body
+	record.CompletedAt = &now
+
+	if err := s.repo.Save(ctx, record); err != nil {
+		return fmt.Errorf("couldn't store idempotent response: %w", err)
+	}
+
+	return nil
+}
+
+func (s *idempotencyService) Release(ctx context.Context, record *model.IdempotencyKey) error {
+	if err := s.repo.Delete(ctx, record.ID); err != nil {
+		return fmt.Errorf("couldn't release idempotency key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+func TestIdempotencyBegin(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+	policy := IdempotencyPolicy{TTL: time.Hour, LockTimeout: time.Minute}
+
+	stored := func(fingerprint string, completed bool, updatedAt time.Time) *model.IdempotencyKey {
+		record := &model.IdempotencyKey{
+			Model:       gorm.Model{ID: 7, CreatedAt: updatedAt, UpdatedAt: updatedAt},
+			UserID:      1,
+			Key:         "key",
+			Fingerprint: fingerprint,
+		}
+		if completed {
+			record.StatusCode = 201
+			record.CompletedAt = &updatedAt
+		}
+		return record
+	}
+	newKey := mocklib.MatchedBy(func(r *model.IdempotencyKey) bool {
+		return r.UserID == 1 && r.Key == "key" && r.Fingerprint == "f1"
+	})
+
+	testCases := []struct {
+		name          string
+		expectedErr   error
+		expectReplay  bool
+		errorContains string
+		setupMock     func(repo *mock.MockIdempotencyRepo)
+	}{
+		{
+			name: "new key",
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				repo.On("Create", ctx, newKey).Return(nil)
+			},
+		},
+		{
+			name:         "completed request is replayed",
+			expectReplay: true,
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				repo.On("Create", ctx, newKey).Return(gorm.ErrDuplicatedKey)
+				repo.On("Get", ctx, uint(1), "key").Return(stored("f1", true, now.Add(-time.Minute)), nil)
+			},
+		},
+		{
+			name:        "key reused for a different request",
+			expectedErr: ErrIdempotencyKeyReused,
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				repo.On("Create", ctx, newKey).Return(gorm.ErrDuplicatedKey)
+				repo.On("Get", ctx, uint(1), "key").Return(stored("f2", true, now.Add(-time.Minute)), nil)
+			},
+		},
+		{
+			name:        "duplicate of a running request",
+			expectedErr: ErrRequestInProgress,
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				repo.On("Create", ctx, newKey).Return(gorm.ErrDuplicatedKey)
+				repo.On("Get", ctx, uint(1), "key").Return(stored("f1", false, now.Add(-time.Second)), nil)
+			},
+		},
+		{
+			name: "abandoned request is taken over",
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				abandoned := stored("f1", false, now.Add(-time.Hour))
+				repo.On("Create", ctx, newKey).Return(gorm.ErrDuplicatedKey).Once()
+				repo.On("Get", ctx, uint(1), "key").Return(abandoned, nil).Once()
+				repo.On("DeleteStale", ctx, uint(7), abandoned.UpdatedAt).Return(true, nil).Once()
+				repo.On("Create", ctx, newKey).Return(nil).Once()
+			},
+		},
+		{
+			name: "expired key is reused",
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				expired := stored("f2", true, now.Add(-time.Hour*2))
+				repo.On("Create", ctx, newKey).Return(gorm.ErrDuplicatedKey).Once()
+				repo.On("Get", ctx, uint(1), "key").Return(expired, nil).Once()
+				repo.On("DeleteStale", ctx, uint(7), expired.UpdatedAt).Return(true, nil).Once()
+				repo.On("Create", ctx, newKey).Return(nil).Once()
+			},
+		},
+		{
+			name:          "repository error",
+			errorContains: "couldn't store idempotency key",
+			setupMock: func(repo *mock.MockIdempotencyRepo) {
+				repo.On("Create", ctx, newKey).Return(errors.New("disk full"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := new(mock.MockIdempotencyRepo)
+			tc.setupMock(repo)
+			svc := NewIdempotencyService(repo, policy)
+
+			record, err := svc.Begin(ctx, 1, "key", "f1")
+			switch {
+			case tc.expectedErr != nil:
+				require.ErrorIs(t, err, tc.expectedErr)
+			case tc.errorContains != "":
+				require.ErrorContains(t, err, tc.errorContains)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tc.expectReplay, record.CompletedAt != nil)
+			}
+
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIdempotencyComplete(t *testing.T) {
+	ctx := context.Background()
+	repo := new(mock.MockIdempotencyRepo)
+	repo.On("Save", ctx, mocklib.MatchedBy(func(r *model.IdempotencyKey) bool {
+		return r.StatusCode == 201 && r.ContentType == "application/json" && string(r.ResponseBody) == `{"id":1}` && r.CompletedAt != nil
+	})).Return(nil)
+
+	svc := NewIdempotencyService(repo, DefaultIdempotencyPolicy())
+	require.NoError(t, svc.Complete(ctx, &model.IdempotencyKey{Model: gorm.Model{ID: 7}}, 201, "application/json", []byte(`{"id":1}`)))
+
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,312 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/pdf"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// Seller is who invoices are issued by.
+type Seller struct {
+	Name    string
+	Address string
+	Country string
+	TaxID   string
+}
+
+// InvoicePolicy configures invoice and credit note numbering, the seller
+// details printed on invoices and the branding of their PDFs. Each calendar
+// year gets its own series, e.g. INV-2026 and CN-2026. Tax is how invoice
+// lines are taxed and rounded; the zero value is money.DefaultTaxPolicy.
+type InvoicePolicy struct {
+	Prefix           string
+	CreditNotePrefix string
+	Seller           Seller
+	Branding         pdf.Branding
+	Tax              money.TaxPolicy
+}
+
+func DefaultInvoicePolicy() InvoicePolicy {
+	return InvoicePolicy{Prefix: "INV", CreditNotePrefix: "CN", Seller: Seller{Name: "Subserv"}}
+}
+
+// InvoiceItem is one line to invoice. Credit items, such as the unused time
+// of a previous plan, and discount items, which name their Coupon, carry
+// negative amounts. Until an item is taxed its NetCent is its price, which
+// includes tax under an Inclusive policy; taxing splits it into NetCent and
+// TaxCent.
+type InvoiceItem struct {
+	ProductID   uint
+	Credit      bool
+	Coupon      string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	NetCent     int
+	TaxRate     money.Rate
+	TaxCent     int
+}
+
+type InvoicePage struct {
+	Invoices   []model.Invoice
+	NextCursor string
+}
+
+type InvoiceService interface {
+	// Issue finalizes the invoice of a succeeded payment of the
+	// subscription. Issuing a payment's invoice again returns the existing
+	// one.
+	Issue(ctx context.Context, payment *model.Payment, subscription *model.Subscription, items []InvoiceItem) (*model.Invoice, error)
+	// Get fetches the user's invoice with its lines.
+	Get(ctx context.Context, ID uint, userID uint) (*model.Invoice, error)
+	// Lookup fetches any invoice with its lines.
+	Lookup(ctx context.Context, ID uint) (*model.Invoice, error)
+	// List returns one page of the user's invoices, newest first.
+	List(ctx context.Context, userID uint, cursor string, limit int) (*InvoicePage, error)
+	// ListAll returns one page of every user's invoices, newest first.
+	ListAll(ctx context.Context, cursor string, limit int) (*InvoicePage, error)
+	// RenderPDF writes the invoice as a PDF with the configured branding.
+	RenderPDF(invoice *model.Invoice, w io.Writer) error
+}
+
+type invoiceService struct {
+	repo           repo.InvoiceRepository
+	productService ProductService
+	userService    UserService
+	policy         InvoicePolicy
+}
+
+func NewInvoiceService(repo repo.InvoiceRepository, prodSvc ProductService, userSvc UserService, policy InvoicePolicy) InvoiceService {
+	defaults := DefaultInvoicePolicy()
+	if policy.Prefix == "" {
+		policy.Prefix = defaults.Prefix
+	}
+	if policy.Seller.Name == "" {
+		policy.Seller.Name = defaults.Seller.Name
+	}
+	return &invoiceService{
+		repo:           repo,
+		productService: prodSvc,
+		userService:    userSvc,
+		policy:         policy,
+	}
+}
+
+func (s *invoiceService) Issue(ctx context.Context, payment *model.Payment, subscription *model.Subscription, items []InvoiceItem) (*model.Invoice, error) {
+	if payment.Status != model.PaymentSucceeded {
+		return nil, fmt.Errorf("couldn't issue invoice: payment %d is %s", payment.ID, payment.Status)
+	}
+
+	user, err := s.userService.Get(ctx, payment.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't issue invoice: %w", err)
+	}
+
+	issuedAt := time.Now().In(UTCLocation)
+	invoice := &model.Invoice{
+		Series:         fmt.Sprintf("%s-%d", s.policy.Prefix, issuedAt.Year()),
+		UserID:         payment.UserID,
+		SubscriptionID: payment.SubscriptionID,
+		PaymentID:      payment.ID,
+		Currency:       payment.Currency,
+		SellerName:     s.policy.Seller.Name,
+		SellerAddress:  s.policy.Seller.Address,
+		SellerCountry:  s.policy.Seller.Country,
+		SellerTaxID:    s.policy.Seller.TaxID,
+		BuyerName:      user.Name,
+		BuyerEmail:     user.Email,
+		BuyerCountry:   user.BillingCountry,
+		BuyerRegion:    user.BillingRegion,
+		BuyerTaxID:     user.TaxID,
+		TaxCountry:     subscription.TaxCountry,
+		TaxRegion:      subscription.TaxRegion,
+		TaxReason:      subscription.TaxReason,
+		IssuedAt:       issuedAt,
+	}
+
+	items = slices.Clone(items)
+	taxItems(s.policy.Tax, payment.Currency, items)
+	names := make(map[uint]string)
+	for i, item := range items {
+		name, ok := names[item.ProductID]
+		if !ok {
+			product, err := s.productService.GetWithArchived(ctx, item.ProductID)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't issue invoice: %w", err)
+			}
+			name = product.Name
+			names[item.ProductID] = name
+		}
+
+		line := model.InvoiceLine{
+			Position:    i + 1,
+			Description: name,
+			ProductID:   item.ProductID,
+			NetCent:     item.NetCent,
+			TaxRate:     item.TaxRate,
+			TaxCent:     item.TaxCent,
+			TotalCent:   item.NetCent + item.TaxCent,
+		}
+		switch {
+		case item.Credit:
+			line.Description = "Unused time on " + name
+		case item.Coupon != "":
+			line.Description = "Coupon " + item.Coupon
+		}
+		if !item.PeriodStart.IsZero() {
+			start, end := item.PeriodStart.In(UTCLocation), item.PeriodEnd.In(UTCLocation)
+			line.PeriodStart, line.PeriodEnd = &start, &end
+		}
+
+		invoice.Lines = append(invoice.Lines, line)
+		invoice.NetCent += line.NetCent
+		invoice.TaxCent += line.TaxCent
+		invoice.TotalCent += line.TotalCent
+	}
+
+	if err := s.repo.Create(ctx, invoice); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return s.repo.GetByPayment(ctx, payment.ID)
+		}
+		return nil, fmt.Errorf("couldn't issue invoice: %w", err)
+	}
+
+	return invoice, nil
+}
+
+func (s *invoiceService) Get(ctx context.Context, ID uint, userID uint) (*model.Invoice, error) {
+	invoice, err := s.Lookup(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// other users' invoices don't exist as far as the caller can tell
+	if invoice.UserID != userID {
+		return nil, ErrInvoiceNotFound
+	}
+
+	return invoice, nil
+}
+
+func (s *invoiceService) Lookup(ctx context.Context, ID uint) (*model.Invoice, error) {
+	invoice, err := s.repo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch invoice: %w", err)
+	}
+
+	return invoice, nil
+}
+
+func (s *invoiceService) List(ctx context.Context, userID uint, cursor string, limit int) (*InvoicePage, error) {
+	return s.list(ctx, userID, cursor, limit)
+}
+
+func (s *invoiceService) ListAll(ctx context.Context, cursor string, limit int) (*InvoicePage, error) {
+	return s.list(ctx, 0, cursor, limit)
+}
+
+func (s *invoiceService) RenderPDF(invoice *model.Invoice, w io.Writer) error {
+	return pdf.RenderInvoice(w, invoice, s.policy.Branding)
+}
+
+// list lists the user's invoices, or everyone's for userID 0.
+func (s *invoiceService) list(ctx context.Context, userID uint, cursor string, limit int) (*InvoicePage, error) {
+	beforeID, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit = pageSize(limit)
+	invoices, err := s.repo.List(ctx, repo.InvoiceFilter{
+		UserID:   userID,
+		BeforeID: beforeID,
+		Limit:    limit + 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invoices: %w", err)
+	}
+
+	page := &InvoicePage{Invoices: invoices}
+	if len(invoices) > limit {
+		page.Invoices = invoices[:limit]
+		page.NextCursor = encodeCursor(page.Invoices[limit-1].ID)
+	}
+
+	return page, nil
+}
+
+// periodItems invoices the subscription's current period at its locked-in
+// price and its discount, if any, taxed under policy as charged by
+// chargeSubscription.
+func periodItems(policy money.TaxPolicy, sub *model.Subscription) []InvoiceItem {
+	items := []InvoiceItem{{
+		ProductID:   sub.ProductID,
+		PeriodStart: sub.Start,
+		PeriodEnd:   sub.End,
+		NetCent:     sub.PriceCent,
+		TaxRate:     sub.TaxRate,
+	}}
+	if sub.DiscountCent > 0 {
+		items = append(items, InvoiceItem{
+			ProductID:   sub.ProductID,
+			Coupon:      sub.CouponCode,
+			PeriodStart: sub.Start,
+			PeriodEnd:   sub.End,
+			NetCent:     -sub.DiscountCent,
+			TaxRate:     sub.TaxRate,
+		})
+	}
+	taxItems(policy, sub.Currency, items)
+	return items
+}
+
+// taxItems sets the net amount and tax of the items under policy, so a
+// per-invoice policy rounds once per rate over all of them. Taxing items
+// again gives the same amounts. Credit items keep their tax, which is a share
+// of the payment they credit.
+func taxItems(policy money.TaxPolicy, currency string, items []InvoiceItem) {
+	var lines []money.Line
+	var taxed []int
+	for i, item := range items {
+		if item.Credit {
+			continue
+		}
+		price := item.NetCent
+		if policy.Inclusive {
+			price += item.TaxCent
+		}
+		lines = append(lines, money.Line{Price: money.New(int64(price), money.Currency(currency)), Rate: item.TaxRate})
+		taxed = append(taxed, i)
+	}
+	// the lines are of one currency, so this only fails without lines
+	totals, err := policy.Calculate(lines)
+	if err != nil {
+		return
+	}
+	for j, i := range taxed {
+		items[i].NetCent = int(totals.Lines[j].Net.Amount)
+		items[i].TaxCent = int(totals.Lines[j].Tax.Amount)
+	}
+}
+
+// issueInvoice issues the invoice of a succeeded charge. The money has
+// already moved, so a failure is logged for reconciliation rather than
+// failing the charge.
+func issueInvoice(ctx context.Context, invoices InvoiceService, payment *model.Payment, sub *model.Subscription, items ...InvoiceItem) {
+	if _, err := invoices.Issue(ctx, payment, sub, items); err != nil {
+		log.Printf("couldn't issue invoice for payment %d of subscription %d [Transaction ID %s]: %v",
+			payment.ID, sub.ID, payment.TxID, err)
+	}
+}
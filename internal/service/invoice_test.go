@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+// stubInvoiceService records the items of every issued invoice.
+type stubInvoiceService struct {
+	InvoiceService
+	issued [][]InvoiceItem
+}
+
+func (s *stubInvoiceService) Issue(ctx context.Context, payment *model.Payment, subscription *model.Subscription, items []InvoiceItem) (*model.Invoice, error) {
+	s.issued = append(s.issued, items)
+	return &model.Invoice{PaymentID: payment.ID}, nil
+}
+
+func TestIssueInvoice(t *testing.T) {
+	ctx := context.Background()
+	start := fixedTime
+	end := start.Add(time.Hour * 24 * 30)
+	payment := &model.Payment{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 3850, TaxCent: 350, Currency: "EUR", Status: model.PaymentSucceeded}
+	sub := &model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, TaxCountry: "NL", TaxReason: "taxed"}
+	items := []InvoiceItem{
+		{ProductID: 2, PeriodStart: start, PeriodEnd: end, NetCent: 5000, TaxRate: money.Percent(10), TaxCent: 500},
+		{ProductID: 1, Credit: true, PeriodStart: start, PeriodEnd: end, NetCent: -1500, TaxRate: money.Percent(10), TaxCent: -150},
+	}
+
+	testCases := []struct {
+		name          string
+		payment       *model.Payment
+		expectedErr   error
+		errorContains string
+		setupMock     func(invoices *mock.MockInvoiceRepo, products *mock.MockProductRepo, users *mock.MockUserRepo)
+		check         func(t *testing.T, invoice *model.Invoice)
+	}{
+		{
+			name:    "plan change",
+			payment: payment,
+			setupMock: func(invoices *mock.MockInvoiceRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, Name: "Alice", Email: "alice@d.com", BillingCountry: "NL", TaxID: "NL123"}, nil)
+				products.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Name: "Pro Plan"}, nil)
+				products.On("GetByIDWithArchived", ctx, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Basic Plan"}, nil)
+				invoices.On("Create", ctx, mocklib.AnythingOfType("*model.Invoice")).Return(nil)
+			},
+			check: func(t *testing.T, invoice *model.Invoice) {
+				require.Equal(t, fmt.Sprintf("INV-%d", time.Now().UTC().Year()), invoice.Series)
+				require.Equal(t, uint(7), invoice.PaymentID)
+				require.Equal(t, "EUR", invoice.Currency)
+				require.Equal(t, "ACME", invoice.SellerName)
+				require.Equal(t, "NL", invoice.SellerCountry)
+				require.Equal(t, "Alice", invoice.BuyerName)
+				require.Equal(t, "NL123", invoice.BuyerTaxID)
+				require.Equal(t, "NL", invoice.TaxCountry)
+				require.Equal(t, 3500, invoice.NetCent)
+				require.Equal(t, 350, invoice.TaxCent)
+				require.Equal(t, payment.AmountCent, invoice.TotalCent)
+
+				require.Len(t, invoice.Lines, 2)
+				require.Equal(t, "Pro Plan", invoice.Lines[0].Description)
+				require.Equal(t, 5500, invoice.Lines[0].TotalCent)
+				require.Equal(t, "Unused time on Basic Plan", invoice.Lines[1].Description)
+				require.Equal(t, 2, invoice.Lines[1].Position)
+				require.True(t, invoice.Lines[1].PeriodEnd.Equal(end))
+			},
+		},
+		{
+			name:    "invoice of the payment was already issued",
+			payment: payment,
+			setupMock: func(invoices *mock.MockInvoiceRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+				products.On("GetByIDWithArchived", ctx, mocklib.Anything).Return(&model.Product{}, nil)
+				invoices.On("Create", ctx, mocklib.Anything).Return(gorm.ErrDuplicatedKey)
+				invoices.On("GetByPayment", ctx, uint(7)).Return(&model.Invoice{Model: gorm.Model{ID: 3}, Number: "INV-2025-000003"}, nil)
+			},
+			check: func(t *testing.T, invoice *model.Invoice) {
+				require.Equal(t, "INV-2025-000003", invoice.Number)
+			},
+		},
+		{
+			name:          "failed payments aren't invoiced",
+			payment:       &model.Payment{Model: gorm.Model{ID: 8}, Status: model.PaymentFailed},
+			errorContains: "payment 8 is failed",
+			setupMock:     func(invoices *mock.MockInvoiceRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {},
+		},
+		{
+			name:        "unknown buyer",
+			payment:     payment,
+			expectedErr: ErrUserNotFound,
+			setupMock: func(invoices *mock.MockInvoiceRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				users.On("GetByID", ctx, uint(1)).Return((*model.User)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+		{
+			name:          "numbering fails",
+			payment:       payment,
+			errorContains: "couldn't issue invoice",
+			setupMock: func(invoices *mock.MockInvoiceRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+				products.On("GetByIDWithArchived", ctx, mocklib.Anything).Return(&model.Product{}, nil)
+				invoices.On("Create", ctx, mocklib.Anything).Return(errors.New("database is locked"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			invoices := new(mock.MockInvoiceRepo)
+			products := new(mock.MockProductRepo)
+			users := new(mock.MockUserRepo)
+			tc.setupMock(invoices, products, users)
+			svc := NewInvoiceService(invoices, &productService{products}, &userService{users}, InvoicePolicy{
+				Prefix: "INV",
+				Seller: Seller{Name: "ACME", Country: "NL"},
+			})
+
+			invoice, err := svc.Issue(ctx, tc.payment, sub, items)
+			switch {
+			case tc.expectedErr != nil:
+				require.ErrorIs(t, err, tc.expectedErr)
+			case tc.errorContains != "":
+				require.ErrorContains(t, err, tc.errorContains)
+			default:
+				require.NoError(t, err)
+				tc.check(t, invoice)
+			}
+
+			invoices.AssertExpectations(t)
+			products.AssertExpectations(t)
+			users.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIssueInvoiceTaxPolicy(t *testing.T) {
+	ctx := context.Background()
+	payment := &model.Payment{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, Currency: "EUR", Status: model.PaymentSucceeded}
+	sub := &model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1}
+	// each line is 28.5 cents of tax at 19%, 142.5 together, or 23.9 cents
+	// included in it; the credit keeps the tax of the payment it credits
+	line := InvoiceItem{ProductID: 1, NetCent: 150, TaxRate: money.Percent(19)}
+	items := []InvoiceItem{line, line, line, line, line, {ProductID: 1, Credit: true, NetCent: -100, TaxRate: money.Percent(19), TaxCent: -19}}
+
+	testCases := []struct {
+		name    string
+		policy  money.TaxPolicy
+		netCent int
+		taxCent int
+	}{
+		{name: "half up per line", policy: money.TaxPolicy{Rounding: money.HalfUp, Level: money.PerLine}, netCent: 650, taxCent: 5*29 - 19},
+		{name: "half even per line", policy: money.TaxPolicy{Rounding: money.HalfEven, Level: money.PerLine}, netCent: 650, taxCent: 5*28 - 19},
+		{name: "half up per invoice", policy: money.TaxPolicy{Rounding: money.HalfUp, Level: money.PerInvoice}, netCent: 650, taxCent: 143 - 19},
+		{name: "half even per invoice", policy: money.TaxPolicy{Rounding: money.HalfEven, Level: money.PerInvoice}, netCent: 650, taxCent: 142 - 19},
+		{name: "inclusive per line", policy: money.TaxPolicy{Inclusive: true}, netCent: 5*126 - 100, taxCent: 5*24 - 19},
+		{name: "inclusive per invoice", policy: money.TaxPolicy{Inclusive: true, Level: money.PerInvoice}, netCent: 750 - 120 - 100, taxCent: 120 - 19},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			invoices := new(mock.MockInvoiceRepo)
+			products := new(mock.MockProductRepo)
+			users := new(mock.MockUserRepo)
+			users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+			products.On("GetByIDWithArchived", ctx, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Name: "Pro Plan"}, nil)
+			invoices.On("Create", ctx, mocklib.AnythingOfType("*model.Invoice")).Return(nil)
+
+			svc := NewInvoiceService(invoices, &productService{products}, &userService{users}, InvoicePolicy{Tax: tc.policy})
+			invoice, err := svc.Issue(ctx, payment, sub, items)
+			require.NoError(t, err)
+			require.Equal(t, tc.netCent, invoice.NetCent)
+			require.Equal(t, tc.taxCent, invoice.TaxCent)
+			require.Equal(t, tc.netCent+tc.taxCent, invoice.TotalCent)
+
+			var lineTax int
+			for _, line := range invoice.Lines {
+				lineTax += line.TaxCent
+			}
+			require.Equal(t, invoice.TaxCent, lineTax)
+			require.Equal(t, -19, invoice.Lines[5].TaxCent)
+			// the caller's items are left as they were
+			require.Zero(t, items[0].TaxCent)
+
+			// taxing items again, as Issue does with periodItems, changes
+			// nothing
+			taxed := slices.Clone(items)
+			taxItems(tc.policy, "EUR", taxed)
+			again := slices.Clone(taxed)
+			taxItems(tc.policy, "EUR", again)
+			require.Equal(t, taxed, again)
+		})
+	}
+}
+
+func TestGetInvoice(t *testing.T) {
+	ctx := context.Background()
+	invoices := new(mock.MockInvoiceRepo)
+	invoices.On("GetByID", ctx, uint(1)).Return(&model.Invoice{Model: gorm.Model{ID: 1}, UserID: 1}, nil)
+	invoices.On("GetByID", ctx, uint(9)).Return((*model.Invoice)(nil), gorm.ErrRecordNotFound)
+	svc := NewInvoiceService(invoices, &productService{}, &userService{}, DefaultInvoicePolicy())
+
+	invoice, err := svc.Get(ctx, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint(1), invoice.ID)
+
+	_, err = svc.Get(ctx, 1, 2)
+	require.ErrorIs(t, err, ErrInvoiceNotFound)
+
+	_, err = svc.Get(ctx, 9, 1)
+	require.ErrorIs(t, err, ErrInvoiceNotFound)
+}
+
+func TestListInvoices(t *testing.T) {
+	ctx := context.Background()
+	invoices := new(mock.MockInvoiceRepo)
+	invoices.On("List", ctx, repo.InvoiceFilter{UserID: 1, Limit: 3}).Return([]model.Invoice{
+		{Model: gorm.Model{ID: 5}}, {Model: gorm.Model{ID: 4}}, {Model: gorm.Model{ID: 3}},
+	}, nil)
+	invoices.On("List", ctx, repo.InvoiceFilter{UserID: 1, BeforeID: 4, Limit: 3}).Return([]model.Invoice{
+		{Model: gorm.Model{ID: 3}},
+	}, nil)
+	svc := NewInvoiceService(invoices, &productService{}, &userService{}, DefaultInvoicePolicy())
+
+	page, err := svc.List(ctx, 1, "", 2)
+	require.NoError(t, err)
+	require.Len(t, page.Invoices, 2)
+	require.NotEmpty(t, page.NextCursor)
+
+	page, err = svc.List(ctx, 1, page.NextCursor, 2)
+	require.NoError(t, err)
+	require.Len(t, page.Invoices, 1)
+	require.Empty(t, page.NextCursor)
+
+	_, err = svc.List(ctx, 1, "not a cursor", 2)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+	invoices.AssertExpectations(t)
+}
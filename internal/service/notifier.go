@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/thatmatin/subserv/internal/model"
+)
+
+// Notifier tells users about changes to their subscriptions.
+type Notifier interface {
+	// TrialEnding reminds the user that the subscription's trial ends soon.
+	TrialEnding(ctx context.Context, user *model.User, sub *model.Subscription) error
+}
+
+type logNotifier struct{}
+
+// NewLogNotifier returns a Notifier that logs the messages instead of
+// delivering them.
+func NewLogNotifier() Notifier {
+	return logNotifier{}
+}
+
+func (logNotifier) TrialEnding(ctx context.Context, user *model.User, sub *model.Subscription) error {
+	if user.PaymentMethod == "" {
+		log.Printf("notify %s: the trial of subscription %d ends on %s, add a payment method to keep it", user.Email, sub.ID, sub.End.Format("2006-01-02"))
+		return nil
+	}
+	log.Printf("notify %s: the trial of subscription %d ends on %s and will be charged then", user.Email, sub.ID, sub.End.Format("2006-01-02"))
+	return nil
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"encoding/base64"
+	"strconv"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// encodeCursor turns the ID of the last returned row into an opaque token.
+func encodeCursor(ID uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(ID), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+
+	ID, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil || ID == 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return uint(ID), nil
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
@@ -1,18 +1,44 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"math/rand"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
 )
 
 type PaymentProcessor interface {
+	Name() string
 	Charge(req PaymentRequest) (*PaymentResult, error)
+	// Refund returns money of an earlier charge, identified by its TxID.
+	Refund(req RefundRequest) (*PaymentResult, error)
 }
 
 type PaymentRequest struct {
 	UserID    uint
 	ProductID uint
-	Amount    int
+	// Amount is in the minor unit of Currency.
+	Amount   int
+	Currency string
+	// PaymentMethod is the processor's token of the saved card to charge.
+	// It is empty for charges the user starts, which pay as they check out.
+	PaymentMethod string
+	// IdempotencyKey is the same for every retry of one logical charge, so
+	// processors can deduplicate on their side.
+	IdempotencyKey string
+}
+
+type RefundRequest struct {
+	UserID         uint
+	TxID           string
+	Amount         int
+	Currency       string
+	IdempotencyKey string
 }
 
 type PaymentResult struct {
@@ -27,6 +53,10 @@ func NewDummyPaymentProcessor() PaymentProcessor {
 	return &dummyPaymentProcessor{}
 }
 
+func (p *dummyPaymentProcessor) Name() string {
+	return "dummy"
+}
+
 func (p *dummyPaymentProcessor) Charge(req PaymentRequest) (*PaymentResult, error) {
 	// simulate 95% success rate
 	// returned error will be used for internal errors but payment errors checked seperately
@@ -42,3 +72,352 @@ func (p *dummyPaymentProcessor) Charge(req PaymentRequest) (*PaymentResult, erro
 		Error:   "payment failed",
 	}, nil
 }
+
+func (p *dummyPaymentProcessor) Refund(req RefundRequest) (*PaymentResult, error) {
+	// refunds of a real charge rarely fail, simulate 99% success rate
+	if rand.Float64() < 0.99 {
+		return &PaymentResult{
+			Success: true,
+			TxID:    fmt.Sprintf("rf-%d", rand.Intn(1000000)),
+		}, nil
+	}
+
+	return &PaymentResult{
+		Success: false,
+		Error:   "refund failed",
+	}, nil
+}
+
+// BalanceProvider is the provider of payments paid entirely from the
+// customer balance.
+const BalanceProvider = "balance"
+
+// paymentLedger charges subscriptions, refunds their payments and keeps a
+// row for every attempt. Charges are paid from the customer balance first;
+// money given back is documented with a credit note.
+type paymentLedger struct {
+	repo      repo.PaymentRepository
+	processor PaymentProcessor
+	credits   CreditService
+	// tax is how charges are taxed and rounded; the zero value is
+	// money.DefaultTaxPolicy.
+	tax money.TaxPolicy
+}
+
+// chargeSubscription charges one period of the subscription's locked-in price
+// less its coupon's discount, including tax. The payment is stored as pending
+// before the processor is called and updated with the outcome afterwards, so
+// a successful charge is on record even if saving the subscription fails
+// later. A declined charge is not an error; check the returned payment's
+// Status. The user's balance in the subscription's currency is spent first
+// and only the rest is sent to the processor, charged to paymentMethod if
+// it isn't empty.
+func (l *paymentLedger) chargeSubscription(ctx context.Context, sub *model.Subscription, kind model.PaymentKind, paymentMethod string, idempotencyKey string) (*model.Payment, error) {
+	price := taxPeriod(l.tax, sub.PriceCent, nextDiscount(l.tax.Rounding, sub, sub.PriceCent), sub.TaxRate, sub.Currency)
+	return l.charge(ctx, sub, kind, int(price.Gross.Amount), int(price.Tax.Amount), paymentMethod, idempotencyKey)
+}
+
+// charge charges amount, of which taxCent is tax, for the subscription. See
+// chargeSubscription.
+func (l *paymentLedger) charge(ctx context.Context, sub *model.Subscription, kind model.PaymentKind, amount int, taxCent int, paymentMethod string, idempotencyKey string) (*model.Payment, error) {
+	payment := &model.Payment{
+		SubscriptionID: sub.ID,
+		UserID:         sub.UserID,
+		Kind:           kind,
+		AmountCent:     amount,
+		TaxCent:        taxCent,
+		Currency:       sub.Currency,
+		Provider:       l.processor.Name(),
+		Status:         model.PaymentPending,
+		IdempotencyKey: idempotencyKey,
+		AttemptedAt:    time.Now().In(UTCLocation),
+	}
+	if err := l.repo.Create(ctx, payment); err != nil {
+		return nil, fmt.Errorf("couldn't record payment: %w", err)
+	}
+
+	if payment.IdempotencyKey == "" {
+		payment.IdempotencyKey = fmt.Sprintf("payment-%d", payment.ID)
+	}
+
+	var spent int
+	if amount > 0 {
+		var spendErr error
+		spent, spendErr = l.credits.SpendBalance(ctx, payment, amount)
+		if spendErr != nil {
+			// charging the processor in full is still correct
+			log.Printf("couldn't spend balance on payment %d of subscription %d: %v", payment.ID, sub.ID, spendErr)
+		}
+		payment.BalanceCent = spent
+	}
+
+	result := &PaymentResult{Success: true}
+	var err error
+	switch {
+	case amount == 0:
+		// nothing to charge, e.g. a period fully discounted by a coupon
+		payment.Provider = ""
+	case spent < amount:
+		result, err = l.processor.Charge(PaymentRequest{
+			UserID:         sub.UserID,
+			ProductID:      sub.ProductID,
+			Amount:         amount - spent,
+			Currency:       payment.Currency,
+			PaymentMethod:  paymentMethod,
+			IdempotencyKey: payment.IdempotencyKey,
+		})
+	default:
+		payment.Provider = BalanceProvider
+	}
+	completedAt := time.Now().In(UTCLocation)
+	payment.CompletedAt = &completedAt
+
+	switch {
+	case err != nil:
+		payment.Status = model.PaymentFailed
+		payment.FailureReason = err.Error()
+	case result.Success:
+		payment.Status = model.PaymentSucceeded
+		payment.TxID = result.TxID
+	default:
+		payment.Status = model.PaymentFailed
+		payment.FailureReason = result.Error
+	}
+
+	if payment.Status == model.PaymentFailed && spent > 0 {
+		if restoreErr := l.credits.RestoreBalance(ctx, payment, spent); restoreErr != nil {
+			log.Printf("couldn't give back %d cents of balance spent on declined payment %d: %v", spent, payment.ID, restoreErr)
+		} else {
+			payment.BalanceCent = 0
+		}
+	}
+
+	if saveErr := l.repo.Save(ctx, payment); saveErr != nil {
+		// The pending row is already stored and the money may have moved, so
+		// carry on with the outcome and log it for reconciliation.
+		log.Printf("payment %d of subscription %d ended %s [Transaction ID %s] but couldn't be saved: %v",
+			payment.ID, sub.ID, payment.Status, payment.TxID, saveErr)
+	}
+
+	if err != nil {
+		return payment, fmt.Errorf("an error occured in payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+// refundPayment refunds amount of a succeeded payment to the payment method,
+// tax included. The amount is reserved on the payment first so refunds never
+// exceed the charge, and given back if the refund fails. Like charges, a
+// declined refund is not an error; check the returned refund's Status. A
+// succeeded refund is documented with a credit note.
+func (l *paymentLedger) refundPayment(ctx context.Context, payment *model.Payment, amount int, reason string) (*model.Refund, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidRefund
+	}
+
+	ok, err := l.repo.ReserveRefund(ctx, payment.ID, amount)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't reserve refund: %w", err)
+	}
+	if !ok {
+		if payment.Status != model.PaymentSucceeded {
+			return nil, ErrPaymentNotRefundable
+		}
+		return nil, ErrRefundExceedsPayment
+	}
+
+	refund := &model.Refund{
+		PaymentID:      payment.ID,
+		SubscriptionID: payment.SubscriptionID,
+		UserID:         payment.UserID,
+		AmountCent:     amount,
+		TaxCent:        taxShare(l.tax.Rounding, amount, payment.TaxCent, payment.AmountCent),
+		Currency:       payment.Currency,
+		Reason:         reason,
+		Provider:       l.processor.Name(),
+		Status:         model.PaymentPending,
+		AttemptedAt:    time.Now().In(UTCLocation),
+	}
+	if err := l.repo.CreateRefund(ctx, refund); err != nil {
+		l.releaseRefund(ctx, payment, amount)
+		return nil, fmt.Errorf("couldn't record refund: %w", err)
+	}
+	refund.IdempotencyKey = fmt.Sprintf("refund-%d", refund.ID)
+
+	result, err := l.processor.Refund(RefundRequest{
+		UserID:         payment.UserID,
+		TxID:           payment.TxID,
+		Amount:         amount,
+		Currency:       refund.Currency,
+		IdempotencyKey: refund.IdempotencyKey,
+	})
+	completedAt := time.Now().In(UTCLocation)
+	refund.CompletedAt = &completedAt
+
+	switch {
+	case err != nil:
+		refund.Status = model.PaymentFailed
+		refund.FailureReason = err.Error()
+	case result.Success:
+		refund.Status = model.PaymentSucceeded
+		refund.TxID = result.TxID
+	default:
+		refund.Status = model.PaymentFailed
+		refund.FailureReason = result.Error
+	}
+
+	if refund.Status == model.PaymentFailed {
+		l.releaseRefund(ctx, payment, amount)
+	} else {
+		payment.RefundedCent += amount
+	}
+
+	if saveErr := l.repo.SaveRefund(ctx, refund); saveErr != nil {
+		log.Printf("refund %d of payment %d ended %s [Transaction ID %s] but couldn't be saved: %v",
+			refund.ID, payment.ID, refund.Status, refund.TxID, saveErr)
+	}
+
+	if refund.Status == model.PaymentSucceeded {
+		// the money is back with the customer, a missing document is logged
+		// for reconciliation
+		_, noteErr := l.credits.IssueCreditNote(ctx, CreditNoteParams{
+			Payment:    payment,
+			Refund:     refund,
+			AmountCent: refund.AmountCent,
+			TaxCent:    refund.TaxCent,
+			Reason:     reason,
+		})
+		if noteErr != nil {
+			log.Printf("couldn't issue credit note for refund %d of payment %d [Transaction ID %s]: %v",
+				refund.ID, payment.ID, refund.TxID, noteErr)
+		}
+	}
+
+	if err != nil {
+		return refund, fmt.Errorf("an error occured in refund: %w", err)
+	}
+
+	return refund, nil
+}
+
+// creditPayment credits amount of a succeeded payment, tax included, to the
+// user's balance with a credit note. Like refunds, the amount is reserved on
+// the payment first and given back if the credit note can't be issued.
+func (l *paymentLedger) creditPayment(ctx context.Context, payment *model.Payment, amount int, reason string) (*model.CreditNote, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidRefund
+	}
+	if err := l.reserveCredit(ctx, payment, amount); err != nil {
+		return nil, err
+	}
+	return l.issueCredit(ctx, payment, amount, reason)
+}
+
+// reserveCredit counts amount of a succeeded payment as given back, so it
+// can't be refunded or credited again.
+func (l *paymentLedger) reserveCredit(ctx context.Context, payment *model.Payment, amount int) error {
+	ok, err := l.repo.ReserveCredit(ctx, payment.ID, amount)
+	if err != nil {
+		return fmt.Errorf("couldn't reserve credit: %w", err)
+	}
+	if !ok {
+		if payment.Status != model.PaymentSucceeded {
+			return ErrPaymentNotRefundable
+		}
+		return ErrRefundExceedsPayment
+	}
+
+	payment.CreditedCent += amount
+	return nil
+}
+
+// issueCredit issues a credit note for amount reserved on the payment and
+// releases the reservation if it can't.
+func (l *paymentLedger) issueCredit(ctx context.Context, payment *model.Payment, amount int, reason string) (*model.CreditNote, error) {
+	note, err := l.credits.IssueCreditNote(ctx, CreditNoteParams{
+		Payment:    payment,
+		AmountCent: amount,
+		TaxCent:    taxShare(l.tax.Rounding, amount, payment.TaxCent, payment.AmountCent),
+		Reason:     reason,
+	})
+	if err != nil {
+		l.releaseCredit(ctx, payment, amount)
+		return nil, err
+	}
+	return note, nil
+}
+
+func (l *paymentLedger) releaseCredit(ctx context.Context, payment *model.Payment, amount int) {
+	if err := l.repo.ReleaseCredit(ctx, payment.ID, amount); err != nil {
+		log.Printf("couldn't release %d cents of credit reserved on payment %d: %v", amount, payment.ID, err)
+		return
+	}
+	payment.CreditedCent -= amount
+}
+
+func (l *paymentLedger) releaseRefund(ctx context.Context, payment *model.Payment, amount int) {
+	if err := l.repo.ReleaseRefund(ctx, payment.ID, amount); err != nil {
+		log.Printf("couldn't release %d cents reserved on payment %d: %v", amount, payment.ID, err)
+	}
+}
+
+// lastCharge returns the latest succeeded payment of the subscription, which
+// paid for its current period, or nil if it was never paid.
+func (l *paymentLedger) lastCharge(ctx context.Context, subscriptionID uint) (*model.Payment, error) {
+	payments, err := l.repo.ListBySubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list payments: %w", err)
+	}
+
+	for _, payment := range payments {
+		if payment.Status == model.PaymentSucceeded {
+			return &payment, nil
+		}
+	}
+	return nil, nil
+}
+
+// proratedAmount is the share of the subscription's period price, tax
+// included under policy, that is not used up at the given time. A paused
+// subscription stopped using its period when it was paused.
+func proratedAmount(policy money.TaxPolicy, sub *model.Subscription, at time.Time) int {
+	if sub.State == model.Paused && sub.PausedAt != nil {
+		at = *sub.PausedAt
+	}
+
+	// whole seconds keep the product well within int64
+	period := int64(sub.End.Sub(sub.Start) / time.Second)
+	unused := int64(sub.End.Sub(at) / time.Second)
+	if period <= 0 || unused <= 0 {
+		return 0
+	}
+	unused = min(unused, period)
+
+	price := policy.Rounding.Divide(int64(sub.PriceCent-sub.DiscountCent)*unused, period)
+	return int(withTax(policy, int(price), sub.TaxRate, sub.Currency).Gross.Amount)
+}
+
+// withTax taxes a net price in the currency's minor unit at the given rate.
+func withTax(policy money.TaxPolicy, net int, rate money.Rate, currency string) money.TaxedLine {
+	return policy.Apply(money.New(int64(net), money.Currency(currency)), rate)
+}
+
+// taxPeriod taxes one period at a net price less a discount. The price and
+// the discount are taxed as the separate lines they are invoiced as, so
+// what is charged is what the invoice adds up to.
+func taxPeriod(policy money.TaxPolicy, price int, discount int, rate money.Rate, currency string) money.Totals {
+	lines := []money.Line{{Price: money.New(int64(price), money.Currency(currency)), Rate: rate}}
+	if discount > 0 {
+		lines = append(lines, money.Line{Price: money.New(int64(-discount), money.Currency(currency)), Rate: rate})
+	}
+	// the lines are of one currency, so this can't fail
+	totals, _ := policy.Calculate(lines)
+	return totals
+}
+
+// taxShare is the part of amount that is tax when taxCent of totalCent was.
+// totalCent must be positive.
+func taxShare(rounding money.RoundingMode, amount int, taxCent int, totalCent int) int {
+	return int(rounding.Divide(int64(amount)*int64(taxCent), int64(totalCent)))
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+type ChangeMode string
+
+const (
+	// ChangeImmediately switches plans now. The unused share of the current
+	// period is credited against the new plan's first period, which starts
+	// now.
+	ChangeImmediately ChangeMode = "immediate"
+	// ChangeAtPeriodEnd switches plans when the subscription renews.
+	ChangeAtPeriodEnd ChangeMode = "period_end"
+)
+
+func (m ChangeMode) Valid() bool {
+	return m == ChangeImmediately || m == ChangeAtPeriodEnd
+}
+
+// PlanChange is the outcome of ChangePlan. AmountDueCent is ChargeCent minus
+// CreditCent; it is charged when positive and credited to the customer
+// balance when negative.
+type PlanChange struct {
+	Subscription  *model.Subscription
+	CreditCent    int
+	ChargeCent    int
+	AmountDueCent int
+	EffectiveAt   time.Time
+	Payment       *model.Payment
+	CreditNote    *model.CreditNote
+}
+
+// ChangePlan moves an active subscription to another product. Requesting the
+// current product withdraws a change scheduled for the period end.
+func (s *subscriptionService) ChangePlan(ctx context.Context, ID uint, userID uint, productID uint, mode ChangeMode, idempotencyKey string) (*PlanChange, error) {
+	subscription, err := s.Get(ctx, ID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't change plan: %w", err)
+	}
+
+	now := time.Now().In(UTCLocation)
+	if subscription.State != model.Active {
+		return nil, ErrPlanNotChangeable
+	}
+	if !now.Before(subscription.End.In(UTCLocation)) {
+		return nil, ErrAlreadyExpired
+	}
+	if subscription.CancelAt != nil {
+		return nil, ErrCancellationScheduled
+	}
+
+	if productID == subscription.ProductID {
+		if mode != ChangeAtPeriodEnd || subscription.PendingProductID == nil {
+			return nil, ErrSamePlan
+		}
+
+		subscription.PendingProductID = nil
+		if err := s.save(ctx, subscription); err != nil {
+			return nil, fmt.Errorf("couldn't withdraw plan change: %w", err)
+		}
+		return &PlanChange{Subscription: subscription, EffectiveAt: subscription.End}, nil
+	}
+
+	product, err := s.productService.Get(ctx, productID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, fmt.Errorf("couldn't fetch product: %w", err)
+	}
+	price, ok := product.PriceIn(subscription.Currency)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPriceNotAvailable, subscription.Currency)
+	}
+
+	if mode == ChangeAtPeriodEnd {
+		if !subscription.AutoRenew {
+			return nil, ErrPlanChangeNeedsAutoRenew
+		}
+
+		keep, err := keepsDiscount(ctx, s.couponService, subscription, product.ID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't schedule plan change: %w", err)
+		}
+		subscription.PendingProductID = &product.ID
+		if err := s.save(ctx, subscription); err != nil {
+			return nil, fmt.Errorf("couldn't schedule plan change: %w", err)
+		}
+		var discount int
+		if keep {
+			discount = nextDiscount(s.ledger.tax.Rounding, subscription, price)
+		}
+		decision := s.taxService.ForProduct(subscription, product)
+		return &PlanChange{
+			Subscription: subscription,
+			ChargeCent:   int(taxPeriod(s.ledger.tax, price, discount, decision.Rate, subscription.Currency).Gross.Amount),
+			EffectiveAt:  subscription.End,
+		}, nil
+	}
+
+	return s.switchPlan(ctx, subscription, product, price, now, idempotencyKey)
+}
+
+// switchPlan credits the unused share of the last payment, charges the new
+// plan's first period at price and switches the subscription to it. Only the
+// difference is charged; what is left over of a downgrade goes to the
+// customer balance. The credit is reserved on the last payment, so it can't
+// be refunded or credited by a later change too. The coupon keeps
+// discounting if it applies to the new product.
+func (s *subscriptionService) switchPlan(ctx context.Context, subscription *model.Subscription, product *model.Product, price int, now time.Time, idempotencyKey string) (*PlanChange, error) {
+	last, err := s.ledger.lastCharge(ctx, subscription.ID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't change plan: %w", err)
+	}
+	keep, err := keepsDiscount(ctx, s.couponService, subscription, product.ID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't change plan: %w", err)
+	}
+
+	var discount int
+	if keep {
+		discount = nextDiscount(s.ledger.tax.Rounding, subscription, price)
+	}
+	decision := s.taxService.ForProduct(subscription, product)
+	taxed := taxPeriod(s.ledger.tax, price, discount, decision.Rate, subscription.Currency)
+	change := &PlanChange{
+		Subscription: subscription,
+		ChargeCent:   int(taxed.Gross.Amount),
+		EffectiveAt:  now,
+	}
+	// the credit is invoiced against the plan being left
+	previous := *subscription
+	var creditTax int
+	if last != nil && last.AmountCent > 0 {
+		change.CreditCent = min(proratedAmount(s.ledger.tax, subscription, now), last.CreditableCent())
+		creditTax = taxShare(s.ledger.tax.Rounding, change.CreditCent, last.TaxCent, last.AmountCent)
+	}
+	change.AmountDueCent = change.ChargeCent - change.CreditCent
+
+	if err := s.claim(ctx, subscription, now); err != nil {
+		return nil, fmt.Errorf("couldn't change plan: %w", err)
+	}
+	if change.CreditCent > 0 {
+		if err := s.ledger.reserveCredit(ctx, last, change.CreditCent); err != nil {
+			s.unclaim(ctx, subscription)
+			if errors.Is(err, ErrRefundExceedsPayment) || errors.Is(err, ErrPaymentNotRefundable) {
+				// refunded since it was read
+				return nil, ErrConcurrentUpdate
+			}
+			return nil, fmt.Errorf("couldn't change plan: %w", err)
+		}
+	}
+
+	if change.AmountDueCent > 0 {
+		var paymentKey string
+		if idempotencyKey != "" {
+			paymentKey = fmt.Sprintf("plan-change-%d-%s", subscription.ID, idempotencyKey)
+		}
+
+		taxCent := max(int(taxed.Tax.Amount)-creditTax, 0)
+		payment, err := s.ledger.charge(ctx, subscription, model.PaymentPlanChange, change.AmountDueCent, taxCent, "", paymentKey)
+		if err == nil && payment.Status != model.PaymentSucceeded {
+			err = ErrFailedPayment
+		}
+		if err != nil {
+			if change.CreditCent > 0 {
+				s.ledger.releaseCredit(ctx, last, change.CreditCent)
+			}
+			s.unclaim(ctx, subscription)
+			return nil, err
+		}
+		change.Payment = payment
+	}
+
+	switchTo := func(sub *model.Subscription) error {
+		if sub.State != model.Active {
+			return fmt.Errorf("subscription %d became %s while its plan was changed", sub.ID, sub.State)
+		}
+		sub.ProductID = product.ID
+		sub.PriceCent = price
+		applyTax(sub, decision)
+		sub.Start = now
+		sub.End = now.Add(product.Period())
+		sub.PendingProductID = nil
+		sub.ClaimedUntil = nil
+		if !keep {
+			endDiscount(sub)
+		}
+		startDiscountedPeriod(s.ledger.tax.Rounding, sub)
+		return nil
+	}
+	if err := switchTo(subscription); err != nil {
+		return nil, err
+	}
+	if change.Payment != nil {
+		items := periodItems(s.ledger.tax, subscription)
+		if change.CreditCent > 0 {
+			items = append(items, InvoiceItem{
+				ProductID:   previous.ProductID,
+				Credit:      true,
+				PeriodStart: now,
+				PeriodEnd:   previous.End,
+				NetCent:     -(change.CreditCent - creditTax),
+				TaxRate:     previous.TaxRate,
+				TaxCent:     -creditTax,
+			})
+		}
+		issueInvoice(ctx, s.invoiceService, change.Payment, subscription, items...)
+	}
+	if err := s.saveCharged(ctx, subscription, switchTo); err != nil {
+		if change.Payment != nil {
+			return nil, fmt.Errorf("couldn't save plan change [Transaction ID %s] : %w", change.Payment.TxID, err)
+		}
+		if change.CreditCent > 0 {
+			s.ledger.releaseCredit(ctx, last, change.CreditCent)
+		}
+		return nil, fmt.Errorf("couldn't save plan change: %w", err)
+	}
+
+	if change.AmountDueCent < 0 {
+		// the plan is switched either way, a failed credit is released and
+		// logged, and can be refunded by staff later
+		note, err := s.ledger.issueCredit(ctx, last, -change.AmountDueCent, "credit for plan change")
+		if err != nil {
+			log.Printf("couldn't credit %d cents of payment %d for plan change of subscription %d: %v", -change.AmountDueCent, last.ID, subscription.ID, err)
+		}
+		change.CreditNote = note
+	}
+
+	return change, nil
+}
@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"gorm.io/gorm"
+)
+
+func TestChangePlan(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+	period := time.Hour * 24 * 30
+
+	// halfway through a 3000 cent period, so 1500 cents are credited
+	basic := func() *model.Subscription {
+		return &model.Subscription{
+			Model:     gorm.Model{ID: 1},
+			UserID:    1,
+			ProductID: 1,
+			State:     model.Active,
+			AutoRenew: true,
+			PriceCent: 3000,
+			Currency:  model.DefaultCurrency,
+			Start:     now.Add(-period / 2),
+			End:       now.Add(period / 2),
+		}
+	}
+	paid := []model.Payment{{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 3000, TxID: "tx-7", Status: model.PaymentSucceeded}}
+	pro := &model.Product{Model: gorm.Model{ID: 2}, Price: 5000, Duration: period / time.Second}
+	lite := &model.Product{Model: gorm.Model{ID: 3}, Price: 1000, Duration: period / time.Second}
+
+	testCases := []struct {
+		name        string
+		sub         func() *model.Subscription
+		productID   uint
+		mode        ChangeMode
+		declined    bool
+		balance     int
+		setupMock   func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo)
+		expectedErr error
+		check       func(t *testing.T, change *PlanChange, processor *stubPaymentProcessor)
+	}{
+		{
+			name:      "upgrade charges the difference",
+			sub:       basic,
+			productID: 2,
+			mode:      ChangeImmediately,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+				payments.On("ListBySubscription", ctx, uint(1)).Return(paid, nil)
+				subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+				subs.On("Save", ctx, mocklib.Anything).Return(nil).Once()
+				payments.On("ReserveCredit", ctx, uint(7), mocklib.Anything).Return(true, nil).Once()
+				expectPayment(ctx, payments, model.PaymentPlanChange)
+			},
+			check: func(t *testing.T, change *PlanChange, processor *stubPaymentProcessor) {
+				require.InDelta(t, 1500, change.CreditCent, 1)
+				require.Equal(t, 5000, change.ChargeCent)
+				require.InDelta(t, 3500, change.AmountDueCent, 1)
+				require.Len(t, processor.requests, 1)
+				require.Equal(t, change.AmountDueCent, processor.requests[0].Amount)
+				require.Empty(t, processor.refunds)
+
+				sub := change.Subscription
+				require.Equal(t, uint(2), sub.ProductID)
+				require.Equal(t, 5000, sub.PriceCent)
+				require.True(t, sub.Start.Equal(change.EffectiveAt))
+				require.True(t, sub.End.Equal(change.EffectiveAt.Add(period)))
+			},
+		},
+		{
+			name:      "upgrade spends the balance first",
+			sub:       basic,
+			productID: 2,
+			mode:      ChangeImmediately,
+			balance:   1000,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+				payments.On("ListBySubscription", ctx, uint(1)).Return(paid, nil)
+				subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+				subs.On("Save", ctx, mocklib.Anything).Return(nil).Once()
+				payments.On("ReserveCredit", ctx, uint(7), mocklib.Anything).Return(true, nil).Once()
+				expectPayment(ctx, payments, model.PaymentPlanChange)
+			},
+			check: func(t *testing.T, change *PlanChange, processor *stubPaymentProcessor) {
+				require.Equal(t, 1000, change.Payment.BalanceCent)
+				require.Len(t, processor.requests, 1)
+				require.Equal(t, change.AmountDueCent-1000, processor.requests[0].Amount)
+			},
+		},
+		{
+			name:      "downgrade credits the difference to the balance",
+			sub:       basic,
+			productID: 3,
+			mode:      ChangeImmediately,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(3)).Return(lite, nil)
+				payments.On("ListBySubscription", ctx, uint(1)).Return(paid, nil)
+				subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+				subs.On("Save", ctx, mocklib.Anything).Return(nil).Once()
+				// the whole credit is reserved once, the part left over goes to the balance
+				payments.On("ReserveCredit", ctx, uint(7), mocklib.MatchedBy(func(amount int) bool {
+					return amount == 1500 || amount == 1499
+				})).Return(true, nil).Once()
+			},
+			check: func(t *testing.T, change *PlanChange, processor *stubPaymentProcessor) {
+				require.InDelta(t, -500, change.AmountDueCent, 1)
+				require.Empty(t, processor.requests)
+				require.Empty(t, processor.refunds)
+				require.NotNil(t, change.CreditNote)
+				require.Equal(t, model.CreditBalance, change.CreditNote.Method)
+				require.Equal(t, uint(7), change.CreditNote.PaymentID)
+				require.Equal(t, -change.AmountDueCent, change.CreditNote.TotalCent)
+				require.Equal(t, uint(3), change.Subscription.ProductID)
+			},
+		},
+		{
+			name:      "declined upgrade keeps the plan",
+			sub:       basic,
+			productID: 2,
+			mode:      ChangeImmediately,
+			declined:  true,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+				payments.On("ListBySubscription", ctx, uint(1)).Return(paid, nil)
+				subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+				subs.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool { return sub.ProductID == 1 })).Return(nil).Once()
+				payments.On("ReserveCredit", ctx, uint(7), mocklib.Anything).Return(true, nil).Once()
+				expectPayment(ctx, payments, model.PaymentPlanChange)
+				payments.On("ReleaseCredit", ctx, uint(7), mocklib.Anything).Return(nil).Once()
+			},
+			expectedErr: ErrFailedPayment,
+		},
+		{
+			name:      "last payment refunded concurrently",
+			sub:       basic,
+			productID: 2,
+			mode:      ChangeImmediately,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+				payments.On("ListBySubscription", ctx, uint(1)).Return(paid, nil)
+				subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+				subs.On("Save", ctx, mocklib.Anything).Return(nil).Once()
+				payments.On("ReserveCredit", ctx, uint(7), mocklib.Anything).Return(false, nil).Once()
+			},
+			expectedErr: ErrConcurrentUpdate,
+		},
+		{
+			name:      "downgrade at period end",
+			sub:       basic,
+			productID: 3,
+			mode:      ChangeAtPeriodEnd,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(3)).Return(lite, nil)
+				subs.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.ProductID == 1 && sub.PendingProductID != nil && *sub.PendingProductID == 3
+				})).Return(nil).Once()
+			},
+			check: func(t *testing.T, change *PlanChange, processor *stubPaymentProcessor) {
+				require.Empty(t, processor.requests)
+				require.Empty(t, processor.refunds)
+				require.True(t, change.EffectiveAt.Equal(change.Subscription.End))
+			},
+		},
+		{
+			name: "withdraw scheduled change",
+			sub: func() *model.Subscription {
+				sub := basic()
+				pending := uint(3)
+				sub.PendingProductID = &pending
+				return sub
+			},
+			productID: 1,
+			mode:      ChangeAtPeriodEnd,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				subs.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool { return sub.PendingProductID == nil })).Return(nil).Once()
+			},
+		},
+		{
+			name: "change at period end without auto-renew",
+			sub: func() *model.Subscription {
+				sub := basic()
+				sub.AutoRenew = false
+				return sub
+			},
+			productID: 3,
+			mode:      ChangeAtPeriodEnd,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(3)).Return(lite, nil)
+			},
+			expectedErr: ErrPlanChangeNeedsAutoRenew,
+		},
+		{
+			name:        "same plan",
+			sub:         basic,
+			productID:   1,
+			mode:        ChangeImmediately,
+			expectedErr: ErrSamePlan,
+		},
+		{
+			name: "paused subscription",
+			sub: func() *model.Subscription {
+				sub := basic()
+				sub.State = model.Paused
+				return sub
+			},
+			productID:   2,
+			mode:        ChangeImmediately,
+			expectedErr: ErrPlanNotChangeable,
+		},
+		{
+			name: "scheduled cancellation",
+			sub: func() *model.Subscription {
+				sub := basic()
+				sub.CancelAt = &sub.End
+				return sub
+			},
+			productID:   2,
+			mode:        ChangeImmediately,
+			expectedErr: ErrCancellationScheduled,
+		},
+		{
+			name:      "unknown product",
+			sub:       basic,
+			productID: 9,
+			mode:      ChangeImmediately,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(9)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+			},
+			expectedErr: ErrProductNotFound,
+		},
+		{
+			name: "product not sold in the subscription's currency",
+			sub: func() *model.Subscription {
+				sub := basic()
+				sub.Currency = "EUR"
+				return sub
+			},
+			productID: 2,
+			mode:      ChangeImmediately,
+			setupMock: func(subs *mock.MockSubscriptionRepo, payments *mock.MockPaymentRepo, products *mock.MockProductRepo) {
+				products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+			},
+			expectedErr: ErrPriceNotAvailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subs := new(mock.MockSubscriptionRepo)
+			payments := new(mock.MockPaymentRepo)
+			products := new(mock.MockProductRepo)
+			processor := &stubPaymentProcessor{success: !tc.declined}
+			subs.On("GetByID", ctx, uint(1)).Return(tc.sub(), nil)
+			if tc.setupMock != nil {
+				tc.setupMock(subs, payments, products)
+			}
+			invoices := &stubInvoiceService{}
+			svc := NewSubscriptionService(subs, payments, &productService{products}, &userService{}, processor, &quoteService{}, &taxService{}, invoices, &stubCreditService{balance: tc.balance}, &couponService{}, money.DefaultTaxPolicy)
+
+			change, err := svc.ChangePlan(ctx, 1, 1, tc.productID, tc.mode, "")
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				if tc.check != nil {
+					tc.check(t, change, processor)
+				}
+				if change.Payment == nil {
+					require.Empty(t, invoices.issued)
+				} else {
+					// the invoice adds up to what was charged
+					require.Len(t, invoices.issued, 1)
+					var total int
+					for _, item := range invoices.issued[0] {
+						total += item.NetCent + item.TaxCent
+					}
+					require.Equal(t, change.Payment.AmountCent, total)
+				}
+			}
+
+			subs.AssertExpectations(t)
+			payments.AssertExpectations(t)
+			products.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpgradeThenRefund(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+	period := time.Hour * 24 * 30
+
+	sub := &model.Subscription{
+		Model:     gorm.Model{ID: 1},
+		UserID:    1,
+		ProductID: 1,
+		State:     model.Active,
+		PriceCent: 3000,
+		Currency:  model.DefaultCurrency,
+		Start:     now.Add(-period / 2),
+		End:       now.Add(period / 2),
+	}
+	last := &model.Payment{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 3000, TxID: "tx-7", Status: model.PaymentSucceeded}
+	pro := &model.Product{Model: gorm.Model{ID: 2}, Price: 5000, Duration: period / time.Second}
+
+	subs := new(mock.MockSubscriptionRepo)
+	payments := new(mock.MockPaymentRepo)
+	products := new(mock.MockProductRepo)
+	subs.On("GetByID", ctx, uint(1)).Return(sub, nil)
+	subs.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+	subs.On("Save", ctx, mocklib.Anything).Return(nil).Once()
+	products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+	payments.On("ListBySubscription", ctx, uint(1)).Return([]model.Payment{*last}, nil)
+	payments.On("ReserveCredit", ctx, uint(7), mocklib.Anything).Run(func(args mocklib.Arguments) {
+		last.CreditedCent += args.Int(2)
+	}).Return(true, nil).Once()
+	expectPayment(ctx, payments, model.PaymentPlanChange)
+
+	processor := &stubPaymentProcessor{success: true}
+	credits := &stubCreditService{}
+	svc := NewSubscriptionService(subs, payments, &productService{products}, &userService{}, processor, &quoteService{}, &taxService{}, &stubInvoiceService{}, credits, &couponService{}, money.DefaultTaxPolicy)
+	change, err := svc.ChangePlan(ctx, 1, 1, 2, ChangeImmediately, "")
+	require.NoError(t, err)
+	require.Equal(t, change.CreditCent, last.CreditedCent)
+
+	// only what wasn't credited to the upgrade can be refunded
+	remainder := 3000 - change.CreditCent
+	payments.On("GetByID", ctx, uint(7)).Return(last, nil)
+	// like the repository, refuse to reserve more than is left
+	payments.On("ReserveRefund", ctx, uint(7), mocklib.MatchedBy(func(amount int) bool {
+		return amount > last.CreditableCent()
+	})).Return(false, nil).Once()
+	payments.On("ReserveRefund", ctx, uint(7), remainder).Return(true, nil).Once()
+	payments.On("CreateRefund", ctx, mocklib.Anything).Return(nil).Once()
+	payments.On("SaveRefund", ctx, mocklib.Anything).Return(nil).Once()
+	refunds := NewRefundService(subs, payments, credits, processor, money.DefaultTaxPolicy)
+
+	_, err = refunds.Refund(ctx, 7, RefundParams{AmountCent: 3000})
+	require.ErrorIs(t, err, ErrRefundExceedsPayment)
+	refund, err := refunds.Refund(ctx, 7, RefundParams{Policy: RefundFull})
+	require.NoError(t, err)
+	require.Equal(t, remainder, refund.AmountCent)
+
+	payments.AssertExpectations(t)
+}
+
+func TestConcurrentPlanChange(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+	period := time.Hour * 24 * 30
+	pro := &model.Product{Model: gorm.Model{ID: 2}, Price: 5000, Duration: period / time.Second}
+	// each change credits 1500 cents, both would fit in the last payment
+	paid := []model.Payment{{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 3000, TxID: "tx-7", Status: model.PaymentSucceeded}}
+
+	testCases := []struct {
+		name           string
+		readAfterClaim bool
+	}{
+		{name: "second change read before the claim"},
+		{name: "second change read after the claim", readAfterClaim: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := &memSubscriptionRepo{sub: model.Subscription{
+				Model:     gorm.Model{ID: 1},
+				UserID:    1,
+				ProductID: 1,
+				State:     model.Active,
+				PriceCent: 3000,
+				Currency:  model.DefaultCurrency,
+				Start:     now.Add(-period / 2),
+				End:       now.Add(period / 2),
+			}}
+			payments := new(mock.MockPaymentRepo)
+			products := new(mock.MockProductRepo)
+			products.On("GetByID", ctx, uint(2)).Return(pro, nil)
+			payments.On("ListBySubscription", ctx, uint(1)).Return(paid, nil)
+			payments.On("ReserveCredit", ctx, uint(7), mocklib.Anything).Return(true, nil).Once()
+			succeeded := succeededPayments(ctx, payments)
+			processor := &stubPaymentProcessor{success: true}
+			svc := NewSubscriptionService(subsRepo, payments, &productService{products}, &userService{}, processor, &quoteService{}, &taxService{}, &stubInvoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			var second error
+			changeAgain := func() { _, second = svc.ChangePlan(ctx, 1, 1, 2, ChangeImmediately, "") }
+			if tc.readAfterClaim {
+				processor.onCharge = changeAgain
+			} else {
+				subsRepo.beforeClaim = changeAgain
+			}
+			_, first := svc.ChangePlan(ctx, 1, 1, 2, ChangeImmediately, "")
+
+			if tc.readAfterClaim {
+				require.NoError(t, first)
+				require.ErrorIs(t, second, ErrConcurrentUpdate)
+			} else {
+				require.ErrorIs(t, first, ErrConcurrentUpdate)
+				require.NoError(t, second)
+			}
+			require.Equal(t, 1, *succeeded)
+			require.Len(t, processor.requests, 1)
+			require.Equal(t, uint(2), subsRepo.sub.ProductID)
+			require.Nil(t, subsRepo.sub.ClaimedUntil)
+			payments.AssertExpectations(t)
+		})
+	}
+}
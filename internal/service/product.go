@@ -4,15 +4,60 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
 	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/tax"
 	"gorm.io/gorm"
 )
 
 type ProductService interface {
 	Get(context.Context, uint) (*model.Product, error)
+	// GetWithArchived also returns archived products. Use it for products
+	// referenced by existing subscriptions.
+	GetWithArchived(context.Context, uint) (*model.Product, error)
 	GetAll(context.Context) ([]model.Product, error)
+	Create(ctx context.Context, params ProductParams) (*model.Product, error)
+	Replace(ctx context.Context, ID uint, params ProductParams) (*model.Product, error)
+	Update(ctx context.Context, ID uint, patch ProductPatch) (*model.Product, error)
+	Archive(ctx context.Context, ID uint) error
+}
+
+// ProductParams describes a product in the catalog. Price is in
+// model.DefaultCurrency; Prices maps other currencies to the price in their
+// minor unit. TaxCategory picks the rate from the tax rules, TaxRate is
+// charged where no rule applies. An empty TaxCategory is tax.Standard.
+// TrialDays is the length of the free trial, zero for none; products of the
+// same Family share one trial per user.
+type ProductParams struct {
+	Name        string
+	Description string
+	Price       int
+	Prices      map[string]int
+	TaxRate     uint8
+	TaxCategory string
+	Duration    time.Duration
+	TrialDays   uint
+	Family      string
+}
+
+// ProductPatch holds the fields of a partial product update. Nil fields are
+// left unchanged. A non-nil Prices replaces all prices in other currencies.
+type ProductPatch struct {
+	Name        *string
+	Description *string
+	Price       *int
+	Prices      map[string]int
+	TaxRate     *uint8
+	TaxCategory *string
+	Duration    *time.Duration
+	TrialDays   *uint
+	Family      *string
 }
 
 type productService struct {
@@ -35,6 +80,18 @@ func (s *productService) Get(ctx context.Context, ID uint) (*model.Product, erro
 	return product, nil
 }
 
+func (s *productService) GetWithArchived(ctx context.Context, ID uint) (*model.Product, error) {
+	product, err := s.repo.GetByIDWithArchived(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch product: %w", err)
+	}
+
+	return product, nil
+}
+
 func (s *productService) GetAll(ctx context.Context) ([]model.Product, error) {
 	products, err := s.repo.GetAll(ctx)
 	if err != nil {
@@ -43,3 +100,166 @@ func (s *productService) GetAll(ctx context.Context) ([]model.Product, error) {
 
 	return products, nil
 }
+
+func (s *productService) Create(ctx context.Context, params ProductParams) (*model.Product, error) {
+	product := &model.Product{}
+	params.apply(product)
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, product); err != nil {
+		return nil, fmt.Errorf("couldn't create product: %w", err)
+	}
+
+	return product, nil
+}
+
+func (s *productService) Replace(ctx context.Context, ID uint, params ProductParams) (*model.Product, error) {
+	product, err := s.getForUpdate(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	params.apply(product)
+	return s.save(ctx, product)
+}
+
+func (s *productService) Update(ctx context.Context, ID uint, patch ProductPatch) (*model.Product, error) {
+	product, err := s.getForUpdate(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if patch.Name != nil {
+		product.Name = strings.TrimSpace(*patch.Name)
+	}
+	if patch.Description != nil {
+		product.Description = *patch.Description
+	}
+	if patch.Price != nil {
+		product.Price = *patch.Price
+	}
+	if patch.Prices != nil {
+		product.Prices = productPrices(patch.Prices)
+	}
+	if patch.TaxRate != nil {
+		product.TaxRate = *patch.TaxRate
+	}
+	if patch.TaxCategory != nil {
+		product.TaxCategory = taxCategoryName(*patch.TaxCategory)
+	}
+	if patch.Duration != nil {
+		product.Duration = *patch.Duration / time.Second
+	}
+	if patch.TrialDays != nil {
+		product.TrialDays = *patch.TrialDays
+	}
+	if patch.Family != nil {
+		product.Family = strings.TrimSpace(*patch.Family)
+	}
+
+	return s.save(ctx, product)
+}
+
+// Archive hides the product from the catalog. Subscriptions already bought
+// keep their locked-in price and are still renewed.
+func (s *productService) Archive(ctx context.Context, ID uint) error {
+	archived, err := s.repo.Archive(ctx, ID)
+	if err != nil {
+		return fmt.Errorf("couldn't archive product: %w", err)
+	}
+	if !archived {
+		return ErrProductNotFound
+	}
+
+	return nil
+}
+
+func (s *productService) getForUpdate(ctx context.Context, ID uint) (*model.Product, error) {
+	product, err := s.repo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch product: %w", err)
+	}
+
+	return product, nil
+}
+
+func (s *productService) save(ctx context.Context, product *model.Product) (*model.Product, error) {
+	if err := validateProduct(product); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Save(ctx, product); err != nil {
+		return nil, fmt.Errorf("couldn't update product: %w", err)
+	}
+
+	return product, nil
+}
+
+func (p ProductParams) apply(product *model.Product) {
+	product.Name = strings.TrimSpace(p.Name)
+	product.Description = p.Description
+	product.Price = p.Price
+	product.Prices = productPrices(p.Prices)
+	product.TaxRate = p.TaxRate
+	product.TaxCategory = taxCategoryName(p.TaxCategory)
+	product.Duration = p.Duration / time.Second
+	product.TrialDays = p.TrialDays
+	product.Family = strings.TrimSpace(p.Family)
+}
+
+// taxCategoryName trims the category, which defaults to tax.Standard.
+func taxCategoryName(category string) string {
+	category = strings.TrimSpace(category)
+	if category == "" {
+		return string(tax.Standard)
+	}
+	return category
+}
+
+// productPrices turns a currency to amount map into prices sorted by
+// currency.
+func productPrices(prices map[string]int) []model.ProductPrice {
+	currencies := slices.Sorted(maps.Keys(prices))
+	res := make([]model.ProductPrice, len(currencies))
+	for i, currency := range currencies {
+		res[i] = model.ProductPrice{Currency: currency, Amount: prices[currency]}
+	}
+	return res
+}
+
+func validateProduct(product *model.Product) error {
+	switch {
+	case product.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidProduct)
+	case product.Price <= 0:
+		return fmt.Errorf("%w: price must be positive", ErrInvalidProduct)
+	case product.TaxRate > 100:
+		return fmt.Errorf("%w: tax rate must be between 0 and 100", ErrInvalidProduct)
+	case len(product.TaxCategory) > 50:
+		return fmt.Errorf("%w: tax category is too long", ErrInvalidProduct)
+	case product.Duration <= 0:
+		return fmt.Errorf("%w: duration must be at least one second", ErrInvalidProduct)
+	case product.TrialDays > 365:
+		return fmt.Errorf("%w: a trial can't be longer than 365 days", ErrInvalidProduct)
+	case len(product.Family) > 50:
+		return fmt.Errorf("%w: family is too long", ErrInvalidProduct)
+	}
+
+	for _, price := range product.Prices {
+		switch {
+		case !money.Currency(price.Currency).Valid():
+			return fmt.Errorf("%w: %q is not an ISO 4217 currency code", ErrInvalidProduct, price.Currency)
+		case price.Currency == model.DefaultCurrency:
+			return fmt.Errorf("%w: the %s price is set with price", ErrInvalidProduct, model.DefaultCurrency)
+		case price.Amount <= 0:
+			return fmt.Errorf("%w: %s price must be positive", ErrInvalidProduct, price.Currency)
+		}
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	mocklib "github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/thatmatin/subserv/internal/mock"
 	"github.com/thatmatin/subserv/internal/model"
@@ -137,3 +138,146 @@ func TestFetchListProduct(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateProductValidation(t *testing.T) {
+	ctx := context.Background()
+	valid := ProductParams{Name: "flowmotion", Price: 1000, TaxRate: 9, Duration: time.Hour * 24 * 30}
+
+	testCases := []struct {
+		name          string
+		modify        func(p *ProductParams)
+		errorContains string
+	}{
+		{name: "valid product", modify: func(p *ProductParams) {}},
+		{name: "free tax is allowed", modify: func(p *ProductParams) { p.TaxRate = 0 }},
+		{name: "blank name", modify: func(p *ProductParams) { p.Name = "  " }, errorContains: "name is required"},
+		{name: "zero price", modify: func(p *ProductParams) { p.Price = 0 }, errorContains: "price must be positive"},
+		{name: "negative price", modify: func(p *ProductParams) { p.Price = -1 }, errorContains: "price must be positive"},
+		{name: "tax rate over 100", modify: func(p *ProductParams) { p.TaxRate = 101 }, errorContains: "tax rate must be between 0 and 100"},
+		{name: "zero duration", modify: func(p *ProductParams) { p.Duration = 0 }, errorContains: "duration must be at least one second"},
+		{name: "sub-second duration", modify: func(p *ProductParams) { p.Duration = time.Millisecond }, errorContains: "duration must be at least one second"},
+		{name: "prices in other currencies", modify: func(p *ProductParams) { p.Prices = map[string]int{"EUR": 900, "JPY": 1500} }},
+		{name: "invalid currency", modify: func(p *ProductParams) { p.Prices = map[string]int{"eur": 900} }, errorContains: `"eur" is not an ISO 4217 currency code`},
+		{name: "default currency in prices", modify: func(p *ProductParams) { p.Prices = map[string]int{"USD": 900} }, errorContains: "the USD price is set with price"},
+		{name: "zero price in a currency", modify: func(p *ProductParams) { p.Prices = map[string]int{"EUR": 0} }, errorContains: "EUR price must be positive"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockProductRepo := new(mock.MockProductRepo)
+			if tc.errorContains == "" {
+				mockProductRepo.On("Create", ctx, mocklib.AnythingOfType("*model.Product")).Return(nil)
+			}
+			svc := NewProductService(mockProductRepo)
+
+			params := valid
+			tc.modify(&params)
+			product, err := svc.Create(ctx, params)
+			if tc.errorContains != "" {
+				require.ErrorIs(t, err, ErrInvalidProduct)
+				require.ErrorContains(t, err, tc.errorContains)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, time.Duration(30*24*60*60), product.Duration)
+				require.Equal(t, time.Hour*24*30, product.Period())
+			}
+
+			mockProductRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	ctx := context.Background()
+	stored := func() *model.Product {
+		return &model.Product{Model: gorm.Model{ID: 1}, Name: "flowmotion", Price: 1000, TaxRate: 9, Duration: 30 * 24 * 60 * 60}
+	}
+	price, zero, tax := 1500, 0, uint8(120)
+
+	testCases := []struct {
+		name          string
+		patch         ProductPatch
+		expectedPrice int
+		expectedErr   error
+		setupMock     func(productRepo *mock.MockProductRepo)
+	}{
+		{
+			name:          "price changed",
+			patch:         ProductPatch{Price: &price},
+			expectedPrice: 1500,
+			setupMock: func(productRepo *mock.MockProductRepo) {
+				productRepo.On("GetByID", ctx, uint(1)).Return(stored(), nil)
+				productRepo.On("Save", ctx, mocklib.MatchedBy(func(p *model.Product) bool {
+					return p.Price == 1500 && p.Name == "flowmotion"
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "prices replaced",
+			patch:         ProductPatch{Prices: map[string]int{"JPY": 1500, "EUR": 900}},
+			expectedPrice: 1000,
+			setupMock: func(productRepo *mock.MockProductRepo) {
+				product := stored()
+				product.Prices = []model.ProductPrice{{ID: 3, ProductID: 1, Currency: "GBP", Amount: 800}}
+				productRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
+				productRepo.On("Save", ctx, mocklib.MatchedBy(func(p *model.Product) bool {
+					return len(p.Prices) == 2 && p.Prices[0].Currency == "EUR" && p.Prices[1].Amount == 1500
+				})).Return(nil)
+			},
+		},
+		{
+			name:        "invalid price",
+			patch:       ProductPatch{Price: &zero},
+			expectedErr: ErrInvalidProduct,
+			setupMock: func(productRepo *mock.MockProductRepo) {
+				productRepo.On("GetByID", ctx, uint(1)).Return(stored(), nil)
+			},
+		},
+		{
+			name:        "invalid tax rate",
+			patch:       ProductPatch{TaxRate: &tax},
+			expectedErr: ErrInvalidProduct,
+			setupMock: func(productRepo *mock.MockProductRepo) {
+				productRepo.On("GetByID", ctx, uint(1)).Return(stored(), nil)
+			},
+		},
+		{
+			name:        "archived or missing product",
+			patch:       ProductPatch{Price: &price},
+			expectedErr: ErrProductNotFound,
+			setupMock: func(productRepo *mock.MockProductRepo) {
+				productRepo.On("GetByID", ctx, uint(1)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockProductRepo := new(mock.MockProductRepo)
+			tc.setupMock(mockProductRepo)
+			svc := NewProductService(mockProductRepo)
+
+			product, err := svc.Update(ctx, 1, tc.patch)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedPrice, product.Price)
+			}
+
+			mockProductRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestArchiveProduct(t *testing.T) {
+	ctx := context.Background()
+	mockProductRepo := new(mock.MockProductRepo)
+	mockProductRepo.On("Archive", ctx, uint(1)).Return(true, nil)
+	mockProductRepo.On("Archive", ctx, uint(2)).Return(false, nil)
+	svc := NewProductService(mockProductRepo)
+
+	require.NoError(t, svc.Archive(ctx, 1))
+	require.ErrorIs(t, svc.Archive(ctx, 2), ErrProductNotFound)
+	mockProductRepo.AssertExpectations(t)
+}
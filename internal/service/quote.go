@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/tax"
+	"gorm.io/gorm"
+)
+
+// QuotePolicy configures how long a quoted price can be locked in and how it
+// is taxed; the zero Tax is money.DefaultTaxPolicy.
+type QuotePolicy struct {
+	TTL time.Duration
+	Tax money.TaxPolicy
+}
+
+func DefaultQuotePolicy() QuotePolicy {
+	return QuotePolicy{TTL: time.Minute * 30}
+}
+
+// QuoteParams describes what to quote. An empty Currency quotes in the
+// user's billing currency. Tax is quoted at the user's billing address;
+// Country and Region are optional and must match it if given, so a client
+// can't pick a cheaper jurisdiction. A CouponCode discounts the quoted period
+// if the user can redeem it.
+type QuoteParams struct {
+	ProductID  uint
+	CouponCode string
+	Country    string
+	Region     string
+	Currency   string
+}
+
+type QuoteService interface {
+	Create(ctx context.Context, userID uint, params QuoteParams) (*model.Quote, error)
+	// Claim marks the user's quote as used so its price can be locked in.
+	Claim(ctx context.Context, ID uint, userID uint) (*model.Quote, error)
+	// Release undoes Claim when the subscription couldn't be created.
+	Release(ctx context.Context, quote *model.Quote)
+}
+
+type quoteService struct {
+	repo           repo.QuoteRepository
+	productService ProductService
+	userService    UserService
+	taxService     TaxService
+	couponService  CouponService
+	policy         QuotePolicy
+}
+
+func NewQuoteService(repo repo.QuoteRepository, prodSvc ProductService, userSvc UserService, taxSvc TaxService, couponSvc CouponService, policy QuotePolicy) QuoteService {
+	if policy.TTL <= 0 {
+		policy.TTL = DefaultQuotePolicy().TTL
+	}
+	return &quoteService{
+		repo:           repo,
+		productService: prodSvc,
+		userService:    userSvc,
+		taxService:     taxSvc,
+		couponService:  couponSvc,
+		policy:         policy,
+	}
+}
+
+func (s *quoteService) Create(ctx context.Context, userID uint, params QuoteParams) (*model.Quote, error) {
+	if params.Country != "" && !tax.ValidCountry(params.Country) {
+		return nil, fmt.Errorf("%w: country must be an ISO 3166-1 alpha-2 code", ErrInvalidQuote)
+	}
+	product, err := s.productService.Get(ctx, params.ProductID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, fmt.Errorf("couldn't fetch product: %w", err)
+	}
+
+	user, err := s.userService.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	currency := params.Currency
+	if currency == "" {
+		currency = user.Currency()
+	}
+	price, ok := product.PriceIn(currency)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPriceNotAvailable, currency)
+	}
+
+	if params.Country != "" && params.Country != user.BillingCountry {
+		return nil, fmt.Errorf("%w: country must be the billing country %q", ErrInvalidQuote, user.BillingCountry)
+	}
+	if region := strings.ToUpper(strings.TrimSpace(params.Region)); region != "" && region != user.BillingRegion {
+		return nil, fmt.Errorf("%w: region must be the billing region %q", ErrInvalidQuote, user.BillingRegion)
+	}
+	decision := s.taxService.Decide(buyerOf(user), product)
+
+	var coupon *model.Coupon
+	if params.CouponCode != "" {
+		// redeemed when the subscription is purchased, the limits are checked
+		// again then
+		coupon, err = s.couponService.Check(ctx, params.CouponCode, userID, product.ID, currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().In(UTCLocation)
+	quote := &model.Quote{
+		UserID:       userID,
+		ProductID:    product.ID,
+		ProductName:  product.Name,
+		Country:      decision.Country,
+		Region:       decision.Region,
+		Currency:     currency,
+		NetCent:      price,
+		TaxInclusive: s.policy.Tax.Inclusive,
+		TaxRate:      decision.Rate,
+		TaxCategory:  string(decision.Category),
+		TaxReason:    string(decision.Reason),
+		PeriodStart:  now,
+		PeriodEnd:    now.Add(product.Period()),
+		ExpiresAt:    now.Add(s.policy.TTL),
+	}
+	if coupon != nil {
+		quote.CouponID = &coupon.ID
+		quote.CouponCode = coupon.Code
+		quote.DiscountCent = discountOf(s.policy.Tax.Rounding, coupon.PercentOff, coupon.AmountOffCent, price)
+	}
+	taxed := taxPeriod(s.policy.Tax, quote.NetCent, quote.DiscountCent, quote.TaxRate, quote.Currency)
+	quote.TaxCent = int(taxed.Tax.Amount)
+	quote.TotalCent = int(taxed.Gross.Amount)
+
+	if err := s.repo.Create(ctx, quote); err != nil {
+		return nil, fmt.Errorf("couldn't create quote: %w", err)
+	}
+
+	return quote, nil
+}
+
+func (s *quoteService) Claim(ctx context.Context, ID uint, userID uint) (*model.Quote, error) {
+	quote, err := s.repo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrQuoteNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch quote: %w", err)
+	}
+	if quote.UserID != userID {
+		return nil, ErrQuoteNotFound
+	}
+
+	now := time.Now().In(UTCLocation)
+	switch {
+	case quote.UsedAt != nil:
+		return nil, ErrQuoteUsed
+	case quote.Expired(now):
+		return nil, ErrQuoteExpired
+	}
+
+	ok, err := s.repo.Use(ctx, quote.ID, now)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't use quote: %w", err)
+	}
+	if !ok {
+		// used by a concurrent request, or expired just now
+		return nil, ErrQuoteUsed
+	}
+	quote.UsedAt = &now
+
+	return quote, nil
+}
+
+func (s *quoteService) Release(ctx context.Context, quote *model.Quote) {
+	if err := s.repo.Release(ctx, quote.ID); err != nil {
+		log.Printf("couldn't release quote %d: %v", quote.ID, err)
+		return
+	}
+	quote.UsedAt = nil
+}
+
+// quoteTax is the tax decision the quote was made with.
+func quoteTax(quote *model.Quote) tax.Decision {
+	return tax.Decision{
+		Country:  quote.Country,
+		Region:   quote.Region,
+		Category: tax.Category(quote.TaxCategory),
+		Rate:     quote.TaxRate,
+		Reason:   tax.Reason(quote.TaxReason),
+	}
+}
@@ -0,0 +1,475 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/tax"
+	"gorm.io/gorm"
+)
+
+func TestCreateQuote(t *testing.T) {
+	ctx := context.Background()
+	product := &model.Product{Model: gorm.Model{ID: 1}, Name: "Pro Plan", Price: 2000, TaxRate: 19, Duration: 3600, Prices: []model.ProductPrice{{Currency: "EUR", Amount: 1800}}}
+	rules := &tax.Rules{
+		SellerCountry: "DE",
+		Countries: map[string]tax.Jurisdiction{
+			"DE": {Rates: map[tax.Category]money.Rate{tax.Standard: 1900}, ReverseCharge: true},
+			"FR": {Rates: map[tax.Category]money.Rate{tax.Standard: 2000}, ReverseCharge: true},
+			"US": {Regions: map[string]tax.Jurisdiction{
+				"CA": {Rates: map[tax.Category]money.Rate{tax.Standard: 725}},
+				"NY": {Rates: map[tax.Category]money.Rate{tax.Standard: 888}},
+			}},
+		},
+	}
+
+	spring := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponOnce}
+	euros := &model.Coupon{Model: gorm.Model{ID: 5}, Code: "FIVEOFF", AmountOffCent: 500, Currency: "EUR", Duration: model.CouponForever}
+
+	testCases := []struct {
+		name         string
+		params       QuoteParams
+		rules        *tax.Rules
+		tax          money.TaxPolicy
+		setupMock    func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo)
+		setupCoupons func(coupons *mock.MockCouponRepo)
+		expectedErr  error
+	}{
+		{
+			name:   "quote",
+			params: QuoteParams{ProductID: 1},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.UserID == 7 && q.ProductName == "Pro Plan" && q.NetCent == 2000 && q.Currency == "USD" &&
+						q.TaxCent == 380 && q.TotalCent == 2380 && q.TaxRate == 1900 && q.TaxReason == string(tax.Fallback) &&
+						q.PeriodEnd.Sub(q.PeriodStart) == time.Hour &&
+						q.ExpiresAt.Sub(q.PeriodStart) == DefaultQuotePolicy().TTL
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "quote in the billing currency",
+			params: QuoteParams{ProductID: 1},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}, BillingCurrency: "EUR"}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.Currency == "EUR" && q.NetCent == 1800 && q.TaxCent == 342 && q.TotalCent == 2142
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "quote in a requested currency",
+			params: QuoteParams{ProductID: 1, Currency: "EUR"},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.Currency == "EUR" && q.NetCent == 1800
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "no price in the currency",
+			params: QuoteParams{ProductID: 1, Currency: "JPY"},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+			},
+			expectedErr: ErrPriceNotAvailable,
+		},
+		{
+			name:   "taxed by the rules of the billing region",
+			params: QuoteParams{ProductID: 1, Country: "US"},
+			rules:  rules,
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}, BillingCountry: "US", BillingRegion: "CA"}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.Country == "US" && q.Region == "CA" && q.TaxRate == 725 &&
+						q.TaxCent == 145 && q.TotalCent == 2145 && q.TaxReason == string(tax.Taxed)
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "billing region",
+			params: QuoteParams{ProductID: 1, Country: "US", Region: "ca"},
+			rules:  rules,
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}, BillingCountry: "US", BillingRegion: "CA"}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.Region == "CA" && q.TaxRate == 725
+				})).Return(nil)
+			},
+		},
+		{
+			name:   "region other than the billing region",
+			params: QuoteParams{ProductID: 1, Country: "US", Region: "ny"},
+			rules:  rules,
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}, BillingCountry: "US", BillingRegion: "CA"}, nil)
+			},
+			expectedErr: ErrInvalidQuote,
+		},
+		{
+			name:   "country other than the billing country",
+			params: QuoteParams{ProductID: 1, Country: "FR"},
+			rules:  rules,
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}, BillingCountry: "US", BillingRegion: "CA"}, nil)
+			},
+			expectedErr: ErrInvalidQuote,
+		},
+		{
+			name:   "country without a billing country",
+			params: QuoteParams{ProductID: 1, Country: "FR"},
+			rules:  rules,
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+			},
+			expectedErr: ErrInvalidQuote,
+		},
+		{
+			name:   "reverse charge",
+			params: QuoteParams{ProductID: 1, Country: "FR"},
+			rules:  rules,
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}, BillingCountry: "FR", TaxID: "FR40303265045"}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.TaxRate == 0 && q.TaxCent == 0 && q.TotalCent == 2000 && q.TaxReason == string(tax.ReverseCharge)
+				})).Return(nil)
+			},
+		},
+		{
+			name:        "invalid country",
+			params:      QuoteParams{ProductID: 1, Country: "de"},
+			expectedErr: ErrInvalidQuote,
+		},
+		{
+			name:   "quote with a coupon",
+			params: QuoteParams{ProductID: 1, CouponCode: " spring25"},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.CouponID != nil && *q.CouponID == 4 && q.CouponCode == "SPRING25" &&
+						q.NetCent == 2000 && q.DiscountCent == 500 && q.TaxCent == 285 && q.TotalCent == 1785
+				})).Return(nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByCode", ctx, "SPRING25").Return(spring, nil)
+			},
+		},
+		{
+			name:   "tax-inclusive price",
+			params: QuoteParams{ProductID: 1, CouponCode: "SPRING25"},
+			tax:    money.TaxPolicy{Inclusive: true},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+				// 2000 cents hold 319.3 cents of tax at 19%, 500 off them 79.8
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.TaxInclusive && q.NetCent == 2000 && q.DiscountCent == 500 &&
+						q.TaxCent == 319-80 && q.TotalCent == 1500
+				})).Return(nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByCode", ctx, "SPRING25").Return(spring, nil)
+			},
+		},
+		{
+			name:   "amount off in the quoted currency",
+			params: QuoteParams{ProductID: 1, Currency: "EUR", CouponCode: "FIVEOFF"},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+				quotes.On("Create", ctx, mocklib.MatchedBy(func(q *model.Quote) bool {
+					return q.NetCent == 1800 && q.DiscountCent == 500 && q.TaxCent == 247 && q.TotalCent == 1547
+				})).Return(nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByCode", ctx, "FIVEOFF").Return(euros, nil)
+			},
+		},
+		{
+			name:   "amount off in another currency",
+			params: QuoteParams{ProductID: 1, CouponCode: "FIVEOFF"},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByCode", ctx, "FIVEOFF").Return(euros, nil)
+			},
+			expectedErr: ErrInvalidPromoCode,
+		},
+		{
+			name:   "unknown coupon",
+			params: QuoteParams{ProductID: 1, CouponCode: "FREE"},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(1)).Return(product, nil)
+				users.On("GetByID", ctx, uint(7)).Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByCode", ctx, "FREE").Return((*model.Coupon)(nil), gorm.ErrRecordNotFound)
+			},
+			expectedErr: ErrInvalidPromoCode,
+		},
+		{
+			name:   "archived product",
+			params: QuoteParams{ProductID: 2},
+			setupMock: func(quotes *mock.MockQuoteRepo, products *mock.MockProductRepo, users *mock.MockUserRepo) {
+				products.On("GetByID", ctx, uint(2)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+			},
+			expectedErr: ErrProductNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			quotes := new(mock.MockQuoteRepo)
+			products := new(mock.MockProductRepo)
+			users := new(mock.MockUserRepo)
+			coupons := new(mock.MockCouponRepo)
+			if tc.setupMock != nil {
+				tc.setupMock(quotes, products, users)
+			}
+			if tc.setupCoupons != nil {
+				tc.setupCoupons(coupons)
+			}
+			svc := NewQuoteService(quotes, &productService{products}, &userService{users}, &taxService{tc.rules}, &couponService{repo: coupons}, QuotePolicy{Tax: tc.tax})
+
+			_, err := svc.Create(ctx, 7, tc.params)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			quotes.AssertExpectations(t)
+			products.AssertExpectations(t)
+			users.AssertExpectations(t)
+			coupons.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateFromQuote(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+	used := now.Add(-time.Minute)
+
+	quote := func() *model.Quote {
+		return &model.Quote{
+			Model:       gorm.Model{ID: 3},
+			UserID:      1,
+			ProductID:   1,
+			NetCent:     2000,
+			Country:     "FR",
+			TaxRate:     550,
+			TaxCategory: "ebook",
+			TaxReason:   string(tax.Taxed),
+			PeriodStart: now,
+			PeriodEnd:   now.Add(time.Hour),
+			ExpiresAt:   now.Add(time.Minute),
+		}
+	}
+	couponID := uint(4)
+	discounted := func() *model.Quote {
+		q := quote()
+		q.CouponID, q.CouponCode, q.DiscountCent = &couponID, "SPRING25", 500
+		return q
+	}
+	spring := &model.Coupon{Model: gorm.Model{ID: 4}, Code: "SPRING25", PercentOff: money.Percent(25), Duration: model.CouponRepeating, DurationCycles: 3}
+	ebooks := &model.Product{Model: gorm.Model{ID: 1}, Price: 9999, TaxCategory: "ebook"}
+	rules := &tax.Rules{
+		SellerCountry: "DE",
+		Countries: map[string]tax.Jurisdiction{
+			"DE": {Rates: map[tax.Category]money.Rate{"ebook": 700}},
+			"FR": {Rates: map[tax.Category]money.Rate{"ebook": 550}},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		quote        func() *model.Quote
+		billing      string
+		productID    uint
+		promoCode    string
+		setupMock    func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo)
+		setupCoupons func(coupons *mock.MockCouponRepo)
+		expectedErr  error
+	}{
+		{
+			name:  "locks in the quoted price",
+			quote: quote,
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				products.On("GetByID", ctx, uint(1)).Return(ebooks, nil)
+				subs.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.ProductID == 1 && sub.PriceCent == 2000 && sub.TaxRate == 550 &&
+						sub.TaxCountry == "FR" && sub.TaxCategory == "ebook" && sub.TaxReason == string(tax.Taxed) &&
+						sub.State == model.Pending && sub.End.Sub(sub.Start) == time.Hour && sub.CouponID == nil
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "applies the quoted coupon",
+			quote:     discounted,
+			promoCode: "spring25",
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				products.On("GetByID", ctx, uint(1)).Return(ebooks, nil)
+				subs.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.PriceCent == 2000 && *sub.CouponID == 4 && sub.CouponCode == "SPRING25" &&
+						sub.DiscountPercent == money.Percent(25) && sub.DiscountCyclesLeft == 3 && sub.DiscountCent == 0
+				})).Return(nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByID", ctx, uint(4)).Return(spring, nil)
+			},
+		},
+		{
+			name:  "quoted coupon archived since",
+			quote: discounted,
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				products.On("GetByID", ctx, uint(1)).Return(ebooks, nil)
+				quotes.On("Release", ctx, uint(3)).Return(nil)
+			},
+			setupCoupons: func(coupons *mock.MockCouponRepo) {
+				coupons.On("GetByID", ctx, uint(4)).Return((*model.Coupon)(nil), gorm.ErrRecordNotFound)
+			},
+			expectedErr: ErrInvalidPromoCode,
+		},
+		{
+			name:      "promo code the quote wasn't made with",
+			quote:     quote,
+			promoCode: "SPRING25",
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				quotes.On("Release", ctx, uint(3)).Return(nil)
+			},
+			expectedErr: ErrInvalidPromoCode,
+		},
+		{
+			name:        "quote of another user",
+			quote:       func() *model.Quote { q := quote(); q.UserID = 2; return q },
+			expectedErr: ErrQuoteNotFound,
+		},
+		{
+			name:        "expired quote",
+			quote:       func() *model.Quote { q := quote(); q.ExpiresAt = used; return q },
+			expectedErr: ErrQuoteExpired,
+		},
+		{
+			name:        "used quote",
+			quote:       func() *model.Quote { q := quote(); q.UsedAt = &used; return q },
+			expectedErr: ErrQuoteUsed,
+		},
+		{
+			name:  "quote used concurrently",
+			quote: quote,
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(false, nil)
+			},
+			expectedErr: ErrQuoteUsed,
+		},
+		{
+			name:      "different product",
+			quote:     quote,
+			productID: 2,
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				quotes.On("Release", ctx, uint(3)).Return(nil)
+			},
+			expectedErr: ErrQuoteMismatch,
+		},
+		{
+			name:  "product archived since",
+			quote: quote,
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				products.On("GetByID", ctx, uint(1)).Return((*model.Product)(nil), gorm.ErrRecordNotFound)
+				quotes.On("Release", ctx, uint(3)).Return(nil)
+			},
+			expectedErr: ErrProductNotFound,
+		},
+		{
+			name:    "billing country changed since",
+			quote:   quote,
+			billing: "DE",
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				products.On("GetByID", ctx, uint(1)).Return(ebooks, nil)
+				quotes.On("Release", ctx, uint(3)).Return(nil)
+			},
+			expectedErr: ErrQuoteTaxChanged,
+		},
+		{
+			name: "prices included tax when quoted",
+			quote: func() *model.Quote {
+				q := quote()
+				q.TaxInclusive = true
+				return q
+			},
+			setupMock: func(quotes *mock.MockQuoteRepo, subs *mock.MockSubscriptionRepo, products *mock.MockProductRepo) {
+				quotes.On("Use", ctx, uint(3), mocklib.Anything).Return(true, nil)
+				products.On("GetByID", ctx, uint(1)).Return(ebooks, nil)
+				quotes.On("Release", ctx, uint(3)).Return(nil)
+			},
+			expectedErr: ErrQuoteTaxChanged,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			quotes := new(mock.MockQuoteRepo)
+			subs := new(mock.MockSubscriptionRepo)
+			products := new(mock.MockProductRepo)
+			users := new(mock.MockUserRepo)
+			billing := tc.billing
+			if billing == "" {
+				billing = "FR"
+			}
+			users.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, BillingCountry: billing}, nil)
+			quotes.On("GetByID", ctx, uint(3)).Return(tc.quote(), nil)
+			coupons := new(mock.MockCouponRepo)
+			if tc.setupMock != nil {
+				tc.setupMock(quotes, subs, products)
+			}
+			if tc.setupCoupons != nil {
+				tc.setupCoupons(coupons)
+			}
+			prodSvc := &productService{products}
+			couponSvc := &couponService{repo: coupons}
+			quoteSvc := NewQuoteService(quotes, prodSvc, &userService{users}, &taxService{rules}, couponSvc, DefaultQuotePolicy())
+			svc := NewSubscriptionService(subs, new(mock.MockPaymentRepo), prodSvc, &userService{users}, &dummyPaymentProcessor{}, quoteSvc, &taxService{rules}, &invoiceService{}, &stubCreditService{}, couponSvc, money.DefaultTaxPolicy)
+
+			_, err := svc.CreateFromQuote(ctx, 3, tc.productID, 1, tc.promoCode)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			quotes.AssertExpectations(t)
+			subs.AssertExpectations(t)
+			products.AssertExpectations(t)
+			coupons.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"gorm.io/gorm"
+)
+
+type RefundPolicy string
+
+const (
+	// RefundFull refunds whatever is left of the payment.
+	RefundFull RefundPolicy = "full"
+	// RefundProrated refunds the unused share of the subscription's current
+	// period.
+	RefundProrated RefundPolicy = "prorated"
+)
+
+func (p RefundPolicy) Valid() bool {
+	return p == RefundFull || p == RefundProrated
+}
+
+// RefundParams describes a refund issued by staff. A positive AmountCent
+// refunds exactly that much and can't be combined with a policy; otherwise
+// Policy decides the amount.
+type RefundParams struct {
+	AmountCent int
+	Policy     RefundPolicy
+	Reason     string
+}
+
+type RefundService interface {
+	// Refund refunds a recorded payment. A refund declined by the processor is
+	// returned with status failed and no error.
+	Refund(ctx context.Context, paymentID uint, params RefundParams) (*model.Refund, error)
+	ListRefunds(ctx context.Context, paymentID uint) ([]model.Refund, error)
+}
+
+type refundService struct {
+	subsRepo    repo.SubscriptionRepository
+	paymentRepo repo.PaymentRepository
+	ledger      *paymentLedger
+}
+
+func NewRefundService(subsRepo repo.SubscriptionRepository, paymentRepo repo.PaymentRepository, creditSvc CreditService, processor PaymentProcessor, taxPolicy money.TaxPolicy) RefundService {
+	return &refundService{
+		subsRepo:    subsRepo,
+		paymentRepo: paymentRepo,
+		ledger:      &paymentLedger{repo: paymentRepo, processor: processor, credits: creditSvc, tax: taxPolicy},
+	}
+}
+
+func (s *refundService) Refund(ctx context.Context, paymentID uint, params RefundParams) (*model.Refund, error) {
+	if params.AmountCent < 0 || (params.AmountCent > 0 && params.Policy != "") {
+		return nil, ErrInvalidRefund
+	}
+	if params.AmountCent == 0 && !params.Policy.Valid() {
+		return nil, ErrInvalidRefund
+	}
+
+	payment, err := s.getPayment(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != model.PaymentSucceeded {
+		return nil, ErrPaymentNotRefundable
+	}
+
+	amount := params.AmountCent
+	switch {
+	case amount > 0:
+	case params.Policy == RefundFull:
+		amount = payment.RefundableCent()
+		if amount <= 0 {
+			return nil, ErrRefundExceedsPayment
+		}
+	case params.Policy == RefundProrated:
+		amount, err = s.prorated(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.ledger.refundPayment(ctx, payment, amount, params.Reason)
+}
+
+// prorated is the unused share of the current period, capped at what is left
+// of the payment. Only the charge for the current period can be prorated.
+func (s *refundService) prorated(ctx context.Context, payment *model.Payment) (int, error) {
+	sub, err := s.subsRepo.GetByID(ctx, payment.SubscriptionID)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't fetch subscription: %w", err)
+	}
+
+	last, err := s.ledger.lastCharge(ctx, sub.ID)
+	if err != nil {
+		return 0, err
+	}
+	if last == nil || last.ID != payment.ID {
+		return 0, fmt.Errorf("%w: only the payment for the current period can be prorated", ErrInvalidRefund)
+	}
+
+	amount := min(proratedAmount(s.ledger.tax, sub, time.Now().In(UTCLocation)), payment.RefundableCent())
+	if amount <= 0 {
+		return 0, fmt.Errorf("%w: nothing left to prorate", ErrInvalidRefund)
+	}
+	return amount, nil
+}
+
+func (s *refundService) ListRefunds(ctx context.Context, paymentID uint) ([]model.Refund, error) {
+	if _, err := s.getPayment(ctx, paymentID); err != nil {
+		return nil, err
+	}
+
+	refunds, err := s.paymentRepo.ListRefunds(ctx, paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refunds: %w", err)
+	}
+	return refunds, nil
+}
+
+func (s *refundService) getPayment(ctx context.Context, ID uint) (*model.Payment, error) {
+	payment, err := s.paymentRepo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch payment: %w", err)
+	}
+	return payment, nil
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"gorm.io/gorm"
+)
+
+func TestRefund(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+
+	paid := func() *model.Payment {
+		return &model.Payment{
+			Model:          gorm.Model{ID: 7},
+			SubscriptionID: 1,
+			UserID:         1,
+			AmountCent:     3000,
+			TaxCent:        300,
+			Currency:       model.DefaultCurrency,
+			TxID:           "tx-1",
+			Status:         model.PaymentSucceeded,
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		params      RefundParams
+		payment     func() *model.Payment
+		declined    bool
+		setupMock   func(payments *mock.MockPaymentRepo, subs *mock.MockSubscriptionRepo)
+		expectedErr error
+		amount      int
+	}{
+		{
+			name:    "full refund",
+			params:  RefundParams{Policy: RefundFull},
+			payment: paid,
+			amount:  3000,
+		},
+		{
+			name:   "full refund of what is left",
+			params: RefundParams{Policy: RefundFull},
+			payment: func() *model.Payment {
+				p := paid()
+				p.RefundedCent = 1000
+				return p
+			},
+			amount: 2000,
+		},
+		{
+			name:   "full refund of what the processor charged",
+			params: RefundParams{Policy: RefundFull},
+			payment: func() *model.Payment {
+				p := paid()
+				p.BalanceCent = 1000
+				return p
+			},
+			amount: 2000,
+		},
+		{
+			name:   "paid from the balance only",
+			params: RefundParams{Policy: RefundFull},
+			payment: func() *model.Payment {
+				p := paid()
+				p.BalanceCent = 3000
+				return p
+			},
+			expectedErr: ErrRefundExceedsPayment,
+		},
+		{
+			name:    "partial refund",
+			params:  RefundParams{AmountCent: 500, Reason: "goodwill"},
+			payment: paid,
+			amount:  500,
+		},
+		{
+			name:    "prorated refund",
+			params:  RefundParams{Policy: RefundProrated},
+			payment: paid,
+			setupMock: func(payments *mock.MockPaymentRepo, subs *mock.MockSubscriptionRepo) {
+				subs.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+					Model:     gorm.Model{ID: 1},
+					State:     model.Active,
+					PriceCent: 3000,
+					Start:     now.Add(-time.Hour * 24 * 10),
+					End:       now.Add(time.Hour * 24 * 20),
+				}, nil)
+				payments.On("ListBySubscription", ctx, uint(1)).Return([]model.Payment{*paid()}, nil)
+			},
+			amount: 2000,
+		},
+		{
+			name:    "prorated refund of an earlier period",
+			params:  RefundParams{Policy: RefundProrated},
+			payment: paid,
+			setupMock: func(payments *mock.MockPaymentRepo, subs *mock.MockSubscriptionRepo) {
+				subs.On("GetByID", ctx, uint(1)).Return(&model.Subscription{Model: gorm.Model{ID: 1}, State: model.Active}, nil)
+				renewal := paid()
+				renewal.ID = 8
+				payments.On("ListBySubscription", ctx, uint(1)).Return([]model.Payment{*renewal, *paid()}, nil)
+			},
+			expectedErr: ErrInvalidRefund,
+		},
+		{
+			name:        "amount and policy together",
+			params:      RefundParams{AmountCent: 500, Policy: RefundFull},
+			expectedErr: ErrInvalidRefund,
+		},
+		{
+			name:   "failed payment",
+			params: RefundParams{Policy: RefundFull},
+			payment: func() *model.Payment {
+				p := paid()
+				p.Status = model.PaymentFailed
+				return p
+			},
+			expectedErr: ErrPaymentNotRefundable,
+		},
+		{
+			name:    "more than is left",
+			params:  RefundParams{AmountCent: 500},
+			payment: paid,
+			setupMock: func(payments *mock.MockPaymentRepo, subs *mock.MockSubscriptionRepo) {
+				payments.On("ReserveRefund", ctx, uint(7), 500).Return(false, nil).Once()
+			},
+			expectedErr: ErrRefundExceedsPayment,
+		},
+		{
+			name:     "declined refund",
+			params:   RefundParams{AmountCent: 500},
+			payment:  paid,
+			declined: true,
+			setupMock: func(payments *mock.MockPaymentRepo, subs *mock.MockSubscriptionRepo) {
+				payments.On("ReleaseRefund", ctx, uint(7), 500).Return(nil).Once()
+			},
+			amount: 500,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payments := new(mock.MockPaymentRepo)
+			subs := new(mock.MockSubscriptionRepo)
+			processor := &stubPaymentProcessor{success: !tc.declined}
+			if tc.payment != nil {
+				payments.On("GetByID", ctx, uint(7)).Return(tc.payment(), nil)
+			}
+			if tc.setupMock != nil {
+				tc.setupMock(payments, subs)
+			}
+			if tc.amount > 0 {
+				payments.On("ReserveRefund", ctx, uint(7), mocklib.MatchedBy(func(amount int) bool {
+					// the prorated share may lose a second to the clock
+					return amount == tc.amount || amount == tc.amount-1
+				})).Return(true, nil).Once()
+				payments.On("CreateRefund", ctx, mocklib.MatchedBy(func(r *model.Refund) bool {
+					return r.PaymentID == 7 && r.Status == model.PaymentPending && r.TaxCent == r.AmountCent/10
+				})).Return(nil).Once()
+				payments.On("SaveRefund", ctx, mocklib.Anything).Return(nil).Once()
+			}
+			credits := &stubCreditService{}
+			svc := NewRefundService(subs, payments, credits, processor, money.DefaultTaxPolicy)
+
+			refund, err := svc.Refund(ctx, 7, tc.params)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.InDelta(t, tc.amount, refund.AmountCent, 1)
+				require.Len(t, processor.refunds, 1)
+				require.Equal(t, "tx-1", processor.refunds[0].TxID)
+				require.Equal(t, refund.AmountCent, processor.refunds[0].Amount)
+				if tc.declined {
+					require.Equal(t, model.PaymentFailed, refund.Status)
+					require.Empty(t, credits.notes)
+				} else {
+					require.Equal(t, model.PaymentSucceeded, refund.Status)
+					require.Equal(t, "rf-1", refund.TxID)
+					// the refund is documented by a credit note
+					require.Len(t, credits.notes, 1)
+					require.Equal(t, refund, credits.notes[0].Refund)
+					require.Equal(t, refund.AmountCent, credits.notes[0].AmountCent)
+					require.Equal(t, refund.TaxCent, credits.notes[0].TaxCent)
+				}
+			}
+
+			payments.AssertExpectations(t)
+			subs.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCancelRefundsUnusedPeriod(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+
+	subsRepo := new(mock.MockSubscriptionRepo)
+	payments := new(mock.MockPaymentRepo)
+	processor := &stubPaymentProcessor{success: true}
+	subsRepo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+		Model:     gorm.Model{ID: 1},
+		UserID:    1,
+		State:     model.Active,
+		PriceCent: 3000,
+		Start:     now.Add(-time.Hour * 24 * 15),
+		End:       now.Add(time.Hour * 24 * 15),
+	}, nil)
+	subsRepo.On("Save", ctx, mocklib.Anything).Return(nil)
+	payments.On("ListBySubscription", ctx, uint(1)).Return([]model.Payment{
+		{Model: gorm.Model{ID: 8}, SubscriptionID: 1, UserID: 1, AmountCent: 3000, TxID: "tx-8", Status: model.PaymentFailed},
+		{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 3000, TxID: "tx-7", Status: model.PaymentSucceeded},
+	}, nil)
+	payments.On("ReserveRefund", ctx, uint(7), mocklib.Anything).Return(true, nil)
+	payments.On("CreateRefund", ctx, mocklib.Anything).Return(nil)
+	payments.On("SaveRefund", ctx, mocklib.Anything).Return(nil)
+	svc := NewSubscriptionService(subsRepo, payments, &productService{}, &userService{}, processor, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+	sub, err := svc.Cancel(ctx, 1, 1, CancelImmediately)
+	require.NoError(t, err)
+	require.Equal(t, model.Cancelled, sub.State)
+	require.Len(t, processor.refunds, 1)
+	require.Equal(t, "tx-7", processor.refunds[0].TxID)
+	require.InDelta(t, 1500, processor.refunds[0].Amount, 1)
+
+	payments.AssertExpectations(t)
+}
+
+func TestCancelCreditsWhatTheBalancePaid(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().In(UTCLocation)
+
+	// 2000 of the 3000 were paid from the balance, half the period is unused
+	subsRepo := new(mock.MockSubscriptionRepo)
+	payments := new(mock.MockPaymentRepo)
+	processor := &stubPaymentProcessor{success: true}
+	subsRepo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+		Model:     gorm.Model{ID: 1},
+		UserID:    1,
+		State:     model.Active,
+		PriceCent: 3000,
+		Start:     now.Add(-time.Hour * 24 * 15),
+		End:       now.Add(time.Hour * 24 * 15),
+	}, nil)
+	subsRepo.On("Save", ctx, mocklib.Anything).Return(nil)
+	payments.On("ListBySubscription", ctx, uint(1)).Return([]model.Payment{
+		{Model: gorm.Model{ID: 7}, SubscriptionID: 1, UserID: 1, AmountCent: 3000, BalanceCent: 2000, TxID: "tx-7", Status: model.PaymentSucceeded},
+	}, nil)
+	payments.On("ReserveRefund", ctx, uint(7), 1000).Return(true, nil)
+	payments.On("CreateRefund", ctx, mocklib.Anything).Return(nil)
+	payments.On("SaveRefund", ctx, mocklib.Anything).Return(nil)
+	payments.On("ReserveCredit", ctx, uint(7), mocklib.MatchedBy(func(amount int) bool {
+		return amount == 500 || amount == 499
+	})).Return(true, nil)
+	credits := &stubCreditService{}
+	svc := NewSubscriptionService(subsRepo, payments, &productService{}, &userService{}, processor, &quoteService{}, &taxService{}, &invoiceService{}, credits, &couponService{}, money.DefaultTaxPolicy)
+
+	_, err := svc.Cancel(ctx, 1, 1, CancelImmediately)
+	require.NoError(t, err)
+	require.Len(t, processor.refunds, 1)
+	require.Equal(t, 1000, processor.refunds[0].Amount)
+	require.Len(t, credits.notes, 2)
+	require.NotNil(t, credits.notes[0].Refund)
+	require.Nil(t, credits.notes[1].Refund)
+	require.InDelta(t, 500, credits.balance, 1)
+
+	payments.AssertExpectations(t)
+}
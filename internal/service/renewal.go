@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+)
+
+// RenewalPolicy configures the renewal job. LeadTime is how long before End
+// the next period is charged, Lease is how long a worker owns a subscription
+// while renewing it.
+type RenewalPolicy struct {
+	LeadTime  time.Duration
+	BatchSize int
+	Lease     time.Duration
+	Dunning   DunningPolicy
+	// Tax is how renewals are taxed and rounded; the zero value is
+	// money.DefaultTaxPolicy.
+	Tax money.TaxPolicy
+}
+
+// DunningPolicy decides what happens after a declined renewal. RetryAfter
+// holds the retry times as offsets from the first failure, e.g. 1, 3 and 7
+// days. The subscription keeps its entitlements for GracePeriod after the
+// first failure; once all retries are declined it moves to FinalState.
+type DunningPolicy struct {
+	RetryAfter  []time.Duration
+	GracePeriod time.Duration
+	FinalState  model.State
+}
+
+func DefaultDunningPolicy() DunningPolicy {
+	return DunningPolicy{
+		RetryAfter:  []time.Duration{time.Hour * 24, time.Hour * 24 * 3, time.Hour * 24 * 7},
+		GracePeriod: time.Hour * 24 * 7,
+		FinalState:  model.Failed,
+	}
+}
+
+type RenewalResult struct {
+	Renewed int
+	PastDue int
+	Failed  int
+	// Ended counts trials that ended because the user had no payment method.
+	Ended int
+}
+
+type RenewalService interface {
+	RenewDue(ctx context.Context, now time.Time) (RenewalResult, error)
+	RetryPastDue(ctx context.Context, now time.Time) (RenewalResult, error)
+}
+
+type renewalService struct {
+	subsRepo       repo.SubscriptionRepository
+	dunningRepo    repo.DunningRepository
+	productService ProductService
+	userService    UserService
+	taxService     TaxService
+	invoiceService InvoiceService
+	couponService  CouponService
+	ledger         *paymentLedger
+	policy         RenewalPolicy
+}
+
+func NewRenewalService(
+	subsRepo repo.SubscriptionRepository,
+	dunningRepo repo.DunningRepository,
+	paymentRepo repo.PaymentRepository,
+	prodSvc ProductService,
+	userSvc UserService,
+	taxSvc TaxService,
+	invoiceSvc InvoiceService,
+	creditSvc CreditService,
+	couponSvc CouponService,
+	paySvc PaymentProcessor,
+	policy RenewalPolicy,
+) RenewalService {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 100
+	}
+	if policy.Lease <= 0 {
+		policy.Lease = 5 * time.Minute
+	}
+	if policy.Dunning.FinalState != model.Cancelled {
+		policy.Dunning.FinalState = model.Failed
+	}
+	return &renewalService{
+		subsRepo:       subsRepo,
+		dunningRepo:    dunningRepo,
+		productService: prodSvc,
+		userService:    userSvc,
+		taxService:     taxSvc,
+		invoiceService: invoiceSvc,
+		couponService:  couponSvc,
+		ledger:         &paymentLedger{repo: paymentRepo, processor: paySvc, credits: creditSvc, tax: policy.Tax},
+		policy:         policy,
+	}
+}
+
+// RenewDue charges the next period of every auto-renewing subscription that
+// ends within the lead time, and converts trials that are over. A trial
+// ends as Expired if the user has no payment method. A declined charge
+// moves the subscription to PastDue and schedules the first retry. Errors
+// of single subscriptions don't stop the run; their lease is kept so they
+// are retried once it lapses.
+func (s *renewalService) RenewDue(ctx context.Context, now time.Time) (RenewalResult, error) {
+	return s.run(ctx, now, func() ([]model.Subscription, error) {
+		return s.subsRepo.DueForRenewal(ctx, now, now.Add(s.policy.LeadTime), s.policy.BatchSize)
+	})
+}
+
+// RetryPastDue retries the charge of PastDue subscriptions whose next retry
+// is due according to the dunning policy.
+func (s *renewalService) RetryPastDue(ctx context.Context, now time.Time) (RenewalResult, error) {
+	return s.run(ctx, now, func() ([]model.Subscription, error) {
+		return s.subsRepo.DueForRetry(ctx, now, s.policy.BatchSize)
+	})
+}
+
+func (s *renewalService) run(ctx context.Context, now time.Time, fetch func() ([]model.Subscription, error)) (RenewalResult, error) {
+	now = now.In(UTCLocation)
+
+	var result RenewalResult
+	var errs []error
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		subs, err := fetch()
+		if err != nil {
+			return result, fmt.Errorf("failed to fetch subscriptions due for renewal: %w", err)
+		}
+
+		for i := range subs {
+			if err := s.renew(ctx, &subs[i], now, &result); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		if len(subs) < s.policy.BatchSize {
+			return result, errors.Join(errs...)
+		}
+	}
+}
+
+func (s *renewalService) renew(ctx context.Context, subscription *model.Subscription, now time.Time, result *RenewalResult) error {
+	until := now.Add(s.policy.Lease)
+	claimed, err := s.subsRepo.ClaimRenewal(ctx, subscription.ID, subscription.Version, now, until)
+	if err != nil {
+		return fmt.Errorf("couldn't claim subscription %d for renewal: %w", subscription.ID, err)
+	}
+	if !claimed {
+		return nil
+	}
+	subscription.Version++
+	// keep the lease when saving so the renewed subscription is not picked
+	// up again before it lapses
+	subscription.RenewalLockedUntil = &until
+
+	// renewals are charged to the card the user saved
+	user, err := s.userService.Get(ctx, subscription.UserID)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch user of subscription %d: %w", subscription.ID, err)
+	}
+	if subscription.State == model.Trialing && user.PaymentMethod == "" {
+		subscription.State = model.Expired
+		if err := s.subsRepo.Save(ctx, subscription); err != nil {
+			return fmt.Errorf("couldn't end trial of subscription %d: %w", subscription.ID, err)
+		}
+		result.Ended++
+		return nil
+	}
+
+	// a plan change scheduled for the period end applies from this renewal on
+	productID := subscription.ProductID
+	if subscription.PendingProductID != nil {
+		productID = *subscription.PendingProductID
+	}
+
+	// the product may have been archived since, renewals keep working
+	product, err := s.productService.GetWithArchived(ctx, productID)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch product of subscription %d: %w", subscription.ID, err)
+	}
+	if subscription.PendingProductID != nil {
+		subscription.PendingProductID = nil
+		// the new product may have lost the price in the subscription's
+		// currency since the change was scheduled; renew the current plan then
+		if price, ok := product.PriceIn(subscription.Currency); ok {
+			keep, err := keepsDiscount(ctx, s.couponService, subscription, product.ID)
+			if err != nil {
+				return fmt.Errorf("couldn't check coupon of subscription %d: %w", subscription.ID, err)
+			}
+			if !keep {
+				endDiscount(subscription)
+			}
+			subscription.ProductID = product.ID
+			subscription.PriceCent = price
+			applyTax(subscription, s.taxService.ForProduct(subscription, product))
+		} else {
+			log.Printf("dropping plan change of subscription %d: product %d has no %s price", subscription.ID, product.ID, subscription.Currency)
+			product, err = s.productService.GetWithArchived(ctx, subscription.ProductID)
+			if err != nil {
+				return fmt.Errorf("couldn't fetch product of subscription %d: %w", subscription.ID, err)
+			}
+		}
+	}
+
+	kind, attemptNo := model.PaymentRenewal, uint8(0)
+	if subscription.State == model.PastDue {
+		kind, attemptNo = model.PaymentRetry, subscription.RetryCount+1
+	}
+
+	// one key per period and attempt, so a renewal retried after a lapsed
+	// lease is deduplicated by the processor
+	key := fmt.Sprintf("renewal-%d-%d-%d", subscription.ID, subscription.End.Unix(), attemptNo)
+
+	payment, err := s.ledger.chargeSubscription(ctx, subscription, kind, user.PaymentMethod, key)
+	if err != nil {
+		return fmt.Errorf("renewal payment of subscription %d failed: %w", subscription.ID, err)
+	}
+
+	attempt := &model.DunningAttempt{
+		SubscriptionID: subscription.ID,
+		PaymentID:      payment.ID,
+		AmountCent:     payment.AmountCent,
+		Success:        payment.Status == model.PaymentSucceeded,
+		TxID:           payment.TxID,
+		FailureReason:  payment.FailureReason,
+		Attempt:        attemptNo,
+		AttemptedAt:    now,
+	}
+
+	if attempt.Success {
+		subscription.State = model.Active
+		subscription.Start = nextPeriodStart(subscription.End, now, product.Period())
+		subscription.End = subscription.Start.Add(product.Period())
+		subscription.PastDueSince = nil
+		subscription.GraceUntil = nil
+		subscription.NextRetryAt = nil
+		subscription.RetryCount = 0
+		startDiscountedPeriod(s.ledger.tax.Rounding, subscription)
+		issueInvoice(ctx, s.invoiceService, payment, subscription, periodItems(s.ledger.tax, subscription)...)
+		result.Renewed++
+	} else {
+		s.markDeclined(subscription, now, result)
+	}
+
+	if err := s.dunningRepo.Create(ctx, attempt); err != nil {
+		return fmt.Errorf("couldn't record renewal attempt of subscription %d [Transaction ID %s] : %w", subscription.ID, payment.TxID, err)
+	}
+
+	if err := s.subsRepo.Save(ctx, subscription); err != nil {
+		return fmt.Errorf("couldn't save renewal of subscription %d [Transaction ID %s] : %w", subscription.ID, payment.TxID, err)
+	}
+
+	return nil
+}
+
+// markDeclined applies the dunning policy to a subscription whose charge was
+// declined.
+func (s *renewalService) markDeclined(subscription *model.Subscription, now time.Time, result *RenewalResult) {
+	policy := s.policy.Dunning
+
+	if subscription.State != model.PastDue {
+		grace := now.Add(policy.GracePeriod)
+		subscription.State = model.PastDue
+		subscription.PastDueSince = &now
+		subscription.GraceUntil = &grace
+		subscription.RetryCount = 0
+	} else {
+		subscription.RetryCount++
+	}
+
+	if int(subscription.RetryCount) >= len(policy.RetryAfter) {
+		subscription.State = policy.FinalState
+		subscription.NextRetryAt = nil
+		subscription.GraceUntil = nil
+		result.Failed++
+		return
+	}
+
+	next := subscription.PastDueSince.Add(policy.RetryAfter[subscription.RetryCount])
+	subscription.NextRetryAt = &next
+	result.PastDue++
+}
+
+// nextPeriodStart keeps periods consecutive, unless the previous period
+// ended so long ago that the new one would already be over.
+func nextPeriodStart(end time.Time, now time.Time, period time.Duration) time.Time {
+	if end.Add(period).Before(now) {
+		return now
+	}
+	return end
+}
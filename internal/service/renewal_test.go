@@ -0,0 +1,464 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+// stubPaymentProcessor answers every charge and refund with the same outcome.
+type stubPaymentProcessor struct {
+	success  bool
+	err      error
+	requests []PaymentRequest
+	refunds  []RefundRequest
+	// onCharge runs once during the next charge, to interleave a concurrent
+	// request with it.
+	onCharge func()
+}
+
+func (p *stubPaymentProcessor) Name() string {
+	return "stub"
+}
+
+func (p *stubPaymentProcessor) Charge(req PaymentRequest) (*PaymentResult, error) {
+	if onCharge := p.onCharge; onCharge != nil {
+		p.onCharge = nil
+		onCharge()
+	}
+	p.requests = append(p.requests, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if !p.success {
+		return &PaymentResult{Success: false, Error: "card declined"}, nil
+	}
+	return &PaymentResult{Success: true, TxID: "tx-1"}, nil
+}
+
+func (p *stubPaymentProcessor) Refund(req RefundRequest) (*PaymentResult, error) {
+	p.refunds = append(p.refunds, req)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if !p.success {
+		return &PaymentResult{Success: false, Error: "refund declined"}, nil
+	}
+	return &PaymentResult{Success: true, TxID: "rf-1"}, nil
+}
+
+// expectPayment lets the ledger record a payment of the given kind.
+func expectPayment(ctx context.Context, repo *mock.MockPaymentRepo, kind model.PaymentKind) {
+	repo.On("Create", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+		return p.Kind == kind && p.Status == model.PaymentPending && p.SubscriptionID == 1
+	})).Return(nil).Maybe()
+	repo.On("Save", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+		return p.Kind == kind && p.Status != model.PaymentPending
+	})).Return(nil).Maybe()
+}
+
+func TestRenewDue(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+	period := time.Hour * 24 * 30
+	policy := RenewalPolicy{
+		LeadTime:  time.Hour * 24,
+		BatchSize: 10,
+		Lease:     time.Minute,
+		Dunning: DunningPolicy{
+			RetryAfter:  []time.Duration{time.Hour * 24, time.Hour * 72},
+			GracePeriod: time.Hour * 24 * 7,
+			FinalState:  model.Failed,
+		},
+	}
+	lease := now.Add(policy.Lease)
+
+	due := func() model.Subscription {
+		return model.Subscription{
+			Model:     gorm.Model{ID: 1},
+			UserID:    1,
+			ProductID: 2,
+			State:     model.Active,
+			AutoRenew: true,
+			PriceCent: 1000,
+			Currency:  model.DefaultCurrency,
+			Start:     now.Add(-period + time.Hour),
+			End:       now.Add(time.Hour),
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		processor     *stubPaymentProcessor
+		expected      RenewalResult
+		errorContains string
+		setupMock     func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo)
+	}{
+		{
+			name:      "successful renewal extends the period",
+			processor: &stubPaymentProcessor{success: true},
+			expected:  RenewalResult{Renewed: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{due()}, nil)
+				subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(true, nil)
+				prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Duration: period / time.Second}, nil)
+				dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+					return a.Success && a.Attempt == 0 && a.TxID == "tx-1"
+				})).Return(nil)
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Active &&
+						sub.Start.Equal(now.Add(time.Hour)) &&
+						sub.End.Equal(now.Add(time.Hour+period))
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "declined renewal moves to past due",
+			processor: &stubPaymentProcessor{success: false},
+			expected:  RenewalResult{PastDue: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{due()}, nil)
+				subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(true, nil)
+				prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Duration: period / time.Second}, nil)
+				dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+					return !a.Success && a.Attempt == 0 && a.FailureReason == "card declined"
+				})).Return(nil)
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.PastDue &&
+						sub.End.Equal(now.Add(time.Hour)) &&
+						sub.PastDueSince.Equal(now) &&
+						sub.GraceUntil.Equal(now.Add(time.Hour*24*7)) &&
+						sub.NextRetryAt.Equal(now.Add(time.Hour*24)) &&
+						sub.Entitled(now)
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "scheduled plan change applies at renewal",
+			processor: &stubPaymentProcessor{success: true},
+			expected:  RenewalResult{Renewed: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				sub := due()
+				pending := uint(3)
+				sub.PendingProductID = &pending
+				subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{sub}, nil)
+				subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(true, nil)
+				prodRepo.On("GetByIDWithArchived", ctx, uint(3)).Return(&model.Product{Model: gorm.Model{ID: 3}, Price: 500, Duration: 2 * period / time.Second}, nil)
+				dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+					return a.Success && a.AmountCent == 500
+				})).Return(nil)
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.ProductID == 3 && sub.PriceCent == 500 && sub.PendingProductID == nil &&
+						sub.End.Equal(now.Add(time.Hour+2*period))
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "scheduled plan change without a price in the currency is dropped",
+			processor: &stubPaymentProcessor{success: true},
+			expected:  RenewalResult{Renewed: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				sub := due()
+				sub.Currency = "JPY"
+				pending := uint(3)
+				sub.PendingProductID = &pending
+				subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{sub}, nil)
+				subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(true, nil)
+				prodRepo.On("GetByIDWithArchived", ctx, uint(3)).Return(&model.Product{Model: gorm.Model{ID: 3}, Price: 500, Duration: 2 * period / time.Second}, nil)
+				prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Price: 1000, Duration: period / time.Second}, nil)
+				dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+					return a.Success && a.AmountCent == 1000
+				})).Return(nil)
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.ProductID == 2 && sub.PriceCent == 1000 && sub.PendingProductID == nil &&
+						sub.End.Equal(now.Add(time.Hour+period))
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "subscription claimed by another worker",
+			processor: &stubPaymentProcessor{success: true},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{due()}, nil)
+				subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(false, nil)
+			},
+		},
+		{
+			name:          "payment processor error keeps the subscription active",
+			processor:     &stubPaymentProcessor{err: errors.New("gateway timeout")},
+			errorContains: "gateway timeout",
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				subsRepo.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{due()}, nil)
+				subsRepo.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(true, nil)
+				prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Duration: period / time.Second}, nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(mock.MockSubscriptionRepo)
+			d := new(mock.MockDunningRepo)
+			p := new(mock.MockProductRepo)
+			u := new(mock.MockUserRepo)
+			pay := new(mock.MockPaymentRepo)
+			tc.setupMock(s, d, p)
+			// not fetched when the claim is lost
+			u.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, PaymentMethod: "pm_card_visa"}, nil).Maybe()
+			expectPayment(ctx, pay, model.PaymentRenewal)
+			invoices := &stubInvoiceService{}
+			svc := NewRenewalService(s, d, pay, &productService{p}, &userService{u}, &taxService{}, invoices, &stubCreditService{}, &couponService{}, tc.processor, policy)
+
+			res, err := svc.RenewDue(ctx, now)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expected, res)
+			require.Len(t, invoices.issued, res.Renewed)
+			// renewals are charged to the saved card
+			for _, req := range tc.processor.requests {
+				require.Equal(t, "pm_card_visa", req.PaymentMethod)
+			}
+
+			s.AssertExpectations(t)
+			d.AssertExpectations(t)
+			p.AssertExpectations(t)
+			pay.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConvertTrial(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+	period := time.Hour * 24 * 30
+	policy := RenewalPolicy{LeadTime: time.Hour * 24, BatchSize: 10, Lease: time.Minute, Dunning: DefaultDunningPolicy()}
+	lease := now.Add(policy.Lease)
+
+	trial := model.Subscription{
+		Model:     gorm.Model{ID: 1},
+		UserID:    1,
+		ProductID: 2,
+		State:     model.Trialing,
+		AutoRenew: true,
+		PriceCent: 1000,
+		Currency:  model.DefaultCurrency,
+		Start:     now.Add(-time.Hour * 24 * 14),
+		End:       now.Add(-time.Minute),
+	}
+
+	testCases := []struct {
+		name      string
+		user      *model.User
+		processor *stubPaymentProcessor
+		expected  RenewalResult
+		setupMock func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo)
+	}{
+		{
+			name:      "trial is converted with a charge",
+			user:      &model.User{Model: gorm.Model{ID: 1}, PaymentMethod: "pm_card_visa"},
+			processor: &stubPaymentProcessor{success: true},
+			expected:  RenewalResult{Renewed: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Duration: period / time.Second}, nil)
+				dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+					return a.Success && a.AmountCent == 1000
+				})).Return(nil)
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Active &&
+						sub.Start.Equal(trial.End) &&
+						sub.End.Equal(trial.End.Add(period))
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "trial without a payment method ends",
+			user:      &model.User{Model: gorm.Model{ID: 1}},
+			processor: &stubPaymentProcessor{success: true},
+			expected:  RenewalResult{Ended: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Expired && sub.End.Equal(trial.End) && !sub.Entitled(now)
+				})).Return(nil)
+			},
+		},
+		{
+			name:      "declined conversion moves to past due",
+			user:      &model.User{Model: gorm.Model{ID: 1}, PaymentMethod: "pm_card_visa"},
+			processor: &stubPaymentProcessor{success: false},
+			expected:  RenewalResult{PastDue: 1},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, dunningRepo *mock.MockDunningRepo, prodRepo *mock.MockProductRepo) {
+				prodRepo.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Duration: period / time.Second}, nil)
+				dunningRepo.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+					return !a.Success
+				})).Return(nil)
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.PastDue && sub.End.Equal(trial.End)
+				})).Return(nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(mock.MockSubscriptionRepo)
+			d := new(mock.MockDunningRepo)
+			p := new(mock.MockProductRepo)
+			u := new(mock.MockUserRepo)
+			pay := new(mock.MockPaymentRepo)
+			s.On("DueForRenewal", ctx, now, now.Add(policy.LeadTime), 10).Return([]model.Subscription{trial}, nil)
+			s.On("ClaimRenewal", ctx, uint(1), uint(0), now, lease).Return(true, nil)
+			u.On("GetByID", ctx, uint(1)).Return(tc.user, nil)
+			tc.setupMock(s, d, p)
+			expectPayment(ctx, pay, model.PaymentRenewal)
+			invoices := &stubInvoiceService{}
+			svc := NewRenewalService(s, d, pay, &productService{p}, &userService{u}, &taxService{}, invoices, &stubCreditService{}, &couponService{}, tc.processor, policy)
+
+			res, err := svc.RenewDue(ctx, now)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res)
+			require.Len(t, invoices.issued, res.Renewed)
+			if tc.user.PaymentMethod == "" {
+				require.Empty(t, tc.processor.requests)
+			} else {
+				require.Len(t, tc.processor.requests, 1)
+				require.Equal(t, tc.user.PaymentMethod, tc.processor.requests[0].PaymentMethod)
+			}
+
+			s.AssertExpectations(t)
+			d.AssertExpectations(t)
+			p.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRetryPastDue(t *testing.T) {
+	ctx := context.Background()
+	period := time.Hour * 24 * 30
+	pastDueSince := fixedTime
+	graceUntil := pastDueSince.Add(time.Hour * 24 * 7)
+	policy := RenewalPolicy{
+		BatchSize: 10,
+		Lease:     time.Minute,
+		Dunning: DunningPolicy{
+			RetryAfter:  []time.Duration{time.Hour * 24, time.Hour * 72},
+			GracePeriod: time.Hour * 24 * 7,
+			FinalState:  model.Cancelled,
+		},
+	}
+
+	pastDue := func(retries uint8) model.Subscription {
+		next := pastDueSince.Add(policy.Dunning.RetryAfter[retries])
+		return model.Subscription{
+			Model:        gorm.Model{ID: 1},
+			UserID:       1,
+			ProductID:    2,
+			State:        model.PastDue,
+			AutoRenew:    true,
+			PriceCent:    1000,
+			Currency:     model.DefaultCurrency,
+			Start:        pastDueSince.Add(-period),
+			End:          pastDueSince,
+			PastDueSince: &pastDueSince,
+			GraceUntil:   &graceUntil,
+			NextRetryAt:  &next,
+			RetryCount:   retries,
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		now       time.Time
+		retries   uint8
+		processor *stubPaymentProcessor
+		expected  RenewalResult
+		checkSave func(sub *model.Subscription) bool
+		attempt   uint8
+	}{
+		{
+			name:      "first retry succeeds",
+			now:       pastDueSince.Add(time.Hour * 24),
+			processor: &stubPaymentProcessor{success: true},
+			expected:  RenewalResult{Renewed: 1},
+			attempt:   1,
+			checkSave: func(sub *model.Subscription) bool {
+				return sub.State == model.Active &&
+					sub.Start.Equal(pastDueSince) &&
+					sub.End.Equal(pastDueSince.Add(period)) &&
+					sub.NextRetryAt == nil && sub.GraceUntil == nil && sub.RetryCount == 0
+			},
+		},
+		{
+			name:      "first retry declined schedules the second",
+			now:       pastDueSince.Add(time.Hour * 24),
+			processor: &stubPaymentProcessor{success: false},
+			expected:  RenewalResult{PastDue: 1},
+			attempt:   1,
+			checkSave: func(sub *model.Subscription) bool {
+				return sub.State == model.PastDue &&
+					sub.RetryCount == 1 &&
+					sub.NextRetryAt.Equal(pastDueSince.Add(time.Hour*72)) &&
+					sub.GraceUntil.Equal(graceUntil)
+			},
+		},
+		{
+			name:      "last retry declined",
+			now:       pastDueSince.Add(time.Hour * 72),
+			retries:   1,
+			processor: &stubPaymentProcessor{success: false},
+			expected:  RenewalResult{Failed: 1},
+			attempt:   2,
+			checkSave: func(sub *model.Subscription) bool {
+				return sub.State == model.Cancelled && sub.NextRetryAt == nil && !sub.Entitled(pastDueSince)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(mock.MockSubscriptionRepo)
+			d := new(mock.MockDunningRepo)
+			p := new(mock.MockProductRepo)
+			pay := new(mock.MockPaymentRepo)
+
+			s.On("DueForRetry", ctx, tc.now, 10).Return([]model.Subscription{pastDue(tc.retries)}, nil)
+			s.On("ClaimRenewal", ctx, uint(1), uint(0), tc.now, tc.now.Add(policy.Lease)).Return(true, nil)
+			p.On("GetByIDWithArchived", ctx, uint(2)).Return(&model.Product{Model: gorm.Model{ID: 2}, Duration: period / time.Second}, nil)
+			d.On("Create", ctx, mocklib.MatchedBy(func(a *model.DunningAttempt) bool {
+				return a.Attempt == tc.attempt && a.Success == tc.processor.success && a.AttemptedAt.Equal(tc.now)
+			})).Return(nil)
+			s.On("Save", ctx, mocklib.MatchedBy(tc.checkSave)).Return(nil)
+			pay.On("Create", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+				return p.Kind == model.PaymentRetry && p.AmountCent == 1000
+			})).Return(nil).Once()
+			pay.On("Save", ctx, mocklib.AnythingOfType("*model.Payment")).Return(nil).Once()
+			u := new(mock.MockUserRepo)
+			u.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}, PaymentMethod: "pm_card_visa"}, nil)
+
+			invoices := &stubInvoiceService{}
+			svc := NewRenewalService(s, d, pay, &productService{p}, &userService{u}, &taxService{}, invoices, &stubCreditService{}, &couponService{}, tc.processor, policy)
+			res, err := svc.RetryPastDue(ctx, tc.now)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, res)
+			require.Len(t, invoices.issued, res.Renewed)
+			require.Len(t, tc.processor.requests, 1)
+			require.Equal(t, fmt.Sprintf("renewal-1-%d-%d", pastDueSince.Unix(), tc.attempt), tc.processor.requests[0].IdempotencyKey)
+			require.Equal(t, "pm_card_visa", tc.processor.requests[0].PaymentMethod)
+
+			s.AssertExpectations(t)
+			d.AssertExpectations(t)
+			p.AssertExpectations(t)
+			pay.AssertExpectations(t)
+		})
+	}
+}
@@ -4,47 +4,130 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
 	"github.com/thatmatin/subserv/internal/repo"
-	"github.com/thatmatin/subserv/internal/utils"
 	"gorm.io/gorm"
 )
 
 var UTCLocation *time.Location
 
 type SubscriptionService interface {
-	Get(ctx context.Context, ID uint) (*model.Subscription, error)
-	Create(ctx context.Context, productID uint, userID uint) (*model.Subscription, error)
-	Purchase(ctx context.Context, ID uint) error
-	Pause(ctx context.Context, ID uint) error
-	Unpause(ctx context.Context, ID uint) error
-	Cancel(ctx context.Context, ID uint) error
+	Get(ctx context.Context, ID uint, userID uint) (*model.Subscription, error)
+	// Lookup fetches any user's subscription, for support staff.
+	Lookup(ctx context.Context, ID uint) (*model.Subscription, error)
+	List(ctx context.Context, userID uint, opts SubscriptionListOptions) (*SubscriptionPage, error)
+	// Create creates a pending subscription priced in the currency, or in the
+	// user's billing currency if currency is empty. A non-empty promoCode
+	// redeems that coupon for the subscription. If the product has a trial the
+	// user hasn't used yet, the subscription is trialing instead.
+	Create(ctx context.Context, productID uint, userID uint, currency string, promoCode string) (*model.Subscription, error)
+	// CreateFromQuote creates a subscription at the price of the user's quote.
+	// A non-zero productID must match the quoted product, and a non-empty
+	// promoCode the quoted coupon.
+	CreateFromQuote(ctx context.Context, quoteID uint, productID uint, userID uint, promoCode string) (*model.Subscription, error)
+	Purchase(ctx context.Context, ID uint, userID uint, idempotencyKey string) error
+	Pause(ctx context.Context, ID uint, userID uint) error
+	Unpause(ctx context.Context, ID uint, userID uint) error
+	// Cancel ends the subscription now or schedules it to end with the paid
+	// period, depending on mode. It returns the updated subscription.
+	Cancel(ctx context.Context, ID uint, userID uint, mode CancelMode) (*model.Subscription, error)
+	// Uncancel withdraws a cancellation scheduled for the period end.
+	Uncancel(ctx context.Context, ID uint, userID uint) (*model.Subscription, error)
+	SetAutoRenew(ctx context.Context, ID uint, userID uint, enabled bool) error
+	ChangePlan(ctx context.Context, ID uint, userID uint, productID uint, mode ChangeMode, idempotencyKey string) (*PlanChange, error)
+	ListPayments(ctx context.Context, ID uint, userID uint) ([]model.Payment, error)
+}
+
+// SubscriptionListOptions filters a user's subscriptions. Zero values are
+// ignored; date ranges are inclusive at From and exclusive at To.
+type SubscriptionListOptions struct {
+	State     *model.State
+	ProductID uint
+	StartFrom time.Time
+	StartTo   time.Time
+	EndFrom   time.Time
+	EndTo     time.Time
+	Cursor    string
+	Limit     int
+}
+
+type CancelMode string
+
+const (
+	// CancelImmediately ends the subscription now; the rest of the period is
+	// forfeited.
+	CancelImmediately CancelMode = "immediate"
+	// CancelAtPeriodEnd keeps the subscription Active until End and stops it
+	// from renewing; the expiry job cancels it then.
+	CancelAtPeriodEnd CancelMode = "period_end"
+)
+
+func (m CancelMode) Valid() bool {
+	return m == CancelImmediately || m == CancelAtPeriodEnd
+}
+
+type SubscriptionPage struct {
+	Subscriptions []model.Subscription
+	NextCursor    string
 }
 
 type subscriptionService struct {
-	subsRepo         repo.SubscriptionRepository
-	productService   ProductService
-	userService      UserService
-	paymentProcessor PaymentProcessor
+	subsRepo       repo.SubscriptionRepository
+	paymentRepo    repo.PaymentRepository
+	productService ProductService
+	userService    UserService
+	quoteService   QuoteService
+	taxService     TaxService
+	invoiceService InvoiceService
+	couponService  CouponService
+	ledger         *paymentLedger
 }
 
 func NewSubscriptionService(
 	subsRepo repo.SubscriptionRepository,
+	paymentRepo repo.PaymentRepository,
 	prodSvc ProductService,
 	userSvc UserService,
 	paySvc PaymentProcessor,
+	quoteSvc QuoteService,
+	taxSvc TaxService,
+	invoiceSvc InvoiceService,
+	creditSvc CreditService,
+	couponSvc CouponService,
+	taxPolicy money.TaxPolicy,
 ) SubscriptionService {
 	return &subscriptionService{
-		subsRepo:         subsRepo,
-		productService:   prodSvc,
-		userService:      userSvc,
-		paymentProcessor: paySvc,
+		subsRepo:       subsRepo,
+		paymentRepo:    paymentRepo,
+		productService: prodSvc,
+		userService:    userSvc,
+		quoteService:   quoteSvc,
+		taxService:     taxSvc,
+		invoiceService: invoiceSvc,
+		couponService:  couponSvc,
+		ledger:         &paymentLedger{repo: paymentRepo, processor: paySvc, credits: creditSvc, tax: taxPolicy},
+	}
+}
+
+// Get fetches the subscription and makes sure it belongs to userID.
+func (s *subscriptionService) Get(ctx context.Context, ID uint, userID uint) (*model.Subscription, error) {
+	subscription, err := s.Lookup(ctx, ID)
+	if err != nil {
+		return nil, err
 	}
+
+	if subscription.UserID != userID {
+		return nil, ErrUnauthorizedAccess
+	}
+
+	return subscription, nil
 }
 
-func (s *subscriptionService) Get(ctx context.Context, ID uint) (*model.Subscription, error) {
+func (s *subscriptionService) Lookup(ctx context.Context, ID uint) (*model.Subscription, error) {
 	subscription, err := s.subsRepo.GetByID(ctx, ID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -56,13 +139,45 @@ func (s *subscriptionService) Get(ctx context.Context, ID uint) (*model.Subscrip
 	return subscription, nil
 }
 
-func (s *subscriptionService) Create(ctx context.Context, productID uint, userID uint) (*model.Subscription, error) {
-	exists, err := s.userService.Exists(ctx, userID)
+// List returns one page of the user's subscriptions, newest first.
+func (s *subscriptionService) List(ctx context.Context, userID uint, opts SubscriptionListOptions) (*SubscriptionPage, error) {
+	beforeID, err := decodeCursor(opts.Cursor)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check user existence: %w", err)
+		return nil, err
 	}
-	if !exists {
-		return nil, ErrUserNotFound
+
+	limit := pageSize(opts.Limit)
+	subscriptions, err := s.subsRepo.List(ctx, repo.SubscriptionFilter{
+		UserID:    userID,
+		State:     opts.State,
+		ProductID: opts.ProductID,
+		StartFrom: opts.StartFrom,
+		StartTo:   opts.StartTo,
+		EndFrom:   opts.EndFrom,
+		EndTo:     opts.EndTo,
+		BeforeID:  beforeID,
+		Limit:     limit + 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+
+	page := &SubscriptionPage{Subscriptions: subscriptions}
+	if len(subscriptions) > limit {
+		page.Subscriptions = subscriptions[:limit]
+		page.NextCursor = encodeCursor(page.Subscriptions[limit-1].ID)
+	}
+
+	return page, nil
+}
+
+func (s *subscriptionService) Create(ctx context.Context, productID uint, userID uint, currency string, promoCode string) (*model.Subscription, error) {
+	user, err := s.userService.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if currency == "" {
+		currency = user.Currency()
 	}
 
 	product, err := s.productService.Get(ctx, productID)
@@ -72,27 +187,176 @@ func (s *subscriptionService) Create(ctx context.Context, productID uint, userID
 		}
 		return nil, fmt.Errorf("couldn't fetch product: %w", err)
 	}
+	price, ok := product.PriceIn(currency)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPriceNotAvailable, currency)
+	}
 
 	now := time.Now().In(UTCLocation)
 	subscription := &model.Subscription{
 		UserID:    userID,
 		ProductID: productID,
 		Start:     now,
-		End:       now.Add(product.Duration * 1e9), // Duration is in seconds, convert to nanoseconds
+		End:       now.Add(product.Period()),
+		State:     model.Pending,
+		PriceCent: price,
+		Currency:  currency,
+	}
+	applyTax(subscription, s.taxService.Decide(buyerOf(user), product))
+	family, err := s.startTrial(ctx, subscription, product)
+	if err != nil {
+		return nil, err
+	}
+
+	if promoCode != "" {
+		coupon, err := s.couponService.Check(ctx, promoCode, userID, productID, currency)
+		if err != nil {
+			return nil, err
+		}
+		// a trial is never purchased, so it redeems its coupon right away
+		if family != "" {
+			if err := s.couponService.Redeem(ctx, coupon, userID); err != nil {
+				return nil, err
+			}
+		}
+		applyCoupon(subscription, coupon)
+	}
+
+	if err := s.insert(ctx, subscription, family); err != nil {
+		if family != "" {
+			s.releaseCoupon(ctx, subscription)
+		}
+		return nil, fmt.Errorf("couldn't create subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+// CreateFromQuote claims the quote and creates a pending subscription at the
+// quoted price. Purchase then charges the quoted total.
+func (s *subscriptionService) CreateFromQuote(ctx context.Context, quoteID uint, productID uint, userID uint, promoCode string) (*model.Subscription, error) {
+	user, err := s.userService.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := s.quoteService.Claim(ctx, quoteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if productID != 0 && productID != quote.ProductID {
+		s.quoteService.Release(ctx, quote)
+		return nil, ErrQuoteMismatch
+	}
+	if promoCode != "" && normalizeCode(promoCode) != quote.CouponCode {
+		s.quoteService.Release(ctx, quote)
+		return nil, fmt.Errorf("%w: the quote was made without %s", ErrInvalidPromoCode, normalizeCode(promoCode))
+	}
+
+	// the product may have been archived since the quote was made
+	product, err := s.productService.Get(ctx, quote.ProductID)
+	if err != nil {
+		s.quoteService.Release(ctx, quote)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrProductNotFound
+		}
+		return nil, fmt.Errorf("couldn't fetch product: %w", err)
+	}
+	// the quoted tax only stands while the billing address and rules that
+	// decided it are unchanged
+	if s.taxService.Decide(buyerOf(user), product) != quoteTax(quote) || quote.TaxInclusive != s.ledger.tax.Inclusive {
+		s.quoteService.Release(ctx, quote)
+		return nil, ErrQuoteTaxChanged
+	}
+
+	now := time.Now().In(UTCLocation)
+	subscription := &model.Subscription{
+		UserID:    userID,
+		ProductID: quote.ProductID,
+		Start:     now,
+		End:       now.Add(quote.PeriodEnd.Sub(quote.PeriodStart)),
 		State:     model.Pending,
-		PriceCent: product.Price,
-		TaxRate:   product.TaxRate,
+		PriceCent: quote.NetCent,
+		Currency:  quote.Currency,
+	}
+	applyTax(subscription, quoteTax(quote))
+	family, err := s.startTrial(ctx, subscription, product)
+	if err != nil {
+		s.quoteService.Release(ctx, quote)
+		return nil, err
 	}
 
-	if err := s.subsRepo.Create(ctx, subscription); err != nil {
+	// the quote locked in its coupon, only the redemption limits still apply
+	if quote.CouponID != nil {
+		coupon, err := s.couponService.Get(ctx, *quote.CouponID)
+		if err == nil && family != "" {
+			err = s.couponService.Redeem(ctx, coupon, userID)
+		}
+		if err != nil {
+			s.quoteService.Release(ctx, quote)
+			if errors.Is(err, ErrCouponNotFound) {
+				return nil, fmt.Errorf("%w: %s can't be redeemed anymore", ErrInvalidPromoCode, quote.CouponCode)
+			}
+			return nil, err
+		}
+		applyCoupon(subscription, coupon)
+	}
+
+	if err := s.insert(ctx, subscription, family); err != nil {
+		if family != "" {
+			s.releaseCoupon(ctx, subscription)
+		}
+		s.quoteService.Release(ctx, quote)
 		return nil, fmt.Errorf("couldn't create subscription: %w", err)
 	}
 
 	return subscription, nil
 }
 
-func (s *subscriptionService) Purchase(ctx context.Context, ID uint) error {
-	subscription, err := s.Get(ctx, ID)
+// startTrial makes the new subscription a free trial if the product has one
+// and the user hasn't had a trial of its family yet. It returns the family,
+// or an empty string if there's no trial.
+func (s *subscriptionService) startTrial(ctx context.Context, subscription *model.Subscription, product *model.Product) (string, error) {
+	if product.TrialDays == 0 {
+		return "", nil
+	}
+
+	family := product.TrialFamily()
+	used, err := s.subsRepo.TrialUsed(ctx, subscription.UserID, family)
+	if err != nil {
+		return "", fmt.Errorf("couldn't check trials of user %d: %w", subscription.UserID, err)
+	}
+	if used {
+		return "", nil
+	}
+
+	// the renewal job converts the trial when it ends
+	subscription.State = model.Trialing
+	subscription.End = subscription.Start.Add(product.TrialPeriod())
+	subscription.AutoRenew = true
+	return family, nil
+}
+
+// insert creates the subscription, recording its trial if family is set.
+func (s *subscriptionService) insert(ctx context.Context, subscription *model.Subscription, family string) error {
+	if family == "" {
+		return s.subsRepo.Create(ctx, subscription)
+	}
+
+	err := s.subsRepo.CreateTrial(ctx, subscription, family)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// a concurrent request started the trial first
+		return ErrTrialUsed
+	}
+	return err
+}
+
+// Purchase charges the first period and activates the subscription. A
+// non-empty idempotencyKey is forwarded to the payment processor so a
+// retried purchase can't be charged twice. The subscription's coupon is
+// redeemed for the charge and given back if the charge doesn't succeed.
+func (s *subscriptionService) Purchase(ctx context.Context, ID uint, userID uint, idempotencyKey string) error {
+	subscription, err := s.Get(ctx, ID, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return ErrSubscriptionNotFound
@@ -105,32 +369,56 @@ func (s *subscriptionService) Purchase(ctx context.Context, ID uint) error {
 		return ErrNoPendingPayment
 	}
 
-	payResult, err := s.paymentProcessor.Charge(PaymentRequest{
-		UserID:    subscription.UserID,
-		ProductID: subscription.ID,
-		Amount:    utils.CalculateFinalAmount(subscription.PriceCent, subscription.TaxRate),
-	})
-	if err != nil {
-		return fmt.Errorf("an error occured in payment: %w", err)
+	if err := s.claim(ctx, subscription, time.Now().In(UTCLocation)); err != nil {
+		return fmt.Errorf("couldn't purchase subscription: %w", err)
 	}
-	if !payResult.Success {
-		return ErrFailedPayment
+
+	var paymentKey string
+	if idempotencyKey != "" {
+		paymentKey = fmt.Sprintf("purchase-%d-%s", subscription.ID, idempotencyKey)
+	}
+
+	if err := s.redeemCoupon(ctx, subscription); err != nil {
+		s.unclaim(ctx, subscription)
+		return err
+	}
+
+	payment, err := s.ledger.chargeSubscription(ctx, subscription, model.PaymentPurchase, "", paymentKey)
+	if err == nil && payment.Status != model.PaymentSucceeded {
+		err = ErrFailedPayment
+	}
+	if err != nil {
+		s.releaseCoupon(ctx, subscription)
+		s.unclaim(ctx, subscription)
+		return err
 	}
 
-	// idempotency and log of successful payment must be implemented in real payment implementation
-	subscription.State = model.Active
 	duration := subscription.End.Sub(subscription.Start)
-	subscription.Start = time.Now().In(UTCLocation)
-	subscription.End = subscription.Start.Add(duration)
-	if err := s.subsRepo.Save(ctx, subscription); err != nil {
-		return fmt.Errorf("couldn't save successful payment [Transaction ID %s] : %w", payResult.TxID, err)
+	start := time.Now().In(UTCLocation)
+	activate := func(sub *model.Subscription) error {
+		if sub.State != model.Pending {
+			return fmt.Errorf("subscription %d became %s while it was purchased", sub.ID, sub.State)
+		}
+		sub.State = model.Active
+		sub.Start = start
+		sub.End = start.Add(duration)
+		sub.ClaimedUntil = nil
+		startDiscountedPeriod(s.ledger.tax.Rounding, sub)
+		return nil
+	}
+	if err := activate(subscription); err != nil {
+		return err
+	}
+	issueInvoice(ctx, s.invoiceService, payment, subscription, periodItems(s.ledger.tax, subscription)...)
+	if err := s.saveCharged(ctx, subscription, activate); err != nil {
+		return fmt.Errorf("couldn't save successful payment [Transaction ID %s] : %w", payment.TxID, err)
 	}
 
 	return nil
 }
 
-func (s *subscriptionService) Pause(ctx context.Context, ID uint) error {
-	subscription, err := s.Get(ctx, ID)
+func (s *subscriptionService) Pause(ctx context.Context, ID uint, userID uint) error {
+	subscription, err := s.Get(ctx, ID, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return ErrSubscriptionNotFound
@@ -138,6 +426,9 @@ func (s *subscriptionService) Pause(ctx context.Context, ID uint) error {
 
 		return fmt.Errorf("couldn't pause subscription: %w", err)
 	}
+	if subscription.Claimed(time.Now().In(UTCLocation)) {
+		return ErrConcurrentUpdate
+	}
 
 	switch subscription.State {
 	case model.Active:
@@ -146,7 +437,7 @@ func (s *subscriptionService) Pause(ctx context.Context, ID uint) error {
 			now := time.Now().In(UTCLocation)
 			subscription.PausedAt = &now
 
-			if err := s.subsRepo.Save(ctx, subscription); err != nil {
+			if err := s.save(ctx, subscription); err != nil {
 				return fmt.Errorf("couldn't pause subscription: %w", err)
 			}
 
@@ -160,38 +451,113 @@ func (s *subscriptionService) Pause(ctx context.Context, ID uint) error {
 	}
 }
 
-func (s *subscriptionService) Cancel(ctx context.Context, ID uint) error {
-	subscription, err := s.Get(ctx, ID)
+func (s *subscriptionService) Cancel(ctx context.Context, ID uint, userID uint, mode CancelMode) (*model.Subscription, error) {
+	subscription, err := s.Get(ctx, ID, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return ErrSubscriptionNotFound
+			return nil, ErrSubscriptionNotFound
 		}
 
-		return fmt.Errorf("couldn't cancel subscription: %w", err)
+		return nil, fmt.Errorf("couldn't cancel subscription: %w", err)
+	}
+
+	now := time.Now().In(UTCLocation)
+	if subscription.Claimed(now) {
+		// a purchase or plan change is charging it
+		return nil, ErrConcurrentUpdate
+	}
+	if mode == CancelAtPeriodEnd {
+		if subscription.State != model.Active && subscription.State != model.Trialing {
+			if subscription.State == model.Cancelled {
+				return nil, ErrAlreadyCancelled
+			}
+			return nil, ErrNotCancellableAtPeriodEnd
+		}
+		if !now.Before(subscription.End.In(UTCLocation)) {
+			return nil, ErrAlreadyExpired
+		}
+
+		cancelAt := subscription.End
+		subscription.CancelAt = &cancelAt
+		// a plan change scheduled for the same time won't happen anymore
+		subscription.PendingProductID = nil
+		if err := s.save(ctx, subscription); err != nil {
+			return nil, fmt.Errorf("couldn't schedule cancellation: %w", err)
+		}
+
+		return subscription, nil
 	}
 
 	switch subscription.State {
-	case model.Active, model.Pending, model.Paused:
-		if time.Now().In(UTCLocation).Before(subscription.End.In(UTCLocation)) {
+	case model.Active, model.Pending, model.Paused, model.Trialing:
+		if now.Before(subscription.End.In(UTCLocation)) {
+			var unused int
+			// nothing was paid for pending and trialing subscriptions
+			trialing := subscription.State == model.Trialing
+			pending := subscription.State == model.Pending || trialing
+			if !pending {
+				unused = proratedAmount(s.ledger.tax, subscription, now)
+			}
+
 			subscription.State = model.Cancelled
-			now := time.Now().In(UTCLocation)
 			subscription.End = now
-			if err := s.subsRepo.Save(ctx, subscription); err != nil {
-				return fmt.Errorf("couldn't cancel subscription: %w", err)
+			subscription.CancelAt = &now
+			if err := s.save(ctx, subscription); err != nil {
+				return nil, fmt.Errorf("couldn't cancel subscription: %w", err)
 			}
 
-			return nil
+			if trialing {
+				// trials redeem their coupon when they start, a pending
+				// subscription only once it's purchased
+				s.releaseCoupon(ctx, subscription)
+			}
+			s.refundUnused(ctx, subscription, unused)
+			return subscription, nil
 		}
-		return ErrAlreadyExpired
+		return nil, ErrAlreadyExpired
+	case model.PastDue:
+		// the period already ended unpaid, End stays where it was
+		subscription.State = model.Cancelled
+		subscription.CancelAt = &now
+		if err := s.save(ctx, subscription); err != nil {
+			return nil, fmt.Errorf("couldn't cancel subscription: %w", err)
+		}
+
+		return subscription, nil
 	case model.Cancelled:
-		return ErrAlreadyCancelled
+		return nil, ErrAlreadyCancelled
 	default:
-		return ErrInvalidState
+		return nil, ErrInvalidState
 	}
 }
 
-func (s *subscriptionService) Unpause(ctx context.Context, ID uint) error {
-	subscription, err := s.Get(ctx, ID)
+func (s *subscriptionService) Uncancel(ctx context.Context, ID uint, userID uint) (*model.Subscription, error) {
+	subscription, err := s.Get(ctx, ID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't withdraw cancellation: %w", err)
+	}
+
+	switch subscription.State {
+	case model.Active, model.Paused, model.Trialing:
+		if subscription.CancelAt == nil || !time.Now().In(UTCLocation).Before(subscription.CancelAt.In(UTCLocation)) {
+			return nil, ErrNoScheduledCancellation
+		}
+
+		subscription.CancelAt = nil
+		if err := s.save(ctx, subscription); err != nil {
+			return nil, fmt.Errorf("couldn't withdraw cancellation: %w", err)
+		}
+
+		return subscription, nil
+	case model.Cancelled:
+		return nil, ErrAlreadyCancelled
+	default:
+		return nil, ErrInvalidState
+	}
+}
+
+func (s *subscriptionService) Unpause(ctx context.Context, ID uint, userID uint) error {
+	subscription, err := s.Get(ctx, ID, userID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return ErrSubscriptionNotFound
@@ -207,8 +573,13 @@ func (s *subscriptionService) Unpause(ctx context.Context, ID uint) error {
 		// TODO: Handle case where PausedAt is nil
 		pausedDuration := now.Sub(*subscription.PausedAt)
 		subscription.End = subscription.End.Add(pausedDuration)
+		if subscription.CancelAt != nil {
+			// a cancellation scheduled for the period end follows the end
+			cancelAt := subscription.End
+			subscription.CancelAt = &cancelAt
+		}
 
-		if err := s.subsRepo.Save(ctx, subscription); err != nil {
+		if err := s.save(ctx, subscription); err != nil {
 			return fmt.Errorf("couldn't cancel subscription: %w", err)
 		}
 
@@ -220,6 +591,168 @@ func (s *subscriptionService) Unpause(ctx context.Context, ID uint) error {
 	}
 }
 
+// SetAutoRenew turns automatic renewal on or off. Subscriptions that already
+// ended can't be changed.
+func (s *subscriptionService) SetAutoRenew(ctx context.Context, ID uint, userID uint, enabled bool) error {
+	subscription, err := s.Get(ctx, ID, userID)
+	if err != nil {
+		return fmt.Errorf("couldn't update auto-renew: %w", err)
+	}
+
+	switch subscription.State {
+	case model.Pending, model.Active, model.Paused, model.PastDue, model.Trialing:
+		if subscription.AutoRenew == enabled {
+			return nil
+		}
+
+		subscription.AutoRenew = enabled
+		if err := s.save(ctx, subscription); err != nil {
+			return fmt.Errorf("couldn't update auto-renew: %w", err)
+		}
+
+		return nil
+	default:
+		return ErrInvalidState
+	}
+}
+
+// refundUnused refunds the unused share of a paid period to a subscription
+// cancelled before its end. What was paid from the customer balance goes
+// back to the balance. The cancellation stands even if the refund fails; the
+// failure is logged and staff can refund the payment later.
+func (s *subscriptionService) refundUnused(ctx context.Context, sub *model.Subscription, amount int) {
+	if amount <= 0 {
+		return
+	}
+
+	payment, err := s.ledger.lastCharge(ctx, sub.ID)
+	if err != nil {
+		log.Printf("couldn't find the payment to refund for cancelled subscription %d: %v", sub.ID, err)
+		return
+	}
+	if payment == nil {
+		return
+	}
+
+	amount = min(amount, payment.CreditableCent())
+	if refundable := min(amount, payment.RefundableCent()); refundable > 0 {
+		amount -= refundable
+		refund, err := s.ledger.refundPayment(ctx, payment, refundable, "prorated refund on cancellation")
+		if err != nil {
+			log.Printf("couldn't refund %d cents of payment %d for cancelled subscription %d: %v", refundable, payment.ID, sub.ID, err)
+		} else if refund.Status != model.PaymentSucceeded {
+			log.Printf("refund %d for cancelled subscription %d was declined: %s", refund.ID, sub.ID, refund.FailureReason)
+		}
+	}
+
+	if amount > 0 {
+		if _, err := s.ledger.creditPayment(ctx, payment, amount, "prorated credit on cancellation"); err != nil {
+			log.Printf("couldn't credit %d cents of payment %d for cancelled subscription %d: %v", amount, payment.ID, sub.ID, err)
+		}
+	}
+}
+
+// save stores a subscription read by Get. Losing the race against another
+// write is reported as ErrConcurrentUpdate.
+func (s *subscriptionService) save(ctx context.Context, subscription *model.Subscription) error {
+	err := s.subsRepo.Save(ctx, subscription)
+	if errors.Is(err, repo.ErrVersionConflict) {
+		return ErrConcurrentUpdate
+	}
+	return err
+}
+
+// claimLease is how long a purchase or plan change owns the subscription it
+// charges. It only runs out if the request dies before saving.
+const claimLease = 5 * time.Minute
+
+// claim takes the subscription for a purchase or plan change before it is
+// charged. Concurrent requests for it get ErrConcurrentUpdate, whether they
+// read it before or after the claim, instead of charging a second time.
+func (s *subscriptionService) claim(ctx context.Context, subscription *model.Subscription, now time.Time) error {
+	until := now.Add(claimLease)
+	claimed, err := s.subsRepo.Claim(ctx, subscription.ID, subscription.Version, now, until)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrConcurrentUpdate
+	}
+	subscription.Version++
+	subscription.ClaimedUntil = &until
+	return nil
+}
+
+// unclaim gives the claim back when nothing was charged, so the request can
+// be retried right away. If that fails the claim runs out on its own.
+func (s *subscriptionService) unclaim(ctx context.Context, subscription *model.Subscription) {
+	subscription.ClaimedUntil = nil
+	if err := s.save(ctx, subscription); err != nil {
+		log.Printf("couldn't release claim of subscription %d: %v", subscription.ID, err)
+	}
+}
+
+// saveCharged saves a subscription that apply changed after it was charged.
+// The charge can't be undone anymore, so a conflicting write is reconciled
+// by applying the change again to the subscription as stored; apply fails
+// if it doesn't fit anymore.
+func (s *subscriptionService) saveCharged(ctx context.Context, subscription *model.Subscription, apply func(*model.Subscription) error) error {
+	err := s.save(ctx, subscription)
+	for retries := 3; errors.Is(err, ErrConcurrentUpdate) && retries > 0; retries-- {
+		stored, getErr := s.subsRepo.GetByID(ctx, subscription.ID)
+		if getErr != nil {
+			return getErr
+		}
+		if err = apply(stored); err != nil {
+			return err
+		}
+		if err = s.save(ctx, stored); err == nil {
+			*subscription = *stored
+		}
+	}
+	return err
+}
+
+// redeemCoupon counts the subscription's coupon against its redemption
+// limits. The coupon may have been used up or archived since the
+// subscription was created.
+func (s *subscriptionService) redeemCoupon(ctx context.Context, subscription *model.Subscription) error {
+	if subscription.CouponID == nil {
+		return nil
+	}
+
+	coupon, err := s.couponService.Get(ctx, *subscription.CouponID)
+	if err != nil {
+		if errors.Is(err, ErrCouponNotFound) {
+			return fmt.Errorf("%w: %s can't be redeemed anymore", ErrInvalidPromoCode, subscription.CouponCode)
+		}
+		return err
+	}
+
+	return s.couponService.Redeem(ctx, coupon, subscription.UserID)
+}
+
+// releaseCoupon gives back the redemption of the subscription's coupon.
+func (s *subscriptionService) releaseCoupon(ctx context.Context, subscription *model.Subscription) {
+	if subscription.CouponID != nil {
+		s.couponService.Release(ctx, *subscription.CouponID, subscription.UserID)
+	}
+}
+
+// ListPayments returns every charge attempt of the subscription, newest first.
+func (s *subscriptionService) ListPayments(ctx context.Context, ID uint, userID uint) ([]model.Payment, error) {
+	if _, err := s.Get(ctx, ID, userID); err != nil {
+		return nil, err
+	}
+
+	payments, err := s.paymentRepo.ListBySubscription(ctx, ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payments: %w", err)
+	}
+
+	return payments, nil
+}
+
 func init() {
 	var err error
 	UTCLocation, err = time.LoadLocation("UTC")
@@ -10,6 +10,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/thatmatin/subserv/internal/mock"
 	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/tax"
 	"gorm.io/gorm"
 )
 
@@ -20,6 +23,7 @@ func TestFetchSubscriptionInfo(t *testing.T) {
 	testCases := []struct {
 		name             string
 		inputID          uint
+		callerID         uint
 		expectedUserID   uint
 		expectedErr      error
 		errorContains    string
@@ -32,6 +36,7 @@ func TestFetchSubscriptionInfo(t *testing.T) {
 		{
 			name:             "existing subscription",
 			inputID:          1,
+			callerID:         1,
 			expectedUserID:   1,
 			expectedErr:      nil,
 			expectedState:    model.Pending,
@@ -46,11 +51,25 @@ func TestFetchSubscriptionInfo(t *testing.T) {
 					End:       fixedTime.Add(time.Hour * 24 * 30),
 					State:     model.Pending,
 					PriceCent: 1000,
-					TaxRate:   10,
+					TaxRate:   money.Percent(10),
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
 		},
+		{
+			name:        "subscription of another user",
+			inputID:     3,
+			callerID:    2,
+			expectedErr: ErrUnauthorizedAccess,
+			setupMock: func(repo *mock.MockSubscriptionRepo) {
+				subscription := &model.Subscription{
+					Model:  gorm.Model{ID: 3},
+					UserID: uint(1),
+					State:  model.Active,
+				}
+				repo.On("GetByID", ctx, uint(3)).Return(subscription, nil)
+			},
+		},
 		{
 			name:        "non-existent subscription",
 			inputID:     2,
@@ -77,9 +96,9 @@ func TestFetchSubscriptionInfo(t *testing.T) {
 			p := new(mock.MockProductRepo)
 			tc.setupMock(s)
 
-			svc := NewSubscriptionService(s, &productService{p}, &userService{u}, &dummyPaymentProcessor{})
+			svc := NewSubscriptionService(s, new(mock.MockPaymentRepo), &productService{p}, &userService{u}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
 
-			subscription, err := svc.Get(ctx, tc.inputID)
+			subscription, err := svc.Get(ctx, tc.inputID, tc.callerID)
 			if tc.expectedErr != nil {
 				require.Error(t, err)
 				if tc.errorContains == "" {
@@ -105,10 +124,24 @@ func TestFetchSubscriptionInfo(t *testing.T) {
 
 func TestCreateSubscription(t *testing.T) {
 	ctx := context.Background()
+	rules := &tax.Rules{
+		SellerCountry: "DE",
+		Countries: map[string]tax.Jurisdiction{
+			"DE": {Rates: map[tax.Category]money.Rate{tax.Standard: 1900}, ReverseCharge: true},
+			"FR": {Rates: map[tax.Category]money.Rate{tax.Standard: 2000, "ebook": 550}, ReverseCharge: true},
+			"US": {Regions: map[string]tax.Jurisdiction{"CA": {Rates: map[tax.Category]money.Rate{tax.Standard: 725}}}},
+		},
+	}
+	ebook := func() *model.Product {
+		return &model.Product{Model: gorm.Model{ID: 1}, Duration: time.Hour, Price: 10000, TaxRate: 10, TaxCategory: "ebook"}
+	}
+
 	testCases := []struct {
 		name          string
 		productID     uint
 		userID        uint
+		currency      string
+		rules         *tax.Rules
 		expectedState model.State
 		expectedErr   error
 		errorContains string
@@ -128,25 +161,194 @@ func TestCreateSubscription(t *testing.T) {
 					Price:    10000,
 					TaxRate:  10,
 				}
-				userRepo.On("Exists", ctx, uint(2)).Return(true, nil)
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}}, nil)
 				prodRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
 				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
 					return sub.UserID == uint(2) &&
 						sub.ProductID == uint(1) &&
 						sub.State == model.Pending &&
 						sub.PriceCent == product.Price &&
-						sub.TaxRate == product.TaxRate &&
+						sub.Currency == model.DefaultCurrency &&
+						sub.TaxRate == 1000 &&
+						sub.TaxReason == string(tax.Fallback) &&
 						!sub.Start.IsZero() &&
 						!sub.End.IsZero()
 				})).Return(nil)
 			},
 		},
+		{
+			name:          "billing currency",
+			productID:     1,
+			userID:        2,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				product := &model.Product{
+					Model:    gorm.Model{ID: 1},
+					Duration: time.Hour * 24 * 30,
+					Price:    10000,
+					Prices:   []model.ProductPrice{{Currency: "JPY", Amount: 1500}},
+				}
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCurrency: "JPY"}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.PriceCent == 1500 && sub.Currency == "JPY"
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "requested currency overrides the billing currency",
+			productID:     1,
+			userID:        2,
+			currency:      "EUR",
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				product := &model.Product{
+					Model:    gorm.Model{ID: 1},
+					Duration: time.Hour * 24 * 30,
+					Price:    10000,
+					Prices:   []model.ProductPrice{{Currency: "EUR", Amount: 9000}, {Currency: "JPY", Amount: 1500}},
+				}
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCurrency: "JPY"}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.PriceCent == 9000 && sub.Currency == "EUR"
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "taxed by the rules",
+			productID:     1,
+			userID:        2,
+			rules:         rules,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCountry: "FR"}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(ebook(), nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.TaxRate == 550 && sub.TaxCountry == "FR" && sub.TaxRegion == "" &&
+						sub.TaxCategory == "ebook" && sub.TaxReason == string(tax.Taxed)
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "taxed by the region",
+			productID:     1,
+			userID:        2,
+			rules:         rules,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCountry: "US", BillingRegion: "CA"}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(ebook(), nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.TaxRate == 725 && sub.TaxJurisdiction() == "US-CA" && sub.TaxReason == string(tax.Taxed)
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "business abroad is reverse charged",
+			productID:     1,
+			userID:        2,
+			rules:         rules,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCountry: "FR", TaxID: "FR40303265045"}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(ebook(), nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.TaxRate == 0 && sub.TaxCountry == "FR" && sub.TaxReason == string(tax.ReverseCharge)
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "exempt buyer",
+			productID:     1,
+			userID:        2,
+			rules:         rules,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCountry: "DE", TaxExempt: true}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(ebook(), nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.TaxRate == 0 && sub.TaxReason == string(tax.ExemptBuyer)
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "country without rules falls back to the product's rate",
+			productID:     1,
+			userID:        2,
+			rules:         rules,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}, BillingCountry: "JP"}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(ebook(), nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.TaxRate == 1000 && sub.TaxCountry == "JP" && sub.TaxReason == string(tax.Fallback)
+				})).Return(nil)
+			},
+		},
+		{
+			name:          "free trial",
+			productID:     1,
+			userID:        2,
+			expectedState: model.Trialing,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				product := &model.Product{Model: gorm.Model{ID: 1}, Duration: 2592000, Price: 10000, TrialDays: 14, Family: "flowmotion"}
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
+				subsRepo.On("TrialUsed", ctx, uint(2), "flowmotion").Return(false, nil)
+				subsRepo.On("CreateTrial", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Trialing &&
+						sub.AutoRenew &&
+						sub.PriceCent == 10000 &&
+						sub.End.Sub(sub.Start) == time.Hour*24*14
+				}), "flowmotion").Return(nil)
+			},
+		},
+		{
+			name:          "trial of the family already used",
+			productID:     1,
+			userID:        2,
+			expectedState: model.Pending,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				product := &model.Product{Model: gorm.Model{ID: 1}, Duration: 2592000, Price: 10000, TrialDays: 14}
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
+				subsRepo.On("TrialUsed", ctx, uint(2), "product-1").Return(true, nil)
+				subsRepo.On("Create", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Pending && sub.End.Sub(sub.Start) == time.Hour*24*30
+				})).Return(nil)
+			},
+		},
+		{
+			name:        "trial started concurrently",
+			productID:   1,
+			userID:      2,
+			expectedErr: ErrTrialUsed,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				product := &model.Product{Model: gorm.Model{ID: 1}, Duration: 2592000, Price: 10000, TrialDays: 14}
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(product, nil)
+				subsRepo.On("TrialUsed", ctx, uint(2), "product-1").Return(false, nil)
+				subsRepo.On("CreateTrial", ctx, mocklib.Anything, "product-1").Return(gorm.ErrDuplicatedKey)
+			},
+		},
+		{
+			name:        "no price in the currency",
+			productID:   1,
+			userID:      2,
+			currency:    "GBP",
+			expectedErr: ErrPriceNotAvailable,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}}, nil)
+				prodRepo.On("GetByID", ctx, uint(1)).Return(&model.Product{Model: gorm.Model{ID: 1}, Price: 10000}, nil)
+			},
+		},
 		{
 			name:        "user not found",
 			userID:      2,
 			expectedErr: ErrUserNotFound,
 			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
-				userRepo.On("Exists", ctx, uint(2)).Return(false, nil)
+				userRepo.On("GetByID", ctx, uint(2)).Return((*model.User)(nil), gorm.ErrRecordNotFound)
 			},
 		},
 		{
@@ -155,7 +357,7 @@ func TestCreateSubscription(t *testing.T) {
 			productID:   2,
 			expectedErr: ErrProductNotFound,
 			setupMock: func(subsRepo *mock.MockSubscriptionRepo, prodRepo *mock.MockProductRepo, userRepo *mock.MockUserRepo) {
-				userRepo.On("Exists", ctx, uint(2)).Return(true, nil)
+				userRepo.On("GetByID", ctx, uint(2)).Return(&model.User{Model: gorm.Model{ID: 2}}, nil)
 				prodRepo.On("GetByID", ctx, uint(2)).Return((*model.Product)(nil), ErrProductNotFound)
 			},
 		},
@@ -168,9 +370,9 @@ func TestCreateSubscription(t *testing.T) {
 			u := new(mock.MockUserRepo)
 			tc.setupMock(s, p, u)
 
-			svc := NewSubscriptionService(s, &productService{p}, &userService{u}, &dummyPaymentProcessor{})
+			svc := NewSubscriptionService(s, new(mock.MockPaymentRepo), &productService{p}, &userService{u}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{tc.rules}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
 
-			subscription, err := svc.Create(ctx, tc.productID, tc.userID)
+			subscription, err := svc.Create(ctx, tc.productID, tc.userID, tc.currency, "")
 			if tc.expectedErr != nil {
 				require.Error(t, err)
 				if tc.errorContains == "" {
@@ -208,23 +410,55 @@ func TestPauseSubscription(t *testing.T) {
 				start := time.Now()
 				end := start.Add(time.Hour * 24 * 30)
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
-					Start: start,
-					End:   end,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
+					Start:  start,
+					End:    end,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.State == model.Paused })).Return(nil)
 			},
 		},
+		{
+			name:        "pause concurrently modified subscription",
+			expectedErr: ErrConcurrentUpdate,
+			status:      model.Active,
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo, state model.State) {
+				start := time.Now()
+				subscription := &model.Subscription{
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
+					Start:  start,
+					End:    start.Add(time.Hour * 24 * 30),
+				}
+				subsRepo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
+				subsRepo.On("Save", ctx, mocklib.Anything).Return(repo.ErrVersionConflict)
+			},
+		},
+		{
+			name:        "pause subscription of another user",
+			expectedErr: ErrUnauthorizedAccess,
+			status:      model.Active,
+			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
+				subscription := &model.Subscription{
+					Model:  gorm.Model{ID: 1},
+					UserID: 2,
+					State:  state,
+				}
+				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
+			},
+		},
 		{
 			name:        "pause paused subscription",
 			expectedErr: ErrAlreadyPaused,
 			status:      model.Paused,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -235,8 +469,9 @@ func TestPauseSubscription(t *testing.T) {
 			status:      model.Cancelled,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -247,8 +482,9 @@ func TestPauseSubscription(t *testing.T) {
 			status:      model.Expired,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -259,9 +495,9 @@ func TestPauseSubscription(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := new(mock.MockSubscriptionRepo)
 			tc.setupMock(repo, tc.status)
-			svc := NewSubscriptionService(repo, &productService{}, &userService{}, &dummyPaymentProcessor{})
+			svc := NewSubscriptionService(repo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
 
-			if err := svc.Pause(ctx, 1); err != nil {
+			if err := svc.Pause(ctx, 1, 1); err != nil {
 				require.ErrorIs(t, err, tc.expectedErr)
 			} else {
 				require.NoError(t, err)
@@ -289,15 +525,29 @@ func TestCancelSubscription(t *testing.T) {
 				start := time.Now()
 				end := start.Add(time.Hour * 24 * 30)
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
-					Start: start,
-					End:   end,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
+					Start:  start,
+					End:    end,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.State == model.Cancelled })).Return(nil)
 			},
 		},
+		{
+			name:        "cancel subscription of another user",
+			expectedErr: ErrUnauthorizedAccess,
+			status:      model.Active,
+			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
+				subscription := &model.Subscription{
+					Model:  gorm.Model{ID: 1},
+					UserID: 2,
+					State:  state,
+				}
+				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
+			},
+		},
 		{
 			name:        "cancel pending subscription",
 			expectedErr: nil,
@@ -306,15 +556,34 @@ func TestCancelSubscription(t *testing.T) {
 				start := time.Now()
 				end := start.Add(time.Hour * 24 * 30)
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
-					Start: start,
-					End:   end,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
+					Start:  start,
+					End:    end,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.State == model.Cancelled })).Return(nil)
 			},
 		},
+		{
+			name:        "cancel subscription while its purchase is charged",
+			expectedErr: ErrConcurrentUpdate,
+			status:      model.Pending,
+			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
+				start := time.Now()
+				claimedUntil := start.Add(time.Minute)
+				subscription := &model.Subscription{
+					Model:        gorm.Model{ID: 1},
+					UserID:       1,
+					State:        state,
+					Start:        start,
+					End:          start.Add(time.Hour * 24 * 30),
+					ClaimedUntil: &claimedUntil,
+				}
+				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
+			},
+		},
 
 		{
 			name:        "cancel paused subscription",
@@ -324,10 +593,29 @@ func TestCancelSubscription(t *testing.T) {
 				start := time.Now()
 				end := start.Add(time.Hour * 24 * 30)
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
-					Start: start,
-					End:   end,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
+					Start:  start,
+					End:    end,
+				}
+				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
+				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.State == model.Cancelled })).Return(nil)
+			},
+		},
+		{
+			name:        "cancel trialing subscription without a refund",
+			expectedErr: nil,
+			status:      model.Trialing,
+			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
+				start := time.Now()
+				subscription := &model.Subscription{
+					Model:     gorm.Model{ID: 1},
+					UserID:    1,
+					State:     state,
+					PriceCent: 1000,
+					Start:     start,
+					End:       start.Add(time.Hour * 24 * 14),
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.State == model.Cancelled })).Return(nil)
@@ -339,8 +627,9 @@ func TestCancelSubscription(t *testing.T) {
 			status:      model.Cancelled,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -351,8 +640,9 @@ func TestCancelSubscription(t *testing.T) {
 			status:      model.Expired,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -363,9 +653,95 @@ func TestCancelSubscription(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := new(mock.MockSubscriptionRepo)
 			tc.setupMock(repo, tc.status)
-			svc := NewSubscriptionService(repo, &productService{}, &userService{}, &dummyPaymentProcessor{})
+			svc := NewSubscriptionService(repo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			if _, err := svc.Cancel(ctx, 1, 1, CancelImmediately); err != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCancelAtPeriodEnd(t *testing.T) {
+	ctx := context.Background()
+	end := time.Now().Add(time.Hour * 24)
+
+	testCases := []struct {
+		name        string
+		state       model.State
+		end         time.Time
+		expectedErr error
+	}{
+		{name: "active subscription", state: model.Active, end: end},
+		{name: "paused subscription", state: model.Paused, end: end, expectedErr: ErrNotCancellableAtPeriodEnd},
+		{name: "pending subscription", state: model.Pending, end: end, expectedErr: ErrNotCancellableAtPeriodEnd},
+		{name: "cancelled subscription", state: model.Cancelled, end: end, expectedErr: ErrAlreadyCancelled},
+		{name: "ended period", state: model.Active, end: time.Now().Add(-time.Hour), expectedErr: ErrAlreadyExpired},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := new(mock.MockSubscriptionRepo)
+			repo.On("GetByID", ctx, uint(1)).
+				Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, State: tc.state, End: tc.end}, nil)
+			if tc.expectedErr == nil {
+				repo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Active && sub.CancelAt != nil && sub.CancelAt.Equal(tc.end)
+				})).Return(nil)
+			}
+			svc := NewSubscriptionService(repo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			sub, err := svc.Cancel(ctx, 1, 1, CancelAtPeriodEnd)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, model.Active, sub.State)
+				require.True(t, sub.CancelAt.Equal(tc.end))
+			}
+
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUncancelSubscription(t *testing.T) {
+	ctx := context.Background()
+	future := time.Now().Add(time.Hour * 24)
+	past := time.Now().Add(-time.Hour)
 
-			if err := svc.Cancel(ctx, 1); err != nil {
+	testCases := []struct {
+		name        string
+		state       model.State
+		cancelAt    *time.Time
+		expectedErr error
+	}{
+		{name: "scheduled cancellation", state: model.Active, cancelAt: &future},
+		{name: "scheduled cancellation of paused subscription", state: model.Paused, cancelAt: &future},
+		{name: "nothing scheduled", state: model.Active, expectedErr: ErrNoScheduledCancellation},
+		{name: "cancellation already due", state: model.Active, cancelAt: &past, expectedErr: ErrNoScheduledCancellation},
+		{name: "cancelled subscription", state: model.Cancelled, cancelAt: &past, expectedErr: ErrAlreadyCancelled},
+		{name: "expired subscription", state: model.Expired, expectedErr: ErrInvalidState},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := new(mock.MockSubscriptionRepo)
+			repo.On("GetByID", ctx, uint(1)).
+				Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1, State: tc.state, End: future, CancelAt: tc.cancelAt}, nil)
+			if tc.expectedErr == nil {
+				repo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == tc.state && sub.CancelAt == nil
+				})).Return(nil)
+			}
+			svc := NewSubscriptionService(repo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			_, err := svc.Uncancel(ctx, 1, 1)
+			if tc.expectedErr != nil {
 				require.ErrorIs(t, err, tc.expectedErr)
 			} else {
 				require.NoError(t, err)
@@ -395,6 +771,7 @@ func TestUnpauseSubscription(t *testing.T) {
 				pauseAt := start.Add(time.Hour * 24)
 				subscription := &model.Subscription{
 					Model:    gorm.Model{ID: 1},
+					UserID:   1,
 					State:    state,
 					Start:    start,
 					End:      end,
@@ -404,14 +781,28 @@ func TestUnpauseSubscription(t *testing.T) {
 				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.State == model.Active })).Return(nil)
 			},
 		},
+		{
+			name:        "unpause subscription of another user",
+			expectedErr: ErrUnauthorizedAccess,
+			state:       model.Active,
+			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
+				subscription := &model.Subscription{
+					Model:  gorm.Model{ID: 1},
+					UserID: 2,
+					State:  state,
+				}
+				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
+			},
+		},
 		{
 			name:        "unpause active subscription",
 			expectedErr: ErrAlreadyActive,
 			state:       model.Active,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -422,8 +813,9 @@ func TestUnpauseSubscription(t *testing.T) {
 			state:       model.Cancelled,
 			setupMock: func(repo *mock.MockSubscriptionRepo, state model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: state,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  state,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -434,8 +826,9 @@ func TestUnpauseSubscription(t *testing.T) {
 			state:       model.Expired,
 			setupMock: func(repo *mock.MockSubscriptionRepo, status model.State) {
 				subscription := &model.Subscription{
-					Model: gorm.Model{ID: 1},
-					State: status,
+					Model:  gorm.Model{ID: 1},
+					UserID: 1,
+					State:  status,
 				}
 				repo.On("GetByID", ctx, uint(1)).Return(subscription, nil)
 			},
@@ -446,9 +839,136 @@ func TestUnpauseSubscription(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			repo := new(mock.MockSubscriptionRepo)
 			tc.setupMock(repo, tc.state)
-			svc := NewSubscriptionService(repo, &productService{}, &userService{}, &dummyPaymentProcessor{})
+			svc := NewSubscriptionService(repo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			if err := svc.Unpause(ctx, 1, 1); err != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	active := model.Active
+
+	subs := func(ids ...uint) []model.Subscription {
+		res := make([]model.Subscription, len(ids))
+		for i, id := range ids {
+			res[i] = model.Subscription{Model: gorm.Model{ID: id}, UserID: 1}
+		}
+		return res
+	}
+
+	testCases := []struct {
+		name           string
+		opts           SubscriptionListOptions
+		expectedIDs    []uint
+		expectedCursor string
+		expectedErr    error
+		setupMock      func(subsRepo *mock.MockSubscriptionRepo)
+	}{
+		{
+			name:           "first page with more results",
+			opts:           SubscriptionListOptions{Limit: 2, State: &active},
+			expectedIDs:    []uint{9, 7},
+			expectedCursor: encodeCursor(7),
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo) {
+				subsRepo.On("List", ctx, mocklib.MatchedBy(func(f repo.SubscriptionFilter) bool {
+					return f.UserID == 1 && f.Limit == 3 && f.BeforeID == 0 && *f.State == model.Active
+				})).Return(subs(9, 7, 4), nil)
+			},
+		},
+		{
+			name:        "last page",
+			opts:        SubscriptionListOptions{Limit: 2, Cursor: encodeCursor(7)},
+			expectedIDs: []uint{4},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo) {
+				subsRepo.On("List", ctx, mocklib.MatchedBy(func(f repo.SubscriptionFilter) bool {
+					return f.UserID == 1 && f.Limit == 3 && f.BeforeID == 7
+				})).Return(subs(4), nil)
+			},
+		},
+		{
+			name:        "default page size",
+			opts:        SubscriptionListOptions{},
+			expectedIDs: []uint{},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo) {
+				subsRepo.On("List", ctx, mocklib.MatchedBy(func(f repo.SubscriptionFilter) bool {
+					return f.Limit == DefaultPageSize+1
+				})).Return(subs(), nil)
+			},
+		},
+		{
+			name:        "invalid cursor",
+			opts:        SubscriptionListOptions{Cursor: "not-a-cursor"},
+			expectedErr: ErrInvalidCursor,
+			setupMock:   func(subsRepo *mock.MockSubscriptionRepo) {},
+		},
+	}
 
-			if err := svc.Unpause(ctx, 1); err != nil {
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := new(mock.MockSubscriptionRepo)
+			tc.setupMock(subsRepo)
+			svc := NewSubscriptionService(subsRepo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			page, err := svc.List(ctx, 1, tc.opts)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				ids := make([]uint, len(page.Subscriptions))
+				for i, sub := range page.Subscriptions {
+					ids[i] = sub.ID
+				}
+				require.Equal(t, tc.expectedIDs, ids)
+				require.Equal(t, tc.expectedCursor, page.NextCursor)
+			}
+
+			subsRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSetAutoRenew(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name        string
+		state       model.State
+		autoRenew   bool
+		enable      bool
+		expectSave  bool
+		expectedErr error
+	}{
+		{name: "enable on active subscription", state: model.Active, enable: true, expectSave: true},
+		{name: "disable on paused subscription", state: model.Paused, autoRenew: true, enable: false, expectSave: true},
+		{name: "enable twice is a no-op", state: model.Active, autoRenew: true, enable: true},
+		{name: "enable on cancelled subscription", state: model.Cancelled, enable: true, expectedErr: ErrInvalidState},
+		{name: "enable on expired subscription", state: model.Expired, enable: true, expectedErr: ErrInvalidState},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := new(mock.MockSubscriptionRepo)
+			repo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+				Model:     gorm.Model{ID: 1},
+				UserID:    1,
+				State:     tc.state,
+				AutoRenew: tc.autoRenew,
+			}, nil)
+			if tc.expectSave {
+				repo.On("Save", ctx, mocklib.MatchedBy(func(subs *model.Subscription) bool { return subs.AutoRenew == tc.enable })).Return(nil)
+			}
+			svc := NewSubscriptionService(repo, new(mock.MockPaymentRepo), &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			err := svc.SetAutoRenew(ctx, 1, 1, tc.enable)
+			if tc.expectedErr != nil {
 				require.ErrorIs(t, err, tc.expectedErr)
 			} else {
 				require.NoError(t, err)
@@ -458,3 +978,383 @@ func TestUnpauseSubscription(t *testing.T) {
 		})
 	}
 }
+
+func TestPurchaseRecordsPayment(t *testing.T) {
+	ctx := context.Background()
+	// 1000 cents plus 10% tax
+	amount := 1100
+
+	pending := func() *model.Subscription {
+		return &model.Subscription{
+			Model:     gorm.Model{ID: 1},
+			UserID:    1,
+			ProductID: 2,
+			State:     model.Pending,
+			PriceCent: 1000,
+			TaxRate:   money.Percent(10),
+			Currency:  model.DefaultCurrency,
+			Start:     fixedTime,
+			End:       fixedTime.Add(time.Hour * 24 * 30),
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		processor      *stubPaymentProcessor
+		lostClaim      bool
+		createErr      error
+		expectedErr    error
+		errorContains  string
+		expectedStatus model.PaymentStatus
+		expectCharge   bool
+		expectSave     bool
+	}{
+		{
+			name:           "successful purchase",
+			processor:      &stubPaymentProcessor{success: true},
+			expectedStatus: model.PaymentSucceeded,
+			expectCharge:   true,
+			expectSave:     true,
+		},
+		{
+			name:           "declined purchase",
+			processor:      &stubPaymentProcessor{success: false},
+			expectedErr:    ErrFailedPayment,
+			expectedStatus: model.PaymentFailed,
+			expectCharge:   true,
+		},
+		{
+			name:           "processor error",
+			processor:      &stubPaymentProcessor{err: errors.New("gateway timeout")},
+			errorContains:  "gateway timeout",
+			expectedStatus: model.PaymentFailed,
+			expectCharge:   true,
+		},
+		{
+			name:          "payment can't be recorded",
+			processor:     &stubPaymentProcessor{success: true},
+			createErr:     errors.New("disk full"),
+			errorContains: "couldn't record payment",
+		},
+		{
+			name:        "concurrent purchase is not charged",
+			processor:   &stubPaymentProcessor{success: true},
+			lostClaim:   true,
+			expectedErr: ErrConcurrentUpdate,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := new(mock.MockSubscriptionRepo)
+			payRepo := new(mock.MockPaymentRepo)
+
+			subsRepo.On("GetByID", ctx, uint(1)).Return(pending(), nil)
+			subsRepo.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(!tc.lostClaim, nil).Once()
+			if !tc.lostClaim && !tc.expectSave {
+				// the claim is given back when nothing was charged
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Pending && sub.ClaimedUntil == nil && sub.Version == 1
+				})).Return(nil).Once()
+			}
+			payRepo.On("Create", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+				return p.SubscriptionID == 1 &&
+					p.UserID == 1 &&
+					p.Kind == model.PaymentPurchase &&
+					p.AmountCent == amount &&
+					p.TaxCent == amount-1000 &&
+					p.Currency == model.DefaultCurrency &&
+					p.Provider == "stub" &&
+					p.IdempotencyKey == "purchase-1-key-1"
+			})).Return(tc.createErr).Maybe()
+			if tc.expectCharge {
+				payRepo.On("Save", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+					return p.Status == tc.expectedStatus && p.CompletedAt != nil
+				})).Return(nil)
+			}
+			if tc.expectSave {
+				subsRepo.On("Save", ctx, mocklib.MatchedBy(func(sub *model.Subscription) bool {
+					return sub.State == model.Active && sub.ClaimedUntil == nil
+				})).Return(nil)
+			}
+
+			invoices := &stubInvoiceService{}
+			svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, tc.processor, &quoteService{}, &taxService{}, invoices, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+			err := svc.Purchase(ctx, 1, 1, "key-1")
+			switch {
+			case tc.expectedErr != nil:
+				require.ErrorIs(t, err, tc.expectedErr)
+			case tc.errorContains != "":
+				require.ErrorContains(t, err, tc.errorContains)
+			default:
+				require.NoError(t, err)
+			}
+
+			if tc.expectCharge {
+				require.Len(t, tc.processor.requests, 1)
+				require.Equal(t, amount, tc.processor.requests[0].Amount)
+				require.Equal(t, "purchase-1-key-1", tc.processor.requests[0].IdempotencyKey)
+			} else {
+				require.Empty(t, tc.processor.requests)
+			}
+			if tc.expectSave {
+				require.Len(t, invoices.issued, 1)
+				require.Equal(t, 1000, invoices.issued[0][0].NetCent)
+				require.Equal(t, amount-1000, invoices.issued[0][0].TaxCent)
+			} else {
+				require.Empty(t, invoices.issued)
+			}
+
+			subsRepo.AssertExpectations(t)
+			payRepo.AssertExpectations(t)
+		})
+	}
+}
+
+// memSubscriptionRepo stores one subscription with the version and claim
+// checks of the real repository, so concurrent requests can be interleaved.
+type memSubscriptionRepo struct {
+	mock.MockSubscriptionRepo
+	sub model.Subscription
+	// beforeClaim runs once before the next claim is taken.
+	beforeClaim func()
+}
+
+func (r *memSubscriptionRepo) GetByID(ctx context.Context, ID uint) (*model.Subscription, error) {
+	sub := r.sub
+	return &sub, nil
+}
+
+func (r *memSubscriptionRepo) Claim(ctx context.Context, ID uint, version uint, now time.Time, until time.Time) (bool, error) {
+	if beforeClaim := r.beforeClaim; beforeClaim != nil {
+		r.beforeClaim = nil
+		beforeClaim()
+	}
+	if r.sub.Version != version || r.sub.Claimed(now) {
+		return false, nil
+	}
+	r.sub.Version++
+	r.sub.ClaimedUntil = &until
+	return true, nil
+}
+
+func (r *memSubscriptionRepo) Save(ctx context.Context, sub *model.Subscription) error {
+	if sub.Version != r.sub.Version {
+		return repo.ErrVersionConflict
+	}
+	sub.Version++
+	r.sub = *sub
+	return nil
+}
+
+// succeededPayments records payments in payRepo and counts those that
+// succeeded.
+func succeededPayments(ctx context.Context, payRepo *mock.MockPaymentRepo) *int {
+	var succeeded int
+	payRepo.On("Create", ctx, mocklib.Anything).Return(nil)
+	payRepo.On("Save", ctx, mocklib.Anything).Run(func(args mocklib.Arguments) {
+		if args.Get(1).(*model.Payment).Status == model.PaymentSucceeded {
+			succeeded++
+		}
+	}).Return(nil)
+	return &succeeded
+}
+
+func TestConcurrentPurchase(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name           string
+		readAfterClaim bool
+	}{
+		{name: "second purchase read before the claim"},
+		{name: "second purchase read after the claim", readAfterClaim: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := &memSubscriptionRepo{sub: model.Subscription{
+				Model:     gorm.Model{ID: 1},
+				UserID:    1,
+				ProductID: 2,
+				State:     model.Pending,
+				PriceCent: 1000,
+				Currency:  model.DefaultCurrency,
+				Start:     fixedTime,
+				End:       fixedTime.Add(time.Hour * 24 * 30),
+			}}
+			payRepo := new(mock.MockPaymentRepo)
+			succeeded := succeededPayments(ctx, payRepo)
+			processor := &stubPaymentProcessor{success: true}
+			svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, processor, &quoteService{}, &taxService{}, &stubInvoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			var second error
+			purchaseAgain := func() { second = svc.Purchase(ctx, 1, 1, "") }
+			if tc.readAfterClaim {
+				processor.onCharge = purchaseAgain
+			} else {
+				subsRepo.beforeClaim = purchaseAgain
+			}
+			first := svc.Purchase(ctx, 1, 1, "")
+
+			if tc.readAfterClaim {
+				require.NoError(t, first)
+				require.ErrorIs(t, second, ErrConcurrentUpdate)
+			} else {
+				require.ErrorIs(t, first, ErrConcurrentUpdate)
+				require.NoError(t, second)
+			}
+			require.Equal(t, 1, *succeeded)
+			require.Len(t, processor.requests, 1)
+			require.Equal(t, model.Active, subsRepo.sub.State)
+			require.Nil(t, subsRepo.sub.ClaimedUntil)
+		})
+	}
+}
+
+func TestPurchaseReconcilesSave(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name          string
+		concurrent    func(sub *model.Subscription)
+		errorContains string
+	}{
+		{
+			name:       "unrelated change",
+			concurrent: func(sub *model.Subscription) { sub.AutoRenew = true },
+		},
+		{
+			name:          "cancelled regardless of the claim",
+			concurrent:    func(sub *model.Subscription) { sub.State = model.Cancelled },
+			errorContains: "couldn't save successful payment [Transaction ID tx-1]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := &memSubscriptionRepo{sub: model.Subscription{
+				Model:     gorm.Model{ID: 1},
+				UserID:    1,
+				ProductID: 2,
+				State:     model.Pending,
+				PriceCent: 1000,
+				Currency:  model.DefaultCurrency,
+				Start:     fixedTime,
+				End:       fixedTime.Add(time.Hour * 24 * 30),
+			}}
+			payRepo := new(mock.MockPaymentRepo)
+			succeeded := succeededPayments(ctx, payRepo)
+			processor := &stubPaymentProcessor{success: true}
+			// another write lands while the purchase is charged
+			processor.onCharge = func() {
+				tc.concurrent(&subsRepo.sub)
+				subsRepo.sub.Version++
+			}
+			svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, processor, &quoteService{}, &taxService{}, &stubInvoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+			err := svc.Purchase(ctx, 1, 1, "")
+			require.Equal(t, 1, *succeeded)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				require.NotErrorIs(t, err, ErrConcurrentUpdate)
+				return
+			}
+			require.NoError(t, err, "a charged purchase isn't turned into a conflict")
+			require.Equal(t, model.Active, subsRepo.sub.State)
+			require.True(t, subsRepo.sub.AutoRenew, "the concurrent change is kept")
+			require.Nil(t, subsRepo.sub.ClaimedUntil)
+			require.Equal(t, 24*30*time.Hour, subsRepo.sub.End.Sub(subsRepo.sub.Start))
+		})
+	}
+}
+
+func TestPurchaseTaxPolicy(t *testing.T) {
+	ctx := context.Background()
+	couponID := uint(4)
+
+	// 1150 cents at 19% is 218.5 cents of tax, and 330 cents off it 62.7;
+	// when included, 1150 cents hold 183.6 cents of tax and 330 cents 52.7
+	testCases := []struct {
+		name       string
+		policy     money.TaxPolicy
+		amountCent int
+		taxCent    int
+	}{
+		{name: "half up per line", policy: money.TaxPolicy{Rounding: money.HalfUp, Level: money.PerLine}, amountCent: 820 + 219 - 63, taxCent: 219 - 63},
+		{name: "half even per line", policy: money.TaxPolicy{Rounding: money.HalfEven, Level: money.PerLine}, amountCent: 820 + 218 - 63, taxCent: 218 - 63},
+		{name: "half up per invoice", policy: money.TaxPolicy{Rounding: money.HalfUp, Level: money.PerInvoice}, amountCent: 820 + 156, taxCent: 156},
+		{name: "half even per invoice", policy: money.TaxPolicy{Rounding: money.HalfEven, Level: money.PerInvoice}, amountCent: 820 + 156, taxCent: 156},
+		{name: "inclusive", policy: money.TaxPolicy{Inclusive: true}, amountCent: 820, taxCent: 184 - 53},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subsRepo := new(mock.MockSubscriptionRepo)
+			payRepo := new(mock.MockPaymentRepo)
+			subsRepo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{
+				Model:              gorm.Model{ID: 1},
+				UserID:             1,
+				ProductID:          2,
+				State:              model.Pending,
+				PriceCent:          1150,
+				TaxRate:            money.Percent(19),
+				Currency:           model.DefaultCurrency,
+				CouponID:           &couponID,
+				DiscountAmountCent: 330,
+				DiscountForever:    true,
+				Start:              fixedTime,
+				End:                fixedTime.Add(time.Hour * 24 * 30),
+			}, nil)
+			subsRepo.On("Claim", ctx, uint(1), uint(0), mocklib.Anything, mocklib.Anything).Return(true, nil).Once()
+			subsRepo.On("Save", ctx, mocklib.Anything).Return(nil)
+			payRepo.On("Create", ctx, mocklib.MatchedBy(func(p *model.Payment) bool {
+				return p.AmountCent == tc.amountCent && p.TaxCent == tc.taxCent
+			})).Return(nil).Once()
+			payRepo.On("Save", ctx, mocklib.Anything).Return(nil)
+			coupons := new(mock.MockCouponRepo)
+			coupon := &model.Coupon{Model: gorm.Model{ID: couponID}, AmountOffCent: 330, Duration: model.CouponForever}
+			coupons.On("GetByID", ctx, couponID).Return(coupon, nil)
+			coupons.On("Redeem", ctx, coupon, uint(1), mocklib.Anything).Return(nil)
+
+			invoices := &stubInvoiceService{}
+			svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, &stubPaymentProcessor{success: true}, &quoteService{}, &taxService{}, invoices, &stubCreditService{}, &couponService{repo: coupons}, tc.policy)
+			require.NoError(t, svc.Purchase(ctx, 1, 1, ""))
+
+			// the invoice adds up to what was charged
+			require.Len(t, invoices.issued, 1)
+			var total, tax int
+			for _, item := range invoices.issued[0] {
+				total += item.NetCent + item.TaxCent
+				tax += item.TaxCent
+			}
+			require.Equal(t, tc.amountCent, total)
+			require.Equal(t, tc.taxCent, tax)
+			payRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListPayments(t *testing.T) {
+	ctx := context.Background()
+
+	subsRepo := new(mock.MockSubscriptionRepo)
+	payRepo := new(mock.MockPaymentRepo)
+	subsRepo.On("GetByID", ctx, uint(1)).Return(&model.Subscription{Model: gorm.Model{ID: 1}, UserID: 1}, nil)
+	payRepo.On("ListBySubscription", ctx, uint(1)).Return([]model.Payment{
+		{Model: gorm.Model{ID: 2}, SubscriptionID: 1, Status: model.PaymentSucceeded},
+		{Model: gorm.Model{ID: 1}, SubscriptionID: 1, Status: model.PaymentFailed},
+	}, nil)
+
+	svc := NewSubscriptionService(subsRepo, payRepo, &productService{}, &userService{}, &dummyPaymentProcessor{}, &quoteService{}, &taxService{}, &invoiceService{}, &stubCreditService{}, &couponService{}, money.DefaultTaxPolicy)
+
+	payments, err := svc.ListPayments(ctx, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+
+	_, err = svc.ListPayments(ctx, 1, 2)
+	require.ErrorIs(t, err, ErrUnauthorizedAccess)
+
+	subsRepo.AssertExpectations(t)
+	payRepo.AssertExpectations(t)
+}
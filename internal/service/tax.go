@@ -0,0 +1,89 @@
+package service
+
+import (
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
+	"github.com/thatmatin/subserv/internal/tax"
+)
+
+// TaxService decides which tax applies to a sale. Sales the tax rules don't
+// cover are taxed at the product's own TaxRate.
+type TaxService interface {
+	// Decide decides the tax of selling the product to the buyer.
+	Decide(buyer tax.Buyer, product *model.Product) tax.Decision
+	// ForProduct decides the tax of switching the subscription to the
+	// product, for the buyer the subscription's tax was decided for.
+	ForProduct(subscription *model.Subscription, product *model.Product) tax.Decision
+}
+
+type taxService struct {
+	rules *tax.Rules
+}
+
+// NewTaxService returns a TaxService deciding by the rules. With nil rules
+// every sale is taxed at the product's TaxRate unless the buyer is exempt.
+func NewTaxService(rules *tax.Rules) TaxService {
+	return &taxService{rules: rules}
+}
+
+func (s *taxService) Decide(buyer tax.Buyer, product *model.Product) tax.Decision {
+	decision, err := s.rules.Decide(buyer, taxCategory(product))
+	if err != nil {
+		return fallbackTax(buyer.Country, buyer.Region, product)
+	}
+	return decision
+}
+
+func (s *taxService) ForProduct(subscription *model.Subscription, product *model.Product) tax.Decision {
+	decision, err := s.rules.Recategorize(subscriptionTax(subscription), taxCategory(product))
+	if err != nil {
+		return fallbackTax(subscription.TaxCountry, subscription.TaxRegion, product)
+	}
+	return decision
+}
+
+// buyerOf describes the user as a buyer at their billing address.
+func buyerOf(user *model.User) tax.Buyer {
+	return tax.Buyer{
+		Country: user.BillingCountry,
+		Region:  user.BillingRegion,
+		TaxID:   user.TaxID,
+		Exempt:  user.TaxExempt,
+	}
+}
+
+// applyTax snapshots the decision on the subscription.
+func applyTax(subscription *model.Subscription, decision tax.Decision) {
+	subscription.TaxRate = decision.Rate
+	subscription.TaxCountry = decision.Country
+	subscription.TaxRegion = decision.Region
+	subscription.TaxCategory = string(decision.Category)
+	subscription.TaxReason = string(decision.Reason)
+}
+
+func subscriptionTax(subscription *model.Subscription) tax.Decision {
+	return tax.Decision{
+		Country:  subscription.TaxCountry,
+		Region:   subscription.TaxRegion,
+		Category: tax.Category(subscription.TaxCategory),
+		Rate:     subscription.TaxRate,
+		Reason:   tax.Reason(subscription.TaxReason),
+	}
+}
+
+func taxCategory(product *model.Product) tax.Category {
+	if product.TaxCategory == "" {
+		return tax.Standard
+	}
+	return tax.Category(product.TaxCategory)
+}
+
+func fallbackTax(country string, region string, product *model.Product) tax.Decision {
+	return tax.Decision{
+		Country:  country,
+		Region:   region,
+		Category: taxCategory(product),
+		Rate:     money.Percent(product.TaxRate),
+		Reason:   tax.Fallback,
+	}
+}
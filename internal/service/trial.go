@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thatmatin/subserv/internal/repo"
+)
+
+// TrialPolicy configures the trial reminders. ReminderLead is how long before
+// a trial ends the user is reminded.
+type TrialPolicy struct {
+	ReminderLead time.Duration
+	BatchSize    int
+}
+
+type TrialService interface {
+	// RemindEnding reminds the users whose trial ends within the lead time,
+	// once per trial, and returns how many were reminded.
+	RemindEnding(ctx context.Context, now time.Time) (int, error)
+}
+
+type trialService struct {
+	subsRepo    repo.SubscriptionRepository
+	userService UserService
+	notifier    Notifier
+	policy      TrialPolicy
+}
+
+func NewTrialService(subsRepo repo.SubscriptionRepository, userSvc UserService, notifier Notifier, policy TrialPolicy) TrialService {
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = 100
+	}
+	return &trialService{subsRepo: subsRepo, userService: userSvc, notifier: notifier, policy: policy}
+}
+
+func (s *trialService) RemindEnding(ctx context.Context, now time.Time) (int, error) {
+	now = now.In(UTCLocation)
+
+	var reminded int
+	var errs []error
+	for {
+		if err := ctx.Err(); err != nil {
+			return reminded, err
+		}
+
+		subs, err := s.subsRepo.TrialsEnding(ctx, now.Add(s.policy.ReminderLead), s.policy.BatchSize)
+		if err != nil {
+			return reminded, fmt.Errorf("failed to fetch trials ending soon: %w", err)
+		}
+
+		var marked int
+		for i := range subs {
+			sub := &subs[i]
+			// mark first, so concurrent runs don't remind the user twice
+			claimed, err := s.subsRepo.MarkTrialReminded(ctx, sub.ID, now)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("couldn't mark trial of subscription %d reminded: %w", sub.ID, err))
+				continue
+			}
+			if !claimed {
+				continue
+			}
+			marked++
+
+			// a missed reminder doesn't stop the trial from converting, so
+			// failures are only logged
+			user, err := s.userService.Get(ctx, sub.UserID)
+			if err != nil {
+				log.Printf("couldn't remind user %d of the trial of subscription %d ending: %v", sub.UserID, sub.ID, err)
+				continue
+			}
+			if err := s.notifier.TrialEnding(ctx, user, sub); err != nil {
+				log.Printf("couldn't remind user %d of the trial of subscription %d ending: %v", sub.UserID, sub.ID, err)
+				continue
+			}
+			reminded++
+		}
+
+		// a batch that can't be marked would be fetched again forever
+		if len(subs) < s.policy.BatchSize || marked == 0 {
+			return reminded, errors.Join(errs...)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"gorm.io/gorm"
+)
+
+// stubNotifier records the trials it was told about.
+type stubNotifier struct {
+	err      error
+	reminded []uint
+}
+
+func (n *stubNotifier) TrialEnding(ctx context.Context, user *model.User, sub *model.Subscription) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.reminded = append(n.reminded, sub.ID)
+	return nil
+}
+
+func TestRemindEndingTrials(t *testing.T) {
+	ctx := context.Background()
+	now := fixedTime
+	policy := TrialPolicy{ReminderLead: time.Hour * 72, BatchSize: 10}
+	ending := []model.Subscription{
+		{Model: gorm.Model{ID: 1}, UserID: 1, State: model.Trialing, End: now.Add(time.Hour * 48)},
+		{Model: gorm.Model{ID: 2}, UserID: 1, State: model.Trialing, End: now.Add(time.Hour * 60)},
+	}
+
+	testCases := []struct {
+		name      string
+		notifier  *stubNotifier
+		expected  int
+		reminded  []uint
+		setupMock func(subsRepo *mock.MockSubscriptionRepo)
+	}{
+		{
+			name:     "every trial ending is reminded",
+			notifier: &stubNotifier{},
+			expected: 2,
+			reminded: []uint{1, 2},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo) {
+				subsRepo.On("MarkTrialReminded", ctx, uint(1), now).Return(true, nil)
+				subsRepo.On("MarkTrialReminded", ctx, uint(2), now).Return(true, nil)
+			},
+		},
+		{
+			name:     "trial reminded by another run is skipped",
+			notifier: &stubNotifier{},
+			expected: 1,
+			reminded: []uint{2},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo) {
+				subsRepo.On("MarkTrialReminded", ctx, uint(1), now).Return(false, nil)
+				subsRepo.On("MarkTrialReminded", ctx, uint(2), now).Return(true, nil)
+			},
+		},
+		{
+			name:     "failed notification is not retried",
+			notifier: &stubNotifier{err: errors.New("mail server down")},
+			setupMock: func(subsRepo *mock.MockSubscriptionRepo) {
+				subsRepo.On("MarkTrialReminded", ctx, uint(1), now).Return(true, nil)
+				subsRepo.On("MarkTrialReminded", ctx, uint(2), now).Return(true, nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := new(mock.MockSubscriptionRepo)
+			u := new(mock.MockUserRepo)
+			s.On("TrialsEnding", ctx, now.Add(policy.ReminderLead), 10).Return(ending, nil)
+			u.On("GetByID", ctx, uint(1)).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+			tc.setupMock(s)
+			svc := NewTrialService(s, &userService{u}, tc.notifier, policy)
+
+			n, err := svc.RemindEnding(ctx, now)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, n)
+			require.Equal(t, tc.reminded, tc.notifier.reminded)
+
+			s.AssertExpectations(t)
+		})
+	}
+}
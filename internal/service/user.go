@@ -2,13 +2,62 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
 
+	"github.com/thatmatin/subserv/internal/model"
+	"github.com/thatmatin/subserv/internal/money"
 	"github.com/thatmatin/subserv/internal/repo"
+	"github.com/thatmatin/subserv/internal/tax"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after the 72nd byte
+	maxPasswordLength = 72
 )
 
 type UserService interface {
 	Exists(context.Context, uint) (bool, error)
+	Get(ctx context.Context, ID uint) (*model.User, error)
+	// SetRole changes the user's role. Tokens issued before keep the old
+	// role until they expire.
+	SetRole(ctx context.Context, ID uint, role model.Role) error
+	// SetTaxExempt marks the user as exempt from tax on subscriptions they
+	// create afterwards, or withdraws the exemption.
+	SetTaxExempt(ctx context.Context, ID uint, exempt bool) error
+	Register(ctx context.Context, params RegisterParams) (*model.User, error)
+	// Authenticate returns the user with the email and password, or
+	// ErrInvalidCredentials.
+	Authenticate(ctx context.Context, email string, password string) (*model.User, error)
+	UpdateProfile(ctx context.Context, ID uint, patch ProfilePatch) (*model.User, error)
+}
+
+type RegisterParams struct {
+	Name     string
+	Email    string
+	Password string
+}
+
+// ProfilePatch holds the fields of a profile update. Nil fields are left
+// unchanged. Changing the email or password needs the current password.
+// Changing the billing country clears the billing region unless it is
+// changed too. An empty PaymentMethod removes the saved one.
+type ProfilePatch struct {
+	Name            *string
+	Email           *string
+	Password        *string
+	BillingCurrency *string
+	BillingCountry  *string
+	BillingRegion   *string
+	TaxID           *string
+	PaymentMethod   *string
+	CurrentPassword string
 }
 
 type userService struct {
@@ -27,3 +76,202 @@ func (s *userService) Exists(ctx context.Context, ID uint) (bool, error) {
 
 	return exists, nil
 }
+
+func (s *userService) Get(ctx context.Context, ID uint) (*model.User, error) {
+	user, err := s.repo.GetByID(ctx, ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *userService) SetRole(ctx context.Context, ID uint, role model.Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	updated, err := s.repo.SetRole(ctx, ID, role)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+	if !updated {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *userService) SetTaxExempt(ctx context.Context, ID uint, exempt bool) error {
+	updated, err := s.repo.SetTaxExempt(ctx, ID, exempt)
+	if err != nil {
+		return fmt.Errorf("failed to update user tax exemption: %w", err)
+	}
+	if !updated {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *userService) Register(ctx context.Context, params RegisterParams) (*model.User, error) {
+	user := &model.User{
+		Name:  strings.TrimSpace(params.Name),
+		Email: normalizeEmail(params.Email),
+		Role:  model.RoleCustomer,
+	}
+	if err := validateUser(user); err != nil {
+		return nil, err
+	}
+	if err := setPassword(user, params.Password); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrEmailTaken
+		}
+		return nil, fmt.Errorf("couldn't create user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *userService) Authenticate(ctx context.Context, email string, password string) (*model.User, error) {
+	user, err := s.repo.GetByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// spend the same time as for a known email so response times
+			// don't tell which emails are registered
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	if !checkPassword(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+func (s *userService) UpdateProfile(ctx context.Context, ID uint, patch ProfilePatch) (*model.User, error) {
+	user, err := s.Get(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if (patch.Email != nil || patch.Password != nil) && !checkPassword(user, patch.CurrentPassword) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if patch.Name != nil {
+		user.Name = strings.TrimSpace(*patch.Name)
+	}
+	if patch.Email != nil {
+		user.Email = normalizeEmail(*patch.Email)
+	}
+	if patch.BillingCurrency != nil {
+		user.BillingCurrency = strings.ToUpper(*patch.BillingCurrency)
+	}
+	if patch.BillingCountry != nil {
+		country := strings.ToUpper(strings.TrimSpace(*patch.BillingCountry))
+		if country != user.BillingCountry {
+			user.BillingRegion = ""
+		}
+		user.BillingCountry = country
+	}
+	if patch.BillingRegion != nil {
+		user.BillingRegion = strings.ToUpper(strings.TrimSpace(*patch.BillingRegion))
+	}
+	if patch.PaymentMethod != nil {
+		user.PaymentMethod = strings.TrimSpace(*patch.PaymentMethod)
+	}
+	if patch.TaxID != nil {
+		user.TaxID = strings.ToUpper(strings.ReplaceAll(*patch.TaxID, " ", ""))
+	}
+	if err := validateUser(user); err != nil {
+		return nil, err
+	}
+	if patch.Password != nil {
+		if err := setPassword(user, *patch.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.Save(ctx, user); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrEmailTaken
+		}
+		return nil, fmt.Errorf("couldn't update user: %w", err)
+	}
+
+	return user, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func validateUser(user *model.User) error {
+	if user.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProfile)
+	}
+	if len(user.Name) > 100 {
+		return fmt.Errorf("%w: name is too long", ErrInvalidProfile)
+	}
+	if addr, err := mail.ParseAddress(user.Email); err != nil || addr.Address != user.Email || len(user.Email) > 100 {
+		return fmt.Errorf("%w: invalid email address", ErrInvalidProfile)
+	}
+	if user.BillingCurrency != "" && !money.Currency(user.BillingCurrency).Valid() {
+		return fmt.Errorf("%w: billing currency must be an ISO 4217 code", ErrInvalidProfile)
+	}
+	if user.BillingCountry != "" && !tax.ValidCountry(user.BillingCountry) {
+		return fmt.Errorf("%w: billing country must be an ISO 3166-1 alpha-2 code", ErrInvalidProfile)
+	}
+	if user.BillingRegion != "" && user.BillingCountry == "" {
+		return fmt.Errorf("%w: billing region needs a billing country", ErrInvalidProfile)
+	}
+	if len(user.BillingRegion) > 10 {
+		return fmt.Errorf("%w: billing region is too long", ErrInvalidProfile)
+	}
+	if len(user.TaxID) > 50 {
+		return fmt.Errorf("%w: tax ID is too long", ErrInvalidProfile)
+	}
+
+	return nil
+}
+
+func setPassword(user *model.User, password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("%w: password must be at least %d characters", ErrInvalidProfile, minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("%w: password must be at most %d bytes", ErrInvalidProfile, maxPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("couldn't hash password: %w", err)
+	}
+	user.PasswordHash = string(hash)
+
+	return nil
+}
+
+func checkPassword(user *model.User, password string) bool {
+	if user.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	return hash
+})
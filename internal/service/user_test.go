@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	mocklib "github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/mock"
+	"github.com/thatmatin/subserv/internal/model"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func hashedUser(t *testing.T, password string) *model.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+	return &model.User{Model: gorm.Model{ID: 1}, Name: "Alice", Email: "alice@d.com", Role: model.RoleCustomer, PasswordHash: string(hash)}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name          string
+		params        RegisterParams
+		expectedErr   error
+		errorContains string
+		setupMock     func(userRepo *mock.MockUserRepo)
+	}{
+		{
+			name:   "new user",
+			params: RegisterParams{Name: " Alice ", Email: " Alice@D.com", Password: "correct horse"},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Create", ctx, mocklib.MatchedBy(func(u *model.User) bool {
+					return u.Name == "Alice" && u.Email == "alice@d.com" && u.Role == model.RoleCustomer &&
+						bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("correct horse")) == nil
+				})).Return(nil)
+			},
+		},
+		{
+			name:        "email already registered",
+			params:      RegisterParams{Name: "Alice", Email: "alice@d.com", Password: "correct horse"},
+			expectedErr: ErrEmailTaken,
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Create", ctx, mocklib.Anything).Return(gorm.ErrDuplicatedKey)
+			},
+		},
+		{
+			name:          "invalid email",
+			params:        RegisterParams{Name: "Alice", Email: "Alice <alice@d.com>", Password: "correct horse"},
+			expectedErr:   ErrInvalidProfile,
+			errorContains: "invalid email address",
+			setupMock:     func(userRepo *mock.MockUserRepo) {},
+		},
+		{
+			name:          "short password",
+			params:        RegisterParams{Name: "Alice", Email: "alice@d.com", Password: "short"},
+			expectedErr:   ErrInvalidProfile,
+			errorContains: "at least 8 characters",
+			setupMock:     func(userRepo *mock.MockUserRepo) {},
+		},
+		{
+			name:          "blank name",
+			params:        RegisterParams{Name: " ", Email: "alice@d.com", Password: "correct horse"},
+			expectedErr:   ErrInvalidProfile,
+			errorContains: "name is required",
+			setupMock:     func(userRepo *mock.MockUserRepo) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userRepo := new(mock.MockUserRepo)
+			tc.setupMock(userRepo)
+			svc := NewUserService(userRepo)
+
+			user, err := svc.Register(ctx, tc.params)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				require.ErrorContains(t, err, tc.errorContains)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "alice@d.com", user.Email)
+			}
+
+			userRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	userRepo := new(mock.MockUserRepo)
+	userRepo.On("GetByEmail", ctx, "alice@d.com").Return(hashedUser(t, "correct horse"), nil)
+	userRepo.On("GetByEmail", ctx, "bob@d.com").Return((*model.User)(nil), gorm.ErrRecordNotFound)
+	svc := NewUserService(userRepo)
+
+	user, err := svc.Authenticate(ctx, "ALICE@d.com ", "correct horse")
+	require.NoError(t, err)
+	require.Equal(t, uint(1), user.ID)
+
+	_, err = svc.Authenticate(ctx, "alice@d.com", "wrong horse")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = svc.Authenticate(ctx, "bob@d.com", "correct horse")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	name, email, password := "Alice B", "alice@b.com", "new password"
+	currency, badCurrency := "eur", "E1R"
+	country, region, taxID, badCountry := "us", "ca", "de 136 695 976", "USA"
+	paymentMethod := " pm_card_visa "
+
+	testCases := []struct {
+		name        string
+		patch       ProfilePatch
+		expectedErr error
+		setupMock   func(userRepo *mock.MockUserRepo)
+	}{
+		{
+			name:  "rename without password",
+			patch: ProfilePatch{Name: &name},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.MatchedBy(func(u *model.User) bool { return u.Name == "Alice B" })).Return(nil)
+			},
+		},
+		{
+			name:  "billing currency without password",
+			patch: ProfilePatch{BillingCurrency: &currency},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.MatchedBy(func(u *model.User) bool { return u.BillingCurrency == "EUR" })).Return(nil)
+			},
+		},
+		{
+			name:        "invalid billing currency",
+			patch:       ProfilePatch{BillingCurrency: &badCurrency},
+			expectedErr: ErrInvalidProfile,
+			setupMock:   func(userRepo *mock.MockUserRepo) {},
+		},
+		{
+			name:  "billing address and tax ID",
+			patch: ProfilePatch{BillingCountry: &country, BillingRegion: &region, TaxID: &taxID},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.MatchedBy(func(u *model.User) bool {
+					return u.BillingCountry == "US" && u.BillingRegion == "CA" && u.TaxID == "DE136695976"
+				})).Return(nil)
+			},
+		},
+		{
+			name:  "payment method without password",
+			patch: ProfilePatch{PaymentMethod: &paymentMethod},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.MatchedBy(func(u *model.User) bool { return u.PaymentMethod == "pm_card_visa" })).Return(nil)
+			},
+		},
+		{
+			name:        "invalid billing country",
+			patch:       ProfilePatch{BillingCountry: &badCountry},
+			expectedErr: ErrInvalidProfile,
+			setupMock:   func(userRepo *mock.MockUserRepo) {},
+		},
+		{
+			name:        "billing region without a country",
+			patch:       ProfilePatch{BillingRegion: &region},
+			expectedErr: ErrInvalidProfile,
+			setupMock:   func(userRepo *mock.MockUserRepo) {},
+		},
+		{
+			name:        "email change needs current password",
+			patch:       ProfilePatch{Email: &email},
+			expectedErr: ErrInvalidCredentials,
+			setupMock:   func(userRepo *mock.MockUserRepo) {},
+		},
+		{
+			name:  "email change with current password",
+			patch: ProfilePatch{Email: &email, CurrentPassword: "correct horse"},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.MatchedBy(func(u *model.User) bool { return u.Email == "alice@b.com" })).Return(nil)
+			},
+		},
+		{
+			name:        "email taken",
+			patch:       ProfilePatch{Email: &email, CurrentPassword: "correct horse"},
+			expectedErr: ErrEmailTaken,
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.Anything).Return(gorm.ErrDuplicatedKey)
+			},
+		},
+		{
+			name:  "password change",
+			patch: ProfilePatch{Password: &password, CurrentPassword: "correct horse"},
+			setupMock: func(userRepo *mock.MockUserRepo) {
+				userRepo.On("Save", ctx, mocklib.MatchedBy(func(u *model.User) bool {
+					return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("new password")) == nil
+				})).Return(nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userRepo := new(mock.MockUserRepo)
+			userRepo.On("GetByID", ctx, uint(1)).Return(hashedUser(t, "correct horse"), nil)
+			tc.setupMock(userRepo)
+			svc := NewUserService(userRepo)
+
+			_, err := svc.UpdateProfile(ctx, 1, tc.patch)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			userRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSetTaxExempt(t *testing.T) {
+	ctx := context.Background()
+	userRepo := new(mock.MockUserRepo)
+	userRepo.On("SetTaxExempt", ctx, uint(1), true).Return(true, nil)
+	userRepo.On("SetTaxExempt", ctx, uint(9), true).Return(false, nil)
+	svc := NewUserService(userRepo)
+
+	require.NoError(t, svc.SetTaxExempt(ctx, 1, true))
+	require.ErrorIs(t, svc.SetTaxExempt(ctx, 9, true), ErrUserNotFound)
+	userRepo.AssertExpectations(t)
+}
@@ -0,0 +1,239 @@
+// Package tax decides which VAT or sales tax rate applies to a sale, from
+// rules kept in a local JSON file.
+package tax
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/thatmatin/subserv/internal/money"
+)
+
+// ErrNoRule is returned when the rules don't cover the buyer's country or the
+// product's category.
+var ErrNoRule = errors.New("no tax rule")
+
+// Category groups products that are taxed alike, e.g. "standard" or
+// "ebook".
+type Category string
+
+// Standard is the category of products without one, and the rate used for
+// categories a jurisdiction doesn't list.
+const Standard Category = "standard"
+
+// Reason records why a rate was applied.
+type Reason string
+
+const (
+	// Taxed is a sale taxed at the jurisdiction's rate for the category.
+	Taxed Reason = "taxed"
+	// ReverseCharge is a cross-border sale to a business, which accounts for
+	// the tax itself.
+	ReverseCharge Reason = "reverse_charge"
+	// ExemptBuyer is a sale to a buyer exempt from tax.
+	ExemptBuyer Reason = "exempt_buyer"
+	// ExemptProduct is a sale of a category exempt in the jurisdiction.
+	ExemptProduct Reason = "exempt_product"
+	// Fallback is a sale no rule covered, taxed at the product's own rate.
+	Fallback Reason = "fallback"
+)
+
+// Note explains to the buyer why no tax was charged, or is empty if it was.
+func (r Reason) Note() string {
+	switch r {
+	case ReverseCharge:
+		return "Reverse charge: tax to be accounted for by the customer"
+	case ExemptBuyer:
+		return "Tax exempt customer"
+	case ExemptProduct:
+		return "Tax exempt product"
+	}
+	return ""
+}
+
+// Rules holds the tax rules of every country the seller sells to.
+type Rules struct {
+	// SellerCountry is where the seller is registered. Sales to businesses
+	// in other countries with ReverseCharge aren't taxed.
+	SellerCountry string                  `json:"seller_country"`
+	Countries     map[string]Jurisdiction `json:"countries"`
+}
+
+// Jurisdiction is a country, or a region (state, province) of one. A region
+// inherits everything it doesn't set from its country.
+type Jurisdiction struct {
+	// Rates maps categories to their rate in percent. The Standard rate
+	// applies to categories that aren't listed.
+	Rates map[Category]money.Rate `json:"rates"`
+	// Exempt lists categories that aren't taxed.
+	Exempt []Category `json:"exempt"`
+	// ReverseCharge makes businesses from this country pay the tax on
+	// cross-border sales themselves. Only read on countries.
+	ReverseCharge bool                    `json:"reverse_charge"`
+	Regions       map[string]Jurisdiction `json:"regions"`
+}
+
+// Buyer is who a sale is taxed for.
+type Buyer struct {
+	// Country is an ISO 3166-1 alpha-2 code; Region is optional.
+	Country string
+	Region  string
+	// TaxID is the buyer's VAT or tax registration number. Buyers with one
+	// are businesses.
+	TaxID  string
+	Exempt bool
+}
+
+// Decision is the tax applied to a sale, kept for audit.
+type Decision struct {
+	Country  string
+	Region   string
+	Category Category
+	Rate     money.Rate
+	Reason   Reason
+}
+
+// Jurisdiction names where the tax is due, e.g. "DE" or "US-CA".
+func (d Decision) Jurisdiction() string {
+	if d.Region == "" {
+		return d.Country
+	}
+	return d.Country + "-" + d.Region
+}
+
+// Load reads rules from a JSON file.
+func Load(path string) (*Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open tax rules: %w", err)
+	}
+	defer f.Close()
+
+	var rules Rules
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("couldn't parse tax rules %s: %w", path, err)
+	}
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("invalid tax rules %s: %w", path, err)
+	}
+
+	return &rules, nil
+}
+
+// Decide returns the tax of a sale of a product in the category to the
+// buyer. A region the rules don't know is taxed like its country. Exempt
+// buyers aren't taxed anywhere, even by a nil Rules, which has no rules.
+func (r *Rules) Decide(buyer Buyer, category Category) (Decision, error) {
+	if category == "" {
+		category = Standard
+	}
+	if buyer.Exempt {
+		return Decision{Country: buyer.Country, Region: buyer.Region, Category: category, Reason: ExemptBuyer}, nil
+	}
+	if r == nil {
+		return Decision{}, ErrNoRule
+	}
+
+	country, ok := r.Countries[buyer.Country]
+	if !ok {
+		return Decision{}, fmt.Errorf("%w for country %q", ErrNoRule, buyer.Country)
+	}
+	decision := Decision{Country: buyer.Country, Category: category}
+	jurisdictions := []Jurisdiction{country}
+	if region, ok := country.Regions[buyer.Region]; ok && buyer.Region != "" {
+		decision.Region = buyer.Region
+		// the region comes first so its rates win
+		jurisdictions = []Jurisdiction{region, country}
+	}
+
+	if buyer.TaxID != "" && country.ReverseCharge && buyer.Country != r.SellerCountry {
+		decision.Reason = ReverseCharge
+		return decision, nil
+	}
+
+	for _, j := range jurisdictions {
+		if slices.Contains(j.Exempt, category) {
+			decision.Reason = ExemptProduct
+			return decision, nil
+		}
+	}
+
+	for _, c := range []Category{category, Standard} {
+		for _, j := range jurisdictions {
+			if rate, ok := j.Rates[c]; ok {
+				decision.Rate = rate
+				decision.Reason = Taxed
+				return decision, nil
+			}
+		}
+	}
+
+	return Decision{}, fmt.Errorf("%w for %s products in %s", ErrNoRule, category, decision.Jurisdiction())
+}
+
+// Recategorize returns the tax of a sale to the same buyer as an earlier
+// decision, of a product in another category. Decisions made for the buyer
+// rather than the product carry over.
+func (r *Rules) Recategorize(previous Decision, category Category) (Decision, error) {
+	if category == "" {
+		category = Standard
+	}
+
+	switch previous.Reason {
+	case ExemptBuyer, ReverseCharge:
+		previous.Category = category
+		return previous, nil
+	}
+
+	// the buyer wasn't exempt and either a consumer or a business taxed like
+	// one, so deciding for a consumer gives the same result
+	return r.Decide(Buyer{Country: previous.Country, Region: previous.Region}, category)
+}
+
+func (r *Rules) validate() error {
+	if r.SellerCountry != "" && !ValidCountry(r.SellerCountry) {
+		return fmt.Errorf("seller_country %q is not an ISO 3166-1 alpha-2 code", r.SellerCountry)
+	}
+
+	for code, country := range r.Countries {
+		if !ValidCountry(code) {
+			return fmt.Errorf("country %q is not an ISO 3166-1 alpha-2 code", code)
+		}
+		if err := country.validate(); err != nil {
+			return fmt.Errorf("country %s: %w", code, err)
+		}
+		for name, region := range country.Regions {
+			if name == "" {
+				return fmt.Errorf("country %s: region without a name", code)
+			}
+			if len(region.Regions) > 0 {
+				return fmt.Errorf("region %s-%s: regions can't have regions", code, name)
+			}
+			if err := region.validate(); err != nil {
+				return fmt.Errorf("region %s-%s: %w", code, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (j Jurisdiction) validate() error {
+	for category, rate := range j.Rates {
+		if rate < 0 || rate > 100*100 {
+			return fmt.Errorf("%s rate must be between 0 and 100", category)
+		}
+	}
+	return nil
+}
+
+// ValidCountry reports whether code has the shape of an ISO 3166-1 alpha-2
+// code: two uppercase letters.
+func ValidCountry(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
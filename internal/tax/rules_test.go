@@ -0,0 +1,179 @@
+package tax
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thatmatin/subserv/internal/money"
+)
+
+func TestLoad(t *testing.T) {
+	rules, err := Load("testdata/rules.json")
+	require.NoError(t, err)
+	require.Equal(t, "DE", rules.SellerCountry)
+	require.Len(t, rules.Countries, 3)
+	require.EqualValues(t, 550, rules.Countries["FR"].Rates["ebook"])
+	require.EqualValues(t, 725, rules.Countries["US"].Regions["CA"].Rates[Standard])
+
+	testCases := []struct {
+		name          string
+		content       string
+		errorContains string
+	}{
+		{name: "unknown field", content: `{"countries": {"DE": {"rate": 19}}}`, errorContains: "unknown field"},
+		{name: "lowercase country", content: `{"countries": {"de": {"rates": {"standard": 19}}}}`, errorContains: `country "de"`},
+		{name: "invalid seller country", content: `{"seller_country": "GER"}`, errorContains: "seller_country"},
+		{name: "rate over 100", content: `{"countries": {"DE": {"rates": {"standard": 190}}}}`, errorContains: "between 0 and 100"},
+		{name: "rate with three decimals", content: `{"countries": {"DE": {"rates": {"standard": 19.125}}}}`, errorContains: "invalid tax rate"},
+		{name: "nested regions", content: `{"countries": {"US": {"regions": {"CA": {"regions": {"LA": {}}}}}}}`, errorContains: "regions can't have regions"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+
+			_, err := Load(path)
+			require.ErrorContains(t, err, tc.errorContains)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestDecide(t *testing.T) {
+	rules, err := Load("testdata/rules.json")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		buyer    Buyer
+		category Category
+		expected Decision
+		noRule   bool
+	}{
+		{
+			name:     "standard rate",
+			buyer:    Buyer{Country: "DE"},
+			expected: Decision{Country: "DE", Category: Standard, Rate: 1900, Reason: Taxed},
+		},
+		{
+			name:     "category rate",
+			buyer:    Buyer{Country: "FR"},
+			category: "ebook",
+			expected: Decision{Country: "FR", Category: "ebook", Rate: 550, Reason: Taxed},
+		},
+		{
+			name:     "unlisted category falls back to the standard rate",
+			buyer:    Buyer{Country: "DE"},
+			category: "software",
+			expected: Decision{Country: "DE", Category: "software", Rate: 1900, Reason: Taxed},
+		},
+		{
+			name:     "region rate",
+			buyer:    Buyer{Country: "US", Region: "CA"},
+			expected: Decision{Country: "US", Region: "CA", Category: Standard, Rate: 725, Reason: Taxed},
+		},
+		{
+			name:     "unknown region is taxed like the country",
+			buyer:    Buyer{Country: "US", Region: "OR"},
+			expected: Decision{Country: "US", Category: Standard, Rate: 0, Reason: Taxed},
+		},
+		{
+			name:     "product exempt in the region",
+			buyer:    Buyer{Country: "US", Region: "NY"},
+			category: "ebook",
+			expected: Decision{Country: "US", Region: "NY", Category: "ebook", Reason: ExemptProduct},
+		},
+		{
+			name:     "product exempt in the country",
+			buyer:    Buyer{Country: "FR"},
+			category: "education",
+			expected: Decision{Country: "FR", Category: "education", Reason: ExemptProduct},
+		},
+		{
+			name:     "exempt buyer",
+			buyer:    Buyer{Country: "DE", Exempt: true},
+			expected: Decision{Country: "DE", Category: Standard, Reason: ExemptBuyer},
+		},
+		{
+			name:     "business abroad is reverse charged",
+			buyer:    Buyer{Country: "FR", TaxID: "FR40303265045"},
+			expected: Decision{Country: "FR", Category: Standard, Reason: ReverseCharge},
+		},
+		{
+			name:     "domestic business is taxed",
+			buyer:    Buyer{Country: "DE", TaxID: "DE136695976"},
+			expected: Decision{Country: "DE", Category: Standard, Rate: 1900, Reason: Taxed},
+		},
+		{
+			name:     "business without reverse charge is taxed",
+			buyer:    Buyer{Country: "US", Region: "CA", TaxID: "12-3456789"},
+			expected: Decision{Country: "US", Region: "CA", Category: Standard, Rate: 725, Reason: Taxed},
+		},
+		{
+			name:     "exempt buyer in an unknown country",
+			buyer:    Buyer{Country: "JP", Region: "13", Exempt: true},
+			expected: Decision{Country: "JP", Region: "13", Category: Standard, Reason: ExemptBuyer},
+		},
+		{
+			name:   "unknown country",
+			buyer:  Buyer{Country: "JP"},
+			noRule: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decision, err := rules.Decide(tc.buyer, tc.category)
+			if tc.noRule {
+				require.ErrorIs(t, err, ErrNoRule)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, decision)
+		})
+	}
+
+	t.Run("no standard rate", func(t *testing.T) {
+		rules := &Rules{Countries: map[string]Jurisdiction{"DE": {Rates: map[Category]money.Rate{"ebook": 700}}}}
+		_, err := rules.Decide(Buyer{Country: "DE"}, "software")
+		require.ErrorIs(t, err, ErrNoRule)
+	})
+}
+
+func TestDecideWithoutRules(t *testing.T) {
+	var rules *Rules
+
+	_, err := rules.Decide(Buyer{Country: "DE"}, Standard)
+	require.ErrorIs(t, err, ErrNoRule)
+
+	decision, err := rules.Decide(Buyer{Country: "DE", Exempt: true}, Standard)
+	require.NoError(t, err)
+	require.Equal(t, ExemptBuyer, decision.Reason)
+}
+
+func TestRecategorize(t *testing.T) {
+	rules, err := Load("testdata/rules.json")
+	require.NoError(t, err)
+
+	reverseCharged := Decision{Country: "FR", Category: Standard, Reason: ReverseCharge}
+	decision, err := rules.Recategorize(reverseCharged, "ebook")
+	require.NoError(t, err)
+	require.Equal(t, Decision{Country: "FR", Category: "ebook", Reason: ReverseCharge}, decision)
+
+	taxed := Decision{Country: "US", Region: "NY", Category: Standard, Rate: 888, Reason: Taxed}
+	decision, err = rules.Recategorize(taxed, "ebook")
+	require.NoError(t, err)
+	require.Equal(t, Decision{Country: "US", Region: "NY", Category: "ebook", Reason: ExemptProduct}, decision)
+}
+
+func TestJurisdiction(t *testing.T) {
+	require.Equal(t, "DE", Decision{Country: "DE"}.Jurisdiction())
+	require.Equal(t, "US-CA", Decision{Country: "US", Region: "CA"}.Jurisdiction())
+}
@@ -3,8 +3,13 @@ package utils
 import (
 	"github.com/thatmatin/subserv/internal/db"
 	"github.com/thatmatin/subserv/internal/model"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// TestUserPassword is the password of every user created by
+// PopulateDBWithTestData.
+const TestUserPassword = "password123"
+
 func PopulateDBWithTestData() {
 	db, err := db.Setup()
 	if err != nil {
@@ -12,8 +17,10 @@ func PopulateDBWithTestData() {
 	}
 
 	products := []model.Product{
-		{Name: "Basic Plan", Price: 999, TaxRate: 15, Duration: 2592000, Description: "Basic plan for individuals"},
-		{Name: "Pro Plan", Price: 1999, TaxRate: 5, Duration: 2592000, Description: "Pro plan for small teams"},
+		{Name: "Basic Plan", Price: 999, TaxRate: 15, Duration: 2592000, Description: "Basic plan for individuals",
+			Prices: []model.ProductPrice{{Currency: "EUR", Amount: 949}, {Currency: "JPY", Amount: 1500}}},
+		{Name: "Pro Plan", Price: 1999, TaxRate: 5, Duration: 2592000, Description: "Pro plan for small teams",
+			Prices: []model.ProductPrice{{Currency: "EUR", Amount: 1899}, {Currency: "JPY", Amount: 3000}}},
 		{Name: "Enterprise Plan", Price: 4999, TaxRate: 5, Duration: 2592000, Description: "Enterprise plan with advanced features"},
 		{Name: "Premium Plan", Price: 9999, TaxRate: 20, Duration: 2592000, Description: "Premium plan with all features included"},
 	}
@@ -24,12 +31,20 @@ func PopulateDBWithTestData() {
 		}
 	}
 
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(TestUserPassword), bcrypt.DefaultCost)
+	if err != nil {
+		panic("Failed to hash test password: " + err.Error())
+	}
+
 	users := []model.User{
 		{Name: "Alice", Email: "alice@d.com"},
 		{Name: "Bob", Email: "bob@d.com"},
+		{Name: "Sam", Email: "support@d.com", Role: model.RoleSupport},
+		{Name: "Ada", Email: "admin@d.com", Role: model.RoleAdmin},
 	}
 
 	for _, user := range users {
+		user.PasswordHash = string(passwordHash)
 		if err := db.Create(&user).Error; err != nil {
 			panic("Failed to populate database: " + err.Error())
 		}
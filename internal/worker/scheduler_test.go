@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerRunsJobsUntilCancelled(t *testing.T) {
+	var runs, failing atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := NewScheduler(
+		Job{Name: "counter", Interval: time.Millisecond, Run: func(ctx context.Context) error {
+			if runs.Add(1) == 3 {
+				cancel()
+			}
+			return nil
+		}},
+		Job{Name: "failing", Interval: time.Millisecond, Run: func(ctx context.Context) error {
+			failing.Add(1)
+			return errors.New("boom")
+		}},
+	)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("scheduler did not stop after cancellation")
+	}
+
+	require.GreaterOrEqual(t, runs.Load(), int32(3))
+	require.GreaterOrEqual(t, failing.Load(), int32(1))
+}